@@ -1,12 +1,20 @@
 package main
 
 import (
+	"DocuDefense/backend/src/encryption"
 	"DocuDefense/backend/src/handlers"
+	"DocuDefense/backend/src/mailer"
+	"DocuDefense/backend/src/models"
+	"DocuDefense/backend/src/scanner"
+	"DocuDefense/backend/src/signing"
+	"DocuDefense/backend/src/storage"
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
@@ -19,6 +27,15 @@ import (
 var client *mongo.Client
 
 func main() {
+	migrate := flag.Bool("migrate", false, "run data migrations and exit")
+	integrityScan := flag.Bool("integrity-scan", false, "verify the SHA-256 of every stored document and exit")
+	verifyAudit := flag.Bool("verify-audit", false, "verify the audit log hash chain and exit")
+	rotateKeys := flag.Bool("rotate-keys", false, "re-wrap document data keys with the active master key and exit")
+	unlockAccount := flag.String("unlock-account", "", "lift the login lockout on the account with this email and exit")
+	makeAdmin := flag.String("make-admin", "", "grant the admin role to the account with this email and exit")
+	scanDocuments := flag.Bool("scan-documents", false, "scan document versions stored before malware scanning was enabled and exit")
+	flag.Parse()
+
 	// Load environment variables from .env file
 	err := godotenv.Load()
 	if err != nil {
@@ -45,31 +62,251 @@ func main() {
 		}
 	}()
 
-	if err := client.Database("admin").RunCommand(context.TODO(), bson.D{{"ping", 1}}).Err(); err != nil {
+	if err := client.Database("admin").RunCommand(context.TODO(), bson.D{{Key: "ping", Value: 1}}).Err(); err != nil {
 		log.Fatal("Ping failed:", err)
 	}
 	fmt.Println("Pinged MongoDB successfully!")
 
+	// Load access token signing keys (JWT_SIGNING_KEYS, or the JWT_SECRET fallback)
+	tokenKeys, err := signing.NewKeySetFromEnv()
+	if err != nil {
+		log.Fatal("Error configuring token signing:", err)
+	}
+	if tokenKeys.ActiveKeyID() == "" {
+		log.Println("Warning: JWT_SIGNING_KEYS is not set; signing tokens with the shared HS256 secret")
+	}
+	handlers.SetTokenKeys(tokenKeys)
+
 	// Pass the MongoDB client to handlers
 	handlers.SetMongoClient(client)
+	if err := handlers.EnsureIndexes(context.TODO()); err != nil {
+		log.Fatal("Error creating indexes:", err)
+	}
+
+	// Enable OpenID Connect single sign-on when OIDC_ISSUER_URL is set
+	if oidcConfig, ok := handlers.OIDCConfigFromEnv(); ok {
+		oidcClient, err := handlers.NewOIDCClient(context.TODO(), oidcConfig)
+		if err != nil {
+			log.Fatal("Error configuring single sign-on:", err)
+		}
+		handlers.SetOIDCClient(oidcClient)
+	}
+
+	// Deliver verification and password reset emails through MAIL_DRIVER
+	mail, err := mailer.NewFromEnv()
+	if err != nil {
+		log.Fatal("Error configuring mail:", err)
+	}
+	handlers.SetMailer(mail)
+
+	// Select the blob storage backend from STORAGE_DRIVER
+	store, err := storage.NewFromEnv(context.TODO(), client.Database("docudefense"))
+	if err != nil {
+		log.Fatal("Error configuring storage:", err)
+	}
+	handlers.SetStorage(store)
+
+	// Load master keys for encryption at rest from ENCRYPTION_MASTER_KEYS
+	keyring, err := encryption.NewKeyringFromEnv()
+	if err != nil {
+		log.Fatal("Error configuring encryption:", err)
+	}
+	if keyring == nil {
+		log.Println("Warning: ENCRYPTION_MASTER_KEYS is not set; uploads will be stored unencrypted")
+	}
+	handlers.SetKeyring(keyring)
+
+	// Select the malware scanner from SCANNER_DRIVER
+	malwareScanner, err := scanner.NewFromEnv()
+	if err != nil {
+		log.Fatal("Error configuring malware scanning:", err)
+	}
+	if _, ok := malwareScanner.(scanner.Noop); ok {
+		log.Println("Warning: SCANNER_DRIVER is not set; uploads will not be scanned for malware")
+	}
+	handlers.SetScanner(malwareScanner)
+
+	if *migrate {
+		if err := handlers.RunMigrations(context.TODO()); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Migrations completed successfully")
+		return
+	}
+
+	if *integrityScan {
+		report, err := handlers.ScanAllDocuments(context.TODO())
+		if err != nil {
+			log.Fatal("Integrity scan failed:", err)
+		}
+		fmt.Printf("Checked %d document versions: %d ok, %d unverified, %d corrupted, missing or unreadable\n", report.Checked, report.OK, report.Unverified, len(report.Problems))
+		if len(report.Problems) > 0 {
+			log.Fatal("Integrity scan found problems")
+		}
+		return
+	}
+
+	if *verifyAudit {
+		result, err := handlers.VerifyAuditLog(context.TODO())
+		if err != nil {
+			log.Fatal("Audit verification failed:", err)
+		}
+		if !result.Valid {
+			log.Fatalf("Audit chain broken at event %d after %d events: %s", result.BrokenAt, result.Checked, result.Reason)
+		}
+		fmt.Printf("Audit chain intact: %d events, head hash %s\n", result.Checked, result.HeadHash)
+		return
+	}
+
+	if *unlockAccount != "" {
+		if err := handlers.UnlockLogin(context.TODO(), *unlockAccount); err != nil {
+			log.Fatal("Unlock failed:", err)
+		}
+		fmt.Printf("Cleared failed login attempts for %s\n", *unlockAccount)
+		return
+	}
+
+	if *makeAdmin != "" {
+		if err := handlers.GrantRole(context.TODO(), *makeAdmin, models.RoleAdmin); err != nil {
+			log.Fatal("Granting admin role failed:", err)
+		}
+		fmt.Printf("%s is now an administrator\n", *makeAdmin)
+		return
+	}
+
+	if *scanDocuments {
+		scanned, infected, err := handlers.ScanUnscannedDocuments(context.TODO())
+		if err != nil {
+			log.Fatal("Malware scan failed:", err)
+		}
+		fmt.Printf("Scanned %d document versions: %d infected and quarantined\n", scanned, infected)
+		return
+	}
+
+	if *rotateKeys {
+		rotated, err := handlers.RotateEncryptionKeys(context.TODO())
+		if err != nil {
+			log.Fatal("Key rotation failed:", err)
+		}
+		fmt.Printf("Re-wrapped %d data keys with master key %s\n", rotated, keyring.ActiveKeyID())
+		return
+	}
 
 	// Set up routes
 	r := mux.NewRouter()
-	r.HandleFunc("/users", handlers.GetUsers).Methods("GET")
+	// Listing accounts is an administrative action, like /admin/users
+	listUsers := func(h http.HandlerFunc) http.Handler {
+		return handlers.JWTAuthMiddleware(handlers.RequirePermission(models.PermUsersRead)(h))
+	}
+	r.Handle("/users", listUsers(handlers.GetUsers)).Methods("GET")
 	r.HandleFunc("/users", handlers.CreateUser).Methods("POST")
 	r.HandleFunc("/login", handlers.LoginUser).Methods("POST")
+	r.HandleFunc("/login/mfa", handlers.LoginMFA).Methods("POST")
+	r.HandleFunc("/email/verify", handlers.VerifyEmail).Methods("POST")
+	r.HandleFunc("/password/forgot", handlers.ForgotPassword).Methods("POST")
+	r.HandleFunc("/password/reset", handlers.ResetPassword).Methods("POST")
+	r.HandleFunc("/auth/oidc/login", handlers.OIDCLogin).Methods("GET")
+	r.HandleFunc("/auth/oidc/callback", handlers.OIDCCallback).Methods("GET")
+	r.HandleFunc("/token/refresh", handlers.RefreshToken).Methods("POST")
+	r.HandleFunc("/.well-known/jwks.json", handlers.GetJWKS).Methods("GET")
+	r.Handle("/logout", handlers.JWTAuthMiddleware(http.HandlerFunc(handlers.Logout))).Methods("POST")
+
+	// Endpoint for fetching the signed-in user's ID by email
+	r.Handle("/users/email", handlers.JWTAuthMiddleware(http.HandlerFunc(handlers.GetUserByEmail))).Methods("GET")
 
-	// Endpoint for fetching user data by email (e.g., for user ID lookup)
-	r.HandleFunc("/users/email", handlers.GetUserByEmail).Methods("GET")
+	// User-specific routes: every route below /users/{id} requires a valid JWT
+	// and is only reachable by the owner of that account. File routes work on the
+	// organization named by the X-Organization-ID header, or the personal workspace.
+	userRoutes := r.PathPrefix("/users/{id}").Subrouter()
+	userRoutes.Use(handlers.JWTAuthMiddleware, handlers.RequireAccountOwner, handlers.ResolveTenant)
+	userRoutes.HandleFunc("", handlers.GetUser).Methods("GET")
+	userRoutes.Handle("", handlers.RequireRecentMFA(http.HandlerFunc(handlers.UpdateUser))).Methods("PUT")
+	userRoutes.Handle("", handlers.RequireRecentMFA(http.HandlerFunc(handlers.DeleteUser))).Methods("DELETE")
+	userRoutes.HandleFunc("/email/verification", handlers.ResendVerificationEmail).Methods("POST")
+	userRoutes.HandleFunc("/mfa/totp", handlers.EnrollTOTP).Methods("POST")
+	userRoutes.HandleFunc("/mfa/totp/verify", handlers.ConfirmTOTP).Methods("POST")
+	userRoutes.HandleFunc("/mfa/recovery-codes", handlers.RegenerateRecoveryCodes).Methods("POST")
+	userRoutes.HandleFunc("/mfa/step-up", handlers.StepUpMFA).Methods("POST")
+	userRoutes.HandleFunc("/mfa", handlers.DisableMFA).Methods("DELETE")
+	userRoutes.HandleFunc("/audit", handlers.GetAuditEvents).Methods("GET")
+	userRoutes.HandleFunc("/upload", handlers.UploadFile).Methods("POST")
+	userRoutes.HandleFunc("/uploads", handlers.GetUploadOptions).Methods("OPTIONS")
+	userRoutes.HandleFunc("/uploads", handlers.CreateUpload).Methods("POST")
+	userRoutes.HandleFunc("/uploads/{uploadID}", handlers.GetUploadOffset).Methods("HEAD")
+	userRoutes.HandleFunc("/uploads/{uploadID}", handlers.PatchUpload).Methods("PATCH")
+	userRoutes.HandleFunc("/uploads/{uploadID}", handlers.TerminateUpload).Methods("DELETE")
+	userRoutes.HandleFunc("/files", handlers.GetUserFiles).Methods("GET")
+	userRoutes.HandleFunc("/files/integrity", handlers.ScanUserFiles).Methods("GET")
+	userRoutes.HandleFunc("/files/{filename}/download", handlers.DownloadFile).Methods("GET")
+	userRoutes.Handle("/files/{filename}/delete", handlers.RequireRecentMFA(http.HandlerFunc(handlers.DeleteFile))).Methods("DELETE")
+	userRoutes.HandleFunc("/files/{filename}/versions", handlers.GetFileVersions).Methods("GET")
+	userRoutes.HandleFunc("/files/{filename}/versions/{version}/restore", handlers.RestoreFileVersion).Methods("POST")
+	userRoutes.HandleFunc("/files/{filename}/versions/{version}/verify", handlers.VerifyFileVersion).Methods("GET")
+	userRoutes.HandleFunc("/files/{filename}/{version}/blob", handlers.GetFileVersionBlob).Methods("GET")
+	userRoutes.HandleFunc("/files/{filename}/{version}/download", handlers.DownloadFileVersion).Methods("GET")
+	userRoutes.HandleFunc("/documents/{documentID}/blob", handlers.GetDocumentBlob).Methods("GET")
+	userRoutes.HandleFunc("/documents/{documentID}/download", handlers.DownloadDocument).Methods("GET")
+	userRoutes.HandleFunc("/files/{filename}/shares", handlers.ShareFile).Methods("POST")
+	userRoutes.HandleFunc("/files/{filename}/shares", handlers.ListFileShares).Methods("GET")
+	userRoutes.HandleFunc("/files/{filename}/shares/{shareID}", handlers.RevokeShare).Methods("DELETE")
+	userRoutes.HandleFunc("/files/{filename}/{version}/links", handlers.CreateShareLink).Methods("POST")
+	userRoutes.HandleFunc("/files/{filename}/links", handlers.ListShareLinks).Methods("GET")
+	userRoutes.HandleFunc("/files/{filename}/links/{linkID}", handlers.RevokeShareLink).Methods("DELETE")
+	userRoutes.HandleFunc("/files/{filename}/lock", handlers.CheckOutFile).Methods("POST")
+	userRoutes.HandleFunc("/files/{filename}/lock", handlers.GetFileLock).Methods("GET")
+	userRoutes.HandleFunc("/files/{filename}/lock", handlers.CheckInFile).Methods("DELETE")
+	userRoutes.HandleFunc("/locks", handlers.ListLocks).Methods("GET")
 
-	// User-specific routes that require JWT authentication
-	r.Handle("/users/{id}", handlers.JWTAuthMiddleware(http.HandlerFunc(handlers.UpdateUser))).Methods("PUT")
-	r.Handle("/users/{id}", handlers.JWTAuthMiddleware(http.HandlerFunc(handlers.DeleteUser))).Methods("DELETE")
-	r.Handle("/users/{id}/upload", handlers.JWTAuthMiddleware(http.HandlerFunc(handlers.UploadFile))).Methods("POST")
-	r.Handle("/users/{id}/files", handlers.JWTAuthMiddleware(http.HandlerFunc(handlers.GetUserFiles))).Methods("GET")
-	r.Handle("/users/{id}/files/{filename}/download", handlers.JWTAuthMiddleware(http.HandlerFunc(handlers.DownloadFile))).Methods("GET")
-	r.Handle("/users/{id}/files/{filename}/delete", handlers.JWTAuthMiddleware(http.HandlerFunc(handlers.DeleteFile))).Methods("DELETE")
-	r.HandleFunc("/api/users", handlers.GetUsersOrSearch).Methods("GET")
+	// Documents shared with the caller: access comes from the owner's shares, not the URL
+	sharedRoutes := r.PathPrefix("/shared/documents/{documentID}").Subrouter()
+	sharedRoutes.Use(handlers.JWTAuthMiddleware)
+	sharedRoutes.HandleFunc("/blob", handlers.GetSharedDocumentBlob).Methods("GET")
+	sharedRoutes.HandleFunc("/download", handlers.DownloadSharedDocument).Methods("GET")
+	sharedRoutes.HandleFunc("/versions", handlers.GetSharedDocumentVersions).Methods("GET")
+	sharedRoutes.HandleFunc("/upload", handlers.UploadSharedDocumentVersion).Methods("POST")
+	sharedRoutes.HandleFunc("/lock", handlers.CheckOutSharedDocument).Methods("POST")
+	sharedRoutes.HandleFunc("/lock", handlers.CheckInSharedDocument).Methods("DELETE")
+
+	// Public share links: the signed token in the URL is the only credential
+	r.HandleFunc("/public/links/{token}", handlers.GetPublicShareLink).Methods("GET")
+	r.HandleFunc("/public/links/{token}/document", handlers.GetPublicSharedDocument).Methods("GET")
+
+	// Organizations: creating and listing them needs a valid JWT, everything below
+	// /orgs/{orgID} also needs membership of that organization
+	r.Handle("/orgs", handlers.JWTAuthMiddleware(http.HandlerFunc(handlers.CreateOrganization))).Methods("POST")
+	r.Handle("/orgs", handlers.JWTAuthMiddleware(http.HandlerFunc(handlers.ListOrganizations))).Methods("GET")
+	r.Handle("/invitations/accept", handlers.JWTAuthMiddleware(http.HandlerFunc(handlers.AcceptInvitation))).Methods("POST")
+	orgRoutes := r.PathPrefix("/orgs/{orgID}").Subrouter()
+	orgRoutes.Use(handlers.JWTAuthMiddleware, handlers.RequireOrgMember)
+	orgRoutes.HandleFunc("/members", handlers.ListMembers).Methods("GET")
+	orgRoutes.HandleFunc("/members/{userID}", handlers.UpdateMemberRole).Methods("PUT")
+	orgRoutes.HandleFunc("/members/{userID}", handlers.RemoveMember).Methods("DELETE")
+	orgRoutes.HandleFunc("/invitations", handlers.InviteMember).Methods("POST")
+	orgRoutes.HandleFunc("/invitations", handlers.ListInvitations).Methods("GET")
+	orgRoutes.HandleFunc("/invitations/{invitationID}", handlers.RevokeInvitation).Methods("DELETE")
+	orgRoutes.HandleFunc("/documents", handlers.ListOrganizationDocuments).Methods("GET")
+
+	// Administration routes: each one also requires the permission it is registered with
+	adminRoutes := r.PathPrefix("/admin").Subrouter()
+	adminRoutes.Use(handlers.JWTAuthMiddleware)
+	requireUsersRead := handlers.RequirePermission(models.PermUsersRead)
+	requireUsersManage := handlers.RequirePermission(models.PermUsersManage)
+	requireDocumentsRead := handlers.RequirePermission(models.PermDocumentsRead)
+	requireDocumentsManage := handlers.RequirePermission(models.PermDocumentsManage)
+	requireAuditRead := handlers.RequirePermission(models.PermAuditRead)
+	adminRoutes.Handle("/users", requireUsersRead(http.HandlerFunc(handlers.AdminListUsers))).Methods("GET")
+	adminRoutes.Handle("/users/{id}/roles", requireUsersManage(http.HandlerFunc(handlers.AdminSetRoles))).Methods("PUT")
+	adminRoutes.Handle("/users/{id}/disable", requireUsersManage(http.HandlerFunc(handlers.AdminDisableUser))).Methods("POST")
+	adminRoutes.Handle("/users/{id}/enable", requireUsersManage(http.HandlerFunc(handlers.AdminEnableUser))).Methods("POST")
+	adminRoutes.Handle("/users/{id}", requireUsersManage(http.HandlerFunc(handlers.AdminDeleteUser))).Methods("DELETE")
+	adminRoutes.Handle("/users/{id}/documents/reassign", requireDocumentsManage(http.HandlerFunc(handlers.AdminReassignDocuments))).Methods("POST")
+	adminRoutes.Handle("/documents", requireDocumentsRead(http.HandlerFunc(handlers.AdminListDocuments))).Methods("GET")
+	adminRoutes.Handle("/quarantine", requireDocumentsRead(http.HandlerFunc(handlers.AdminListQuarantine))).Methods("GET")
+	adminRoutes.Handle("/locks", requireDocumentsRead(http.HandlerFunc(handlers.AdminListLocks))).Methods("GET")
+	adminRoutes.Handle("/locks/{lockID}", requireDocumentsManage(http.HandlerFunc(handlers.AdminBreakLock))).Methods("DELETE")
+	adminRoutes.Handle("/audit", requireAuditRead(http.HandlerFunc(handlers.AdminAuditEvents))).Methods("GET")
+
+	r.Handle("/api/users", listUsers(handlers.GetUsersOrSearch)).Methods("GET")
 
 	// Serve static files such as pdf.worker.js from the public directory
 	fs := http.FileServer(http.Dir("./public"))
@@ -77,12 +314,25 @@ func main() {
 
 	c := cors.New(cors.Options{
 		AllowedOrigins:   []string{"http://localhost:3000"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE"},
-		AllowedHeaders:   []string{"Authorization", "Content-Type"},
+		AllowedMethods:   []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type", "X-Organization-ID", "X-Share-Password", "Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Metadata", "Range", "If-Range", "If-Match", "If-None-Match", "If-Modified-Since"},
+		ExposedHeaders:   []string{"Content-Disposition", "Content-Length", "X-Content-SHA256", "X-Integrity-Status", "WWW-Authenticate", "Retry-After", "Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size", "Upload-Offset", "Upload-Length", "Upload-Expires", "ETag", "Last-Modified", "Accept-Ranges", "Content-Range"},
 		AllowCredentials: true,
 	})
 
 	handler := c.Handler(r)
 
+	// Remove resumable uploads abandoned for longer than UPLOAD_EXPIRY
+	go func() {
+		for range time.Tick(time.Hour) {
+			removed, err := handlers.ExpireUploads(context.Background())
+			if err != nil {
+				log.Println("Error expiring uploads:", err)
+			} else if removed > 0 {
+				log.Printf("Removed %d expired uploads", removed)
+			}
+		}
+	}()
+
 	log.Fatal(http.ListenAndServe(":8000", handler))
 }
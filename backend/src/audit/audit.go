@@ -0,0 +1,304 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Actions recorded in the audit log
+const (
+	ActionLogin                = "auth.login"
+	ActionLoginFailed          = "auth.login_failed"
+	ActionLogout               = "auth.logout"
+	ActionLoginLocked          = "auth.lockout"
+	ActionLoginUnlocked        = "auth.unlock"
+	ActionTokenReuse           = "auth.refresh_token_reuse"
+	ActionMFAEnable            = "auth.mfa_enable"
+	ActionMFADisable           = "auth.mfa_disable"
+	ActionMFARecoveryCodes     = "auth.mfa_recovery_codes"
+	ActionEmailVerify          = "account.email_verify"
+	ActionPasswordResetRequest = "account.password_reset_request"
+	ActionPasswordReset        = "account.password_reset"
+	ActionAccountCreate        = "account.create"
+	ActionAccountUpdate        = "account.update"
+	ActionAccountDelete        = "account.delete"
+	ActionAccountDisable       = "account.disable"
+	ActionAccountEnable        = "account.enable"
+	ActionRoleChange           = "account.role_change"
+	ActionDocumentUpload       = "document.upload"
+	ActionDocumentView         = "document.download"
+	ActionDocumentDelete       = "document.delete"
+	ActionDocumentRestore      = "document.restore"
+	ActionDocumentReassign     = "document.reassign"
+	ActionDocumentReject       = "document.reject"
+	ActionDocumentQuarantine   = "document.quarantine"
+	ActionDocumentCheckOut     = "document.checkout"
+	ActionDocumentCheckIn      = "document.checkin"
+	ActionDocumentLockBreak    = "document.lock_break"
+	ActionShareCreate          = "document.share"
+	ActionShareRevoke          = "document.unshare"
+	ActionShareLinkCreate      = "share_link.create"
+	ActionShareLinkRevoke      = "share_link.revoke"
+	ActionShareLinkAccess      = "share_link.access"
+	ActionOrgCreate            = "org.create"
+	ActionOrgInvite            = "org.invite"
+	ActionOrgInviteRevoke      = "org.invite_revoke"
+	ActionOrgJoin              = "org.join"
+	ActionOrgMemberRole        = "org.member_role"
+	ActionOrgMemberRemove      = "org.member_remove"
+)
+
+// Event is a single entry in the audit log. Each event stores the hash of the one before
+// it, so altering or removing any stored event breaks the chain from that point on.
+type Event struct {
+	ID           primitive.ObjectID `json:"id" bson:"_id"`
+	Seq          int64              `json:"seq" bson:"seq"`
+	Action       string             `json:"action" bson:"action"`
+	Actor        string             `json:"actor,omitempty" bson:"actor,omitempty"`
+	ActorID      primitive.ObjectID `json:"actor_id,omitempty" bson:"actor_id,omitempty"`
+	TargetUserID primitive.ObjectID `json:"target_user_id,omitempty" bson:"target_user_id,omitempty"`
+	DocumentID   primitive.ObjectID `json:"document_id,omitempty" bson:"document_id,omitempty"`
+	Target       string             `json:"target,omitempty" bson:"target,omitempty"`
+	IP           string             `json:"ip,omitempty" bson:"ip,omitempty"`
+	Details      map[string]string  `json:"details,omitempty" bson:"details,omitempty"`
+	Timestamp    time.Time          `json:"timestamp" bson:"timestamp"`
+	PrevHash     string             `json:"prev_hash" bson:"prev_hash"`
+	Hash         string             `json:"hash" bson:"hash"`
+}
+
+// Collection is the subset of a MongoDB collection the audit log needs
+type Collection interface {
+	Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error)
+	FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult
+	InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error)
+}
+
+// Logger appends hash-chained events to an audit collection
+type Logger struct {
+	coll Collection
+	mu   sync.Mutex
+}
+
+// NewLogger returns a logger writing to coll
+func NewLogger(coll Collection) *Logger {
+	return &Logger{coll: coll}
+}
+
+// EnsureIndexes creates the unique sequence index that keeps concurrent writers from
+// forking the chain, plus the indexes used by Query
+func EnsureIndexes(ctx context.Context, coll *mongo.Collection) error {
+	_, err := coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "seq", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "actor_id", Value: 1}, {Key: "timestamp", Value: -1}}},
+		{Keys: bson.D{{Key: "target_user_id", Value: 1}, {Key: "timestamp", Value: -1}}},
+		{Keys: bson.D{{Key: "document_id", Value: 1}, {Key: "timestamp", Value: -1}}},
+	})
+	return err
+}
+
+// computeHash hashes every field except Hash itself, chained to the previous event's hash
+func computeHash(e *Event) (string, error) {
+	payload, err := json.Marshal(struct {
+		Seq          int64             `json:"seq"`
+		Action       string            `json:"action"`
+		Actor        string            `json:"actor"`
+		ActorID      string            `json:"actor_id"`
+		TargetUserID string            `json:"target_user_id"`
+		DocumentID   string            `json:"document_id"`
+		Target       string            `json:"target"`
+		IP           string            `json:"ip"`
+		Details      map[string]string `json:"details"`
+		Timestamp    int64             `json:"timestamp"`
+		PrevHash     string            `json:"prev_hash"`
+	}{
+		Seq:          e.Seq,
+		Action:       e.Action,
+		Actor:        e.Actor,
+		ActorID:      hexOrEmpty(e.ActorID),
+		TargetUserID: hexOrEmpty(e.TargetUserID),
+		DocumentID:   hexOrEmpty(e.DocumentID),
+		Target:       e.Target,
+		IP:           e.IP,
+		Details:      e.Details,
+		Timestamp:    e.Timestamp.UnixMilli(),
+		PrevHash:     e.PrevHash,
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func hexOrEmpty(id primitive.ObjectID) string {
+	if id.IsZero() {
+		return ""
+	}
+	return id.Hex()
+}
+
+// last returns the newest event in the chain, or nil for an empty log
+func (l *Logger) last(ctx context.Context) (*Event, error) {
+	var e Event
+	err := l.coll.FindOne(ctx, bson.M{}, options.FindOne().SetSort(bson.D{{Key: "seq", Value: -1}})).Decode(&e)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// Record appends an event to the chain. Conflicting appends from other replicas are
+// detected through the unique sequence index and retried on top of the new head.
+func (l *Logger) Record(ctx context.Context, e Event) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// MongoDB stores millisecond precision and drops empty maps, so hash exactly what will be read back
+	e.Timestamp = time.Now().UTC().Truncate(time.Millisecond)
+	if len(e.Details) == 0 {
+		e.Details = nil
+	}
+
+	const attempts = 5
+	for i := 0; i < attempts; i++ {
+		head, err := l.last(ctx)
+		if err != nil {
+			return err
+		}
+
+		e.ID = primitive.NewObjectID()
+		e.Seq, e.PrevHash = 1, ""
+		if head != nil {
+			e.Seq, e.PrevHash = head.Seq+1, head.Hash
+		}
+		if e.Hash, err = computeHash(&e); err != nil {
+			return err
+		}
+
+		_, err = l.coll.InsertOne(ctx, e)
+		if mongo.IsDuplicateKeyError(err) {
+			continue
+		}
+		return err
+	}
+	return fmt.Errorf("audit: could not append event after %d attempts", attempts)
+}
+
+// Filter narrows an audit query; zero values are ignored
+type Filter struct {
+	UserID     primitive.ObjectID // matches events the user performed or that targeted them
+	DocumentID primitive.ObjectID
+	Target     string
+	From       time.Time
+	To         time.Time
+	Limit      int64
+}
+
+// Query returns matching events, newest first
+func (l *Logger) Query(ctx context.Context, f Filter) ([]Event, error) {
+	filter := bson.M{}
+	if !f.UserID.IsZero() {
+		filter["$or"] = []bson.M{{"actor_id": f.UserID}, {"target_user_id": f.UserID}}
+	}
+	if !f.DocumentID.IsZero() {
+		filter["document_id"] = f.DocumentID
+	}
+	if f.Target != "" {
+		filter["target"] = f.Target
+	}
+	timeRange := bson.M{}
+	if !f.From.IsZero() {
+		timeRange["$gte"] = f.From
+	}
+	if !f.To.IsZero() {
+		timeRange["$lte"] = f.To
+	}
+	if len(timeRange) > 0 {
+		filter["timestamp"] = timeRange
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "seq", Value: -1}})
+	if f.Limit > 0 {
+		opts.SetLimit(f.Limit)
+	}
+
+	cursor, err := l.coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	events := []Event{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// Verification is the result of walking the whole chain. Removing events from the end
+// of the log leaves a valid shorter chain, so keep a copy of HeadHash outside the
+// database and compare it on the next run to detect truncation.
+type Verification struct {
+	Checked  int64  `json:"checked"`
+	Valid    bool   `json:"valid"`
+	HeadHash string `json:"head_hash,omitempty"`
+	BrokenAt int64  `json:"broken_at,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// Verify recomputes every hash in sequence order and reports the first event that does
+// not match, is missing, or does not point at its predecessor
+func (l *Logger) Verify(ctx context.Context) (*Verification, error) {
+	cursor, err := l.coll.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "seq", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	result := &Verification{Valid: true}
+	prevHash := ""
+	expectedSeq := int64(1)
+	for cursor.Next(ctx) {
+		var e Event
+		if err := cursor.Decode(&e); err != nil {
+			return nil, err
+		}
+		result.Checked++
+
+		reason := ""
+		hash, err := computeHash(&e)
+		switch {
+		case err != nil:
+			return nil, err
+		case e.Seq != expectedSeq:
+			reason = fmt.Sprintf("expected sequence %d, found %d", expectedSeq, e.Seq)
+		case e.PrevHash != prevHash:
+			reason = "previous hash does not match the preceding event"
+		case e.Hash != hash:
+			reason = "event contents do not match its hash"
+		}
+		if reason != "" {
+			result.Valid, result.BrokenAt, result.Reason = false, e.Seq, reason
+			return result, nil
+		}
+
+		prevHash = e.Hash
+		expectedSeq++
+	}
+	result.HeadHash = prevHash
+	return result, cursor.Err()
+}
@@ -0,0 +1,310 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// fakeCollection is an in-memory Collection supporting equality and $or filters, sorting
+// and limits on seq, and the unique seq index
+type fakeCollection struct {
+	mu     sync.Mutex
+	docs   []bson.M
+	before func() // runs once, just before the next insert
+}
+
+func toM(v interface{}) bson.M {
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	var m bson.M
+	if err := bson.Unmarshal(raw, &m); err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func matches(doc bson.M, filter bson.M) bool {
+	for key, want := range filter {
+		if key == "$or" {
+			matched := false
+			for _, alternative := range want.(bson.A) {
+				matched = matched || matches(doc, alternative.(bson.M))
+			}
+			if !matched {
+				return false
+			}
+			continue
+		}
+		if !reflect.DeepEqual(doc[key], want) {
+			return false
+		}
+	}
+	return true
+}
+
+// sorted returns the matching documents ordered by seq, descending when the sort asks for it
+func (c *fakeCollection) sorted(filter interface{}, sortSpec interface{}) []bson.M {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f := toM(filter)
+	var found []bson.M
+	for _, doc := range c.docs {
+		if matches(doc, f) {
+			found = append(found, doc)
+		}
+	}
+	descending := false
+	if spec, ok := sortSpec.(bson.D); ok && len(spec) > 0 {
+		descending = spec[0].Value == -1
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if descending {
+			return found[i]["seq"].(int64) > found[j]["seq"].(int64)
+		}
+		return found[i]["seq"].(int64) < found[j]["seq"].(int64)
+	})
+	return found
+}
+
+func (c *fakeCollection) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	var sortSpec interface{}
+	var limit int64
+	for _, opt := range opts {
+		if opt.Sort != nil {
+			sortSpec = opt.Sort
+		}
+		if opt.Limit != nil {
+			limit = *opt.Limit
+		}
+	}
+	var docs []interface{}
+	for _, doc := range c.sorted(filter, sortSpec) {
+		if limit > 0 && int64(len(docs)) == limit {
+			break
+		}
+		docs = append(docs, doc)
+	}
+	return mongo.NewCursorFromDocuments(docs, nil, nil)
+}
+
+func (c *fakeCollection) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	var sortSpec interface{}
+	for _, opt := range opts {
+		if opt.Sort != nil {
+			sortSpec = opt.Sort
+		}
+	}
+	found := c.sorted(filter, sortSpec)
+	if len(found) == 0 {
+		return mongo.NewSingleResultFromDocument(bson.D{}, mongo.ErrNoDocuments, nil)
+	}
+	return mongo.NewSingleResultFromDocument(found[0], nil, nil)
+}
+
+func (c *fakeCollection) InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	c.mu.Lock()
+	before := c.before
+	c.before = nil
+	c.mu.Unlock()
+	if before != nil {
+		before()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	doc := toM(document)
+	for _, existing := range c.docs {
+		if existing["seq"] == doc["seq"] {
+			return nil, mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000, Message: "E11000 duplicate key error"}}}
+		}
+	}
+	c.docs = append(c.docs, doc)
+	return &mongo.InsertOneResult{InsertedID: doc["_id"]}, nil
+}
+
+// event returns the stored event with the given sequence number
+func (c *fakeCollection) event(t *testing.T, seq int64) bson.M {
+	t.Helper()
+	for _, doc := range c.docs {
+		if doc["seq"] == seq {
+			return doc
+		}
+	}
+	t.Fatalf("no event %d", seq)
+	return nil
+}
+
+func record(t *testing.T, logger *Logger, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := logger.Record(context.Background(), Event{Action: ActionLogin, Target: fmt.Sprintf("user%d@example.com", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRecordChainsEvents(t *testing.T) {
+	coll := &fakeCollection{}
+	logger := NewLogger(coll)
+	record(t, logger, 3)
+
+	events, err := logger.Query(context.Background(), Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3", len(events))
+	}
+	// Newest first
+	for i, e := range events {
+		if want := int64(3 - i); e.Seq != want {
+			t.Fatalf("event %d has sequence %d, want %d", i, e.Seq, want)
+		}
+		hash, _ := computeHash(&e)
+		if e.Hash != hash {
+			t.Fatalf("event %d: stored hash %s, computed %s", e.Seq, e.Hash, hash)
+		}
+		if i+1 < len(events) && e.PrevHash != events[i+1].Hash {
+			t.Fatalf("event %d does not point at event %d", e.Seq, events[i+1].Seq)
+		}
+	}
+	if events[2].PrevHash != "" {
+		t.Fatalf("first event has previous hash %q", events[2].PrevHash)
+	}
+
+	result, err := logger.Verify(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid || result.Checked != 3 || result.HeadHash != events[0].Hash {
+		t.Fatalf("verification of an intact chain: %+v", result)
+	}
+}
+
+func TestConcurrentRecordsGetDistinctSequences(t *testing.T) {
+	coll := &fakeCollection{}
+	logger := NewLogger(coll)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := logger.Record(context.Background(), Event{Action: ActionDocumentView}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	result, err := logger.Verify(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid || result.Checked != 20 {
+		t.Fatalf("verification after concurrent records: %+v", result)
+	}
+}
+
+func TestRecordRetriesOnTopOfAnotherReplica(t *testing.T) {
+	coll := &fakeCollection{}
+	replica, other := NewLogger(coll), NewLogger(coll)
+	record(t, replica, 1)
+
+	// The other replica appends between this one reading the head and inserting
+	coll.before = func() {
+		if err := other.Record(context.Background(), Event{Action: ActionLogout}); err != nil {
+			t.Error(err)
+		}
+	}
+	record(t, replica, 1)
+
+	if got := coll.event(t, 2)["action"]; got != ActionLogout {
+		t.Fatalf("event 2 has action %v, want the other replica's", got)
+	}
+	if got := coll.event(t, 3)["action"]; got != ActionLogin {
+		t.Fatalf("retried event has action %v", got)
+	}
+	result, err := replica.Verify(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid || result.Checked != 3 {
+		t.Fatalf("verification after a conflicting append: %+v", result)
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name     string
+		tamper   func(t *testing.T, coll *fakeCollection)
+		brokenAt int64
+		reason   string
+	}{
+		{"altered event", func(t *testing.T, coll *fakeCollection) {
+			coll.event(t, 2)["target"] = "mallory@example.com"
+		}, 2, "event contents do not match its hash"},
+		{"removed event", func(t *testing.T, coll *fakeCollection) {
+			coll.docs = append(coll.docs[:1], coll.docs[2:]...)
+		}, 3, "expected sequence 2, found 3"},
+		{"rehashed event", func(t *testing.T, coll *fakeCollection) {
+			doc := coll.event(t, 2)
+			doc["target"] = "mallory@example.com"
+			doc["prev_hash"] = ""
+			var e Event
+			raw, _ := bson.Marshal(doc)
+			bson.Unmarshal(raw, &e)
+			doc["hash"], _ = computeHash(&e)
+		}, 2, "previous hash does not match the preceding event"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			coll := &fakeCollection{}
+			logger := NewLogger(coll)
+			record(t, logger, 3)
+			tt.tamper(t, coll)
+
+			result, err := logger.Verify(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Valid || result.BrokenAt != tt.brokenAt || result.Reason != tt.reason {
+				t.Fatalf("got %+v, want broken at %d: %s", result, tt.brokenAt, tt.reason)
+			}
+		})
+	}
+}
+
+func TestQueryFiltersByUser(t *testing.T) {
+	logger := NewLogger(&fakeCollection{})
+	alice, bob := primitive.NewObjectID(), primitive.NewObjectID()
+	for _, e := range []Event{
+		{Action: ActionLogin, ActorID: alice},
+		{Action: ActionRoleChange, ActorID: bob, TargetUserID: alice},
+		{Action: ActionLogin, ActorID: bob},
+		{Action: ActionLogout, ActorID: alice},
+	} {
+		if err := logger.Record(context.Background(), e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events, err := logger.Query(context.Background(), Filter{UserID: alice, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Action != ActionLogout || events[1].Action != ActionRoleChange {
+		t.Fatalf("got %+v, want alice's two newest events", events)
+	}
+}
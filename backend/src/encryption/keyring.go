@@ -0,0 +1,144 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KeySize is the length in bytes of master and data keys (AES-256)
+const KeySize = 32
+
+// ErrUnknownKey is returned when a wrapped key names a master key that is not configured
+var ErrUnknownKey = errors.New("encryption: unknown master key")
+
+// Keyring holds the configured master keys. New data keys are always wrapped with the
+// active key; older keys stay available so existing documents can still be unwrapped
+// until they are rotated.
+type Keyring struct {
+	keys     map[string][]byte
+	activeID string
+}
+
+// NewKeyring builds a keyring from master keys indexed by ID
+func NewKeyring(keys map[string][]byte, activeID string) (*Keyring, error) {
+	for id, key := range keys {
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("encryption: master key %q must be %d bytes, got %d", id, KeySize, len(key))
+		}
+	}
+	if _, ok := keys[activeID]; !ok {
+		return nil, fmt.Errorf("encryption: active master key %q is not configured", activeID)
+	}
+	return &Keyring{keys: keys, activeID: activeID}, nil
+}
+
+// NewKeyringFromEnv loads master keys from ENCRYPTION_MASTER_KEYS, a comma separated list
+// of id:base64key pairs, and the active key ID from ENCRYPTION_ACTIVE_KEY. It returns nil
+// when no keys are configured, which leaves encryption at rest disabled.
+func NewKeyringFromEnv() (*Keyring, error) {
+	spec := os.Getenv("ENCRYPTION_MASTER_KEYS")
+	if spec == "" {
+		return nil, nil
+	}
+
+	keys := map[string][]byte{}
+	for _, entry := range strings.Split(spec, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("encryption: invalid ENCRYPTION_MASTER_KEYS entry %q", entry)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("encryption: master key %q is not valid base64: %w", id, err)
+		}
+		keys[id] = key
+	}
+
+	activeID := os.Getenv("ENCRYPTION_ACTIVE_KEY")
+	if activeID == "" && len(keys) == 1 {
+		for id := range keys {
+			activeID = id
+		}
+	}
+	return NewKeyring(keys, activeID)
+}
+
+// ActiveKeyID returns the ID of the master key used for new documents
+func (k *Keyring) ActiveKeyID() string {
+	return k.activeID
+}
+
+// NewDataKey generates a random per-document key and returns it with its wrapped form
+func (k *Keyring) NewDataKey() (dataKey []byte, keyID string, wrapped []byte, err error) {
+	dataKey = make([]byte, KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, "", nil, err
+	}
+	wrapped, err = k.Wrap(dataKey)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return dataKey, k.activeID, wrapped, nil
+}
+
+// Wrap encrypts a data key with the active master key
+func (k *Keyring) Wrap(dataKey []byte) ([]byte, error) {
+	aead, err := newGCM(k.keys[k.activeID])
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	// Bind the wrapped key to its master key ID so it cannot be replayed under another one
+	return aead.Seal(nonce, nonce, dataKey, []byte(k.activeID)), nil
+}
+
+// Unwrap decrypts a data key that was wrapped with the master key keyID
+func (k *Keyring) Unwrap(keyID string, wrapped []byte) ([]byte, error) {
+	master, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, keyID)
+	}
+	aead, err := newGCM(master)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, ErrAuthentication
+	}
+	nonce, sealed := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, sealed, []byte(keyID))
+	if err != nil {
+		return nil, ErrAuthentication
+	}
+	return dataKey, nil
+}
+
+// Rewrap unwraps a data key with its current master key and wraps it with the active one
+func (k *Keyring) Rewrap(keyID string, wrapped []byte) (string, []byte, error) {
+	dataKey, err := k.Unwrap(keyID, wrapped)
+	if err != nil {
+		return "", nil, err
+	}
+	rewrapped, err := k.Wrap(dataKey)
+	if err != nil {
+		return "", nil, err
+	}
+	return k.activeID, rewrapped, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
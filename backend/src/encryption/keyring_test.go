@@ -0,0 +1,92 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func TestWrapRoundTripAndRewrap(t *testing.T) {
+	old, err := NewKeyring(map[string][]byte{"k1": testKey(1)}, "k1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataKey, keyID, wrapped, err := old.NewDataKey()
+	if err != nil || keyID != "k1" || len(dataKey) != KeySize {
+		t.Fatalf("new data key: %v, key ID %q", err, keyID)
+	}
+	got, err := old.Unwrap(keyID, wrapped)
+	if err != nil || !bytes.Equal(got, dataKey) {
+		t.Fatalf("unwrap: %v", err)
+	}
+
+	rotated, err := NewKeyring(map[string][]byte{"k1": testKey(1), "k2": testKey(2)}, "k2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newID, rewrapped, err := rotated.Rewrap(keyID, wrapped)
+	if err != nil || newID != "k2" {
+		t.Fatalf("rewrap: %v, key ID %q", err, newID)
+	}
+	if got, err := rotated.Unwrap(newID, rewrapped); err != nil || !bytes.Equal(got, dataKey) {
+		t.Fatalf("unwrap after rewrap: %v", err)
+	}
+	if _, err := old.Unwrap(newID, rewrapped); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("unwrap with a retired keyring: got %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestUnwrapRejectsWrongMasterKey(t *testing.T) {
+	keys, _ := NewKeyring(map[string][]byte{"k1": testKey(1)}, "k1")
+	_, keyID, wrapped, err := keys.NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, _ := NewKeyring(map[string][]byte{"k1": testKey(9)}, "k1")
+	if _, err := other.Unwrap(keyID, wrapped); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("wrong master key: got %v, want ErrAuthentication", err)
+	}
+	if _, err := keys.Unwrap(keyID, wrapped[:5]); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("truncated wrapped key: got %v, want ErrAuthentication", err)
+	}
+}
+
+func TestWrappedKeyIsBoundToItsKeyID(t *testing.T) {
+	// The same master key under two IDs: a key wrapped under one must not unwrap as the other
+	keys, _ := NewKeyring(map[string][]byte{"k1": testKey(1), "k2": testKey(1)}, "k1")
+	_, keyID, wrapped, err := keys.NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.Unwrap(keyID, wrapped); err != nil {
+		t.Fatalf("unwrap under its own ID: %v", err)
+	}
+	if _, err := keys.Unwrap("k2", wrapped); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("unwrap under another ID: got %v, want ErrAuthentication", err)
+	}
+}
+
+func TestNewKeyringFromEnv(t *testing.T) {
+	t.Setenv("ENCRYPTION_MASTER_KEYS", "")
+	if keys, err := NewKeyringFromEnv(); keys != nil || err != nil {
+		t.Fatalf("no keys: got %v, %v", keys, err)
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(testKey(1))
+	t.Setenv("ENCRYPTION_MASTER_KEYS", "k1:"+encoded)
+	if keys, err := NewKeyringFromEnv(); err != nil || keys.ActiveKeyID() != "k1" {
+		t.Fatalf("single key: got %v, %v", keys, err)
+	}
+
+	t.Setenv("ENCRYPTION_MASTER_KEYS", "k1:"+encoded+", k2:"+encoded)
+	t.Setenv("ENCRYPTION_ACTIVE_KEY", "k3")
+	if _, err := NewKeyringFromEnv(); err == nil {
+		t.Fatal("unknown active key was accepted")
+	}
+	t.Setenv("ENCRYPTION_MASTER_KEYS", "k1:"+base64.StdEncoding.EncodeToString([]byte("short")))
+	if _, err := NewKeyringFromEnv(); err == nil {
+		t.Fatal("short master key was accepted")
+	}
+}
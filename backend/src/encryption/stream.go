@@ -0,0 +1,188 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Encrypted blobs start with a short header followed by a sequence of independently
+// sealed AES-GCM chunks, so they can be encrypted and decrypted while streaming:
+//
+//	header: magic "DDE1" | 7 byte random nonce prefix
+//	chunk:  AES-GCM(plaintext[i*ChunkSize:(i+1)*ChunkSize])
+//
+// Each chunk's nonce is the prefix, a 4 byte big-endian chunk counter and a final-chunk
+// flag, so chunks cannot be reordered, dropped or the stream truncated without detection.
+const (
+	ChunkSize   = 64 * 1024
+	prefixSize  = 7
+	tagSize     = 16
+	sealedChunk = ChunkSize + tagSize
+)
+
+var magic = []byte("DDE1")
+
+const headerSize = 4 + prefixSize
+
+// ErrAuthentication is returned when ciphertext or a wrapped key fails authentication
+var ErrAuthentication = errors.New("encryption: message authentication failed")
+
+// CiphertextSize returns the encrypted length of a plaintext of the given size
+func CiphertextSize(plaintextSize int64) int64 {
+	chunks := plaintextSize / ChunkSize
+	if plaintextSize%ChunkSize != 0 || plaintextSize == 0 {
+		chunks++
+	}
+	return headerSize + plaintextSize + chunks*tagSize
+}
+
+func chunkNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[prefixSize:], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// encryptReader produces the encrypted form of src as it is read
+type encryptReader struct {
+	src     *bufio.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	out     bytes.Buffer
+	plain   []byte
+	done    bool
+}
+
+// NewEncryptReader returns a reader yielding src encrypted with dataKey
+func NewEncryptReader(src io.Reader, dataKey []byte) (io.Reader, error) {
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, prefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+
+	r := &encryptReader{
+		src:    bufio.NewReaderSize(src, ChunkSize+1),
+		aead:   aead,
+		prefix: prefix,
+		plain:  make([]byte, ChunkSize),
+	}
+	r.out.Write(magic)
+	r.out.Write(prefix)
+	return r, nil
+}
+
+func (r *encryptReader) Read(p []byte) (int, error) {
+	for r.out.Len() == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.sealNext(); err != nil {
+			return 0, err
+		}
+	}
+	return r.out.Read(p)
+}
+
+func (r *encryptReader) sealNext() error {
+	n, err := io.ReadFull(r.src, r.plain)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	// The chunk is final when no more plaintext follows it
+	_, peekErr := r.src.Peek(1)
+	if peekErr != nil && peekErr != io.EOF {
+		return peekErr
+	}
+	last := peekErr == io.EOF
+
+	r.out.Write(r.aead.Seal(nil, chunkNonce(r.prefix, r.counter, last), r.plain[:n], nil))
+	r.counter++
+	r.done = last
+	return nil
+}
+
+// decryptReader yields the plaintext of an encrypted stream, authenticating each chunk
+type decryptReader struct {
+	src     *bufio.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	sealed  []byte
+	out     []byte
+	done    bool
+}
+
+// NewDecryptReader returns a reader yielding the plaintext of src, which must have been
+// produced by NewEncryptReader with the same dataKey
+func NewDecryptReader(src io.Reader, dataKey []byte) (io.Reader, error) {
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(src, header); err != nil {
+		return nil, ErrAuthentication
+	}
+	if !bytes.Equal(header[:len(magic)], magic) {
+		return nil, ErrAuthentication
+	}
+
+	return &decryptReader{
+		src:    bufio.NewReaderSize(src, sealedChunk+1),
+		aead:   aead,
+		prefix: header[len(magic):],
+		sealed: make([]byte, sealedChunk),
+	}, nil
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.openNext(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+func (r *decryptReader) openNext() error {
+	n, err := io.ReadFull(r.src, r.sealed)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	if n < tagSize {
+		return ErrAuthentication
+	}
+	_, peekErr := r.src.Peek(1)
+	if peekErr != nil && peekErr != io.EOF {
+		return peekErr
+	}
+	last := peekErr == io.EOF
+
+	plain, err := r.aead.Open(r.sealed[:0], chunkNonce(r.prefix, r.counter, last), r.sealed[:n], nil)
+	if err != nil {
+		return ErrAuthentication
+	}
+	r.out = plain
+	r.counter++
+	r.done = last
+	return nil
+}
@@ -0,0 +1,122 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, KeySize)
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func encrypt(t *testing.T, plaintext, key []byte) []byte {
+	t.Helper()
+	r, err := NewEncryptReader(bytes.NewReader(plaintext), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealed
+}
+
+func decrypt(ciphertext, key []byte) ([]byte, error) {
+	r, err := NewDecryptReader(bytes.NewReader(ciphertext), key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	key := testKey(1)
+	for _, size := range []int{0, 1, ChunkSize, ChunkSize + 1} {
+		plaintext := randomBytes(t, size)
+		sealed := encrypt(t, plaintext, key)
+		if int64(len(sealed)) != CiphertextSize(int64(size)) {
+			t.Fatalf("size %d: ciphertext is %d bytes, CiphertextSize says %d", size, len(sealed), CiphertextSize(int64(size)))
+		}
+		got, err := decrypt(sealed, key)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Fatalf("size %d: decrypted content differs", size)
+		}
+	}
+}
+
+// sealChunks encrypts chunks as given, marking only the chunk at lastIndex as final
+func sealChunks(t *testing.T, key []byte, chunks [][]byte, lastIndex int) []byte {
+	t.Helper()
+	aead, err := newGCM(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := randomBytes(t, prefixSize)
+	out := append(append([]byte{}, magic...), prefix...)
+	for i, chunk := range chunks {
+		out = append(out, aead.Seal(nil, chunkNonce(prefix, uint32(i), i == lastIndex), chunk, nil)...)
+	}
+	return out
+}
+
+func TestStreamRejectsTampering(t *testing.T) {
+	key := testKey(1)
+	plaintext := randomBytes(t, 2*ChunkSize+100)
+	sealed := encrypt(t, plaintext, key)
+	first, second := sealed[headerSize:headerSize+sealedChunk], sealed[headerSize+sealedChunk:headerSize+2*sealedChunk]
+
+	var reordered []byte
+	reordered = append(reordered, sealed[:headerSize]...)
+	reordered = append(reordered, second...)
+	reordered = append(reordered, first...)
+	reordered = append(reordered, sealed[headerSize+2*sealedChunk:]...)
+
+	flipped := append([]byte{}, sealed...)
+	flipped[headerSize+10] ^= 1
+
+	chunks := [][]byte{plaintext[:ChunkSize], plaintext[ChunkSize : 2*ChunkSize], plaintext[2*ChunkSize:]}
+
+	tests := map[string][]byte{
+		"truncated at a chunk boundary": sealed[:headerSize+2*sealedChunk],
+		"truncated inside a chunk":      sealed[:len(sealed)-5],
+		"header only":                   sealed[:headerSize],
+		"reordered chunks":              reordered,
+		"appended bytes":                append(append([]byte{}, sealed...), sealed[headerSize:headerSize+tagSize+1]...),
+		"flipped bit":                   flipped,
+		"final chunk not marked final":  sealChunks(t, key, chunks, -1),
+		"earlier chunk marked final":    sealChunks(t, key, chunks[:2], 0),
+		"wrong magic":                   append([]byte("DDE0"), sealed[4:]...),
+	}
+	for name, ciphertext := range tests {
+		if _, err := decrypt(ciphertext, key); !errors.Is(err, ErrAuthentication) {
+			t.Errorf("%s: got %v, want ErrAuthentication", name, err)
+		}
+	}
+
+	if _, err := decrypt(sealChunks(t, key, chunks, 2), key); err != nil {
+		t.Fatalf("chunks sealed the same way as the stream: %v", err)
+	}
+}
+
+func TestStreamRejectsWrongDataKey(t *testing.T) {
+	sealed := encrypt(t, []byte("%PDF-1.7"), testKey(1))
+	if _, err := decrypt(sealed, testKey(2)); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("got %v, want ErrAuthentication", err)
+	}
+}
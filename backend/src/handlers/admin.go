@@ -0,0 +1,536 @@
+package handlers
+
+import (
+	"DocuDefense/backend/src/audit"
+	"DocuDefense/backend/src/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// adminUser is the view of an account returned to administrators; it never includes
+// password hashes or second-factor secrets
+type adminUser struct {
+	ID            primitive.ObjectID `json:"id"`
+	FirstName     string             `json:"first_name"`
+	Surname       string             `json:"surname"`
+	Email         string             `json:"email"`
+	EmailVerified bool               `json:"email_verified"`
+	Roles         []string           `json:"roles"`
+	Disabled      bool               `json:"disabled"`
+	MFAEnabled    bool               `json:"mfa_enabled"`
+	SSO           bool               `json:"sso"`
+}
+
+func newAdminUser(u *models.User) adminUser {
+	return adminUser{
+		ID:            u.ID,
+		FirstName:     u.FirstName,
+		Surname:       u.Surname,
+		Email:         u.Email,
+		EmailVerified: u.EmailVerified,
+		Roles:         u.EffectiveRoles(),
+		Disabled:      u.Disabled,
+		MFAEnabled:    u.MFAEnabled,
+		SSO:           u.ExternalSubject != "",
+	}
+}
+
+func adminUsers(users []models.User) []adminUser {
+	views := make([]adminUser, 0, len(users))
+	for i := range users {
+		views = append(views, newAdminUser(&users[i]))
+	}
+	return views
+}
+
+// paginate reads the page and limit query parameters (default 1 and 50, limit at most 500)
+func paginate(r *http.Request) *options.FindOptions {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+	return options.Find().SetSkip(int64((page - 1) * limit)).SetLimit(int64(limit))
+}
+
+// resolveAdminTarget looks up the account named by the {id} route variable
+func resolveAdminTarget(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	userID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+		return nil, false
+	}
+
+	var user models.User
+	err = usersCollection.FindOne(r.Context(), bson.M{"_id": userID}).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Error looking up user %s: %v", userID.Hex(), err)
+		http.Error(w, "Error retrieving user", http.StatusInternalServerError)
+		return nil, false
+	}
+	return &user, true
+}
+
+// isCaller reports whether user is the account making the request. Administrators cannot
+// change their own roles, disable or delete themselves, so they cannot lock everyone out.
+func isCaller(r *http.Request, user *models.User) bool {
+	claims, ok := claimsFromContext(r)
+	return ok && claims.Subject == user.ID.Hex()
+}
+
+// AdminListUsers returns every account, paginated with page and limit. The optional term
+// parameter filters by email, first name or surname.
+func AdminListUsers(w http.ResponseWriter, r *http.Request) {
+	filter := bson.M{}
+	if term := strings.TrimSpace(r.URL.Query().Get("term")); term != "" {
+		pattern := bson.M{"$regex": regexp.QuoteMeta(term), "$options": "i"}
+		filter["$or"] = []bson.M{{"email": pattern}, {"first_name": pattern}, {"surname": pattern}}
+	}
+
+	cursor, err := usersCollection.Find(r.Context(), filter, paginate(r))
+	if err != nil {
+		log.Printf("Error retrieving users: %v", err)
+		http.Error(w, "Error retrieving users", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(r.Context())
+
+	var users []models.User
+	if err := cursor.All(r.Context(), &users); err != nil {
+		log.Printf("Error decoding users: %v", err)
+		http.Error(w, "Error decoding users", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(adminUsers(users))
+}
+
+// AdminSetRoles replaces the account's roles with {"roles": [...]}. Every token issued to
+// the account is invalidated so the new roles apply from its next login.
+func AdminSetRoles(w http.ResponseWriter, r *http.Request) {
+	user, ok := resolveAdminTarget(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Roles []string `json:"roles"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid roles", http.StatusBadRequest)
+		return
+	}
+	roles := []string{}
+	for _, role := range body.Roles {
+		if !models.ValidRole(role) {
+			http.Error(w, fmt.Sprintf("Unknown role %q", role), http.StatusBadRequest)
+			return
+		}
+		if role != models.RoleUser {
+			roles = append(roles, role)
+		}
+	}
+	if isCaller(r, user) {
+		http.Error(w, "You cannot change your own roles", http.StatusForbidden)
+		return
+	}
+
+	previous := user.EffectiveRoles()
+	// The access tokens are invalidated in the same update, so none outlives the old roles
+	update := bson.M{"$set": bson.M{"roles": roles}, "$inc": bson.M{"token_version": 1}}
+	if _, err := usersCollection.UpdateOne(r.Context(), bson.M{"_id": user.ID}, update); err != nil {
+		log.Printf("Error updating roles for %s: %v", user.Email, err)
+		http.Error(w, "Error updating roles", http.StatusInternalServerError)
+		return
+	}
+	if err := revokeRefreshTokens(r.Context(), user.ID); err != nil {
+		log.Printf("Error revoking tokens for %s after a role change: %v", user.Email, err)
+		http.Error(w, "Error revoking the account's sessions", http.StatusInternalServerError)
+		return
+	}
+	user.Roles = roles
+
+	recordAudit(r, audit.Event{
+		Action:       audit.ActionRoleChange,
+		TargetUserID: user.ID,
+		Target:       user.Email,
+		Details: map[string]string{
+			"previous": strings.Join(previous, ","),
+			"roles":    strings.Join(user.EffectiveRoles(), ","),
+		},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newAdminUser(user))
+}
+
+// AdminDisableUser blocks the account from logging in and invalidates its sessions
+func AdminDisableUser(w http.ResponseWriter, r *http.Request) {
+	setUserDisabled(w, r, true)
+}
+
+// AdminEnableUser lets a disabled account log in again
+func AdminEnableUser(w http.ResponseWriter, r *http.Request) {
+	setUserDisabled(w, r, false)
+}
+
+func setUserDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	user, ok := resolveAdminTarget(w, r)
+	if !ok {
+		return
+	}
+	if disabled && isCaller(r, user) {
+		http.Error(w, "You cannot disable your own account", http.StatusForbidden)
+		return
+	}
+
+	update := bson.M{"$unset": bson.M{"disabled": ""}}
+	action := audit.ActionAccountEnable
+	if disabled {
+		update = bson.M{"$set": bson.M{"disabled": true}, "$inc": bson.M{"token_version": 1}}
+		action = audit.ActionAccountDisable
+	}
+	if _, err := usersCollection.UpdateOne(r.Context(), bson.M{"_id": user.ID}, update); err != nil {
+		log.Printf("Error updating account %s: %v", user.Email, err)
+		http.Error(w, "Error updating account", http.StatusInternalServerError)
+		return
+	}
+	if disabled {
+		if err := revokeRefreshTokens(r.Context(), user.ID); err != nil {
+			log.Printf("Error revoking tokens for disabled account %s: %v", user.Email, err)
+			http.Error(w, "Error revoking the account's sessions", http.StatusInternalServerError)
+			return
+		}
+	}
+	user.Disabled = disabled
+
+	recordAudit(r, audit.Event{
+		Action:       action,
+		TargetUserID: user.ID,
+		Target:       user.Email,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newAdminUser(user))
+}
+
+// AdminDeleteUser deletes an account. Accounts that still own documents are refused so
+// that nothing is orphaned; reassign the documents first.
+func AdminDeleteUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := resolveAdminTarget(w, r)
+	if !ok {
+		return
+	}
+	if isCaller(r, user) {
+		http.Error(w, "You cannot delete your own account here", http.StatusForbidden)
+		return
+	}
+
+	err := documentsCollection.FindOne(r.Context(), bson.M{"user_id": user.ID}).Err()
+	if err == nil {
+		http.Error(w, "User still owns documents; reassign them first", http.StatusConflict)
+		return
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		log.Printf("Error checking documents of %s: %v", user.Email, err)
+		http.Error(w, "Error deleting user", http.StatusInternalServerError)
+		return
+	}
+
+	result, err := usersCollection.DeleteOne(r.Context(), bson.M{"_id": user.ID})
+	if err != nil || result.DeletedCount == 0 {
+		log.Printf("Error deleting user %s: %v", user.ID.Hex(), err)
+		http.Error(w, "Error deleting user", http.StatusInternalServerError)
+		return
+	}
+	if _, err := refreshTokensCollection.UpdateMany(r.Context(), bson.M{
+		"user_id":    user.ID,
+		"revoked_at": bson.M{"$exists": false},
+	}, bson.M{"$set": bson.M{"revoked_at": time.Now()}}); err != nil {
+		log.Printf("Error revoking refresh tokens of deleted user %s: %v", user.Email, err)
+	}
+
+	removeMemberships(r.Context(), user.ID)
+
+	recordAudit(r, audit.Event{
+		Action:       audit.ActionAccountDelete,
+		TargetUserID: user.ID,
+		Target:       user.Email,
+		Details:      map[string]string{"by_admin": "true"},
+	})
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "User deleted"})
+}
+
+// AdminReassignDocuments moves every version of the account's documents, or of one file
+// when "filename" is given, to the account in "to_user_id". Files stay in their workspace.
+// A file the new owner already has under the same name in that workspace, or an
+// organization file for someone outside the organization, is refused with 409.
+func AdminReassignDocuments(w http.ResponseWriter, r *http.Request) {
+	from, ok := resolveAdminTarget(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		ToUserID string `json:"to_user_id"`
+		Filename string `json:"filename"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid reassignment request", http.StatusBadRequest)
+		return
+	}
+	toID, err := primitive.ObjectIDFromHex(body.ToUserID)
+	if err != nil {
+		http.Error(w, "Invalid to_user_id", http.StatusBadRequest)
+		return
+	}
+	if toID == from.ID {
+		http.Error(w, "Documents already belong to this user", http.StatusBadRequest)
+		return
+	}
+	var to models.User
+	if err := usersCollection.FindOne(r.Context(), bson.M{"_id": toID}).Decode(&to); err != nil {
+		http.Error(w, "Target user not found", http.StatusNotFound)
+		return
+	}
+
+	filter := bson.M{"user_id": from.ID}
+	if body.Filename != "" {
+		filter["filename"] = body.Filename
+	}
+	files, err := documentFiles(r.Context(), filter)
+	if err != nil {
+		log.Printf("Error listing documents of %s: %v", from.Email, err)
+		http.Error(w, "Error reassigning documents", http.StatusInternalServerError)
+		return
+	}
+	if len(files) == 0 {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	// Organization documents can only go to another member of the same organization
+	for _, file := range files {
+		if !file.OrgID.IsZero() {
+			member, err := findMembership(r.Context(), file.OrgID, toID)
+			if err != nil {
+				log.Printf("Error looking up membership of %s: %v", to.Email, err)
+				http.Error(w, "Error reassigning documents", http.StatusInternalServerError)
+				return
+			}
+			if member == nil {
+				http.Error(w, fmt.Sprintf("%s is not a member of the organization that owns %q", to.Email, file.Filename), http.StatusConflict)
+				return
+			}
+		}
+		err := documentsCollection.FindOne(r.Context(), inTenant(bson.M{"user_id": toID, "filename": file.Filename}, file.OrgID)).Err()
+		if err == nil {
+			http.Error(w, fmt.Sprintf("%s already has a file named %q", to.Email, file.Filename), http.StatusConflict)
+			return
+		}
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Printf("Error checking documents of %s: %v", to.Email, err)
+			http.Error(w, "Error reassigning documents", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var moved int64
+	filenames := []string{}
+	for _, file := range files {
+		result, err := documentsCollection.UpdateMany(r.Context(), inTenant(bson.M{"user_id": from.ID, "filename": file.Filename}, file.OrgID), bson.M{"$set": bson.M{"user_id": toID}})
+		if err != nil {
+			log.Printf("Error reassigning %s from %s to %s: %v", file.Filename, from.Email, to.Email, err)
+			http.Error(w, "Error reassigning documents", http.StatusInternalServerError)
+			return
+		}
+		moved += result.ModifiedCount
+		filenames = append(filenames, file.Filename)
+
+		// Shares, links and any check-out follow the file so its collaborators keep their access
+		owned := inTenant(bson.M{"owner_id": from.ID, "filename": file.Filename}, file.OrgID)
+		if _, err := sharesCollection.UpdateMany(r.Context(), owned, bson.M{"$set": bson.M{"owner_id": toID}}); err != nil {
+			log.Printf("Error moving shares of %s to %s: %v", file.Filename, to.Email, err)
+		}
+		if _, err := shareLinksCollection.UpdateMany(r.Context(), owned, bson.M{"$set": bson.M{"owner_id": toID}}); err != nil {
+			log.Printf("Error moving share links of %s to %s: %v", file.Filename, to.Email, err)
+		}
+		if _, err := locksCollection.UpdateMany(r.Context(), owned, bson.M{"$set": bson.M{"owner_id": toID}}); err != nil {
+			log.Printf("Error moving lock on %s to %s: %v", file.Filename, to.Email, err)
+		}
+
+		details := map[string]string{"from_user_id": from.ID.Hex(), "from": from.Email, "to": to.Email}
+		if !file.OrgID.IsZero() {
+			details["org_id"] = file.OrgID.Hex()
+		}
+		recordAudit(r, audit.Event{
+			Action:       audit.ActionDocumentReassign,
+			TargetUserID: toID,
+			Target:       file.Filename,
+			Details:      details,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":   "Documents reassigned",
+		"files":     filenames,
+		"versions":  moved,
+		"to_user":   to.Email,
+		"from_user": from.Email,
+	})
+}
+
+// documentFile identifies a file, and with it all of its versions, within a workspace
+type documentFile struct {
+	OrgID    primitive.ObjectID
+	Filename string
+}
+
+// documentFiles returns the distinct files among the documents matching filter
+func documentFiles(ctx context.Context, filter bson.M) ([]documentFile, error) {
+	cursor, err := documentsCollection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var docs []models.Document
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	seen := map[documentFile]bool{}
+	files := []documentFile{}
+	for _, doc := range docs {
+		file := documentFile{OrgID: doc.OrgID, Filename: doc.Filename}
+		if !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// AdminListDocuments returns document versions across all accounts, paginated with page
+// and limit. Optional user (user ID) and filename parameters narrow the list.
+func AdminListDocuments(w http.ResponseWriter, r *http.Request) {
+	filter := bson.M{}
+	if user := r.URL.Query().Get("user"); user != "" {
+		userID, err := primitive.ObjectIDFromHex(user)
+		if err != nil {
+			http.Error(w, "Invalid user parameter", http.StatusBadRequest)
+			return
+		}
+		filter["user_id"] = userID
+	}
+	if filename := r.URL.Query().Get("filename"); filename != "" {
+		filter["filename"] = filename
+	}
+
+	cursor, err := documentsCollection.Find(r.Context(), filter, paginate(r))
+	if err != nil {
+		log.Printf("Error retrieving documents: %v", err)
+		http.Error(w, "Error retrieving documents", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(r.Context())
+
+	documents := []models.Document{}
+	if err := cursor.All(r.Context(), &documents); err != nil {
+		log.Printf("Error decoding documents: %v", err)
+		http.Error(w, "Error decoding documents", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(documents)
+}
+
+// AdminAuditEvents returns audit events across all accounts, newest first. It accepts
+// GetAuditEvents' parameters plus user (user ID) to narrow the events to one account.
+func AdminAuditEvents(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if user := r.URL.Query().Get("user"); user != "" {
+		if filter.UserID, err = primitive.ObjectIDFromHex(user); err != nil {
+			http.Error(w, "Invalid user parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	if !auditLogEnabled(w) {
+		return
+	}
+
+	events, err := auditLog.Query(r.Context(), filter)
+	if err != nil {
+		log.Printf("Error querying audit events: %v", err)
+		http.Error(w, "Error retrieving audit events", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
+
+// GrantRole adds role to the account with the given email. It is used to bootstrap the
+// first administrator from the command line.
+func GrantRole(ctx context.Context, email, role string) error {
+	if !models.ValidRole(role) {
+		return fmt.Errorf("unknown role %q", role)
+	}
+	var user models.User
+	if err := usersCollection.FindOne(ctx, bson.M{"email": email}).Decode(&user); err != nil {
+		return fmt.Errorf("looking up %s: %w", email, err)
+	}
+	if user.HasRole(role) {
+		return nil
+	}
+
+	roles := append(user.Roles, role)
+	update := bson.M{"$set": bson.M{"roles": roles}, "$inc": bson.M{"token_version": 1}}
+	if _, err := usersCollection.UpdateOne(ctx, bson.M{"_id": user.ID}, update); err != nil {
+		return err
+	}
+	if err := revokeRefreshTokens(ctx, user.ID); err != nil {
+		return err
+	}
+	log.Printf("Granted role %s to %s", role, email)
+	if auditLog != nil {
+		if err := auditLog.Record(ctx, audit.Event{
+			Action:       audit.ActionRoleChange,
+			Actor:        "system",
+			TargetUserID: user.ID,
+			Target:       user.Email,
+			Details:      map[string]string{"granted": role},
+		}); err != nil {
+			log.Printf("Error recording audit event: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"DocuDefense/backend/src/models"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (f *authFixture) send(t *testing.T, method, path, accessToken, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	rec := httptest.NewRecorder()
+	f.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// makeBob grants bob the given role and returns an access token carrying it
+func (f *authFixture) makeBob(t *testing.T, role string) string {
+	t.Helper()
+	if err := GrantRole(context.Background(), f.bob.Email, role); err != nil {
+		t.Fatal(err)
+	}
+	var bob models.User
+	if err := f.users.FindOne(context.Background(), bson.M{"_id": f.bob.ID}).Decode(&bob); err != nil {
+		t.Fatal(err)
+	}
+	session, err := issueSession(context.Background(), &bob, nil, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session["token"].(string)
+}
+
+func TestAdminRoutesRequirePermission(t *testing.T) {
+	f := newAuthFixture(t)
+	access, _ := f.login(t)
+	auditor := f.makeBob(t, models.RoleAuditor)
+
+	if rec := f.send(t, "GET", "/admin/users", access, ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("list users as a regular user: got %d, want 403", rec.Code)
+	}
+	if rec := f.send(t, "GET", "/admin/users", auditor, ""); rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "password") {
+		t.Fatalf("list users as an auditor: got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := f.send(t, "POST", "/admin/users/"+f.alice.ID.Hex()+"/disable", auditor, ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("disable user as an auditor: got %d, want 403", rec.Code)
+	}
+}
+
+func TestUserListingNeedsUsersRead(t *testing.T) {
+	f := newAuthFixture(t)
+	access, _ := f.login(t)
+	auditor := f.makeBob(t, models.RoleAuditor)
+
+	if rec := f.do(t, "GET", "/users", nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("list users anonymously: got %d, want 401", rec.Code)
+	}
+	if rec := f.send(t, "GET", "/users", access, ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("list users as a regular user: got %d, want 403", rec.Code)
+	}
+	rec := f.send(t, "GET", "/users", auditor, "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), f.alice.Email) || strings.Contains(rec.Body.String(), "password") {
+		t.Fatalf("list users as an auditor: got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestDisabledAccountsAreSignedOut(t *testing.T) {
+	f := newAuthFixture(t)
+	access, refresh := f.login(t)
+	admin := f.makeBob(t, models.RoleAdmin)
+
+	if rec := f.send(t, "POST", "/admin/users/"+f.alice.ID.Hex()+"/disable", admin, ""); rec.Code != http.StatusOK {
+		t.Fatalf("disable user: got %d %q", rec.Code, rec.Body.String())
+	}
+	if code := f.listFiles(t, access); code != http.StatusUnauthorized {
+		t.Fatalf("access token of a disabled account: got %d, want 401", code)
+	}
+	if rec := f.post(t, "/token/refresh", "", refreshBody(refresh)); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("refresh token of a disabled account: got %d, want 401", rec.Code)
+	}
+
+	f.setAlicePassword(t, "correct horse")
+	if rec := f.post(t, "/login", "", loginBody("alice@example.com", "correct horse")); rec.Code != http.StatusForbidden {
+		t.Fatalf("login to a disabled account: got %d, want 403", rec.Code)
+	}
+	if rec := f.send(t, "POST", "/admin/users/"+f.bob.ID.Hex()+"/disable", admin, ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("admin disabling themselves: got %d, want 403", rec.Code)
+	}
+}
+
+func TestRoleChangeInvalidatesTokens(t *testing.T) {
+	f := newAuthFixture(t)
+	admin := f.makeBob(t, models.RoleAdmin)
+	access, _ := f.login(t)
+
+	rec := f.send(t, "PUT", "/admin/users/"+f.alice.ID.Hex()+"/roles", admin, `{"roles":["auditor"]}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"auditor"`) {
+		t.Fatalf("set roles: got %d %q", rec.Code, rec.Body.String())
+	}
+	if code := f.listFiles(t, access); code != http.StatusUnauthorized {
+		t.Fatalf("token issued before the role change: got %d, want 401", code)
+	}
+	if rec := f.send(t, "PUT", "/admin/users/"+f.alice.ID.Hex()+"/roles", admin, `{"roles":["root"]}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown role: got %d, want 400", rec.Code)
+	}
+}
+
+// unavailableForUpdateMany fails every UpdateMany, like a database that stops answering
+// part-way through a request
+type unavailableForUpdateMany struct {
+	*fakeCollection
+}
+
+func (c unavailableForUpdateMany) UpdateMany(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return nil, errors.New("connection reset")
+}
+
+func TestRoleChangeFailsWhenSessionsCannotBeRevoked(t *testing.T) {
+	f := newAuthFixture(t)
+	admin := f.makeBob(t, models.RoleAdmin)
+	access, _ := f.login(t)
+	refreshTokensCollection = unavailableForUpdateMany{f.refreshTokens}
+
+	for _, req := range []struct{ method, path, body string }{
+		{"PUT", "/admin/users/" + f.alice.ID.Hex() + "/roles", `{"roles":["auditor"]}`},
+		{"POST", "/admin/users/" + f.alice.ID.Hex() + "/disable", ""},
+	} {
+		if rec := f.send(t, req.method, req.path, admin, req.body); rec.Code != http.StatusInternalServerError {
+			t.Fatalf("%s %s: got %d, want 500", req.method, req.path, rec.Code)
+		}
+	}
+	// Access tokens were invalidated with the change itself
+	if code := f.listFiles(t, access); code != http.StatusUnauthorized {
+		t.Fatalf("token issued before the role change: got %d, want 401", code)
+	}
+}
+
+func TestAuditQueriesWithoutAnAuditLog(t *testing.T) {
+	f := newAuthFixture(t)
+	access, _ := f.login(t)
+	auditor := f.makeBob(t, models.RoleAuditor)
+
+	if rec := f.send(t, "GET", "/users/"+f.alice.ID.Hex()+"/audit", access, ""); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("account audit events: got %d, want 503", rec.Code)
+	}
+	if rec := f.send(t, "GET", "/admin/audit", auditor, ""); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("admin audit events: got %d, want 503", rec.Code)
+	}
+}
+
+func TestReassignDocumentsBeforeDelete(t *testing.T) {
+	f := newAuthFixture(t)
+	admin := f.makeBob(t, models.RoleAdmin)
+	alice := "/admin/users/" + f.alice.ID.Hex()
+
+	if rec := f.send(t, "DELETE", alice, admin, ""); rec.Code != http.StatusConflict {
+		t.Fatalf("delete a user who owns documents: got %d, want 409", rec.Code)
+	}
+	rec := f.send(t, "POST", alice+"/documents/reassign", admin, `{"to_user_id":"`+f.bob.ID.Hex()+`"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("reassign documents: got %d %q", rec.Code, rec.Body.String())
+	}
+	if owner := f.docs.docs[0]["user_id"]; owner != f.bob.ID {
+		t.Fatalf("contract.pdf owned by %v after reassignment, want bob", owner)
+	}
+	if rec := f.send(t, "DELETE", alice, admin, ""); rec.Code != http.StatusOK {
+		t.Fatalf("delete user: got %d %q", rec.Code, rec.Body.String())
+	}
+	if len(f.users.matching(bson.M{"_id": f.alice.ID})) != 0 {
+		t.Fatal("alice still exists after deletion")
+	}
+}
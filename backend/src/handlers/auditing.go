@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"DocuDefense/backend/src/audit"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Audit log shared by all handlers; nil disables auditing (e.g. in tests)
+var auditLog *audit.Logger
+
+// SetAuditLogger sets the logger that records security-relevant actions
+func SetAuditLogger(logger *audit.Logger) {
+	auditLog = logger
+}
+
+// clientIP returns the caller's address. X-Forwarded-For is only trusted when the
+// backend runs behind a proxy and TRUST_PROXY_HEADERS=true.
+func clientIP(r *http.Request) string {
+	if os.Getenv("TRUST_PROXY_HEADERS") == "true" {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// auditLogEnabled answers 503 Service Unavailable when no audit log is configured
+func auditLogEnabled(w http.ResponseWriter) bool {
+	if auditLog == nil {
+		http.Error(w, "The audit log is not enabled", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+// recordAudit appends an event to the audit log, filling in the actor and IP from the
+// request. Failures are logged rather than failing the request.
+func recordAudit(r *http.Request, event audit.Event) {
+	if auditLog == nil {
+		return
+	}
+	if claims, ok := claimsFromContext(r); ok {
+		if event.Actor == "" {
+			event.Actor = claims.Email
+		}
+		if event.ActorID.IsZero() {
+			event.ActorID, _ = primitive.ObjectIDFromHex(claims.Subject)
+		}
+	}
+	event.IP = clientIP(r)
+
+	if err := auditLog.Record(context.Background(), event); err != nil {
+		log.Printf("Error recording audit event %s by %s: %v", event.Action, event.Actor, err)
+	}
+}
+
+// GetAuditEvents returns audit events performed by or targeting the account, newest first.
+// Optional query parameters: document (Document ID), filename, from and to (RFC 3339) and limit.
+func GetAuditEvents(w http.ResponseWriter, r *http.Request) {
+	targetUser, ok := targetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.UserID = targetUser.ID
+	if !auditLogEnabled(w) {
+		return
+	}
+
+	events, err := auditLog.Query(r.Context(), filter)
+	if err != nil {
+		log.Printf("Error querying audit events for %s: %v", targetUser.ID.Hex(), err)
+		http.Error(w, "Error retrieving audit events", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
+
+// parseAuditFilter reads the document, filename, from, to and limit query parameters
+func parseAuditFilter(r *http.Request) (audit.Filter, error) {
+	query := r.URL.Query()
+	filter := audit.Filter{Target: query.Get("filename"), Limit: 100}
+
+	if document := query.Get("document"); document != "" {
+		id, err := primitive.ObjectIDFromHex(document)
+		if err != nil {
+			return filter, fmt.Errorf("Invalid document parameter")
+		}
+		filter.DocumentID = id
+	}
+	for name, dst := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, fmt.Errorf("Invalid %s parameter", name)
+			}
+			*dst = parsed
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || limit < 1 || limit > 1000 {
+			return filter, fmt.Errorf("Invalid limit parameter")
+		}
+		filter.Limit = limit
+	}
+	return filter, nil
+}
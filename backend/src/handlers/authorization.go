@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"DocuDefense/backend/src/models"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// claimsFromContext returns the JWT claims attached by JWTAuthMiddleware
+func claimsFromContext(r *http.Request) (*Claims, bool) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	return claims, ok && claims != nil
+}
+
+// callerID returns the ID of the authenticated user from the JWT claims
+func callerID(r *http.Request) (primitive.ObjectID, bool) {
+	claims, ok := claimsFromContext(r)
+	if !ok {
+		return primitive.NilObjectID, false
+	}
+	id, err := primitive.ObjectIDFromHex(claims.Subject)
+	return id, err == nil
+}
+
+// targetUserFromContext returns the account resolved by RequireAccountOwner
+func targetUserFromContext(r *http.Request) (*models.User, bool) {
+	user, ok := r.Context().Value("targetUser").(*models.User)
+	return user, ok && user != nil
+}
+
+// RequireAccountOwner ensures the authenticated user owns the account named by the {id}
+// route variable. It must run after JWTAuthMiddleware and is applied to every
+// user-scoped route; the resolved account is attached to the request context.
+func RequireAccountOwner(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := claimsFromContext(r)
+		if !ok {
+			log.Println("Unauthorized access: Unable to retrieve user claims")
+			http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+			return
+		}
+
+		userID := mux.Vars(r)["id"]
+		userIDObj, err := primitive.ObjectIDFromHex(userID)
+		if err != nil {
+			log.Printf("Invalid user ID format: %v", err)
+			http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+			return
+		}
+
+		var targetUser models.User
+		err = usersCollection.FindOne(r.Context(), bson.M{"_id": userIDObj}).Decode(&targetUser)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Printf("Error looking up user %s: %v", userID, err)
+			http.Error(w, "Error retrieving user", http.StatusInternalServerError)
+			return
+		}
+
+		// Unknown accounts get the same answer as other people's, so IDs cannot be probed
+		if err != nil || targetUser.ID.Hex() != claims.Subject {
+			log.Printf("Unauthorized %s %s by %s on account %s", r.Method, r.URL.Path, claims.Subject, userID)
+			http.Error(w, "You are not authorized to access this account", http.StatusForbidden)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), "targetUser", &targetUser)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequirePermission allows the request only if the caller's access token carries the given
+// permission (see models.Permissions). It must run after JWTAuthMiddleware; the claims can
+// be trusted because a role change invalidates every token issued before it.
+func RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := claimsFromContext(r)
+			if !ok {
+				log.Println("Unauthorized access: Unable to retrieve user claims")
+				http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+				return
+			}
+			if !claims.HasPermission(permission) {
+				log.Printf("Forbidden %s %s by %s: missing permission %s", r.Method, r.URL.Path, claims.Email, permission)
+				http.Error(w, "You do not have permission to perform this action", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
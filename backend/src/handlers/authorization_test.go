@@ -0,0 +1,509 @@
+package handlers
+
+import (
+	"DocuDefense/backend/src/models"
+	"DocuDefense/backend/src/signing"
+	"DocuDefense/backend/src/storage"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// fakeCollection is an in-memory DatabaseCollection supporting equality, array membership,
+// $exists, $ne, $lt and $gt filters, $set/$unset/$inc/$pull updates, upserts and a unique index
+type fakeCollection struct {
+	docs   []bson.M
+	unique []string // fields no two documents may share all the values of
+}
+
+func newFakeCollection(t *testing.T, docs ...interface{}) *fakeCollection {
+	c := &fakeCollection{}
+	for _, doc := range docs {
+		if _, err := c.InsertOne(context.Background(), doc); err != nil {
+			t.Fatalf("seeding fake collection: %v", err)
+		}
+	}
+	return c
+}
+
+func toM(v interface{}) bson.M {
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	var m bson.M
+	if err := bson.Unmarshal(raw, &m); err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func (c *fakeCollection) matching(filter interface{}) []bson.M {
+	f := toM(filter)
+	var found []bson.M
+	for _, doc := range c.docs {
+		ok := true
+		for key, want := range f {
+			if !fieldMatches(doc, key, want) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			found = append(found, doc)
+		}
+	}
+	return found
+}
+
+func fieldMatches(doc bson.M, key string, want interface{}) bool {
+	if op, isOp := want.(bson.M); isOp {
+		if exists, ok := op["$exists"].(bool); ok {
+			_, present := doc[key]
+			return present == exists
+		}
+		if other, ok := op["$ne"]; ok {
+			return !reflect.DeepEqual(doc[key], other)
+		}
+		switch limit := op["$lt"].(type) {
+		case int32:
+			current, _ := doc[key].(int32)
+			return current < limit
+		case int64:
+			current, present := doc[key].(int64)
+			return present && current < limit
+		case primitive.DateTime:
+			current, present := doc[key].(primitive.DateTime)
+			return present && current < limit
+		}
+		switch limit := op["$gt"].(type) {
+		case int32:
+			current, _ := doc[key].(int32)
+			return current > limit
+		case primitive.DateTime:
+			current, present := doc[key].(primitive.DateTime)
+			return present && current > limit
+		}
+	}
+	if values, isArray := doc[key].(primitive.A); isArray {
+		for _, value := range values {
+			if reflect.DeepEqual(value, want) {
+				return true
+			}
+		}
+	}
+	return reflect.DeepEqual(doc[key], want)
+}
+
+func applyUpdate(doc bson.M, update interface{}) {
+	u := toM(update)
+	if set, ok := u["$set"].(bson.M); ok {
+		for key, value := range set {
+			doc[key] = value
+		}
+	}
+	if inc, ok := u["$inc"].(bson.M); ok {
+		for key, value := range inc {
+			current, _ := doc[key].(int32)
+			doc[key] = current + value.(int32)
+		}
+	}
+	if unset, ok := u["$unset"].(bson.M); ok {
+		for key := range unset {
+			delete(doc, key)
+		}
+	}
+	if pull, ok := u["$pull"].(bson.M); ok {
+		for key, value := range pull {
+			values, _ := doc[key].(primitive.A)
+			kept := primitive.A{}
+			for _, v := range values {
+				if !reflect.DeepEqual(v, value) {
+					kept = append(kept, v)
+				}
+			}
+			doc[key] = kept
+		}
+	}
+}
+
+func (c *fakeCollection) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	var docs []interface{}
+	for _, doc := range c.matching(filter) {
+		docs = append(docs, doc)
+	}
+	return mongo.NewCursorFromDocuments(docs, nil, nil)
+}
+
+func (c *fakeCollection) InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	doc := toM(document)
+	if len(c.unique) > 0 {
+		key := bson.M{}
+		for _, field := range c.unique {
+			key[field] = doc[field]
+		}
+		for _, existing := range c.docs {
+			duplicate := true
+			for field, value := range key {
+				duplicate = duplicate && reflect.DeepEqual(existing[field], value)
+			}
+			if duplicate {
+				return nil, mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000, Message: "E11000 duplicate key error"}}}
+			}
+		}
+	}
+	c.docs = append(c.docs, doc)
+	return &mongo.InsertOneResult{InsertedID: doc["_id"]}, nil
+}
+
+func (c *fakeCollection) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	found := c.matching(filter)
+	if len(found) == 0 {
+		return mongo.NewSingleResultFromDocument(bson.D{}, mongo.ErrNoDocuments, nil)
+	}
+	return mongo.NewSingleResultFromDocument(found[len(found)-1], nil, nil)
+}
+
+func (c *fakeCollection) UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	found := c.matching(filter)
+	if len(found) == 0 {
+		if len(opts) > 0 && opts[0].Upsert != nil && *opts[0].Upsert {
+			doc := bson.M{}
+			for key, value := range toM(filter) {
+				if _, isOp := value.(bson.M); !isOp {
+					doc[key] = value
+				}
+			}
+			applyUpdate(doc, update)
+			c.docs = append(c.docs, doc)
+			return &mongo.UpdateResult{UpsertedCount: 1, UpsertedID: doc["_id"]}, nil
+		}
+		return &mongo.UpdateResult{}, nil
+	}
+	applyUpdate(found[0], update)
+	return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+}
+
+func (c *fakeCollection) FindOneAndUpdate(ctx context.Context, filter interface{}, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult {
+	var upsert, after bool
+	for _, opt := range opts {
+		upsert = upsert || (opt.Upsert != nil && *opt.Upsert)
+		after = after || (opt.ReturnDocument != nil && *opt.ReturnDocument == options.After)
+	}
+	found := c.matching(filter)
+	if len(found) == 0 {
+		if upsert {
+			c.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+			if after {
+				return c.FindOne(ctx, filter)
+			}
+		}
+		return mongo.NewSingleResultFromDocument(bson.D{}, mongo.ErrNoDocuments, nil)
+	}
+	before := bson.M{}
+	for key, value := range found[0] {
+		before[key] = value
+	}
+	applyUpdate(found[0], update)
+	if after {
+		return mongo.NewSingleResultFromDocument(found[0], nil, nil)
+	}
+	return mongo.NewSingleResultFromDocument(before, nil, nil)
+}
+
+func (c *fakeCollection) UpdateMany(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	found := c.matching(filter)
+	for _, doc := range found {
+		applyUpdate(doc, update)
+	}
+	n := int64(len(found))
+	return &mongo.UpdateResult{MatchedCount: n, ModifiedCount: n}, nil
+}
+
+func (c *fakeCollection) DeleteOne(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	found := c.matching(filter)
+	if len(found) == 0 {
+		return &mongo.DeleteResult{}, nil
+	}
+	for i, doc := range c.docs {
+		if reflect.DeepEqual(doc, found[0]) {
+			c.docs = append(c.docs[:i], c.docs[i+1:]...)
+			break
+		}
+	}
+	return &mongo.DeleteResult{DeletedCount: 1}, nil
+}
+
+type authFixture struct {
+	router        *mux.Router
+	users         *fakeCollection
+	docs          *fakeCollection
+	refreshTokens *fakeCollection
+	revokedTokens *fakeCollection
+	accountTokens *fakeCollection
+	loginAttempts *fakeCollection
+	orgs          *fakeCollection
+	memberships   *fakeCollection
+	invitations   *fakeCollection
+	shares        *fakeCollection
+	shareLinks    *fakeCollection
+	quarantine    *fakeCollection
+	uploads       *fakeCollection
+	locks         *fakeCollection
+	alice         models.User
+	bob           models.User
+}
+
+func newAuthFixture(t *testing.T) *authFixture {
+	t.Helper()
+
+	f := &authFixture{
+		alice: models.User{ID: primitive.NewObjectID(), FirstName: "Alice", Email: "alice@example.com"},
+		bob:   models.User{ID: primitive.NewObjectID(), FirstName: "Bob", Email: "bob@example.com"},
+	}
+	f.users = newFakeCollection(t, f.alice, f.bob)
+	// Mirrors the unique email index from ensureUserIndexes
+	f.users.unique = []string{"email"}
+	f.docs = newFakeCollection(t, models.Document{
+		ID:         primitive.NewObjectID(),
+		UserID:     f.alice.ID,
+		Filename:   "contract.pdf",
+		Version:    1,
+		UploadDate: time.Now(),
+		StorageKey: "documents/alice/contract",
+	})
+	// Mirrors the unique version index from ensureDocumentIndexes
+	f.docs.unique = []string{"user_id", "org_id", "filename", "version"}
+
+	store, err := storage.NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(context.Background(), "documents/alice/contract", strings.NewReader("%PDF-1.7"), -1); err != nil {
+		t.Fatal(err)
+	}
+
+	f.refreshTokens = newFakeCollection(t)
+	f.revokedTokens = newFakeCollection(t)
+	f.accountTokens = newFakeCollection(t)
+	f.loginAttempts = newFakeCollection(t)
+	f.orgs, f.memberships, f.invitations = newFakeCollection(t), newFakeCollection(t), newFakeCollection(t)
+	f.shares, f.shareLinks = newFakeCollection(t), newFakeCollection(t)
+	f.quarantine, f.uploads = newFakeCollection(t), newFakeCollection(t)
+	f.locks = newFakeCollection(t)
+	// Mirrors the one-lock-per-file index from ensureLockIndexes
+	f.locks.unique = []string{"owner_id", "org_id", "filename"}
+
+	prevUsers, prevDocs, prevStorage, prevKey := usersCollection, documentsCollection, fileStorage, tokenKeys
+	prevRefresh, prevRevoked, prevAccount := refreshTokensCollection, revokedTokensCollection, accountTokensCollection
+	prevAttempts := loginAttemptsCollection
+	prevOrgs, prevMemberships, prevInvitations := organizationsCollection, membershipsCollection, invitationsCollection
+	prevShares, prevShareLinks, prevQuarantine := sharesCollection, shareLinksCollection, quarantineCollection
+	prevUploads, prevLocks := uploadsCollection, locksCollection
+	usersCollection, documentsCollection, fileStorage, tokenKeys = f.users, f.docs, store, signing.NewHMACKeySet([]byte("test-secret"), "")
+	refreshTokensCollection, revokedTokensCollection, accountTokensCollection = f.refreshTokens, f.revokedTokens, f.accountTokens
+	loginAttemptsCollection = f.loginAttempts
+	organizationsCollection, membershipsCollection, invitationsCollection = f.orgs, f.memberships, f.invitations
+	sharesCollection, shareLinksCollection, quarantineCollection = f.shares, f.shareLinks, f.quarantine
+	uploadsCollection, locksCollection = f.uploads, f.locks
+	t.Cleanup(func() {
+		usersCollection, documentsCollection, fileStorage, tokenKeys = prevUsers, prevDocs, prevStorage, prevKey
+		refreshTokensCollection, revokedTokensCollection, accountTokensCollection = prevRefresh, prevRevoked, prevAccount
+		loginAttemptsCollection = prevAttempts
+		organizationsCollection, membershipsCollection, invitationsCollection = prevOrgs, prevMemberships, prevInvitations
+		sharesCollection, shareLinksCollection, quarantineCollection = prevShares, prevShareLinks, prevQuarantine
+		uploadsCollection, locksCollection = prevUploads, prevLocks
+	})
+
+	// Mirrors the session and user-scoped routes registered in main.go
+	f.router = mux.NewRouter()
+	f.router.Handle("/users", JWTAuthMiddleware(RequirePermission(models.PermUsersRead)(http.HandlerFunc(GetUsers)))).Methods("GET")
+	f.router.Handle("/users/email", JWTAuthMiddleware(http.HandlerFunc(GetUserByEmail))).Methods("GET")
+	f.router.HandleFunc("/users", CreateUser).Methods("POST")
+	f.router.HandleFunc("/login", LoginUser).Methods("POST")
+	f.router.HandleFunc("/login/mfa", LoginMFA).Methods("POST")
+	f.router.HandleFunc("/email/verify", VerifyEmail).Methods("POST")
+	f.router.HandleFunc("/password/forgot", ForgotPassword).Methods("POST")
+	f.router.HandleFunc("/password/reset", ResetPassword).Methods("POST")
+	f.router.HandleFunc("/auth/oidc/login", OIDCLogin).Methods("GET")
+	f.router.HandleFunc("/auth/oidc/callback", OIDCCallback).Methods("GET")
+	f.router.HandleFunc("/token/refresh", RefreshToken).Methods("POST")
+	f.router.Handle("/logout", JWTAuthMiddleware(http.HandlerFunc(Logout))).Methods("POST")
+	userRoutes := f.router.PathPrefix("/users/{id}").Subrouter()
+	userRoutes.Use(JWTAuthMiddleware, RequireAccountOwner, ResolveTenant)
+	userRoutes.HandleFunc("", GetUser).Methods("GET")
+	userRoutes.Handle("", RequireRecentMFA(http.HandlerFunc(UpdateUser))).Methods("PUT")
+	userRoutes.Handle("", RequireRecentMFA(http.HandlerFunc(DeleteUser))).Methods("DELETE")
+	userRoutes.HandleFunc("/email/verification", ResendVerificationEmail).Methods("POST")
+	userRoutes.HandleFunc("/audit", GetAuditEvents).Methods("GET")
+	userRoutes.HandleFunc("/mfa/totp", EnrollTOTP).Methods("POST")
+	userRoutes.HandleFunc("/mfa/totp/verify", ConfirmTOTP).Methods("POST")
+	userRoutes.HandleFunc("/mfa/step-up", StepUpMFA).Methods("POST")
+	userRoutes.HandleFunc("/mfa", DisableMFA).Methods("DELETE")
+	userRoutes.HandleFunc("/upload", UploadFile).Methods("POST")
+	userRoutes.HandleFunc("/uploads", CreateUpload).Methods("POST")
+	userRoutes.HandleFunc("/uploads/{uploadID}", GetUploadOffset).Methods("HEAD")
+	userRoutes.HandleFunc("/uploads/{uploadID}", PatchUpload).Methods("PATCH")
+	userRoutes.HandleFunc("/uploads/{uploadID}", TerminateUpload).Methods("DELETE")
+	userRoutes.HandleFunc("/files", GetUserFiles).Methods("GET")
+	userRoutes.HandleFunc("/files/integrity", ScanUserFiles).Methods("GET")
+	userRoutes.HandleFunc("/files/{filename}/versions/{version}/verify", VerifyFileVersion).Methods("GET")
+	userRoutes.HandleFunc("/files/{filename}/download", DownloadFile).Methods("GET")
+	userRoutes.Handle("/files/{filename}/delete", RequireRecentMFA(http.HandlerFunc(DeleteFile))).Methods("DELETE")
+	userRoutes.HandleFunc("/files/{filename}/shares", ShareFile).Methods("POST")
+	userRoutes.HandleFunc("/files/{filename}/shares/{shareID}", RevokeShare).Methods("DELETE")
+	sharedRoutes := f.router.PathPrefix("/shared/documents/{documentID}").Subrouter()
+	sharedRoutes.Use(JWTAuthMiddleware)
+	sharedRoutes.HandleFunc("/download", DownloadSharedDocument).Methods("GET")
+	sharedRoutes.HandleFunc("/upload", UploadSharedDocumentVersion).Methods("POST")
+	sharedRoutes.HandleFunc("/lock", CheckOutSharedDocument).Methods("POST")
+	sharedRoutes.HandleFunc("/lock", CheckInSharedDocument).Methods("DELETE")
+	userRoutes.HandleFunc("/files/{filename}/{version}/links", CreateShareLink).Methods("POST")
+	userRoutes.HandleFunc("/files/{filename}/links/{linkID}", RevokeShareLink).Methods("DELETE")
+	userRoutes.HandleFunc("/files/{filename}/lock", CheckOutFile).Methods("POST")
+	userRoutes.HandleFunc("/files/{filename}/lock", CheckInFile).Methods("DELETE")
+	userRoutes.HandleFunc("/locks", ListLocks).Methods("GET")
+	f.router.HandleFunc("/public/links/{token}", GetPublicShareLink).Methods("GET")
+	f.router.HandleFunc("/public/links/{token}/document", GetPublicSharedDocument).Methods("GET")
+	f.router.Handle("/orgs", JWTAuthMiddleware(http.HandlerFunc(CreateOrganization))).Methods("POST")
+	f.router.Handle("/invitations/accept", JWTAuthMiddleware(http.HandlerFunc(AcceptInvitation))).Methods("POST")
+	orgRoutes := f.router.PathPrefix("/orgs/{orgID}").Subrouter()
+	orgRoutes.Use(JWTAuthMiddleware, RequireOrgMember)
+	orgRoutes.HandleFunc("/members/{userID}", RemoveMember).Methods("DELETE")
+	orgRoutes.HandleFunc("/invitations", InviteMember).Methods("POST")
+	adminRoutes := f.router.PathPrefix("/admin").Subrouter()
+	adminRoutes.Use(JWTAuthMiddleware)
+	adminRoutes.Handle("/users", RequirePermission(models.PermUsersRead)(http.HandlerFunc(AdminListUsers))).Methods("GET")
+	adminRoutes.Handle("/audit", RequirePermission(models.PermAuditRead)(http.HandlerFunc(AdminAuditEvents))).Methods("GET")
+	adminRoutes.Handle("/users/{id}/roles", RequirePermission(models.PermUsersManage)(http.HandlerFunc(AdminSetRoles))).Methods("PUT")
+	adminRoutes.Handle("/users/{id}/disable", RequirePermission(models.PermUsersManage)(http.HandlerFunc(AdminDisableUser))).Methods("POST")
+	adminRoutes.Handle("/users/{id}", RequirePermission(models.PermUsersManage)(http.HandlerFunc(AdminDeleteUser))).Methods("DELETE")
+	adminRoutes.Handle("/users/{id}/documents/reassign", RequirePermission(models.PermDocumentsManage)(http.HandlerFunc(AdminReassignDocuments))).Methods("POST")
+	adminRoutes.Handle("/locks", RequirePermission(models.PermDocumentsRead)(http.HandlerFunc(AdminListLocks))).Methods("GET")
+	adminRoutes.Handle("/locks/{lockID}", RequirePermission(models.PermDocumentsManage)(http.HandlerFunc(AdminBreakLock))).Methods("DELETE")
+	return f
+}
+
+func (f *authFixture) do(t *testing.T, method, path string, user *models.User) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(`{"first_name":"Mallory"}`))
+	if user != nil {
+		token, err := GenerateJWT(user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	f.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestOwnerCanAccessOwnRoutes(t *testing.T) {
+	f := newAuthFixture(t)
+	base := "/users/" + f.alice.ID.Hex()
+
+	if rec := f.do(t, "GET", base+"/files", &f.alice); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "contract.pdf") {
+		t.Fatalf("listing own files: got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := f.do(t, "GET", base+"/files/contract.pdf/download", &f.alice); rec.Code != http.StatusOK || rec.Body.String() != "%PDF-1.7" {
+		t.Fatalf("downloading own file: got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestOtherUsersAreForbidden(t *testing.T) {
+	f := newAuthFixture(t)
+	base := "/users/" + f.alice.ID.Hex()
+
+	cases := []struct{ method, path string }{
+		{"PUT", base},
+		{"DELETE", base},
+		{"GET", base + "/files"},
+		{"GET", base + "/files/contract.pdf/download"},
+		{"DELETE", base + "/files/contract.pdf/delete"},
+	}
+	for _, tc := range cases {
+		if rec := f.do(t, tc.method, tc.path, &f.bob); rec.Code != http.StatusForbidden {
+			t.Errorf("%s %s as another user: got %d, want 403", tc.method, tc.path, rec.Code)
+		}
+	}
+
+	if len(f.docs.docs) != 1 || len(f.users.docs) != 2 {
+		t.Fatalf("forbidden requests modified data: %d documents, %d users", len(f.docs.docs), len(f.users.docs))
+	}
+	if name := f.users.matching(bson.M{"_id": f.alice.ID})[0]["first_name"]; name != "Alice" {
+		t.Fatalf("forbidden update changed first_name to %v", name)
+	}
+}
+
+func TestSharedEmailDoesNotGrantOwnership(t *testing.T) {
+	f := newAuthFixture(t)
+	impostor := f.bob
+	impostor.Email = f.alice.Email
+
+	if rec := f.do(t, "GET", "/users/"+f.alice.ID.Hex()+"/files", &impostor); rec.Code != http.StatusForbidden {
+		t.Fatalf("another account with the owner's email: got %d, want 403", rec.Code)
+	}
+}
+
+func TestUnknownAccountIsForbidden(t *testing.T) {
+	f := newAuthFixture(t)
+	if rec := f.do(t, "GET", "/users/"+primitive.NewObjectID().Hex()+"/files", &f.alice); rec.Code != http.StatusForbidden {
+		t.Fatalf("got %d, want 403", rec.Code)
+	}
+}
+
+func TestInvalidAccountIDIsRejected(t *testing.T) {
+	f := newAuthFixture(t)
+	if rec := f.do(t, "GET", "/users/not-an-id/files", &f.alice); rec.Code != http.StatusBadRequest {
+		t.Fatalf("got %d, want 400", rec.Code)
+	}
+}
+
+func TestMissingOrForgedTokenIsUnauthorized(t *testing.T) {
+	f := newAuthFixture(t)
+	path := "/users/" + f.alice.ID.Hex() + "/files"
+
+	if rec := f.do(t, "GET", path, nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("no token: got %d, want 401", rec.Code)
+	}
+
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{
+		Email:            f.alice.Email,
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+	})
+	token, err := forged.SignedString([]byte("wrong-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	f.router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("forged token: got %d, want 401", rec.Code)
+	}
+}
+
+func TestRequireAccountOwnerWithoutClaims(t *testing.T) {
+	newAuthFixture(t)
+	handler := RequireAccountOwner(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("next handler must not run without claims")
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/users/x/files", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("got %d, want 401", rec.Code)
+	}
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"DocuDefense/backend/src/models"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// racingCollection runs race just before its first insert, like a concurrent upload that
+// wins the race for the next version number
+type racingCollection struct {
+	*fakeCollection
+	race func()
+}
+
+func (c *racingCollection) InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	if c.race != nil {
+		race := c.race
+		c.race = nil
+		race()
+	}
+	return c.fakeCollection.InsertOne(ctx, document, opts...)
+}
+
+// raceNextUpload makes a concurrent version 2 of contract.pdf appear during the next upload
+func (f *authFixture) raceNextUpload(t *testing.T) primitive.ObjectID {
+	competitor := models.Document{ID: primitive.NewObjectID(), UserID: f.alice.ID, Filename: "contract.pdf", Version: 2, UploadDate: time.Now()}
+	documentsCollection = &racingCollection{fakeCollection: f.docs, race: func() {
+		if _, err := f.docs.InsertOne(context.Background(), competitor); err != nil {
+			t.Fatal(err)
+		}
+	}}
+	t.Cleanup(func() { documentsCollection = f.docs })
+	return competitor.ID
+}
+
+// uploadWith uploads contract.pdf with extra form fields and request headers
+func (f *authFixture) uploadWith(t *testing.T, accessToken string, fields, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("contract", "contract.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(testPDF(""))
+	for name, value := range fields {
+		form.WriteField(name, value)
+	}
+	form.Close()
+
+	req := httptest.NewRequest("POST", "/users/"+f.alice.ID.Hex()+"/upload", &body)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	f.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestConcurrentUploadGetsTheNextFreeVersion(t *testing.T) {
+	f := newAuthFixture(t)
+	access, _ := f.login(t)
+	competitor := f.raceNextUpload(t)
+
+	if rec := f.uploadWith(t, access, nil, nil); rec.Code != http.StatusOK {
+		t.Fatalf("upload: got %d %q", rec.Code, rec.Body.String())
+	}
+	var latest models.Document
+	if err := f.docs.FindOne(context.Background(), bson.M{"filename": "contract.pdf", "version": 3}).Decode(&latest); err != nil {
+		t.Fatalf("the upload that lost the race was not stored as version 3: %v", err)
+	}
+	if latest.PreviousVersionID != competitor {
+		t.Fatalf("version 3 follows %s, want the concurrent version 2", latest.PreviousVersionID.Hex())
+	}
+}
+
+func TestUploadPreconditions(t *testing.T) {
+	f := newAuthFixture(t)
+	access, _ := f.login(t)
+	sum := sha256.Sum256([]byte("%PDF-1.7"))
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+	f.docs.UpdateOne(context.Background(), bson.M{"filename": "contract.pdf"}, bson.M{"$set": bson.M{"sha256": hex.EncodeToString(sum[:])}})
+
+	refused := []struct {
+		name            string
+		fields, headers map[string]string
+	}{
+		{"stale If-Match", nil, map[string]string{"If-Match": `"0000"`}},
+		{"stale expected_version", map[string]string{"expected_version": "2"}, nil},
+		{"new file that exists", map[string]string{"expected_version": "0"}, nil},
+	}
+	for _, tc := range refused {
+		rec := f.uploadWith(t, access, tc.fields, tc.headers)
+		var rejection uploadRejection
+		json.NewDecoder(rec.Body).Decode(&rejection)
+		if rec.Code != http.StatusPreconditionFailed || rejection.Error != "precondition_failed" || rec.Header().Get("ETag") != etag {
+			t.Fatalf("%s: got %d %+v, want 412", tc.name, rec.Code, rejection)
+		}
+	}
+	if len(f.docs.docs) != 1 {
+		t.Fatalf("refused uploads stored %d versions", len(f.docs.docs)-1)
+	}
+
+	if rec := f.uploadWith(t, access, nil, map[string]string{"If-Match": etag}); rec.Code != http.StatusOK {
+		t.Fatalf("current If-Match: got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := f.uploadWith(t, access, map[string]string{"expected_version": "2"}, nil); rec.Code != http.StatusOK {
+		t.Fatalf("current expected_version: got %d %q", rec.Code, rec.Body.String())
+	}
+
+	// A concurrent upload between the check and the insert still fails the precondition
+	f.raceNextUpload(t)
+	f.docs.DeleteOne(context.Background(), bson.M{"version": 2})
+	f.docs.DeleteOne(context.Background(), bson.M{"version": 3})
+	if rec := f.uploadWith(t, access, map[string]string{"expected_version": "1"}, nil); rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("lost race: got %d %q, want 412", rec.Code, rec.Body.String())
+	}
+}
+
+func TestUpdateUserOnlyChangesGivenFieldsOfTheRevisionRead(t *testing.T) {
+	f := newAuthFixture(t)
+	access, _ := f.login(t)
+	profile := "/users/" + f.alice.ID.Hex()
+	before := f.users.matching(bson.M{"_id": f.alice.ID})[0]["password"]
+
+	rec := f.send(t, "GET", profile, access, "")
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != `"0"` || bytes.Contains(rec.Body.Bytes(), []byte("password")) {
+		t.Fatalf("profile: got %d %q %v", rec.Code, rec.Body.String(), rec.Header())
+	}
+
+	req := httptest.NewRequest("PUT", profile, bytes.NewReader([]byte(`{"surname":"Smith"}`)))
+	req.Header.Set("Authorization", "Bearer "+access)
+	req.Header.Set("If-Match", `"0"`)
+	updated := httptest.NewRecorder()
+	f.router.ServeHTTP(updated, req)
+	if updated.Code != http.StatusOK || updated.Header().Get("ETag") != `"1"` {
+		t.Fatalf("update: got %d %q", updated.Code, updated.Body.String())
+	}
+	user := f.users.matching(bson.M{"_id": f.alice.ID})[0]
+	if user["surname"] != "Smith" || user["first_name"] != "Alice" || user["email"] != f.alice.Email || user["password"] != before {
+		t.Fatalf("update touched other fields: %v", user)
+	}
+
+	// A second client still holding revision 0 must not overwrite the first one's change
+	req = httptest.NewRequest("PUT", profile, bytes.NewReader([]byte(`{"surname":"Jones"}`)))
+	req.Header.Set("Authorization", "Bearer "+access)
+	req.Header.Set("If-Match", `"0"`)
+	stale := httptest.NewRecorder()
+	f.router.ServeHTTP(stale, req)
+	if stale.Code != http.StatusPreconditionFailed || stale.Header().Get("ETag") != `"1"` {
+		t.Fatalf("stale update: got %d, want 412", stale.Code)
+	}
+	if rec := f.send(t, "PUT", profile, access, `{}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("empty update: got %d, want 400", rec.Code)
+	}
+}
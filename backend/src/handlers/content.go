@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"DocuDefense/backend/src/encryption"
+	"DocuDefense/backend/src/models"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Master keys for encryption at rest; nil stores new uploads in plaintext
+var keyring *encryption.Keyring
+
+// SetKeyring sets the master keys used to wrap per-document data keys
+func SetKeyring(k *encryption.Keyring) {
+	keyring = k
+}
+
+// sealedContent is an upload prepared for storage
+type sealedContent struct {
+	Reader     io.Reader
+	Size       int64
+	KeyID      string
+	WrappedKey []byte
+}
+
+// sealContent encrypts plaintext with a fresh data key when encryption at rest is enabled
+func sealContent(plaintext io.Reader, size int64) (*sealedContent, error) {
+	if keyring == nil {
+		return &sealedContent{Reader: plaintext, Size: size}, nil
+	}
+
+	dataKey, keyID, wrapped, err := keyring.NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+	reader, err := encryption.NewEncryptReader(plaintext, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if size >= 0 {
+		size = encryption.CiphertextSize(size)
+	}
+	return &sealedContent{Reader: reader, Size: size, KeyID: keyID, WrappedKey: wrapped}, nil
+}
+
+// decryptingReadCloser pairs a decrypting reader with the underlying blob's Close
+type decryptingReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// openDocumentContent opens a version's blob, decrypting it while streaming when it was
+// stored encrypted. Legacy plaintext blobs are returned unchanged.
+func openDocumentContent(ctx context.Context, doc *models.Document) (io.ReadCloser, error) {
+	return openSealedBlob(ctx, doc.BlobKey(), doc.EncryptionKeyID, doc.WrappedDataKey)
+}
+
+// openSealedBlob opens the blob stored under key, decrypting it with the data key wrapped
+// by master key keyID. Blobs without a wrapped key are plaintext and returned unchanged.
+func openSealedBlob(ctx context.Context, key, keyID string, wrapped []byte) (io.ReadCloser, error) {
+	blob, err := fileStorage.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) == 0 {
+		return blob, nil
+	}
+	if keyring == nil {
+		blob.Close()
+		return nil, fmt.Errorf("blob %s is encrypted but no master keys are configured", key)
+	}
+
+	dataKey, err := keyring.Unwrap(keyID, wrapped)
+	if err != nil {
+		blob.Close()
+		return nil, err
+	}
+	plaintext, err := encryption.NewDecryptReader(blob, dataKey)
+	if err != nil {
+		blob.Close()
+		return nil, err
+	}
+	return decryptingReadCloser{Reader: plaintext, Closer: blob}, nil
+}
+
+// RotateEncryptionKeys re-wraps every data key that is not wrapped with the active master
+// key: those of document versions, quarantined versions and the chunks of unfinished
+// uploads. File bodies are left untouched, so the old master key can be retired afterwards.
+func RotateEncryptionKeys(ctx context.Context) (int, error) {
+	if keyring == nil {
+		return 0, fmt.Errorf("no master keys configured")
+	}
+
+	rotated := 0
+	for _, coll := range []DatabaseCollection{documentsCollection, quarantineCollection} {
+		n, err := rotateVersionKeys(ctx, coll)
+		rotated += n
+		if err != nil {
+			return rotated, err
+		}
+	}
+	n, err := rotateUploadKeys(ctx)
+	rotated += n
+	if err != nil {
+		return rotated, err
+	}
+
+	log.Printf("Re-wrapped %d data keys with master key %s", rotated, keyring.ActiveKeyID())
+	return rotated, nil
+}
+
+// rotateVersionKeys re-wraps the data keys of the versions stored in coll
+func rotateVersionKeys(ctx context.Context, coll DatabaseCollection) (int, error) {
+	cursor, err := coll.Find(ctx, bson.M{
+		"wrapped_data_key":  bson.M{"$exists": true},
+		"encryption_key_id": bson.M{"$ne": keyring.ActiveKeyID()},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	rotated := 0
+	for cursor.Next(ctx) {
+		var doc models.Document
+		if err := cursor.Decode(&doc); err != nil {
+			return rotated, err
+		}
+
+		keyID, wrapped, err := keyring.Rewrap(doc.EncryptionKeyID, doc.WrappedDataKey)
+		if err != nil {
+			return rotated, fmt.Errorf("rewrapping key for document %s: %w", doc.ID.Hex(), err)
+		}
+
+		// Only replace the key we unwrapped, in case another rotation got there first
+		_, err = coll.UpdateOne(ctx, bson.M{
+			"_id":               doc.ID,
+			"encryption_key_id": doc.EncryptionKeyID,
+		}, bson.M{
+			"$set": bson.M{"encryption_key_id": keyID, "wrapped_data_key": wrapped},
+		})
+		if err != nil {
+			return rotated, fmt.Errorf("updating document %s: %w", doc.ID.Hex(), err)
+		}
+		rotated++
+	}
+	return rotated, cursor.Err()
+}
+
+// rotateUploadKeys re-wraps the data keys of the chunks stored so far by unfinished uploads
+func rotateUploadKeys(ctx context.Context) (int, error) {
+	cursor, err := uploadsCollection.Find(ctx, bson.M{"document_id": bson.M{"$exists": false}})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	rotated := 0
+	for cursor.Next(ctx) {
+		var upload models.Upload
+		if err := cursor.Decode(&upload); err != nil {
+			return rotated, err
+		}
+		for attempt := 1; ; attempt++ {
+			n, err := rewrapUploadParts(ctx, &upload)
+			if err == nil {
+				rotated += n
+				break
+			}
+			if !errors.Is(err, errUploadChanged) || attempt == maxVersionAttempts {
+				return rotated, fmt.Errorf("rewrapping keys for upload %s: %w", upload.ID.Hex(), err)
+			}
+			// A chunk arrived in the meantime; start again from the upload as it is now
+			err = uploadsCollection.FindOne(ctx, bson.M{"_id": upload.ID}).Decode(&upload)
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				break
+			}
+			if err != nil {
+				return rotated, err
+			}
+		}
+	}
+	return rotated, cursor.Err()
+}
+
+var errUploadChanged = errors.New("upload received a chunk during rotation")
+
+// rewrapUploadParts re-wraps the keys of the upload's chunks and stores them, provided the
+// upload has received no chunk since it was read
+func rewrapUploadParts(ctx context.Context, upload *models.Upload) (int, error) {
+	parts := make([]models.UploadPart, len(upload.Parts))
+	rotated := 0
+	for i, part := range upload.Parts {
+		if len(part.WrappedDataKey) > 0 && part.EncryptionKeyID != keyring.ActiveKeyID() {
+			keyID, wrapped, err := keyring.Rewrap(part.EncryptionKeyID, part.WrappedDataKey)
+			if err != nil {
+				return 0, fmt.Errorf("chunk at %d: %w", part.Offset, err)
+			}
+			part.EncryptionKeyID, part.WrappedDataKey = keyID, wrapped
+			rotated++
+		}
+		parts[i] = part
+	}
+	if rotated == 0 {
+		return 0, nil
+	}
+
+	result, err := uploadsCollection.UpdateOne(ctx, bson.M{"_id": upload.ID, "offset": upload.Offset}, bson.M{
+		"$set": bson.M{"parts": parts},
+	})
+	if err != nil {
+		return 0, err
+	}
+	if result.MatchedCount == 0 {
+		return 0, errUploadChanged
+	}
+	return rotated, nil
+}
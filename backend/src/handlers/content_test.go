@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"DocuDefense/backend/src/encryption"
+	"DocuDefense/backend/src/models"
+	"DocuDefense/backend/src/scanner"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// useKeyring encrypts new uploads with the given master keys for the rest of the test
+func useKeyring(t *testing.T, keys map[string][]byte, activeID string) {
+	t.Helper()
+	ring, err := encryption.NewKeyring(keys, activeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := keyring
+	keyring = ring
+	t.Cleanup(func() { keyring = prev })
+}
+
+func TestRotateEncryptionKeysRewrapsWithTheActiveKey(t *testing.T) {
+	f := newAuthFixture(t)
+	access, _ := f.login(t)
+	k1, k2 := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	useKeyring(t, map[string][]byte{"k1": k1}, "k1")
+	content := testPDF("")
+	if rec := f.upload(t, access, "lease.pdf", content); rec.Code != http.StatusOK {
+		t.Fatalf("upload: got %d %q", rec.Code, rec.Body.String())
+	}
+
+	// A quarantined version and the first chunk of an unfinished upload are sealed with k1 too
+	useScanner(t, stubScanner{signature: "Eicar-Test-Signature"})
+	if rec := f.upload(t, access, "invoice.pdf", content); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("infected upload: got %d %q", rec.Code, rec.Body.String())
+	}
+	useScanner(t, scanner.Noop{})
+	location := f.createUpload(t, access, "scan.pdf", len(content))
+	half := len(content) / 2
+	if rec := f.tus(t, "PATCH", location, access, patchChunk(0), content[:half]); rec.Code != http.StatusNoContent {
+		t.Fatalf("first chunk: got %d %q", rec.Code, rec.Body.String())
+	}
+
+	useKeyring(t, map[string][]byte{"k1": k1, "k2": k2}, "k2")
+	rotated, err := RotateEncryptionKeys(context.Background())
+	if err != nil || rotated != 3 {
+		t.Fatalf("rotation: rotated %d, %v", rotated, err)
+	}
+	if keyID := f.docs.matching(bson.M{"filename": "lease.pdf"})[0]["encryption_key_id"]; keyID != "k2" {
+		t.Fatalf("key ID after rotation: %v, want k2", keyID)
+	}
+	if rotated, err := RotateEncryptionKeys(context.Background()); err != nil || rotated != 0 {
+		t.Fatalf("second rotation: rotated %d, %v", rotated, err)
+	}
+
+	// The old master key can be retired once everything is rewrapped
+	useKeyring(t, map[string][]byte{"k2": k2}, "k2")
+	rec := f.download(t, "/users/"+f.alice.ID.Hex()+"/files/lease.pdf/download", access, nil)
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), content) {
+		t.Fatalf("download after retiring k1: got %d", rec.Code)
+	}
+	var quarantined models.Document
+	if err := f.quarantine.FindOne(context.Background(), bson.M{"filename": "invoice.pdf"}).Decode(&quarantined); err != nil {
+		t.Fatal(err)
+	}
+	blob, err := openSealedBlob(context.Background(), quarantined.BlobKey(), quarantined.EncryptionKeyID, quarantined.WrappedDataKey)
+	if err != nil {
+		t.Fatalf("quarantined version after retiring k1: %v", err)
+	}
+	got, err := io.ReadAll(blob)
+	blob.Close()
+	if err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("reading quarantined version after retiring k1: %v", err)
+	}
+	if rec := f.tus(t, "PATCH", location, access, patchChunk(half), content[half:]); rec.Code != http.StatusNoContent {
+		t.Fatalf("finishing the upload after retiring k1: got %d %q", rec.Code, rec.Body.String())
+	}
+	rec = f.download(t, "/users/"+f.alice.ID.Hex()+"/files/scan.pdf/download", access, nil)
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), content) {
+		t.Fatalf("download of the finished upload: got %d", rec.Code)
+	}
+}
@@ -0,0 +1,419 @@
+package handlers
+
+import (
+	"DocuDefense/backend/src/audit"
+	"DocuDefense/backend/src/models"
+	"DocuDefense/backend/src/storage"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// GetFileVersionBlob streams a specific version of a file inline, e.g. for the PDF previewer.
+// The version may be a number or "latest"; add ?download=1 to receive it as an attachment.
+func GetFileVersionBlob(w http.ResponseWriter, r *http.Request) {
+	doc, ok := resolveFileVersion(w, r)
+	if !ok {
+		return
+	}
+
+	disposition := "inline"
+	if download, _ := strconv.ParseBool(r.URL.Query().Get("download")); download {
+		disposition = "attachment"
+	}
+	serveDocument(w, r, doc, disposition)
+}
+
+// DownloadFileVersion sends a specific version of a file as an attachment
+func DownloadFileVersion(w http.ResponseWriter, r *http.Request) {
+	doc, ok := resolveFileVersion(w, r)
+	if !ok {
+		return
+	}
+	serveDocument(w, r, doc, "attachment")
+}
+
+// GetDocumentBlob streams a document version identified by its Document ID inline
+func GetDocumentBlob(w http.ResponseWriter, r *http.Request) {
+	doc, ok := resolveDocumentByID(w, r)
+	if !ok {
+		return
+	}
+
+	disposition := "inline"
+	if download, _ := strconv.ParseBool(r.URL.Query().Get("download")); download {
+		disposition = "attachment"
+	}
+	serveDocument(w, r, doc, disposition)
+}
+
+// DownloadDocument sends a document version identified by its Document ID as an attachment
+func DownloadDocument(w http.ResponseWriter, r *http.Request) {
+	doc, ok := resolveDocumentByID(w, r)
+	if !ok {
+		return
+	}
+	serveDocument(w, r, doc, "attachment")
+}
+
+// resolveFileVersion looks up the version named by the {id}, {filename} and {version} route variables
+func resolveFileVersion(w http.ResponseWriter, r *http.Request) (*models.Document, bool) {
+	params := mux.Vars(r)
+	filename, err := url.QueryUnescape(params["filename"])
+	if err != nil {
+		http.Error(w, "Invalid filename", http.StatusBadRequest)
+		return nil, false
+	}
+
+	userIDObj, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		log.Printf("Invalid user ID format: %v", err)
+		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+		return nil, false
+	}
+
+	var doc *models.Document
+	if params["version"] == "latest" {
+		doc, err = findLatestDocument(r.Context(), userIDObj, activeTenant(r), filename)
+	} else {
+		version, convErr := strconv.Atoi(params["version"])
+		if convErr != nil || version < 1 {
+			http.Error(w, "Invalid version", http.StatusBadRequest)
+			return nil, false
+		}
+		doc, err = findDocumentVersion(r.Context(), userIDObj, activeTenant(r), filename, version)
+	}
+	if err != nil {
+		log.Printf("Error finding version %s of file %s: %v", params["version"], filename, err)
+		http.Error(w, "Error finding file", http.StatusInternalServerError)
+		return nil, false
+	}
+	if doc == nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return nil, false
+	}
+	return doc, true
+}
+
+// resolveDocumentByID looks up the document named by the {id} and {documentID} route variables
+func resolveDocumentByID(w http.ResponseWriter, r *http.Request) (*models.Document, bool) {
+	params := mux.Vars(r)
+	userIDObj, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		log.Printf("Invalid user ID format: %v", err)
+		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+		return nil, false
+	}
+
+	documentIDObj, err := primitive.ObjectIDFromHex(params["documentID"])
+	if err != nil {
+		http.Error(w, "Invalid document ID format", http.StatusBadRequest)
+		return nil, false
+	}
+
+	var doc models.Document
+	err = documentsCollection.FindOne(r.Context(), inTenant(bson.M{"_id": documentIDObj, "user_id": userIDObj}, activeTenant(r))).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Error finding document %s: %v", params["documentID"], err)
+		http.Error(w, "Error finding file", http.StatusInternalServerError)
+		return nil, false
+	}
+	return &doc, true
+}
+
+// findDocumentVersion returns one version of a user's file in the workspace orgID, or nil if
+// it does not exist
+func findDocumentVersion(ctx context.Context, userID, orgID primitive.ObjectID, filename string, version int) (*models.Document, error) {
+	var doc models.Document
+	err := documentsCollection.FindOne(ctx, inTenant(bson.M{
+		"user_id":  userID,
+		"filename": filename,
+		"version":  version,
+	}, orgID)).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// serveDocument verifies a document version's blob and streams it with its content headers.
+// disposition is either "inline" or "attachment". Versions that failed the malware scan are
+// refused. Byte ranges, including multiple ranges, and conditional requests are answered
+// with http.ServeContent, using the content hash as a strong ETag.
+func serveDocument(w http.ResponseWriter, r *http.Request, doc *models.Document, disposition string) {
+	if !doc.ScanPassed() {
+		log.Printf("Refused download of %s version %d (document %s): scan status %s", doc.Filename, doc.Version, doc.ID.Hex(), doc.ScanStatus)
+		http.Error(w, "This document has not passed the malware scan", http.StatusForbidden)
+		return
+	}
+
+	etag := documentETag(doc)
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if !doc.UploadDate.IsZero() {
+		w.Header().Set("Last-Modified", doc.UploadDate.UTC().Format(http.TimeFormat))
+	}
+	if w.Header().Get("Cache-Control") == "" {
+		w.Header().Set("Cache-Control", "private, no-cache")
+	}
+	// Answer revalidations before reading the blob at all
+	if notModified(r, etag, doc.UploadDate) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	size := doc.Size
+	if size <= 0 && !doc.Encrypted() {
+		info, err := fileStorage.Stat(r.Context(), doc.BlobKey())
+		if err != nil {
+			if !errors.Is(err, storage.ErrNotFound) {
+				log.Printf("Error reading metadata for %s: %v", doc.BlobKey(), err)
+			}
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
+		size = info.Size
+	}
+
+	// Check the blob against its recorded hash before sending the whole of it. A range of an
+	// encrypted version is not hashed: each chunk it is decrypted from is authenticated, so
+	// altered content fails there without reading the rest of the blob on every request.
+	var integrity IntegrityResult
+	if !doc.Encrypted() || (r.Method == http.MethodGet && !servesRange(r, etag, doc.UploadDate)) {
+		var err error
+		integrity, err = verifyDocument(r.Context(), doc)
+		if err != nil {
+			log.Printf("Error verifying document %s: %v", doc.ID.Hex(), err)
+			http.Error(w, "Error verifying document", http.StatusInternalServerError)
+			return
+		}
+		switch integrity.Status {
+		case IntegrityMissing:
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		case IntegrityMismatch:
+			log.Printf("Integrity mismatch for %s version %d (document %s): expected %s, got %s", doc.Filename, doc.Version, doc.ID.Hex(), integrity.ExpectedSHA256, integrity.ActualSHA256)
+			w.Header().Set("X-Integrity-Status", IntegrityMismatch)
+			// The content no longer matches the hash the ETag promises
+			w.Header().Del("ETag")
+			if refuseCorruptDownloads() {
+				http.Error(w, "Document failed integrity verification", http.StatusInternalServerError)
+				return
+			}
+		default:
+			w.Header().Set("X-Integrity-Status", integrity.Status)
+		}
+	}
+	if doc.SHA256 != "" {
+		w.Header().Set("X-Content-SHA256", doc.SHA256)
+	}
+
+	content, err := openContentSeeker(r.Context(), doc, size)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Error opening file %s from storage: %v", doc.BlobKey(), err)
+		}
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	defer content.Close()
+
+	details := map[string]string{"version": strconv.Itoa(doc.Version), "disposition": disposition}
+	if integrity.Status != "" {
+		details["integrity"] = integrity.Status
+	}
+	if spec := r.Header.Get("Range"); spec != "" {
+		details["range"] = spec
+	}
+	recordAudit(r, audit.Event{
+		Action:       audit.ActionDocumentView,
+		TargetUserID: doc.UserID,
+		DocumentID:   doc.ID,
+		Target:       doc.Filename,
+		Details:      details,
+	})
+
+	w.Header().Set("Content-Type", documentContentType(doc))
+	w.Header().Set("Content-Disposition", contentDisposition(disposition, doc.Filename))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, doc.Filename, doc.UploadDate, content)
+	if content.err != nil {
+		log.Printf("Error streaming %s: %v", doc.BlobKey(), content.err)
+	}
+}
+
+// documentETag returns a strong ETag for a version, taken from its content hash. Legacy
+// versions without a recorded hash have none.
+func documentETag(doc *models.Document) string {
+	if doc.SHA256 == "" {
+		return ""
+	}
+	return `"` + doc.SHA256 + `"`
+}
+
+// notModified reports whether the client's cached copy is still current, judged by
+// If-None-Match or, when that is absent, If-Modified-Since
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || modified.IsZero() {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(since)
+}
+
+// servesRange reports whether the response will be part of the content: a Range is asked
+// for and any If-Range still names this version, judged as http.ServeContent does
+func servesRange(r *http.Request, etag string, modified time.Time) bool {
+	if r.Header.Get("Range") == "" {
+		return false
+	}
+	condition := r.Header.Get("If-Range")
+	switch {
+	case condition == "":
+		return true
+	case strings.HasPrefix(condition, `"`):
+		return etag != "" && condition == etag
+	case strings.HasPrefix(condition, "W/"):
+		return false
+	}
+	at, err := http.ParseTime(condition)
+	return err == nil && !modified.IsZero() && modified.Truncate(time.Second).Equal(at)
+}
+
+// contentSeeker lets http.ServeContent seek within a version's content. Blobs, encrypted
+// ones in particular, can only be streamed from the start, so seeking backwards reopens
+// the content and seeking forwards skips what lies between.
+type contentSeeker struct {
+	ctx    context.Context
+	doc    *models.Document
+	size   int64
+	pos    int64 // position the next Read starts at
+	read   int64 // position of reader
+	reader io.ReadCloser
+	err    error // first error reading the blob, after the response has started
+}
+
+// openContentSeeker opens a version's content of the given size. The blob is opened
+// straight away so a missing blob is reported before any response is written.
+func openContentSeeker(ctx context.Context, doc *models.Document, size int64) (*contentSeeker, error) {
+	reader, err := openDocumentContent(ctx, doc)
+	if err != nil {
+		return nil, err
+	}
+	return &contentSeeker{ctx: ctx, doc: doc, size: size, reader: reader}, nil
+}
+
+func (c *contentSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += c.pos
+	case io.SeekEnd:
+		offset += c.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before the start of the content")
+	}
+	c.pos = offset
+	return offset, nil
+}
+
+func (c *contentSeeker) Read(p []byte) (int, error) {
+	if c.reader != nil && c.read > c.pos {
+		c.reader.Close()
+		c.reader = nil
+	}
+	if c.reader == nil {
+		reader, err := openDocumentContent(c.ctx, c.doc)
+		if err != nil {
+			return 0, c.fail(err)
+		}
+		c.reader, c.read = reader, 0
+	}
+	if c.read < c.pos {
+		skipped, err := io.CopyN(io.Discard, c.reader, c.pos-c.read)
+		c.read += skipped
+		if err != nil {
+			return 0, c.fail(err)
+		}
+	}
+	n, err := c.reader.Read(p)
+	c.read += int64(n)
+	c.pos += int64(n)
+	if err != nil && err != io.EOF {
+		c.fail(err)
+	}
+	return n, err
+}
+
+func (c *contentSeeker) fail(err error) error {
+	if c.err == nil {
+		c.err = err
+	}
+	return err
+}
+
+// Close closes the open blob, if any
+func (c *contentSeeker) Close() error {
+	if c.reader == nil {
+		return nil
+	}
+	err := c.reader.Close()
+	c.reader = nil
+	return err
+}
+
+// documentContentType returns the stored content type, falling back to the file extension for legacy documents
+func documentContentType(doc *models.Document) string {
+	if doc.ContentType != "" {
+		return doc.ContentType
+	}
+	if byExt := mime.TypeByExtension(filepath.Ext(doc.Filename)); byExt != "" {
+		return byExt
+	}
+	return "application/octet-stream"
+}
+
+// contentDisposition builds a Content-Disposition header, encoding non-ASCII
+// filenames as RFC 2231 extended parameters
+func contentDisposition(disposition, filename string) string {
+	if header := mime.FormatMediaType(disposition, map[string]string{"filename": filename}); header != "" {
+		return header
+	}
+	return disposition
+}
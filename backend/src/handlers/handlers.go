@@ -1,15 +1,19 @@
 package handlers
 
 import (
+	"DocuDefense/backend/src/audit"
+	"DocuDefense/backend/src/mailer"
 	"DocuDefense/backend/src/models"
+	"DocuDefense/backend/src/signing"
+	"DocuDefense/backend/src/storage"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
-	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -28,6 +32,8 @@ type DatabaseCollection interface {
 	InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error)
 	FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult
 	UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+	FindOneAndUpdate(ctx context.Context, filter interface{}, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult
+	UpdateMany(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
 	DeleteOne(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
 }
 
@@ -40,17 +46,111 @@ type DatabaseClient interface {
 var usersCollection DatabaseCollection
 var documentsCollection DatabaseCollection
 
+// Database handle used for index management
+var mongoDatabase *mongo.Database
+
 // SetMongoClient initializes the MongoDB client and sets the collections
 func SetMongoClient(client DatabaseClient) {
 	db := client.Database("docudefense")
+	mongoDatabase = db
 	usersCollection = db.Collection("users")
 	documentsCollection = db.Collection("documents")
+	refreshTokensCollection = db.Collection("refresh_tokens")
+	revokedTokensCollection = db.Collection("revoked_tokens")
+	oidcStatesCollection = db.Collection("oidc_login_states")
+	accountTokensCollection = db.Collection("account_tokens")
+	loginAttemptsCollection = db.Collection("login_attempts")
+	organizationsCollection = db.Collection("organizations")
+	membershipsCollection = db.Collection("memberships")
+	invitationsCollection = db.Collection("invitations")
+	sharesCollection = db.Collection("shares")
+	quarantineCollection = db.Collection("quarantine")
+	shareLinksCollection = db.Collection("share_links")
+	uploadsCollection = db.Collection("uploads")
+	locksCollection = db.Collection("document_locks")
+	auditLog = audit.NewLogger(db.Collection("audit_events"))
 }
 
-// JWT secret key
-var jwtKey = []byte(os.Getenv("JWT_SECRET"))
+// EnsureIndexes creates the indexes the handlers rely on
+func EnsureIndexes(ctx context.Context) error {
+	if err := audit.EnsureIndexes(ctx, mongoDatabase.Collection("audit_events")); err != nil {
+		return err
+	}
+	if err := ensureUserIndexes(ctx); err != nil {
+		return err
+	}
+	if err := ensureTokenIndexes(ctx); err != nil {
+		return err
+	}
+	if err := ensureOIDCIndexes(ctx); err != nil {
+		return err
+	}
+	if err := ensureAccountTokenIndexes(ctx); err != nil {
+		return err
+	}
+	if err := ensureLoginAttemptIndexes(ctx); err != nil {
+		return err
+	}
+	if err := ensureOrganizationIndexes(ctx); err != nil {
+		return err
+	}
+	if err := ensureShareIndexes(ctx); err != nil {
+		return err
+	}
+	if err := ensureDocumentIndexes(ctx); err != nil {
+		return err
+	}
+	if err := ensureShareLinkIndexes(ctx); err != nil {
+		return err
+	}
+	if err := ensureUploadIndexes(ctx); err != nil {
+		return err
+	}
+	return ensureLockIndexes(ctx)
+}
 
-// Get all users
+// ensureUserIndexes keeps each email address registered to at most one account
+func ensureUserIndexes(ctx context.Context) error {
+	_, err := mongoDatabase.Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// emailTaken reports whether an account other than except already uses the email address
+func emailTaken(ctx context.Context, email string, except primitive.ObjectID) (bool, error) {
+	err := usersCollection.FindOne(ctx, bson.M{"email": email, "_id": bson.M{"$ne": except}}).Err()
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// VerifyAuditLog walks the whole audit chain and reports the first broken event
+func VerifyAuditLog(ctx context.Context) (*audit.Verification, error) {
+	if auditLog == nil {
+		return nil, errors.New("the audit log is not enabled")
+	}
+	return auditLog.Verify(ctx)
+}
+
+// Blob storage backend used for uploaded files
+var fileStorage storage.Storage
+
+// SetStorage sets the backend that file handlers read from and write to
+func SetStorage(store storage.Storage) {
+	fileStorage = store
+}
+
+// Keys used to sign and verify access tokens
+var tokenKeys *signing.KeySet
+
+// SetTokenKeys sets the key set used to sign new access tokens and verify presented ones
+func SetTokenKeys(keys *signing.KeySet) {
+	tokenKeys = keys
+}
+
+// GetUsers lists accounts for callers with users:read, without password hashes
 func GetUsers(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -86,7 +186,7 @@ func GetUsers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	json.NewEncoder(w).Encode(userList)
+	json.NewEncoder(w).Encode(adminUsers(userList))
 }
 
 // CreateUser adds a new user to the database
@@ -100,8 +200,12 @@ func CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Ensure user has a unique ID
+	// Ensure user has a unique ID; the address is unverified until the emailed link is opened.
+	// Roles and the disabled flag are only ever set by administrators.
 	user.ID = primitive.NewObjectID()
+	user.EmailVerified = false
+	user.Roles = nil
+	user.Disabled = false
 
 	if err := user.HashPassword(user.Password); err != nil {
 		log.Printf("Error hashing password for user: %v", err)
@@ -112,24 +216,64 @@ func CreateUser(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	taken, err := emailTaken(ctx, user.Email, user.ID)
+	if err != nil {
+		log.Printf("Error checking whether %s is registered: %v", user.Email, err)
+		http.Error(w, "Error creating user", http.StatusInternalServerError)
+		return
+	}
+	if taken {
+		http.Error(w, "The email address is already in use", http.StatusConflict)
+		return
+	}
+
 	_, err = usersCollection.InsertOne(ctx, user)
+	if mongo.IsDuplicateKeyError(err) {
+		// A concurrent registration got past the check; the unique email index caught it
+		http.Error(w, "The email address is already in use", http.StatusConflict)
+		return
+	}
 	if err != nil {
 		log.Printf("Error inserting user into database: %v", err)
 		http.Error(w, "Error creating user", http.StatusInternalServerError)
 		return
 	}
 
+	recordAudit(r, audit.Event{
+		Action:       audit.ActionAccountCreate,
+		Actor:        user.Email,
+		ActorID:      user.ID,
+		TargetUserID: user.ID,
+		Target:       user.Email,
+	})
+
+	if err := sendVerificationEmail(ctx, &user); err != nil {
+		log.Printf("Error sending verification email to %s: %v", user.Email, err)
+	}
+
 	user.Password = "" // Remove password before returning response
 	json.NewEncoder(w).Encode(user)
 }
 
-// GetUserByEmail retrieves a user document by email and returns their ID
+// GetUserByEmail retrieves a user document by email and returns their ID. Callers may only
+// look up their own address unless they have users:read, so it cannot be used to find out
+// which addresses are registered.
 func GetUserByEmail(w http.ResponseWriter, r *http.Request) {
 	email := r.URL.Query().Get("email")
 	if email == "" {
 		http.Error(w, "Email is required", http.StatusBadRequest)
 		return
 	}
+	claims, ok := claimsFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+	if !strings.EqualFold(email, claims.Email) && !claims.HasPermission(models.PermUsersRead) {
+		log.Printf("Forbidden lookup of %s by %s", email, claims.Email)
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
 
 	var user models.User
 	err := usersCollection.FindOne(context.Background(), bson.M{"email": email}).Decode(&user)
@@ -143,11 +287,22 @@ func GetUserByEmail(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-// GetUserFiles retrieves files for a specific user by ID
+// GetUserFiles retrieves files for a specific user by ID in the active workspace. With
+// ?view=shared it lists the documents other users have shared with them instead.
 func GetUserFiles(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	userID := params["id"]
 
+	if r.URL.Query().Get("view") == "shared" {
+		targetUser, ok := targetUserFromContext(r)
+		if !ok {
+			http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+			return
+		}
+		listSharedWithMe(w, r, targetUser)
+		return
+	}
+
 	userIDObj, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
 		log.Printf("Invalid user ID format: %v", err)
@@ -156,7 +311,7 @@ func GetUserFiles(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var documents []models.Document
-	cursor, err := documentsCollection.Find(context.Background(), bson.M{"user_id": userIDObj})
+	cursor, err := documentsCollection.Find(context.Background(), inTenant(bson.M{"user_id": userIDObj}, activeTenant(r)))
 	if err != nil {
 		log.Printf("Error retrieving documents for user %s: %v", userID, err)
 		http.Error(w, "Error retrieving documents", http.StatusInternalServerError)
@@ -173,104 +328,207 @@ func GetUserFiles(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(documents)
 }
 
-// UpdateUser updates user information if requester is the account owner
-func UpdateUser(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	userID := params["id"]
+// userProfile is the view of an account returned to its owner, without the password hash.
+// Revision changes with every profile update and is the account's ETag.
+type userProfile struct {
+	ID            primitive.ObjectID `json:"id"`
+	FirstName     string             `json:"first_name"`
+	Surname       string             `json:"surname"`
+	Email         string             `json:"email"`
+	Birthdate     string             `json:"birthdate"`
+	EmailVerified bool               `json:"email_verified"`
+	Revision      int                `json:"revision"`
+}
 
-	claims, ok := r.Context().Value("userClaims").(*Claims)
-	if !ok || claims == nil {
-		log.Println("Unauthorized access: Unable to retrieve user claims")
-		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
-		return
+func newUserProfile(u *models.User) userProfile {
+	return userProfile{
+		ID:            u.ID,
+		FirstName:     u.FirstName,
+		Surname:       u.Surname,
+		Email:         u.Email,
+		Birthdate:     u.Birthdate,
+		EmailVerified: u.EmailVerified,
+		Revision:      u.Revision,
 	}
-	requesterEmail := claims.Email
+}
 
-	userIDObj, err := primitive.ObjectIDFromHex(userID)
-	if err != nil {
-		log.Printf("Invalid user ID format: %v", err)
-		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
-		return
-	}
+// userETag identifies a revision of an account's profile, for If-Match on updates
+func userETag(u *models.User) string {
+	return `"` + strconv.Itoa(u.Revision) + `"`
+}
 
-	var targetUser models.User
-	err = usersCollection.FindOne(context.Background(), bson.M{"_id": userIDObj}).Decode(&targetUser)
-	if err != nil {
-		log.Printf("User not found for ID %s: %v", userID, err)
-		http.Error(w, "User not found", http.StatusNotFound)
+// GetUser returns the caller's own profile with its ETag
+func GetUser(w http.ResponseWriter, r *http.Request) {
+	targetUser, ok := targetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
 		return
 	}
+	w.Header().Set("ETag", userETag(targetUser))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newUserProfile(targetUser))
+}
 
-	if requesterEmail != targetUser.Email {
-		log.Printf("Unauthorized update attempt by %s on account %s", requesterEmail, targetUser.Email)
-		http.Error(w, "You are not authorized to update this account", http.StatusForbidden)
+// UpdateUser updates the fields given in the request and leaves the others unchanged;
+// RequireAccountOwner has already checked ownership. With If-Match the update only applies
+// to the revision of the profile the client read, and fails with 412 otherwise. Changing the
+// password or email address needs the current password, and a new password signs out every
+// session.
+func UpdateUser(w http.ResponseWriter, r *http.Request) {
+	targetUser, ok := targetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
 		return
 	}
+	userID := targetUser.ID.Hex()
+	userIDObj := targetUser.ID
 
-	var updatedUser models.User
-	err = json.NewDecoder(r.Body).Decode(&updatedUser)
+	var body struct {
+		models.User
+		CurrentPassword string `json:"current_password"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
 	if err != nil {
 		log.Printf("Error decoding user update data: %v", err)
 		http.Error(w, "Invalid user data", http.StatusBadRequest)
 		return
 	}
+	updatedUser := body.User
+
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch != "" && ifMatch != "*" && ifMatch != userETag(targetUser) {
+		w.Header().Set("ETag", userETag(targetUser))
+		http.Error(w, "The account was changed since it was read", http.StatusPreconditionFailed)
+		return
+	}
 
+	set := bson.M{}
+	for field, value := range map[string]string{
+		"first_name": updatedUser.FirstName,
+		"surname":    updatedUser.Surname,
+		"email":      updatedUser.Email,
+		"birthdate":  updatedUser.Birthdate,
+	} {
+		if value != "" {
+			set[field] = value
+		}
+	}
 	if updatedUser.Password != "" {
 		if err := updatedUser.HashPassword(updatedUser.Password); err != nil {
 			log.Printf("Error hashing updated password: %v", err)
 			http.Error(w, "Error updating password", http.StatusInternalServerError)
 			return
 		}
+		set["password"] = updatedUser.Password
+	}
+	if len(set) == 0 {
+		http.Error(w, "Nothing to update", http.StatusBadRequest)
+		return
 	}
 
-	update := bson.M{
-		"$set": bson.M{
-			"first_name": updatedUser.FirstName,
-			"surname":    updatedUser.Surname,
-			"email":      updatedUser.Email,
-			"password":   updatedUser.Password,
-		},
+	emailChanged := updatedUser.Email != "" && updatedUser.Email != targetUser.Email
+	if emailChanged {
+		taken, err := emailTaken(r.Context(), updatedUser.Email, userIDObj)
+		if err != nil {
+			log.Printf("Error checking whether %s is registered: %v", updatedUser.Email, err)
+			http.Error(w, "Error updating user", http.StatusInternalServerError)
+			return
+		}
+		if taken {
+			http.Error(w, "The email address is already in use", http.StatusConflict)
+			return
+		}
+		// A new address has to be confirmed again
+		set["email_verified"] = false
+	}
+
+	// Whoever holds a stolen session must not be able to take over the account with it
+	if (emailChanged || updatedUser.Password != "") && targetUser.Password != "" {
+		guard, ok := beginLogin(w, r, targetUser.Email)
+		if !ok {
+			return
+		}
+		defer guard.release(r.Context())
+		if err := targetUser.CheckPassword(body.CurrentPassword); err != nil {
+			guard.fail(r.Context())
+			http.Error(w, "Current password is incorrect", http.StatusUnauthorized)
+			return
+		}
+		guard.succeed(r.Context())
 	}
 
-	filter := bson.M{"_id": userIDObj}
-	result, err := usersCollection.UpdateOne(context.Background(), filter, update)
-	if err != nil || result.MatchedCount == 0 {
+	// Only update the revision that was read, so a concurrent update is not overwritten
+	filter := bson.M{"_id": userIDObj, "revision": targetUser.Revision}
+	if targetUser.Revision == 0 {
+		filter["revision"] = bson.M{"$exists": false}
+	}
+	result, err := usersCollection.UpdateOne(context.Background(), filter, bson.M{"$set": set, "$inc": bson.M{"revision": 1}})
+	if mongo.IsDuplicateKeyError(err) {
+		http.Error(w, "The email address is already in use", http.StatusConflict)
+		return
+	}
+	if err != nil {
 		log.Printf("Error updating user %s: %v", userID, err)
 		http.Error(w, "Error updating user", http.StatusInternalServerError)
 		return
 	}
+	if result.MatchedCount == 0 {
+		http.Error(w, "The account was changed since it was read", http.StatusPreconditionFailed)
+		return
+	}
 
-	json.NewEncoder(w).Encode(updatedUser)
-}
-
-// DeleteUser deletes the user if they are the account owner
-func DeleteUser(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	userID := params["id"]
+	if updatedUser.Password != "" {
+		if err := revokeUserTokens(r.Context(), userIDObj); err != nil {
+			log.Printf("Error signing out %s after a password change: %v", userID, err)
+			http.Error(w, "Error updating password", http.StatusInternalServerError)
+			return
+		}
+	}
 
-	claims := r.Context().Value("userClaims").(*Claims)
-	requesterEmail := claims.Email
+	recordAudit(r, audit.Event{
+		Action:       audit.ActionAccountUpdate,
+		TargetUserID: userIDObj,
+		Target:       targetUser.Email,
+		Details: map[string]string{
+			"new_email":        updatedUser.Email,
+			"password_changed": strconv.FormatBool(updatedUser.Password != ""),
+		},
+	})
 
-	userIDObj, err := primitive.ObjectIDFromHex(userID)
-	if err != nil {
-		log.Printf("Invalid user ID format: %v", err)
-		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
-		return
+	if updatedUser.Password != "" {
+		sendAccountEmail(mailer.PasswordChanged, targetUser, mailer.LinkData{})
+	}
+	if emailChanged {
+		targetUser.Email = updatedUser.Email
+		targetUser.EmailVerified = false
+		if err := sendVerificationEmail(r.Context(), targetUser); err != nil {
+			log.Printf("Error sending verification email to %s: %v", targetUser.Email, err)
+		}
 	}
 
-	var targetUser models.User
-	err = usersCollection.FindOne(context.Background(), bson.M{"_id": userIDObj}).Decode(&targetUser)
-	if err != nil {
-		log.Printf("User not found for ID %s: %v", userID, err)
-		http.Error(w, "User not found", http.StatusNotFound)
-		return
+	if updatedUser.FirstName != "" {
+		targetUser.FirstName = updatedUser.FirstName
+	}
+	if updatedUser.Surname != "" {
+		targetUser.Surname = updatedUser.Surname
 	}
+	if updatedUser.Birthdate != "" {
+		targetUser.Birthdate = updatedUser.Birthdate
+	}
+	targetUser.Revision++
+	w.Header().Set("ETag", userETag(targetUser))
+	json.NewEncoder(w).Encode(newUserProfile(targetUser))
+}
 
-	if requesterEmail != targetUser.Email {
-		log.Printf("Unauthorized delete attempt by %s on account %s", requesterEmail, targetUser.Email)
-		http.Error(w, "You are not authorized to delete this account", http.StatusForbidden)
+// DeleteUser deletes the user; RequireAccountOwner has already checked ownership
+func DeleteUser(w http.ResponseWriter, r *http.Request) {
+	targetUser, ok := targetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
 		return
 	}
+	userID := targetUser.ID.Hex()
+	userIDObj := targetUser.ID
 
 	result, err := usersCollection.DeleteOne(context.Background(), bson.M{"_id": userIDObj})
 	if err != nil || result.DeletedCount == 0 {
@@ -279,11 +537,33 @@ func DeleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	removeMemberships(r.Context(), userIDObj)
+
+	recordAudit(r, audit.Event{
+		Action:       audit.ActionAccountDelete,
+		TargetUserID: userIDObj,
+		Target:       targetUser.Email,
+	})
+
 	json.NewEncoder(w).Encode(map[string]string{"message": "User deleted"})
 }
 
 // UploadFile allows a user to upload a PDF file with version control
 func UploadFile(w http.ResponseWriter, r *http.Request) {
+	userIDObj, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		log.Printf("Invalid user ID format: %v", err)
+		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+		return
+	}
+	storeUpload(w, r, userIDObj, activeTenant(r), "")
+}
+
+// storeUpload saves the "contract" form file as the next version of the owner's file in
+// workspace orgID and writes the response. filename is the file being updated, or empty
+// to use the uploaded file's name. With check_in=true the caller's check-out of the file is
+// released once the version is stored.
+func storeUpload(w http.ResponseWriter, r *http.Request, ownerID, orgID primitive.ObjectID, filename string) {
 	err := r.ParseMultipartForm(10 << 20)
 	if err != nil {
 		log.Printf("Error parsing form: %v", err)
@@ -299,73 +579,71 @@ func UploadFile(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	params := mux.Vars(r)
-	userID := params["id"]
-	userIDObj, err := primitive.ObjectIDFromHex(userID)
-	if err != nil {
-		log.Printf("Invalid user ID format: %v", err)
-		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
-		return
+	if filename == "" {
+		filename = strings.ReplaceAll(handler.Filename, " ", "_")
 	}
 
-	filename := strings.ReplaceAll(handler.Filename, " ", "_")
-	filePath := "./uploads/" + filename
-
-	dst, err := os.Create(filePath)
-	if err != nil {
-		log.Printf("Error creating file on disk: %v", err)
-		http.Error(w, "Unable to create file", http.StatusInternalServerError)
+	expected, ok := expectedVersion(w, r, ownerID, orgID, filename, r.FormValue("expected_version"))
+	if !ok {
 		return
 	}
-	defer dst.Close()
 
-	_, err = io.Copy(dst, file)
-	if err != nil {
-		log.Printf("Error saving file to disk: %v", err)
-		http.Error(w, "Error saving file", http.StatusInternalServerError)
+	newDoc, ok := saveUploadedVersion(w, r, ownerID, orgID, filename, r.FormValue("note"), expected, file)
+	if !ok {
 		return
 	}
-
-	var lastDoc models.Document
-	cursor, err := documentsCollection.Find(context.Background(), bson.M{
-		"user_id":  userIDObj,
-		"filename": filename,
-	}, options.Find().SetSort(bson.D{{"version", -1}}).SetLimit(1))
-	if err != nil {
-		log.Printf("Error finding latest version for file %s: %v", filename, err)
-		http.Error(w, "Error finding latest file version", http.StatusInternalServerError)
-		return
+	if checkIn, _ := strconv.ParseBool(r.FormValue("check_in")); checkIn {
+		checkInAfterUpload(r, ownerID, orgID, filename)
 	}
-	defer cursor.Close(context.Background())
 
-	newVersion := 1
-	if cursor.Next(context.Background()) {
-		if err := cursor.Decode(&lastDoc); err != nil {
-			log.Printf("Error decoding document: %v", err)
-			http.Error(w, "Error decoding document", http.StatusInternalServerError)
-			return
-		}
-		newVersion = lastDoc.Version + 1
-	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "File uploaded", "filename": filename, "version": fmt.Sprint(newDoc.Version), "id": newDoc.ID.Hex(), "validation_flags": newDoc.ValidationFlags})
+}
 
-	newDoc := models.Document{
-		ID:         primitive.NewObjectID(),
-		UserID:     userIDObj,
-		Filename:   filename,
-		Version:    newVersion,
-		UploadDate: time.Now(),
-	}
-	_, err = documentsCollection.InsertOne(context.Background(), newDoc)
+// saveUploadedVersion validates uploaded content and records it as the next version of the
+// owner's file in workspace orgID, provided the latest version is still expected (when not
+// nil). When the content is refused it answers the request and returns false.
+func saveUploadedVersion(w http.ResponseWriter, r *http.Request, ownerID, orgID primitive.ObjectID, filename, note string, expected *int, file io.Reader) (*models.Document, bool) {
+	content, flags, ok := validateUpload(w, r, file, filename)
+	if !ok {
+		return nil, false
+	}
+	defer content.Close()
+
+	uploadedBy := ""
+	if claims, ok := claimsFromContext(r); ok {
+		uploadedBy = claims.Email
+	}
+	uploaderID, _ := callerID(r)
+
+	newDoc, err := createDocumentVersion(r.Context(), versionUpload{
+		UserID:          ownerID,
+		OrgID:           orgID,
+		Filename:        filename,
+		UploadedBy:      uploadedBy,
+		UploaderID:      uploaderID,
+		ChangeNote:      note,
+		ContentType:     "application/pdf",
+		Flags:           flags,
+		Content:         content.Reader(),
+		Size:            content.size,
+		ExpectedVersion: expected,
+	})
 	if err != nil {
-		log.Printf("Error creating document entry: %v", err)
-		http.Error(w, "Error creating document entry", http.StatusInternalServerError)
-		return
+		writeVersionError(w, r, err, filename)
+		return nil, false
 	}
 
-	json.NewEncoder(w).Encode(map[string]string{"message": "File uploaded", "filename": filename, "version": fmt.Sprint(newVersion)})
+	recordAudit(r, audit.Event{
+		Action:       audit.ActionDocumentUpload,
+		TargetUserID: newDoc.UserID,
+		DocumentID:   newDoc.ID,
+		Target:       newDoc.Filename,
+		Details:      uploadDetails(newDoc),
+	})
+	return newDoc, true
 }
 
-// DownloadFile allows a user to download a file by filename
+// DownloadFile allows a user to download the latest version of a file by filename
 func DownloadFile(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	encodedFilename := params["filename"]
@@ -375,17 +653,25 @@ func DownloadFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	filePath := "./uploads/" + filename
-	file, err := os.Open(filePath)
+	userIDObj, err := primitive.ObjectIDFromHex(params["id"])
 	if err != nil {
+		log.Printf("Invalid user ID format: %v", err)
+		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+		return
+	}
+
+	doc, err := findLatestDocument(r.Context(), userIDObj, activeTenant(r), filename)
+	if err != nil {
+		log.Printf("Error finding latest version for file %s: %v", filename, err)
+		http.Error(w, "Error finding file", http.StatusInternalServerError)
+		return
+	}
+	if doc == nil {
 		http.Error(w, "File not found", http.StatusNotFound)
 		return
 	}
-	defer file.Close()
 
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
-	w.Header().Set("Content-Type", "application/pdf")
-	io.Copy(w, file)
+	serveDocument(w, r, doc, "attachment")
 }
 
 // DeleteFile allows a user to delete a file by filename
@@ -407,10 +693,10 @@ func DeleteFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cursor, err := documentsCollection.Find(context.Background(), bson.M{
+	cursor, err := documentsCollection.Find(context.Background(), inTenant(bson.M{
 		"user_id":  userIDObj,
 		"filename": filename,
-	})
+	}, activeTenant(r)))
 	if err != nil {
 		log.Printf("Error retrieving file versions for deletion: %v", err)
 		http.Error(w, "Error retrieving file versions", http.StatusInternalServerError)
@@ -426,9 +712,9 @@ func DeleteFile(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
-		filePath := "./uploads/" + doc.Filename
-		if err := os.Remove(filePath); err != nil {
-			log.Printf("Error deleting file from disk: %v", err)
+		// Unmigrated versions may share a legacy blob, so a key that is already gone is not an error
+		if err := fileStorage.Delete(r.Context(), doc.BlobKey()); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Error deleting file from storage: %v", err)
 			deletionErrors = append(deletionErrors, fmt.Sprintf("Error deleting version %d", doc.Version))
 		}
 
@@ -436,17 +722,70 @@ func DeleteFile(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			log.Printf("Error deleting file from database: %v", err)
 			deletionErrors = append(deletionErrors, fmt.Sprintf("Error removing version %d from database", doc.Version))
+			continue
 		}
+
+		recordAudit(r, audit.Event{
+			Action:       audit.ActionDocumentDelete,
+			TargetUserID: doc.UserID,
+			DocumentID:   doc.ID,
+			Target:       doc.Filename,
+			Details:      map[string]string{"version": strconv.Itoa(doc.Version)},
+		})
 	}
 
 	if len(deletionErrors) > 0 {
 		http.Error(w, strings.Join(deletionErrors, "; "), http.StatusInternalServerError)
 	} else {
+		deleteFileShares(r.Context(), userIDObj, activeTenant(r), filename)
+		deleteFileLock(r.Context(), userIDObj, activeTenant(r), filename)
 		json.NewEncoder(w).Encode(map[string]string{"message": "File deleted successfully"})
 	}
 }
 
-// GetUsersOrSearch fetches and searches users with pagination
+// documentStorageKey returns the blob key for a single document version
+func documentStorageKey(userID, documentID primitive.ObjectID) string {
+	return "documents/" + userID.Hex() + "/" + documentID.Hex()
+}
+
+// findLatestDocument returns the highest version of a user's file in the workspace orgID
+// (zero for the personal workspace), or nil if none exists
+func findLatestDocument(ctx context.Context, userID, orgID primitive.ObjectID, filename string) (*models.Document, error) {
+	var doc models.Document
+	err := documentsCollection.FindOne(ctx, inTenant(bson.M{
+		"user_id":  userID,
+		"filename": filename,
+	}, orgID), options.FindOne().SetSort(bson.D{{Key: "version", Value: -1}})).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// discardBlob removes a blob whose document entry could not be created
+func discardBlob(key string) {
+	if err := fileStorage.Delete(context.Background(), key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		log.Printf("Error removing orphaned blob %s: %v", key, err)
+	}
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// GetUsersOrSearch fetches and searches users with pagination for callers with users:read,
+// without password hashes
 func GetUsersOrSearch(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -485,21 +824,36 @@ func GetUsersOrSearch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	json.NewEncoder(w).Encode(users)
+	json.NewEncoder(w).Encode(adminUsers(users))
 }
 
-// GenerateJWT generates a JWT token for authenticated users
+// GenerateJWT generates a short-lived access token for authenticated users
 func GenerateJWT(user *models.User) (string, error) {
-	expirationTime := time.Now().Add(1 * time.Hour)
+	return generateAccessToken(user, time.Time{})
+}
+
+// generateAccessToken issues an access token; mfaAt records when the user last completed
+// two-factor verification and is omitted when zero
+func generateAccessToken(user *models.User, mfaAt time.Time) (string, error) {
+	now := time.Now()
 	claims := &Claims{
-		Email: user.Email,
+		Email:        user.Email,
+		TokenVersion: user.TokenVersion,
+		Roles:        user.EffectiveRoles(),
+		Permissions:  user.Permissions(),
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			ID:        primitive.NewObjectID().Hex(),
+			Issuer:    tokenKeys.Issuer(),
+			Subject:   user.ID.Hex(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(accessTokenTTL())),
 		},
 	}
+	if !mfaAt.IsZero() {
+		claims.MFAAt = jwt.NewNumericDate(mfaAt)
+	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtKey)
+	return tokenKeys.Sign(claims)
 }
 
 // LoginUser logs in a user and generates a JWT
@@ -515,33 +869,87 @@ func LoginUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	guard, ok := beginLogin(w, r, loginData.Email)
+	if !ok {
+		return
+	}
+	defer guard.release(r.Context())
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	// Unknown emails and wrong passwords get the same response after the same amount of
+	// work, so the login form cannot be used to find out which accounts exist
 	var foundUser models.User
 	err = usersCollection.FindOne(ctx, bson.M{"email": loginData.Email}).Decode(&foundUser)
-	if err != nil {
-		log.Printf("Login failed: user with email %s not found", loginData.Email)
-		http.Error(w, "User not found", http.StatusUnauthorized)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		log.Printf("Error looking up user %s: %v", loginData.Email, err)
+		http.Error(w, "Error logging in", http.StatusInternalServerError)
 		return
 	}
+	var candidate *models.User
+	if err == nil {
+		candidate = &foundUser
+	}
 
-	err = foundUser.CheckPassword(loginData.Password)
-	if err != nil {
-		log.Printf("Login failed: invalid password for user %s. Error: %v", foundUser.Email, err)
-		http.Error(w, "Invalid password", http.StatusUnauthorized)
+	if !checkLoginPassword(candidate, loginData.Password) {
+		guard.fail(ctx)
+		event := audit.Event{
+			Action:  audit.ActionLoginFailed,
+			Actor:   loginData.Email,
+			Target:  loginData.Email,
+			Details: map[string]string{"reason": "unknown_user"},
+		}
+		if candidate != nil {
+			log.Printf("Login failed: invalid password for user %s", foundUser.Email)
+			event.ActorID, event.TargetUserID = foundUser.ID, foundUser.ID
+			event.Details["reason"] = "invalid_password"
+		} else {
+			log.Printf("Login failed: user with email %s not found", loginData.Email)
+		}
+		recordAudit(r, event)
+		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
 		return
 	}
 
-	token, err := GenerateJWT(&foundUser)
+	// Enrolled users must complete a second factor at /login/mfa before getting tokens
+	if foundUser.MFAEnabled {
+		challenge, err := generateMFAChallenge(&foundUser)
+		if err != nil {
+			log.Printf("Error generating MFA challenge for user %s: %v", foundUser.Email, err)
+			http.Error(w, "Error generating token", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":      "Two-factor authentication required",
+			"mfa_required": true,
+			"mfa_token":    challenge,
+		})
+		return
+	}
+
+	session, err := issueSession(ctx, &foundUser, nil, time.Time{})
+	if errors.Is(err, errAccountDisabled) {
+		log.Printf("Login refused: account %s is disabled", foundUser.Email)
+		http.Error(w, "This account has been disabled", http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		log.Printf("Error generating token for user %s: %v", foundUser.Email, err)
 		http.Error(w, "Error generating token", http.StatusInternalServerError)
 		return
 	}
+	guard.succeed(ctx)
 
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Login successful",
-		"token":   token,
+	recordAudit(r, audit.Event{
+		Action:       audit.ActionLogin,
+		Actor:        foundUser.Email,
+		ActorID:      foundUser.ID,
+		TargetUserID: foundUser.ID,
+		Target:       foundUser.Email,
 	})
+
+	session["message"] = "Login successful"
+	json.NewEncoder(w).Encode(session)
 }
@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"DocuDefense/backend/src/models"
+	"DocuDefense/backend/src/storage"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryStorage is an in-memory storage.Storage
+type memoryStorage struct {
+	mu    sync.Mutex
+	blobs map[string][]byte
+}
+
+func (s *memoryStorage) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blobs[key] = data
+	return nil
+}
+
+func (s *memoryStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.blobs[key]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *memoryStorage) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.blobs[key]; !ok {
+		return storage.ErrNotFound
+	}
+	delete(s.blobs, key)
+	return nil
+}
+
+func (s *memoryStorage) Stat(ctx context.Context, key string) (storage.ObjectInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.blobs[key]
+	if !ok {
+		return storage.ObjectInfo{}, storage.ErrNotFound
+	}
+	return storage.ObjectInfo{Key: key, Size: int64(len(data))}, nil
+}
+
+func (s *memoryStorage) List(ctx context.Context, prefix string) ([]storage.ObjectInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var objects []storage.ObjectInfo
+	for key, data := range s.blobs {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, storage.ObjectInfo{Key: key, Size: int64(len(data))})
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+// useMemoryStorage moves the fixture's blobs into memory for the rest of the test
+func (f *authFixture) useMemoryStorage(t *testing.T) *memoryStorage {
+	t.Helper()
+	mem := &memoryStorage{blobs: map[string][]byte{"documents/alice/contract": []byte("%PDF-1.7")}}
+	prev := fileStorage
+	fileStorage = mem
+	t.Cleanup(func() { fileStorage = prev })
+	return mem
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// seedIntegrityCases stores one version of alice's in each integrity state and returns
+// the status each file should be reported with
+func (f *authFixture) seedIntegrityCases(t *testing.T, mem *memoryStorage) map[string]string {
+	t.Helper()
+	f.docs.UpdateOne(context.Background(), bson.M{"filename": "contract.pdf"}, bson.M{"$set": bson.M{"sha256": sha256Hex("%PDF-1.7")}})
+	for _, c := range []struct {
+		filename, blob, sha string
+	}{
+		{"altered.pdf", "%PDF-1.7 altered", sha256Hex("%PDF-1.7")},
+		{"missing.pdf", "", sha256Hex("%PDF-1.7")},
+		{"legacy.pdf", "%PDF-1.4", ""},
+	} {
+		key := "documents/alice/" + c.filename
+		if c.blob != "" {
+			mem.blobs[key] = []byte(c.blob)
+		}
+		f.docs.InsertOne(context.Background(), models.Document{
+			ID: primitive.NewObjectID(), UserID: f.alice.ID, Filename: c.filename, Version: 1,
+			UploadDate: time.Now(), StorageKey: key, SHA256: c.sha,
+		})
+	}
+	return map[string]string{
+		"contract.pdf": IntegrityOK,
+		"altered.pdf":  IntegrityMismatch,
+		"missing.pdf":  IntegrityMissing,
+		"legacy.pdf":   IntegrityUnverified,
+	}
+}
+
+func TestIntegrityScanReportsEachStatus(t *testing.T) {
+	f := newAuthFixture(t)
+	access, _ := f.login(t)
+	mem := f.useMemoryStorage(t)
+	want := f.seedIntegrityCases(t, mem)
+
+	// An encrypted version whose ciphertext was altered fails authentication
+	useKeyring(t, map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}, "k1")
+	if rec := f.upload(t, access, "sealed.pdf", testPDF("")); rec.Code != http.StatusOK {
+		t.Fatalf("upload: got %d %q", rec.Code, rec.Body.String())
+	}
+	sealedKey := f.docs.matching(bson.M{"filename": "sealed.pdf"})[0]["storage_key"].(string)
+	mem.blobs[sealedKey][len(mem.blobs[sealedKey])-1] ^= 1
+	want["sealed.pdf"] = IntegrityMismatch
+
+	report, err := ScanAllDocuments(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != 5 || report.OK != 1 || report.Unverified != 1 || len(report.Problems) != 3 {
+		t.Fatalf("got %+v", report)
+	}
+	for _, problem := range report.Problems {
+		if problem.Status != want[problem.Filename] {
+			t.Fatalf("%s: got %s, want %s", problem.Filename, problem.Status, want[problem.Filename])
+		}
+	}
+
+	// Users get the same report for their own files
+	rec := f.send(t, "GET", "/users/"+f.alice.ID.Hex()+"/files/integrity", access, "")
+	var userReport IntegrityReport
+	json.NewDecoder(rec.Body).Decode(&userReport)
+	if rec.Code != http.StatusOK || userReport.Checked != 5 || len(userReport.Problems) != 3 {
+		t.Fatalf("user scan: got %d %+v", rec.Code, userReport)
+	}
+	for filename, status := range want {
+		rec := f.send(t, "GET", "/users/"+f.alice.ID.Hex()+"/files/"+filename+"/versions/1/verify", access, "")
+		var result IntegrityResult
+		json.NewDecoder(rec.Body).Decode(&result)
+		if rec.Code != http.StatusOK || result.Status != status {
+			t.Fatalf("verify %s: got %d %+v, want %s", filename, rec.Code, result, status)
+		}
+	}
+}
+
+func TestIntegrityScanContinuesPastUnreadableVersions(t *testing.T) {
+	f := newAuthFixture(t)
+	mem := f.useMemoryStorage(t)
+	want := f.seedIntegrityCases(t, mem)
+
+	// Sealed with a master key that is no longer configured
+	useKeyring(t, map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}, "k1")
+	mem.blobs["documents/alice/retired.pdf"] = []byte("sealed")
+	f.docs.InsertOne(context.Background(), models.Document{
+		ID: primitive.NewObjectID(), UserID: f.alice.ID, Filename: "retired.pdf", Version: 1, UploadDate: time.Now(),
+		StorageKey: "documents/alice/retired.pdf", SHA256: sha256Hex("%PDF-1.7"),
+		EncryptionKeyID: "k0", WrappedDataKey: []byte("wrapped"),
+	})
+	want["retired.pdf"] = IntegrityError
+
+	report, err := ScanAllDocuments(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != 5 || report.OK != 1 || report.Unverified != 1 || len(report.Problems) != 3 {
+		t.Fatalf("got %+v", report)
+	}
+	for _, problem := range report.Problems {
+		if problem.Status != want[problem.Filename] {
+			t.Fatalf("%s: got %s, want %s", problem.Filename, problem.Status, want[problem.Filename])
+		}
+	}
+}
+
+func TestDownloadsFollowTheIntegrityPolicy(t *testing.T) {
+	f := newAuthFixture(t)
+	access, _ := f.login(t)
+	f.seedIntegrityCases(t, f.useMemoryStorage(t))
+	download := func(filename string) *httptest.ResponseRecorder {
+		return f.download(t, "/users/"+f.alice.ID.Hex()+"/files/"+filename+"/download", access, nil)
+	}
+
+	for filename, want := range map[string]struct {
+		code   int
+		status string
+	}{
+		"contract.pdf": {http.StatusOK, IntegrityOK},
+		"legacy.pdf":   {http.StatusOK, IntegrityUnverified},
+		"altered.pdf":  {http.StatusInternalServerError, IntegrityMismatch},
+		"missing.pdf":  {http.StatusNotFound, ""},
+	} {
+		rec := download(filename)
+		if rec.Code != want.code || rec.Header().Get("X-Integrity-Status") != want.status {
+			t.Fatalf("%s: got %d %q, want %d %q", filename, rec.Code, rec.Header().Get("X-Integrity-Status"), want.code, want.status)
+		}
+	}
+
+	t.Setenv("INTEGRITY_POLICY", "flag")
+	rec := download("altered.pdf")
+	if rec.Code != http.StatusOK || rec.Body.String() != "%PDF-1.7 altered" || rec.Header().Get("X-Integrity-Status") != IntegrityMismatch || rec.Header().Get("ETag") != "" {
+		t.Fatalf("flagged download: got %d %v", rec.Code, rec.Header())
+	}
+}
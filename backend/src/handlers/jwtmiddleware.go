@@ -2,6 +2,8 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
+	"log"
 	"net/http"
 	"strings"
 	"time"
@@ -11,10 +13,33 @@ import (
 
 // Claims defines the structure for JWT claims
 type Claims struct {
-	Email string `json:"email"`
+	Email        string `json:"email"`
+	TokenVersion int    `json:"tv"`
+
+	// Set on special-purpose tokens such as MFA challenges, which are not access tokens
+	Purpose string `json:"purpose,omitempty"`
+
+	// When the user last completed two-factor verification, if ever in this session
+	MFAAt *jwt.NumericDate `json:"mfa_at,omitempty"`
+
+	// Roles and the permissions they grant when the token was issued. A role change bumps
+	// the token version, so these are never staler than the token itself.
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+
 	jwt.RegisteredClaims
 }
 
+// HasPermission reports whether the token grants permission
+func (c *Claims) HasPermission(permission string) bool {
+	for _, p := range c.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
 // JWTAuthMiddleware validates the JWT token and attaches the claims to the request context
 func JWTAuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -31,17 +56,20 @@ func JWTAuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		// The key set picks the verification key from the token's "kid" header and
+		// rejects any algorithm that does not match that key
 		claims := &Claims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			// Ensure that the signing method is the same as the one used for signing
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, http.ErrNotSupported
-			}
-			return jwtKey, nil // Ensure this key is the same as the one used to sign the token
-		})
+		token, err := tokenKeys.Parse(tokenString, claims)
 
 		// Check if there was an error parsing the token or if it's invalid
-		if err != nil || !token.Valid || time.Now().After(claims.ExpiresAt.Time) {
+		if err != nil || !token.Valid || claims.ExpiresAt == nil || time.Now().After(claims.ExpiresAt.Time) || claims.Purpose != "" {
+			http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+			return
+		}
+
+		// Reject tokens revoked by logout or invalidated by a token version bump
+		if err := validateSession(r.Context(), claims); err != nil {
+			log.Printf("Rejected token for %s: %v", claims.Email, err)
 			http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
 			return
 		}
@@ -51,3 +79,11 @@ func JWTAuthMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// GetJWKS publishes the public keys that verify access tokens, so other services can
+// validate DocuDefense tokens without a shared secret
+func GetJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(tokenKeys.JWKS())
+}
@@ -0,0 +1,359 @@
+package handlers
+
+import (
+	"DocuDefense/backend/src/audit"
+	"DocuDefense/backend/src/models"
+	"context"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// Failures allowed before attempts are slowed down, so a typo or two costs nothing
+	freeLoginFailures = 2
+
+	// Longest delay forced between two failed attempts before the lockout threshold is reached
+	maxLoginDelay = 30 * time.Second
+)
+
+// Recent failed logins per account and per client IP
+var loginAttemptsCollection DatabaseCollection
+
+// maxAccountFailures is how many failures within the window lock an account, 5 unless
+// LOGIN_MAX_FAILURES is set
+func maxAccountFailures() int {
+	return intFromEnv("LOGIN_MAX_FAILURES", 5)
+}
+
+// maxIPFailures is how many failures within the window lock out a client IP, 50 unless
+// LOGIN_MAX_IP_FAILURES is set. It is higher than the account limit because many users
+// can share an address behind NAT.
+func maxIPFailures() int {
+	return intFromEnv("LOGIN_MAX_IP_FAILURES", 50)
+}
+
+// lockoutDuration is how long a lockout lasts, 15 minutes unless LOGIN_LOCKOUT_DURATION is set
+func lockoutDuration() time.Duration {
+	return durationFromEnv("LOGIN_LOCKOUT_DURATION", 15*time.Minute)
+}
+
+// failureWindow is how long a failure counts towards a lockout, 15 minutes unless
+// LOGIN_FAILURE_WINDOW is set
+func failureWindow() time.Duration {
+	return durationFromEnv("LOGIN_FAILURE_WINDOW", 15*time.Minute)
+}
+
+func intFromEnv(name string, fallback int) int {
+	if value := os.Getenv(name); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			return n
+		}
+		log.Printf("Ignoring invalid %s=%q", name, value)
+	}
+	return fallback
+}
+
+// ensureLoginAttemptIndexes lets failure records age out once they no longer matter
+func ensureLoginAttemptIndexes(ctx context.Context) error {
+	_, err := mongoDatabase.Collection("login_attempts").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	// Hash the dummy password now so the first unknown-email login is not slower than the rest
+	dummyPasswordHash()
+	return err
+}
+
+// loginDelay is the wait before the next attempt after the given number of failures:
+// nothing for the first freeLoginFailures, then 1s, 2s, 4s, ... up to maxLoginDelay
+func loginDelay(failures int) time.Duration {
+	n := failures - freeLoginFailures
+	if n < 1 {
+		return 0
+	}
+	if n > 6 {
+		return maxLoginDelay
+	}
+	if delay := time.Second << (n - 1); delay < maxLoginDelay {
+		return delay
+	}
+	return maxLoginDelay
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// dummyPasswordHash returns a bcrypt hash with the same cost as real passwords
+func dummyPasswordHash() string {
+	dummyHashOnce.Do(func() {
+		var u models.User
+		if err := u.HashPassword("docudefense-timing-equalizer"); err != nil {
+			log.Printf("Error hashing dummy password: %v", err)
+		}
+		dummyHash = u.Password
+	})
+	return dummyHash
+}
+
+// checkLoginPassword verifies password for user, which is nil when no account has the
+// submitted email. Unknown accounts and accounts without a password still pay for a
+// bcrypt comparison so response times do not reveal which emails are registered.
+func checkLoginPassword(user *models.User, password string) bool {
+	if user == nil || user.Password == "" {
+		(&models.User{Password: dummyPasswordHash()}).CheckPassword(password)
+		return false
+	}
+	return user.CheckPassword(password) == nil
+}
+
+// loginGuard records the outcome of one login attempt against the account and client IP.
+// The attempt is counted as a failure before the secret is checked and given back if it
+// turns out not to be one.
+type loginGuard struct {
+	r          *http.Request
+	accountKey string
+	ipKey      string
+	reserved   map[string]reservation
+	settled    bool
+}
+
+// reservation is an attempt counted against a key, with what it overwrote
+type reservation struct {
+	at          time.Time
+	lastFailure time.Time
+}
+
+func loginAccountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// beginLogin refuses the attempt with 429 Too Many Requests while the account or client IP
+// is locked out or still waiting out its delay. Otherwise it returns a guard that must be
+// told whether the attempt failed or succeeded, and released once the request is over.
+func beginLogin(w http.ResponseWriter, r *http.Request, email string) (*loginGuard, bool) {
+	return beginGuardedAttempt(w, r, loginAccountKey(email))
+}
+
+// beginGuardedAttempt is beginLogin for any secret guessed against key, such as the
+// password of a share link. Failures also count against the client IP's login limit.
+func beginGuardedAttempt(w http.ResponseWriter, r *http.Request, key string) (*loginGuard, bool) {
+	guard := &loginGuard{r: r, accountKey: key, ipKey: "ip:" + clientIP(r), reserved: map[string]reservation{}}
+
+	wait := guard.reserve(r.Context(), guard.accountKey, maxAccountFailures(), true)
+	if wait == 0 {
+		if wait = guard.reserve(r.Context(), guard.ipKey, maxIPFailures(), false); wait > 0 {
+			guard.release(r.Context())
+		}
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, "Too many failed login attempts. Try again later.", http.StatusTooManyRequests)
+		return nil, false
+	}
+	return guard, true
+}
+
+// reserve counts the attempt as a failure against key before the secret is checked, in a
+// single update, so a burst of concurrent guesses cannot all pass the limit before the
+// first of them fails. It returns how long to wait when the attempt is refused; a refused
+// attempt is not counted.
+func (g *loginGuard) reserve(ctx context.Context, key string, limit int, progressive bool) time.Duration {
+	for {
+		now := time.Now()
+
+		// Failures older than the window no longer count
+		_, err := loginAttemptsCollection.UpdateOne(ctx, bson.M{
+			"_id":          key,
+			"last_failure": bson.M{"$lt": now.Add(-failureWindow())},
+		}, bson.M{"$set": bson.M{"failures": 0}})
+		var prev models.LoginAttempt
+		if err == nil {
+			err = loginAttemptsCollection.FindOneAndUpdate(ctx, bson.M{"_id": key}, bson.M{
+				"$inc": bson.M{"failures": 1},
+				"$set": bson.M{"last_failure": now, "expires_at": now.Add(failureWindow() + lockoutDuration())},
+			}, options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before)).Decode(&prev)
+		}
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			// First attempt against the key
+			g.reserved[key] = reservation{at: now}
+			return 0
+		}
+		if err != nil {
+			log.Printf("Error reserving login attempt for %s: %v", key, err)
+			return 0
+		}
+
+		if prev.LockedUntil != nil && !now.Before(*prev.LockedUntil) {
+			// Clears the count, this attempt included, so count it again
+			g.unlock(ctx, key, "expired")
+			continue
+		}
+
+		var wait time.Duration
+		if prev.LockedUntil != nil {
+			wait = prev.LockedUntil.Sub(now)
+		}
+		if now.Before(prev.NextAttemptAt) {
+			wait = maxDuration(wait, prev.NextAttemptAt.Sub(now))
+		}
+		// Attempts still in progress count as failures until they are settled
+		if progressive {
+			if until := prev.LastFailure.Add(loginDelay(prev.Failures)); now.Before(until) {
+				wait = maxDuration(wait, until.Sub(now))
+			}
+		}
+		if prev.Failures >= limit {
+			wait = maxDuration(wait, time.Second)
+		}
+
+		g.reserved[key] = reservation{at: now, lastFailure: prev.LastFailure}
+		if wait > 0 {
+			g.unreserve(ctx, key)
+		}
+		return wait
+	}
+}
+
+// unreserve gives back an attempt counted against key, restoring the time of the last
+// failure unless another attempt has been counted since
+func (g *loginGuard) unreserve(ctx context.Context, key string) {
+	res, ok := g.reserved[key]
+	if !ok {
+		return
+	}
+	delete(g.reserved, key)
+
+	result, err := loginAttemptsCollection.UpdateOne(ctx, bson.M{"_id": key, "last_failure": res.at}, bson.M{
+		"$inc": bson.M{"failures": -1},
+		"$set": bson.M{"last_failure": res.lastFailure},
+	})
+	if err == nil && result.MatchedCount == 0 {
+		_, err = loginAttemptsCollection.UpdateOne(ctx, bson.M{"_id": key, "failures": bson.M{"$gt": 0}}, bson.M{
+			"$inc": bson.M{"failures": -1},
+		})
+	}
+	if err != nil {
+		log.Printf("Error releasing login attempt for %s: %v", key, err)
+	}
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// fail settles the attempt as a failure against the account and the IP and locks either
+// one out once it reaches its limit. Only the account's attempts are slowed down
+// progressively, so one user's typos do not hold up everyone else behind the same address.
+func (g *loginGuard) fail(ctx context.Context) {
+	g.settled = true
+	g.recordFailure(ctx, g.accountKey, maxAccountFailures(), true)
+	g.recordFailure(ctx, g.ipKey, maxIPFailures(), false)
+}
+
+func (g *loginGuard) recordFailure(ctx context.Context, key string, limit int, progressive bool) {
+	now := time.Now()
+
+	// The failure was counted when the attempt was reserved
+	var attempt models.LoginAttempt
+	if err := loginAttemptsCollection.FindOne(ctx, bson.M{"_id": key}).Decode(&attempt); err != nil {
+		log.Printf("Error recording failed login for %s: %v", key, err)
+		return
+	}
+
+	update := bson.M{"next_attempt_at": now}
+	if progressive {
+		update["next_attempt_at"] = now.Add(loginDelay(attempt.Failures))
+	}
+	if attempt.Failures >= limit && attempt.LockedUntil == nil {
+		until := now.Add(lockoutDuration())
+		update["locked_until"] = until
+		log.Printf("Login lockout: %s locked until %s after %d failed attempts", key, until.Format(time.RFC3339), attempt.Failures)
+		recordAudit(g.r, audit.Event{
+			Action:  audit.ActionLoginLocked,
+			Actor:   "system",
+			Target:  key,
+			Details: map[string]string{"failures": strconv.Itoa(attempt.Failures), "locked_until": until.Format(time.RFC3339)},
+		})
+	}
+	if _, err := loginAttemptsCollection.UpdateOne(ctx, bson.M{"_id": key}, bson.M{"$set": update}); err != nil {
+		log.Printf("Error recording failed login for %s: %v", key, err)
+	}
+}
+
+// succeed clears the account's failure count. The IP's earlier failures are left to expire
+// so one valid account cannot be used to reset the limit while guessing at others.
+func (g *loginGuard) succeed(ctx context.Context) {
+	g.settled = true
+	if _, err := loginAttemptsCollection.DeleteOne(ctx, bson.M{"_id": g.accountKey}); err != nil {
+		log.Printf("Error clearing failed logins for %s: %v", g.accountKey, err)
+	}
+	delete(g.reserved, g.accountKey)
+	g.unreserve(ctx, g.ipKey)
+}
+
+// release gives back the attempt if it was neither a failure nor a success, such as a
+// correct password still waiting for its second factor or a request that errored. Call it
+// with defer after beginLogin.
+func (g *loginGuard) release(ctx context.Context) {
+	if g.settled {
+		return
+	}
+	g.settled = true
+	g.unreserve(ctx, g.accountKey)
+	g.unreserve(ctx, g.ipKey)
+}
+
+// unlock ends a lockout and starts a fresh failure count
+func (g *loginGuard) unlock(ctx context.Context, key, reason string) {
+	if err := clearLoginAttempts(ctx, key); err != nil {
+		log.Printf("Error unlocking %s: %v", key, err)
+		return
+	}
+	log.Printf("Login lockout: %s unlocked (%s)", key, reason)
+	recordAudit(g.r, audit.Event{
+		Action:  audit.ActionLoginUnlocked,
+		Actor:   "system",
+		Target:  key,
+		Details: map[string]string{"reason": reason},
+	})
+}
+
+func clearLoginAttempts(ctx context.Context, key string) error {
+	_, err := loginAttemptsCollection.DeleteOne(ctx, bson.M{"_id": key})
+	return err
+}
+
+// UnlockLogin lifts a lockout on the account with the given email ahead of time
+func UnlockLogin(ctx context.Context, email string) error {
+	key := loginAccountKey(email)
+	if err := clearLoginAttempts(ctx, key); err != nil {
+		return err
+	}
+	log.Printf("Login lockout: %s unlocked (manual)", key)
+	if auditLog != nil {
+		if err := auditLog.Record(ctx, audit.Event{
+			Action:  audit.ActionLoginUnlocked,
+			Actor:   "system",
+			Target:  key,
+			Details: map[string]string{"reason": "manual"},
+		}); err != nil {
+			log.Printf("Error recording audit event: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// setAlicePassword stores a cheap bcrypt hash so lockout tests do not spend seconds hashing
+func (f *authFixture) setAlicePassword(t *testing.T, password string) {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.users.UpdateOne(context.Background(), bson.M{"_id": f.alice.ID}, bson.M{"$set": bson.M{"password": string(hash)}})
+}
+
+func loginBody(email, password string) string {
+	return `{"email":"` + email + `","password":"` + password + `"}`
+}
+
+func TestLoginErrorsAreUniform(t *testing.T) {
+	f := newAuthFixture(t)
+	f.setAlicePassword(t, "correct horse")
+
+	unknown := f.post(t, "/login", "", loginBody("nobody@example.com", "guess"))
+	wrong := f.post(t, "/login", "", loginBody("alice@example.com", "guess"))
+	if unknown.Code != http.StatusUnauthorized || wrong.Code != unknown.Code {
+		t.Fatalf("got %d for an unknown email and %d for a wrong password", unknown.Code, wrong.Code)
+	}
+	if unknown.Body.String() != wrong.Body.String() {
+		t.Fatalf("responses differ: %q vs %q", unknown.Body.String(), wrong.Body.String())
+	}
+}
+
+func TestEmailLookupDoesNotRevealAccounts(t *testing.T) {
+	f := newAuthFixture(t)
+	access, _ := f.login(t)
+
+	if rec := f.do(t, "GET", "/users/email?email=bob@example.com", nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous lookup: got %d, want 401", rec.Code)
+	}
+	registered := f.send(t, "GET", "/users/email?email=bob@example.com", access, "")
+	unknown := f.send(t, "GET", "/users/email?email=nobody@example.com", access, "")
+	if registered.Code != http.StatusNotFound || registered.Body.String() != unknown.Body.String() {
+		t.Fatalf("looking up other addresses: got %d %q and %d %q", registered.Code, registered.Body.String(), unknown.Code, unknown.Body.String())
+	}
+	if rec := f.send(t, "GET", "/users/email?email=alice@example.com", access, ""); rec.Code != http.StatusOK {
+		t.Fatalf("own address: got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestLoginFailuresAreDelayedThenLocked(t *testing.T) {
+	t.Setenv("LOGIN_MAX_FAILURES", "3")
+	f := newAuthFixture(t)
+	f.setAlicePassword(t, "correct horse")
+
+	for i := 0; i < freeLoginFailures; i++ {
+		if rec := f.post(t, "/login", "", loginBody("alice@example.com", "guess")); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("failure %d: got %d, want 401", i+1, rec.Code)
+		}
+	}
+	if rec := f.post(t, "/login", "", loginBody("alice@example.com", "guess")); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("third failure: got %d, want 401", rec.Code)
+	}
+
+	rec := f.post(t, "/login", "", loginBody("alice@example.com", "correct horse"))
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("correct password while locked: got %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	if err := UnlockLogin(context.Background(), "Alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if rec := f.post(t, "/login", "", loginBody("alice@example.com", "correct horse")); rec.Code != http.StatusOK {
+		t.Fatalf("login after unlock: got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestLoginFailuresAreLimitedPerIP(t *testing.T) {
+	t.Setenv("LOGIN_MAX_IP_FAILURES", "2")
+	f := newAuthFixture(t)
+	f.setAlicePassword(t, "correct horse")
+
+	// Spreading guesses over different accounts still trips the per-IP limit
+	f.post(t, "/login", "", loginBody("carol@example.com", "guess"))
+	f.post(t, "/login", "", loginBody("dave@example.com", "guess"))
+	if rec := f.post(t, "/login", "", loginBody("alice@example.com", "correct horse")); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("login from a locked IP: got %d, want 429", rec.Code)
+	}
+}
+
+func TestGuessesInProgressCountTowardsTheLimit(t *testing.T) {
+	t.Setenv("LOGIN_MAX_FAILURES", "2")
+	f := newAuthFixture(t)
+	f.setAlicePassword(t, "correct horse")
+
+	// A burst of parallel guesses: none has failed yet when the next one starts
+	var guards []*loginGuard
+	for i := 0; i < 5; i++ {
+		req := httptest.NewRequest("POST", "/login", nil)
+		if guard, ok := beginLogin(httptest.NewRecorder(), req, "alice@example.com"); ok {
+			guards = append(guards, guard)
+		}
+	}
+	if len(guards) != 2 {
+		t.Fatalf("%d of 5 concurrent guesses were let through, want 2", len(guards))
+	}
+
+	// Attempts that turn out not to be failures are given back
+	for _, guard := range guards {
+		guard.release(context.Background())
+	}
+	if rec := f.post(t, "/login", "", loginBody("alice@example.com", "correct horse")); rec.Code != http.StatusOK {
+		t.Fatalf("login after the burst was released: got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRepeatedFailuresSlowDownAttempts(t *testing.T) {
+	f := newAuthFixture(t)
+	f.setAlicePassword(t, "correct horse")
+
+	for i := 0; i <= freeLoginFailures; i++ {
+		f.post(t, "/login", "", loginBody("alice@example.com", "guess"))
+	}
+	rec := f.post(t, "/login", "", loginBody("alice@example.com", "guess"))
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Fatalf("attempt inside the delay: got %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
@@ -0,0 +1,379 @@
+package handlers
+
+import (
+	"DocuDefense/backend/src/audit"
+	"DocuDefense/backend/src/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Check-out locks on files
+var locksCollection DatabaseCollection
+
+// lockTTL is how long a check-out lasts when no expiry is requested, 24 hours unless
+// LOCK_TTL is set
+func lockTTL() time.Duration {
+	return durationFromEnv("LOCK_TTL", 24*time.Hour)
+}
+
+// lockMaxTTL is the furthest ahead a check-out may expire, 7 days unless LOCK_MAX_TTL is set
+func lockMaxTTL() time.Duration {
+	return durationFromEnv("LOCK_MAX_TTL", 7*24*time.Hour)
+}
+
+// ensureLockIndexes allows one lock per file and lets MongoDB remove expired ones
+func ensureLockIndexes(ctx context.Context) error {
+	_, err := mongoDatabase.Collection("document_locks").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "owner_id", Value: 1}, {Key: "org_id", Value: 1}, {Key: "filename", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+// lockedError is returned for a new version of a file checked out to someone else
+type lockedError struct {
+	Lock *models.DocumentLock
+}
+
+func (e *lockedError) Error() string {
+	return e.Lock.Filename + " is checked out to " + e.Lock.HolderEmail
+}
+
+// findLock returns the unexpired lock on a file in the workspace orgID, or nil. MongoDB
+// removes expired locks lazily, so they are filtered out here.
+func findLock(ctx context.Context, ownerID, orgID primitive.ObjectID, filename string) (*models.DocumentLock, error) {
+	var lock models.DocumentLock
+	err := locksCollection.FindOne(ctx, inTenant(bson.M{
+		"owner_id":   ownerID,
+		"filename":   filename,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}, orgID)).Decode(&lock)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &lock, nil
+}
+
+// checkLock returns a *lockedError when the file is checked out to anyone but uploaderID
+func checkLock(ctx context.Context, ownerID, orgID primitive.ObjectID, filename string, uploaderID primitive.ObjectID) error {
+	lock, err := findLock(ctx, ownerID, orgID, filename)
+	if err != nil {
+		return err
+	}
+	if lock != nil && !lock.HeldBy(uploaderID) {
+		return &lockedError{Lock: lock}
+	}
+	return nil
+}
+
+// writeLocked refuses a change to a file checked out to someone else
+func writeLocked(w http.ResponseWriter, lock *models.DocumentLock) {
+	message := "The file is checked out to " + lock.HolderEmail + " until " + lock.ExpiresAt.UTC().Format(time.RFC1123)
+	if lock.Reason != "" {
+		message += ": " + lock.Reason
+	}
+	writeRejection(w, http.StatusLocked, uploadRejection{Error: "locked", Message: message, Lock: lock})
+}
+
+func writeLock(w http.ResponseWriter, status int, lock *models.DocumentLock) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(lock)
+}
+
+// checkOutFile locks the file doc belongs to for the caller, with an optional
+// {"reason": "...", "expires_at": "..."}. Checking out a file again extends the lock.
+func checkOutFile(w http.ResponseWriter, r *http.Request, doc *models.Document) {
+	holderID, ok := callerID(r)
+	claims, _ := claimsFromContext(r)
+	if !ok || claims == nil {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+	var body struct {
+		Reason    string     `json:"reason"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid check-out request", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(lockTTL())
+	if body.ExpiresAt != nil {
+		if !body.ExpiresAt.After(now) || body.ExpiresAt.After(now.Add(lockMaxTTL())) {
+			http.Error(w, "Expiry must be in the future and within "+humanDuration(lockMaxTTL()), http.StatusBadRequest)
+			return
+		}
+		expiresAt = *body.ExpiresAt
+	}
+
+	existing, err := findLock(r.Context(), doc.UserID, doc.OrgID, doc.Filename)
+	if err != nil {
+		log.Printf("Error finding lock on %s: %v", doc.Filename, err)
+		http.Error(w, "Error checking out file", http.StatusInternalServerError)
+		return
+	}
+	if existing != nil && !existing.HeldBy(holderID) {
+		writeLocked(w, existing)
+		return
+	}
+
+	lock := models.DocumentLock{
+		ID:          primitive.NewObjectID(),
+		OwnerID:     doc.UserID,
+		OrgID:       doc.OrgID,
+		Filename:    doc.Filename,
+		HolderID:    holderID,
+		HolderEmail: claims.Email,
+		Reason:      body.Reason,
+		CreatedAt:   now,
+		ExpiresAt:   expiresAt,
+	}
+	status := http.StatusCreated
+	if existing != nil {
+		lock.ID, lock.CreatedAt, status = existing.ID, existing.CreatedAt, http.StatusOK
+		_, err = locksCollection.UpdateOne(r.Context(), bson.M{"_id": existing.ID}, bson.M{
+			"$set": bson.M{"reason": lock.Reason, "expires_at": lock.ExpiresAt},
+		})
+	} else {
+		// Clear an expired lock MongoDB has not removed yet
+		_, err = locksCollection.DeleteOne(r.Context(), inTenant(bson.M{
+			"owner_id":   doc.UserID,
+			"filename":   doc.Filename,
+			"expires_at": bson.M{"$lt": now},
+		}, doc.OrgID))
+		if err == nil {
+			_, err = locksCollection.InsertOne(r.Context(), lock)
+		}
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		// Someone else checked the file out first
+		if winner, findErr := findLock(r.Context(), doc.UserID, doc.OrgID, doc.Filename); findErr == nil && winner != nil {
+			writeLocked(w, winner)
+			return
+		}
+	}
+	if err != nil {
+		log.Printf("Error checking out %s: %v", doc.Filename, err)
+		http.Error(w, "Error checking out file", http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r, audit.Event{
+		Action:       audit.ActionDocumentCheckOut,
+		TargetUserID: doc.UserID,
+		Target:       doc.Filename,
+		Details:      map[string]string{"lock_id": lock.ID.Hex(), "reason": lock.Reason, "expires_at": lock.ExpiresAt.Format(time.RFC3339)},
+	})
+	writeLock(w, status, &lock)
+}
+
+// checkInFile releases the caller's lock on the file doc belongs to
+func checkInFile(w http.ResponseWriter, r *http.Request, doc *models.Document) {
+	holderID, ok := callerID(r)
+	if !ok {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+	lock, err := findLock(r.Context(), doc.UserID, doc.OrgID, doc.Filename)
+	if err != nil {
+		log.Printf("Error finding lock on %s: %v", doc.Filename, err)
+		http.Error(w, "Error checking in file", http.StatusInternalServerError)
+		return
+	}
+	if lock == nil {
+		http.Error(w, "File is not checked out", http.StatusNotFound)
+		return
+	}
+	if !lock.HeldBy(holderID) {
+		writeLocked(w, lock)
+		return
+	}
+	if err := releaseLock(r, lock, audit.ActionDocumentCheckIn); err != nil {
+		log.Printf("Error checking in %s: %v", doc.Filename, err)
+		http.Error(w, "Error checking in file", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"message": "File checked in"})
+}
+
+// releaseLock removes a lock and records why in the audit trail
+func releaseLock(r *http.Request, lock *models.DocumentLock, action string) error {
+	if _, err := locksCollection.DeleteOne(r.Context(), bson.M{"_id": lock.ID}); err != nil {
+		return err
+	}
+	recordAudit(r, audit.Event{
+		Action:       action,
+		TargetUserID: lock.OwnerID,
+		Target:       lock.Filename,
+		Details:      map[string]string{"lock_id": lock.ID.Hex(), "holder": lock.HolderEmail},
+	})
+	return nil
+}
+
+// checkInAfterUpload releases the caller's lock on a file they just uploaded a version of
+// with check_in=true
+func checkInAfterUpload(r *http.Request, ownerID, orgID primitive.ObjectID, filename string) {
+	holderID, _ := callerID(r)
+	lock, err := findLock(r.Context(), ownerID, orgID, filename)
+	if err == nil && lock != nil && lock.HeldBy(holderID) {
+		err = releaseLock(r, lock, audit.ActionDocumentCheckIn)
+	}
+	if err != nil {
+		log.Printf("Error checking in %s after upload: %v", filename, err)
+	}
+}
+
+// deleteFileLock removes the lock on a file whose versions were all deleted
+func deleteFileLock(ctx context.Context, ownerID, orgID primitive.ObjectID, filename string) {
+	if _, err := locksCollection.DeleteOne(ctx, inTenant(bson.M{"owner_id": ownerID, "filename": filename}, orgID)); err != nil {
+		log.Printf("Error deleting lock on %s: %v", filename, err)
+	}
+}
+
+// CheckOutFile locks the file named by {filename} so only the caller can add versions
+func CheckOutFile(w http.ResponseWriter, r *http.Request) {
+	latest, ok := resolveSharedFile(w, r)
+	if !ok {
+		return
+	}
+	checkOutFile(w, r, latest)
+}
+
+// CheckInFile releases the caller's lock on the file named by {filename}
+func CheckInFile(w http.ResponseWriter, r *http.Request) {
+	latest, ok := resolveSharedFile(w, r)
+	if !ok {
+		return
+	}
+	checkInFile(w, r, latest)
+}
+
+// GetFileLock returns the lock on the file named by {filename}, if it is checked out
+func GetFileLock(w http.ResponseWriter, r *http.Request) {
+	latest, ok := resolveSharedFile(w, r)
+	if !ok {
+		return
+	}
+	lock, err := findLock(r.Context(), latest.UserID, latest.OrgID, latest.Filename)
+	if err != nil {
+		log.Printf("Error finding lock on %s: %v", latest.Filename, err)
+		http.Error(w, "Error retrieving lock", http.StatusInternalServerError)
+		return
+	}
+	if lock == nil {
+		http.Error(w, "File is not checked out", http.StatusNotFound)
+		return
+	}
+	writeLock(w, http.StatusOK, lock)
+}
+
+// ListLocks returns the current locks on the user's files in the active workspace
+func ListLocks(w http.ResponseWriter, r *http.Request) {
+	targetUser, ok := targetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+	listLocks(w, r, inTenant(bson.M{"owner_id": targetUser.ID}, activeTenant(r)), nil)
+}
+
+// CheckOutSharedDocument locks a shared file for the caller, who must be an editor of the
+// whole file
+func CheckOutSharedDocument(w http.ResponseWriter, r *http.Request) {
+	doc, _, ok := resolveSharedDocument(w, r, models.ShareEditor, true)
+	if !ok {
+		return
+	}
+	checkOutFile(w, r, doc)
+}
+
+// CheckInSharedDocument releases the caller's lock on a shared file
+func CheckInSharedDocument(w http.ResponseWriter, r *http.Request) {
+	doc, _, ok := resolveSharedDocument(w, r, models.ShareEditor, true)
+	if !ok {
+		return
+	}
+	checkInFile(w, r, doc)
+}
+
+// AdminListLocks returns every current lock, or those on one account's files with
+// ?user=<id>, paginated with page and limit
+func AdminListLocks(w http.ResponseWriter, r *http.Request) {
+	filter := bson.M{}
+	if user := r.URL.Query().Get("user"); user != "" {
+		userID, err := primitive.ObjectIDFromHex(user)
+		if err != nil {
+			http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+			return
+		}
+		filter["owner_id"] = userID
+	}
+	listLocks(w, r, filter, paginate(r))
+}
+
+func listLocks(w http.ResponseWriter, r *http.Request, filter bson.M, opts *options.FindOptions) {
+	filter["expires_at"] = bson.M{"$gt": time.Now()}
+	var findOpts []*options.FindOptions
+	if opts != nil {
+		findOpts = append(findOpts, opts)
+	}
+	cursor, err := locksCollection.Find(r.Context(), filter, findOpts...)
+	if err != nil {
+		log.Printf("Error retrieving locks: %v", err)
+		http.Error(w, "Error retrieving locks", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(r.Context())
+
+	locks := []models.DocumentLock{}
+	if err := cursor.All(r.Context(), &locks); err != nil {
+		log.Printf("Error decoding locks: %v", err)
+		http.Error(w, "Error retrieving locks", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(locks)
+}
+
+// AdminBreakLock removes the lock named by {lockID}, whoever holds it
+func AdminBreakLock(w http.ResponseWriter, r *http.Request) {
+	lockID, err := primitive.ObjectIDFromHex(mux.Vars(r)["lockID"])
+	if err != nil {
+		http.Error(w, "Invalid lock ID format", http.StatusBadRequest)
+		return
+	}
+	var lock models.DocumentLock
+	err = locksCollection.FindOne(r.Context(), bson.M{"_id": lockID}).Decode(&lock)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "Lock not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error finding lock %s: %v", lockID.Hex(), err)
+		http.Error(w, "Error breaking lock", http.StatusInternalServerError)
+		return
+	}
+	if err := releaseLock(r, &lock, audit.ActionDocumentLockBreak); err != nil {
+		log.Printf("Error breaking lock %s: %v", lockID.Hex(), err)
+		http.Error(w, "Error breaking lock", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"message": "Lock broken"})
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"DocuDefense/backend/src/models"
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// sharedUpload uploads a new version of contract.pdf through Bob's share of it
+func (f *authFixture) sharedUpload(t *testing.T, accessToken string, fields map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("contract", "contract.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(testPDF(""))
+	for name, value := range fields {
+		form.WriteField(name, value)
+	}
+	form.Close()
+
+	req := httptest.NewRequest("POST", "/shared/documents/"+f.contractVersion(t, 1)+"/upload", &body)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	rec := httptest.NewRecorder()
+	f.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCheckedOutFileRefusesOtherUploaders(t *testing.T) {
+	f := newAuthFixture(t)
+	access, _ := f.login(t)
+	bob := f.bobToken(t)
+	f.shareContract(t, access, `{"email":"bob@example.com","permission":"editor"}`)
+
+	rec := f.post(t, "/shared/documents/"+f.contractVersion(t, 1)+"/lock", bob, `{"reason":"Redlining offline"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("check out: got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := f.post(t, "/users/"+f.alice.ID.Hex()+"/files/contract.pdf/lock", access, ""); rec.Code != http.StatusLocked {
+		t.Fatalf("second check-out: got %d, want 423", rec.Code)
+	}
+
+	rec = f.uploadWith(t, access, nil, nil)
+	var rejection uploadRejection
+	json.NewDecoder(rec.Body).Decode(&rejection)
+	if rec.Code != http.StatusLocked || rejection.Error != "locked" || rejection.Lock == nil || rejection.Lock.HolderEmail != f.bob.Email {
+		t.Fatalf("owner upload while checked out: got %d %+v", rec.Code, rejection)
+	}
+	if rec := f.tus(t, "POST", "/users/"+f.alice.ID.Hex()+"/uploads", access, map[string]string{
+		"Upload-Length": "8", "Upload-Metadata": "filename Y29udHJhY3QucGRm",
+	}, nil); rec.Code != http.StatusLocked {
+		t.Fatalf("resumable upload while checked out: got %d, want 423", rec.Code)
+	}
+
+	// The holder uploads their edits and checks the file back in
+	if rec := f.sharedUpload(t, bob, map[string]string{"check_in": "true"}); rec.Code != http.StatusOK {
+		t.Fatalf("holder upload: got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := f.uploadWith(t, access, nil, nil); rec.Code != http.StatusOK {
+		t.Fatalf("owner upload after check-in: got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestAdminsBreakLocksAndExpiredLocksLapse(t *testing.T) {
+	f := newAuthFixture(t)
+	access, _ := f.login(t)
+	f.locks.InsertOne(context.Background(), models.DocumentLock{
+		ID: primitive.NewObjectID(), OwnerID: f.alice.ID, Filename: "contract.pdf", HolderID: f.bob.ID, HolderEmail: f.bob.Email,
+		CreatedAt: time.Now().Add(-48 * time.Hour), ExpiresAt: time.Now().Add(-time.Hour),
+	})
+	if rec := f.uploadWith(t, access, nil, nil); rec.Code != http.StatusOK {
+		t.Fatalf("upload past an expired lock: got %d %q", rec.Code, rec.Body.String())
+	}
+
+	rec := f.post(t, "/users/"+f.alice.ID.Hex()+"/files/contract.pdf/lock", access, "")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("check out over an expired lock: got %d %q", rec.Code, rec.Body.String())
+	}
+	var lock models.DocumentLock
+	json.NewDecoder(rec.Body).Decode(&lock)
+
+	f.shareContract(t, access, `{"email":"bob@example.com","permission":"editor"}`)
+	admin := f.makeBob(t, models.RoleAdmin)
+	if rec := f.send(t, "GET", "/admin/locks", admin, ""); rec.Code != http.StatusOK || !bytes.Contains(rec.Body.Bytes(), []byte(lock.ID.Hex())) {
+		t.Fatalf("list locks: got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := f.sharedUpload(t, admin, nil); rec.Code != http.StatusLocked {
+		t.Fatalf("editor upload while checked out: got %d, want 423", rec.Code)
+	}
+	if rec := f.send(t, "DELETE", "/admin/locks/"+lock.ID.Hex(), admin, ""); rec.Code != http.StatusOK {
+		t.Fatalf("break lock: got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := f.sharedUpload(t, admin, nil); rec.Code != http.StatusOK {
+		t.Fatalf("upload after the lock was broken: got %d %q", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,485 @@
+package handlers
+
+import (
+	"DocuDefense/backend/src/audit"
+	"DocuDefense/backend/src/models"
+	"DocuDefense/backend/src/totp"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// Purpose claim of the token returned by LoginUser when a second factor is still needed
+	mfaChallengePurpose = "mfa_challenge"
+
+	// How long the user has to enter their code after the password step
+	mfaChallengeTTL = 5 * time.Minute
+
+	recoveryCodeCount = 10
+)
+
+var errInvalidSecondFactor = errors.New("invalid two-factor code")
+
+// stepUpMaxAge is how recently an enrolled user must have entered a code to perform
+// sensitive actions, 10 minutes unless MFA_STEP_UP_MAX_AGE is set
+func stepUpMaxAge() time.Duration {
+	return durationFromEnv("MFA_STEP_UP_MAX_AGE", 10*time.Minute)
+}
+
+// mfaIssuer is the account issuer shown in authenticator apps
+func mfaIssuer() string {
+	if issuer := os.Getenv("MFA_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "DocuDefense"
+}
+
+// secondFactor is the code a user submits: a TOTP code or one of their recovery codes
+type secondFactor struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// generateMFAChallenge issues the short-lived token that proves the password step succeeded
+func generateMFAChallenge(user *models.User) (string, error) {
+	now := time.Now()
+	return tokenKeys.Sign(&Claims{
+		Email:        user.Email,
+		TokenVersion: user.TokenVersion,
+		Purpose:      mfaChallengePurpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        primitive.NewObjectID().Hex(),
+			Issuer:    tokenKeys.Issuer(),
+			Subject:   user.ID.Hex(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(mfaChallengeTTL)),
+		},
+	})
+}
+
+// hashRecoveryCode normalises a recovery code as typed by the user and hashes it
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRecoveryCodes returns fresh one-time recovery codes and the hashes to store
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, 5)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(raw))
+		codes[i] = code[:4] + "-" + code[4:]
+		hashes[i] = hashRecoveryCode(code)
+	}
+	return codes, hashes, nil
+}
+
+// verifySecondFactor checks a TOTP code or consumes a recovery code for an enrolled user.
+// Both updates are conditional, so a code cannot be used twice even by concurrent requests.
+func verifySecondFactor(ctx context.Context, user *models.User, factor secondFactor) (string, error) {
+	if !user.MFAEnabled {
+		return "", errors.New("two-factor authentication is not enabled")
+	}
+
+	if factor.Code != "" {
+		step, ok := totp.Validate(user.TOTPSecret, strings.TrimSpace(factor.Code), time.Now(), user.TOTPLastStep)
+		if !ok {
+			return "", errInvalidSecondFactor
+		}
+		result, err := usersCollection.UpdateOne(ctx, bson.M{
+			"_id":            user.ID,
+			"totp_last_step": bson.M{"$lt": step},
+		}, bson.M{"$set": bson.M{"totp_last_step": step}})
+		if err != nil {
+			return "", err
+		}
+		if result.ModifiedCount == 0 {
+			return "", errInvalidSecondFactor
+		}
+		user.TOTPLastStep = step
+		return "totp", nil
+	}
+
+	if factor.RecoveryCode != "" {
+		hash := hashRecoveryCode(factor.RecoveryCode)
+		result, err := usersCollection.UpdateOne(ctx, bson.M{
+			"_id":            user.ID,
+			"recovery_codes": hash,
+		}, bson.M{"$pull": bson.M{"recovery_codes": hash}})
+		if err != nil {
+			return "", err
+		}
+		if result.ModifiedCount == 0 {
+			return "", errInvalidSecondFactor
+		}
+		log.Printf("User %s used a recovery code", user.Email)
+		return "recovery_code", nil
+	}
+
+	return "", errInvalidSecondFactor
+}
+
+// decodeSecondFactor reads a secondFactor-shaped JSON body into dst
+func decodeSecondFactor(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(dst); err != nil {
+		http.Error(w, "Invalid two-factor request", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// LoginMFA completes a login for an enrolled user by exchanging the MFA challenge token
+// from LoginUser and a TOTP or recovery code for access and refresh tokens
+func LoginMFA(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		MFAToken string `json:"mfa_token"`
+		secondFactor
+	}
+	if !decodeSecondFactor(w, r, &body) {
+		return
+	}
+
+	claims := &Claims{}
+	token, err := tokenKeys.Parse(body.MFAToken, claims)
+	if err != nil || !token.Valid || claims.Purpose != mfaChallengePurpose {
+		http.Error(w, "Invalid or expired two-factor challenge", http.StatusUnauthorized)
+		return
+	}
+	userID, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil {
+		http.Error(w, "Invalid or expired two-factor challenge", http.StatusUnauthorized)
+		return
+	}
+
+	var user models.User
+	if err := usersCollection.FindOne(r.Context(), bson.M{"_id": userID}).Decode(&user); err != nil || user.TokenVersion != claims.TokenVersion {
+		http.Error(w, "Invalid or expired two-factor challenge", http.StatusUnauthorized)
+		return
+	}
+
+	// Code guesses count towards the same lockout as password guesses
+	guard, ok := beginLogin(w, r, user.Email)
+	if !ok {
+		return
+	}
+	defer guard.release(r.Context())
+
+	method, err := verifySecondFactor(r.Context(), &user, body.secondFactor)
+	if err != nil {
+		guard.fail(r.Context())
+		if !errors.Is(err, errInvalidSecondFactor) {
+			log.Printf("Error verifying second factor for %s: %v", user.Email, err)
+		}
+		recordAudit(r, audit.Event{
+			Action:       audit.ActionLoginFailed,
+			Actor:        user.Email,
+			ActorID:      user.ID,
+			TargetUserID: user.ID,
+			Target:       user.Email,
+			Details:      map[string]string{"reason": "invalid_mfa_code"},
+		})
+		http.Error(w, "Invalid two-factor code", http.StatusUnauthorized)
+		return
+	}
+
+	session, err := issueSession(r.Context(), &user, nil, time.Now())
+	if errors.Is(err, errAccountDisabled) {
+		http.Error(w, "This account has been disabled", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Printf("Error generating token for user %s: %v", user.Email, err)
+		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		return
+	}
+	guard.succeed(r.Context())
+
+	recordAudit(r, audit.Event{
+		Action:       audit.ActionLogin,
+		Actor:        user.Email,
+		ActorID:      user.ID,
+		TargetUserID: user.ID,
+		Target:       user.Email,
+		Details:      map[string]string{"mfa": method},
+	})
+
+	session["message"] = "Login successful"
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(session)
+}
+
+// EnrollTOTP starts TOTP enrollment by generating a secret and its provisioning URI.
+// The secret only takes effect once ConfirmTOTP receives a valid code for it.
+func EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	targetUser, ok := targetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+	if targetUser.MFAEnabled {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		http.Error(w, "Error generating secret", http.StatusInternalServerError)
+		return
+	}
+	_, err = usersCollection.UpdateOne(r.Context(), bson.M{"_id": targetUser.ID}, bson.M{
+		"$set": bson.M{"pending_totp_secret": secret},
+	})
+	if err != nil {
+		log.Printf("Error saving TOTP secret for %s: %v", targetUser.Email, err)
+		http.Error(w, "Error starting enrollment", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret":           secret,
+		"provisioning_uri": totp.ProvisioningURI(mfaIssuer(), targetUser.Email, secret),
+	})
+}
+
+// ConfirmTOTP enables two-factor authentication once the user proves their authenticator
+// produces valid codes, and returns one-time recovery codes that are never shown again
+func ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	targetUser, ok := targetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+	var body secondFactor
+	if !decodeSecondFactor(w, r, &body) {
+		return
+	}
+	if targetUser.PendingTOTPSecret == "" {
+		http.Error(w, "No two-factor enrollment in progress", http.StatusConflict)
+		return
+	}
+
+	step, valid := totp.Validate(targetUser.PendingTOTPSecret, strings.TrimSpace(body.Code), time.Now(), 0)
+	if !valid {
+		http.Error(w, "Invalid two-factor code", http.StatusUnauthorized)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		http.Error(w, "Error generating recovery codes", http.StatusInternalServerError)
+		return
+	}
+	_, err = usersCollection.UpdateOne(r.Context(), bson.M{
+		"_id":                 targetUser.ID,
+		"pending_totp_secret": targetUser.PendingTOTPSecret,
+	}, bson.M{
+		"$set": bson.M{
+			"mfa_enabled":    true,
+			"totp_secret":    targetUser.PendingTOTPSecret,
+			"totp_last_step": step,
+			"recovery_codes": hashes,
+		},
+		"$unset": bson.M{"pending_totp_secret": ""},
+	})
+	if err != nil {
+		log.Printf("Error enabling MFA for %s: %v", targetUser.Email, err)
+		http.Error(w, "Error enabling two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r, audit.Event{
+		Action:       audit.ActionMFAEnable,
+		TargetUserID: targetUser.ID,
+		Target:       targetUser.Email,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// RegenerateRecoveryCodes replaces every recovery code after checking a current TOTP code
+func RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	targetUser, ok := targetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+	var body secondFactor
+	if !decodeSecondFactor(w, r, &body) {
+		return
+	}
+	body.RecoveryCode = "" // a recovery code cannot be used to mint new ones
+
+	guard, ok := beginLogin(w, r, targetUser.Email)
+	if !ok {
+		return
+	}
+	defer guard.release(r.Context())
+	if _, err := verifySecondFactor(r.Context(), targetUser, body); err != nil {
+		guard.fail(r.Context())
+		http.Error(w, "Invalid two-factor code", http.StatusUnauthorized)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		http.Error(w, "Error generating recovery codes", http.StatusInternalServerError)
+		return
+	}
+	_, err = usersCollection.UpdateOne(r.Context(), bson.M{"_id": targetUser.ID}, bson.M{
+		"$set": bson.M{"recovery_codes": hashes},
+	})
+	if err != nil {
+		log.Printf("Error saving recovery codes for %s: %v", targetUser.Email, err)
+		http.Error(w, "Error generating recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r, audit.Event{
+		Action:       audit.ActionMFARecoveryCodes,
+		TargetUserID: targetUser.ID,
+		Target:       targetUser.Email,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"recovery_codes": codes})
+}
+
+// DisableMFA turns two-factor authentication off. The caller must re-authenticate with
+// their password (for accounts that have one) and a current TOTP or recovery code.
+func DisableMFA(w http.ResponseWriter, r *http.Request) {
+	targetUser, ok := targetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+	var body struct {
+		Password string `json:"password"`
+		secondFactor
+	}
+	if !decodeSecondFactor(w, r, &body) {
+		return
+	}
+
+	guard, ok := beginLogin(w, r, targetUser.Email)
+	if !ok {
+		return
+	}
+	defer guard.release(r.Context())
+	if targetUser.Password != "" {
+		if err := targetUser.CheckPassword(body.Password); err != nil {
+			guard.fail(r.Context())
+			http.Error(w, "Re-authentication failed", http.StatusUnauthorized)
+			return
+		}
+	}
+	if _, err := verifySecondFactor(r.Context(), targetUser, body.secondFactor); err != nil {
+		guard.fail(r.Context())
+		http.Error(w, "Re-authentication failed", http.StatusUnauthorized)
+		return
+	}
+
+	_, err := usersCollection.UpdateOne(r.Context(), bson.M{"_id": targetUser.ID}, bson.M{
+		"$set":   bson.M{"mfa_enabled": false},
+		"$unset": bson.M{"totp_secret": "", "totp_last_step": "", "recovery_codes": "", "pending_totp_secret": ""},
+	})
+	if err != nil {
+		log.Printf("Error disabling MFA for %s: %v", targetUser.Email, err)
+		http.Error(w, "Error disabling two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r, audit.Event{
+		Action:       audit.ActionMFADisable,
+		TargetUserID: targetUser.ID,
+		Target:       targetUser.Email,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Two-factor authentication disabled"})
+}
+
+// StepUpMFA verifies a TOTP or recovery code and returns a new access token recording the
+// verification, for use with routes protected by RequireRecentMFA
+func StepUpMFA(w http.ResponseWriter, r *http.Request) {
+	targetUser, ok := targetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+	var body secondFactor
+	if !decodeSecondFactor(w, r, &body) {
+		return
+	}
+
+	guard, ok := beginLogin(w, r, targetUser.Email)
+	if !ok {
+		return
+	}
+	defer guard.release(r.Context())
+	if _, err := verifySecondFactor(r.Context(), targetUser, body); err != nil {
+		guard.fail(r.Context())
+		http.Error(w, "Invalid two-factor code", http.StatusUnauthorized)
+		return
+	}
+
+	token, err := generateAccessToken(targetUser, time.Now())
+	if err != nil {
+		log.Printf("Error generating token for user %s: %v", targetUser.Email, err)
+		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":      token,
+		"token_type": "Bearer",
+		"expires_in": int(accessTokenTTL().Seconds()),
+	})
+}
+
+// RequireRecentMFA protects sensitive routes: users with two-factor authentication enabled
+// must have entered a code within stepUpMaxAge, otherwise they are asked to step up.
+// It must run after RequireAccountOwner.
+func RequireRecentMFA(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := claimsFromContext(r)
+		targetUser, found := targetUserFromContext(r)
+		if !ok || !found {
+			http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+			return
+		}
+
+		if targetUser.MFAEnabled && (claims.MFAAt == nil || time.Since(claims.MFAAt.Time) > stepUpMaxAge()) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_user_authentication", error_description="Two-factor verification required", max_age=%d`, int(stepUpMaxAge().Seconds())))
+			http.Error(w, "Two-factor verification required", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
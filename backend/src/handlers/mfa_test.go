@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"DocuDefense/backend/src/totp"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// enrollAlice gives alice a password and enables TOTP, returning the secret and recovery codes
+func (f *authFixture) enrollAlice(t *testing.T) (string, []string) {
+	t.Helper()
+	if err := f.alice.HashPassword("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	f.users.UpdateOne(context.Background(), bson.M{"_id": f.alice.ID}, bson.M{"$set": bson.M{"password": f.alice.Password}})
+
+	access, _ := f.login(t)
+	rec := f.post(t, "/users/"+f.alice.ID.Hex()+"/mfa/totp", access, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("enroll: got %d %q", rec.Code, rec.Body.String())
+	}
+	var enrollment struct {
+		Secret          string `json:"secret"`
+		ProvisioningURI string `json:"provisioning_uri"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &enrollment)
+	if !strings.HasPrefix(enrollment.ProvisioningURI, "otpauth://totp/") {
+		t.Fatalf("unexpected provisioning URI %q", enrollment.ProvisioningURI)
+	}
+
+	rec = f.post(t, "/users/"+f.alice.ID.Hex()+"/mfa/totp/verify", access, codeBody(t, enrollment.Secret, time.Now()))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("confirm: got %d %q", rec.Code, rec.Body.String())
+	}
+	var confirmed struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &confirmed)
+	if len(confirmed.RecoveryCodes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(confirmed.RecoveryCodes), recoveryCodeCount)
+	}
+	return enrollment.Secret, confirmed.RecoveryCodes
+}
+
+func codeBody(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+	code, err := totp.Code(secret, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return `{"code":"` + code + `"}`
+}
+
+func (f *authFixture) mfaChallenge(t *testing.T) string {
+	t.Helper()
+	rec := f.post(t, "/login", "", `{"email":"alice@example.com","password":"correct horse"}`)
+	var body struct {
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
+		Token       string `json:"token"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if rec.Code != http.StatusOK || !body.MFARequired || body.Token != "" {
+		t.Fatalf("login for enrolled user: got %d %q", rec.Code, rec.Body.String())
+	}
+	return body.MFAToken
+}
+
+func TestLoginRequiresSecondFactor(t *testing.T) {
+	f := newAuthFixture(t)
+	secret, _ := f.enrollAlice(t)
+	challenge := f.mfaChallenge(t)
+
+	if code := f.listFiles(t, challenge); code != http.StatusUnauthorized {
+		t.Fatalf("challenge token used as access token: got %d, want 401", code)
+	}
+
+	// Enrollment consumed the current step, so sign in with the next one
+	next := time.Now().Add(totp.Period)
+	body := `{"mfa_token":"` + challenge + `",` + strings.TrimPrefix(codeBody(t, secret, next), "{")
+	rec := f.post(t, "/login/mfa", "", body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login/mfa: got %d %q", rec.Code, rec.Body.String())
+	}
+	var session struct {
+		Token string `json:"token"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &session)
+	if code := f.listFiles(t, session.Token); code != http.StatusOK {
+		t.Fatalf("access token after MFA rejected with %d", code)
+	}
+
+	if rec := f.post(t, "/login/mfa", "", body); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("replayed TOTP code: got %d, want 401", rec.Code)
+	}
+}
+
+func TestRecoveryCodeIsSingleUse(t *testing.T) {
+	f := newAuthFixture(t)
+	_, codes := f.enrollAlice(t)
+
+	body := `{"mfa_token":"` + f.mfaChallenge(t) + `","recovery_code":"` + strings.ToUpper(codes[0]) + `"}`
+	if rec := f.post(t, "/login/mfa", "", body); rec.Code != http.StatusOK {
+		t.Fatalf("recovery code login: got %d %q", rec.Code, rec.Body.String())
+	}
+	body = `{"mfa_token":"` + f.mfaChallenge(t) + `","recovery_code":"` + codes[0] + `"}`
+	if rec := f.post(t, "/login/mfa", "", body); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("reused recovery code: got %d, want 401", rec.Code)
+	}
+}
+
+func TestDisableMFARequiresPassword(t *testing.T) {
+	f := newAuthFixture(t)
+	_, codes := f.enrollAlice(t)
+	access, _ := f.login(t)
+
+	disable := func(body string) int {
+		req := httptest.NewRequest("DELETE", "/users/"+f.alice.ID.Hex()+"/mfa", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+access)
+		rec := httptest.NewRecorder()
+		f.router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := disable(`{"password":"wrong","recovery_code":"` + codes[0] + `"}`); code != http.StatusUnauthorized {
+		t.Fatalf("disable with wrong password: got %d, want 401", code)
+	}
+	if code := disable(`{"password":"correct horse","recovery_code":"` + codes[1] + `"}`); code != http.StatusOK {
+		t.Fatalf("disable with password and recovery code: got %d", code)
+	}
+	if rec := f.post(t, "/login", "", `{"email":"alice@example.com","password":"correct horse"}`); strings.Contains(rec.Body.String(), "mfa_required") {
+		t.Fatalf("login still requires MFA after disabling it")
+	}
+}
+
+func TestSensitiveActionsRequireStepUp(t *testing.T) {
+	f := newAuthFixture(t)
+	secret, _ := f.enrollAlice(t)
+	access, _ := f.login(t)
+	deletePath := "/users/" + f.alice.ID.Hex() + "/files/contract.pdf/delete"
+
+	req := httptest.NewRequest("DELETE", deletePath, nil)
+	req.Header.Set("Authorization", "Bearer "+access)
+	rec := httptest.NewRecorder()
+	f.router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Header().Get("WWW-Authenticate"), "insufficient_user_authentication") {
+		t.Fatalf("delete without step-up: got %d %q", rec.Code, rec.Header().Get("WWW-Authenticate"))
+	}
+	if rec := f.send(t, "PUT", "/users/"+f.alice.ID.Hex(), access, `{"surname":"Smith"}`); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("profile update without step-up: got %d, want 401", rec.Code)
+	}
+
+	rec = f.post(t, "/users/"+f.alice.ID.Hex()+"/mfa/step-up", access, codeBody(t, secret, time.Now().Add(totp.Period)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("step-up: got %d %q", rec.Code, rec.Body.String())
+	}
+	var stepped struct {
+		Token string `json:"token"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &stepped)
+
+	req = httptest.NewRequest("DELETE", deletePath, nil)
+	req.Header.Set("Authorization", "Bearer "+stepped.Token)
+	rec = httptest.NewRecorder()
+	f.router.ServeHTTP(rec, req)
+	if rec.Code == http.StatusUnauthorized {
+		t.Fatalf("delete after step-up: got %d %q", rec.Code, rec.Body.String())
+	}
+}
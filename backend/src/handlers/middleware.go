@@ -3,14 +3,17 @@ package handlers
 import (
 	"DocuDefense/backend/src/models"
 	"context"
+	"errors"
 	"log"
 	"net/http"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
-// BasicAuthMiddleware provides basic authentication
+// BasicAuthMiddleware provides basic authentication. It shares LoginUser's failed-attempt
+// limits and answers unknown emails and wrong passwords identically.
 func BasicAuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Retrieve Basic Auth credentials (email and password)
@@ -21,27 +24,39 @@ func BasicAuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		guard, ok := beginLogin(w, r, email)
+		if !ok {
+			return
+		}
+		defer guard.release(r.Context())
+
 		// Find the user by email from MongoDB
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
 		var foundUser models.User
 		err := usersCollection.FindOne(ctx, bson.M{"email": email}).Decode(&foundUser)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Printf("Error looking up user %s: %v", email, err)
+			http.Error(w, "Error checking credentials", http.StatusInternalServerError)
+			return
+		}
+		var candidate *models.User
+		if err == nil {
+			candidate = &foundUser
+		}
 
-		// If no user is found or the password check fails
-		if err != nil {
-			log.Printf("User not found for email: %s", email)
+		if !checkLoginPassword(candidate, password) {
+			guard.fail(ctx)
+			log.Printf("Basic auth failed for %s", email)
 			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 			return
 		}
+		guard.succeed(ctx)
 
-		// Log the stored password hash and the provided password for comparison
-		log.Printf("Stored password hash for user %s: %s", foundUser.Email, foundUser.Password)
-		log.Printf("Provided password for user %s: %s", foundUser.Email, password)
-
-		if err := foundUser.CheckPassword(password); err != nil {
-			log.Printf("Invalid password for user %s: %v", foundUser.Email, err)
-			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		if foundUser.Disabled {
+			log.Printf("Basic auth refused: account %s is disabled", foundUser.Email)
+			http.Error(w, "This account has been disabled", http.StatusForbidden)
 			return
 		}
 
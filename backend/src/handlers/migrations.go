@@ -0,0 +1,333 @@
+package handlers
+
+import (
+	"DocuDefense/backend/src/models"
+	"DocuDefense/backend/src/storage"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// migration is a named, idempotent data migration run by the -migrate flag
+type migration struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// migrations are executed in order; each one must be safe to run more than once
+var migrations = []migration{
+	{name: "per-version blob keys", run: migrateLegacyBlobs},
+	{name: "previous version links", run: linkPreviousVersions},
+	{name: "content hashes", run: backfillContentHashes},
+	{name: "encrypt plaintext blobs", run: encryptPlaintextBlobs},
+	{name: "unique version numbers", run: renumberDuplicateVersions},
+}
+
+// RunMigrations applies every data migration in order and stops at the first failure
+func RunMigrations(ctx context.Context) error {
+	for _, m := range migrations {
+		log.Printf("Running migration: %s", m.name)
+		if err := m.run(ctx); err != nil {
+			return fmt.Errorf("migration %q failed: %w", m.name, err)
+		}
+	}
+	return nil
+}
+
+// migrateLegacyBlobs moves files saved under the flat "<filename>" key to per-version keys.
+// The flat layout kept a single file per name, so only the most recent upload of each
+// filename still has content; older versions are pointed at a key that does not exist
+// and will be reported as missing.
+func migrateLegacyBlobs(ctx context.Context) error {
+	cursor, err := documentsCollection.Find(ctx, bson.M{
+		"storage_key": bson.M{"$exists": false},
+	}, options.Find().SetSort(bson.D{{Key: "upload_date", Value: -1}}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var docs []models.Document
+	if err := cursor.All(ctx, &docs); err != nil {
+		return err
+	}
+
+	claimed := map[string]bool{}
+	for _, doc := range docs {
+		key := documentStorageKey(doc.UserID, doc.ID)
+		size := doc.Size
+
+		if !claimed[doc.Filename] {
+			claimed[doc.Filename] = true
+			copied, err := copyBlob(ctx, doc.Filename, key)
+			switch {
+			case errors.Is(err, storage.ErrNotFound):
+				log.Printf("Legacy file %s is missing from storage; version %d cannot be recovered", doc.Filename, doc.Version)
+			case err != nil:
+				return fmt.Errorf("copying %s: %w", doc.Filename, err)
+			default:
+				size = copied
+			}
+		} else {
+			log.Printf("Version %d of %s (document %s) was overwritten before per-version storage and cannot be recovered", doc.Version, doc.Filename, doc.ID.Hex())
+		}
+
+		_, err := documentsCollection.UpdateOne(ctx, bson.M{"_id": doc.ID}, bson.M{
+			"$set": bson.M{"storage_key": key, "size": size},
+		})
+		if err != nil {
+			return fmt.Errorf("updating document %s: %w", doc.ID.Hex(), err)
+		}
+	}
+
+	for filename := range claimed {
+		if err := fileStorage.Delete(ctx, filename); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return fmt.Errorf("removing legacy file %s: %w", filename, err)
+		}
+	}
+
+	log.Printf("Migrated %d legacy document versions", len(docs))
+	return nil
+}
+
+// linkPreviousVersions fills in PreviousVersionID for versions uploaded before it was recorded
+func linkPreviousVersions(ctx context.Context) error {
+	cursor, err := documentsCollection.Find(ctx, bson.M{
+		"version":             bson.M{"$gt": 1},
+		"previous_version_id": bson.M{"$exists": false},
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var docs []models.Document
+	if err := cursor.All(ctx, &docs); err != nil {
+		return err
+	}
+
+	linked := 0
+	for _, doc := range docs {
+		previous, err := findDocumentVersion(ctx, doc.UserID, doc.OrgID, doc.Filename, doc.Version-1)
+		if err != nil {
+			return err
+		}
+		if previous == nil {
+			log.Printf("Version %d of %s (document %s) has no predecessor to link", doc.Version, doc.Filename, doc.ID.Hex())
+			continue
+		}
+		_, err = documentsCollection.UpdateOne(ctx, bson.M{"_id": doc.ID}, bson.M{
+			"$set": bson.M{"previous_version_id": previous.ID},
+		})
+		if err != nil {
+			return fmt.Errorf("updating document %s: %w", doc.ID.Hex(), err)
+		}
+		linked++
+	}
+
+	log.Printf("Linked %d document versions to their predecessors", linked)
+	return nil
+}
+
+// backfillContentHashes records a SHA-256 for versions uploaded before hashes were computed.
+// The hash is taken from the blob as it is now, so run an audit of those files first if
+// they may already have been altered.
+func backfillContentHashes(ctx context.Context) error {
+	cursor, err := documentsCollection.Find(ctx, bson.M{"sha256": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var docs []models.Document
+	if err := cursor.All(ctx, &docs); err != nil {
+		return err
+	}
+
+	hashed := 0
+	for _, doc := range docs {
+		sum, err := hashDocument(ctx, &doc)
+		if errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Cannot hash %s version %d (document %s): blob is missing", doc.Filename, doc.Version, doc.ID.Hex())
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("hashing document %s: %w", doc.ID.Hex(), err)
+		}
+		_, err = documentsCollection.UpdateOne(ctx, bson.M{"_id": doc.ID}, bson.M{
+			"$set": bson.M{"sha256": sum},
+		})
+		if err != nil {
+			return fmt.Errorf("updating document %s: %w", doc.ID.Hex(), err)
+		}
+		hashed++
+	}
+
+	log.Printf("Recorded content hashes for %d document versions", hashed)
+	return nil
+}
+
+// encryptPlaintextBlobs re-stores versions saved before encryption at rest was enabled.
+// Each blob is checked against its recorded hash, written encrypted under a new key and
+// only then is the plaintext removed. Without configured master keys this does nothing.
+func encryptPlaintextBlobs(ctx context.Context) error {
+	if keyring == nil {
+		log.Printf("Encryption at rest is not configured; leaving blobs in plaintext")
+		return nil
+	}
+
+	cursor, err := documentsCollection.Find(ctx, bson.M{
+		"storage_key":      bson.M{"$exists": true},
+		"wrapped_data_key": bson.M{"$exists": false},
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var docs []models.Document
+	if err := cursor.All(ctx, &docs); err != nil {
+		return err
+	}
+
+	encrypted := 0
+	for _, doc := range docs {
+		integrity, err := verifyDocument(ctx, &doc)
+		if err != nil {
+			return fmt.Errorf("verifying document %s: %w", doc.ID.Hex(), err)
+		}
+		if integrity.Status == IntegrityMissing || integrity.Status == IntegrityMismatch {
+			log.Printf("Not encrypting %s version %d (document %s): integrity %s", doc.Filename, doc.Version, doc.ID.Hex(), integrity.Status)
+			continue
+		}
+
+		key := doc.StorageKey + ".enc"
+		sealed, err := sealBlob(ctx, doc.StorageKey, key, knownSize(doc.Size))
+		if err != nil {
+			return fmt.Errorf("encrypting document %s: %w", doc.ID.Hex(), err)
+		}
+
+		_, err = documentsCollection.UpdateOne(ctx, bson.M{"_id": doc.ID}, bson.M{
+			"$set": bson.M{
+				"storage_key":       key,
+				"encryption_key_id": sealed.KeyID,
+				"wrapped_data_key":  sealed.WrappedKey,
+			},
+		})
+		if err != nil {
+			discardBlob(key)
+			return fmt.Errorf("updating document %s: %w", doc.ID.Hex(), err)
+		}
+		if err := fileStorage.Delete(ctx, doc.StorageKey); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Error removing plaintext blob %s: %v", doc.StorageKey, err)
+		}
+		encrypted++
+	}
+
+	log.Printf("Encrypted %d document versions", encrypted)
+	return nil
+}
+
+// sealBlob encrypts the plaintext blob at src into dst and returns the data key used
+func sealBlob(ctx context.Context, src, dst string, size int64) (*sealedContent, error) {
+	reader, err := fileStorage.Get(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	sealed, err := sealContent(reader, size)
+	if err != nil {
+		return nil, err
+	}
+	if err := fileStorage.Put(ctx, dst, sealed.Reader, sealed.Size); err != nil {
+		return nil, err
+	}
+	return sealed, nil
+}
+
+// copyBlob copies the blob stored under src to dst and returns the number of bytes written
+func copyBlob(ctx context.Context, src, dst string) (int64, error) {
+	reader, err := fileStorage.Get(ctx, src)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	counter := &countingReader{r: reader}
+	if err := fileStorage.Put(ctx, dst, counter, -1); err != nil {
+		return 0, err
+	}
+	return counter.n, nil
+}
+
+// renumberDuplicateVersions gives every version of a file its own number so the unique
+// version index can be built. Concurrent uploads before the index existed could record two
+// versions with the same number; those are ordered by upload date and the file's later
+// versions move up to make room.
+func renumberDuplicateVersions(ctx context.Context) error {
+	cursor, err := documentsCollection.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{
+		"user_id": 1, "org_id": 1, "filename": 1, "version": 1, "upload_date": 1, "previous_version_id": 1,
+	}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var docs []models.Document
+	if err := cursor.All(ctx, &docs); err != nil {
+		return err
+	}
+
+	files := map[string][]models.Document{}
+	for _, doc := range docs {
+		key := doc.UserID.Hex() + "/" + doc.OrgID.Hex() + "/" + doc.Filename
+		files[key] = append(files[key], doc)
+	}
+
+	renumbered := 0
+	for _, versions := range files {
+		sort.SliceStable(versions, func(i, j int) bool {
+			if versions[i].Version != versions[j].Version {
+				return versions[i].Version < versions[j].Version
+			}
+			return versions[i].UploadDate.Before(versions[j].UploadDate)
+		})
+		duplicated := false
+		for i := 1; i < len(versions); i++ {
+			duplicated = duplicated || versions[i].Version == versions[i-1].Version
+		}
+		if !duplicated {
+			continue
+		}
+
+		for i, doc := range versions {
+			version, previous := i+1, primitive.NilObjectID
+			if i > 0 {
+				previous = versions[i-1].ID
+			}
+			if doc.Version == version && doc.PreviousVersionID == previous {
+				continue
+			}
+			update := bson.M{"$set": bson.M{"version": version, "previous_version_id": previous}}
+			if previous.IsZero() {
+				update = bson.M{"$set": bson.M{"version": version}, "$unset": bson.M{"previous_version_id": ""}}
+			}
+			if _, err := documentsCollection.UpdateOne(ctx, bson.M{"_id": doc.ID}, update); err != nil {
+				return fmt.Errorf("updating document %s: %w", doc.ID.Hex(), err)
+			}
+			log.Printf("Renumbered %s version %d (document %s) as version %d", doc.Filename, doc.Version, doc.ID.Hex(), version)
+			renumbered++
+		}
+	}
+
+	// The unique version index is built the next time the server starts
+	log.Printf("Renumbered %d document versions", renumbered)
+	return nil
+}
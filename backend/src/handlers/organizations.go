@@ -0,0 +1,743 @@
+package handlers
+
+import (
+	"DocuDefense/backend/src/audit"
+	"DocuDefense/backend/src/mailer"
+	"DocuDefense/backend/src/models"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// tenantHeader selects the organization whose documents a user-scoped request works on.
+// Without it requests work on the user's personal workspace.
+const tenantHeader = "X-Organization-ID"
+
+// Organizations, their members and pending invitations
+var organizationsCollection DatabaseCollection
+var membershipsCollection DatabaseCollection
+var invitationsCollection DatabaseCollection
+
+// invitationTTL is how long an invitation can be accepted, 7 days unless INVITATION_TTL is set
+func invitationTTL() time.Duration {
+	return durationFromEnv("INVITATION_TTL", 7*24*time.Hour)
+}
+
+// ensureOrganizationIndexes keeps one membership per user and organization and lets
+// MongoDB expire old invitations
+func ensureOrganizationIndexes(ctx context.Context) error {
+	_, err := mongoDatabase.Collection("memberships").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "org_id", Value: 1}, {Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = mongoDatabase.Collection("invitations").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "org_id", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = mongoDatabase.Collection("documents").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "org_id", Value: 1}, {Key: "user_id", Value: 1}, {Key: "filename", Value: 1}},
+	})
+	return err
+}
+
+// inTenant restricts a documents filter to the workspace orgID. Personal workspace
+// documents have no organization, so a zero orgID matches only those.
+func inTenant(filter bson.M, orgID primitive.ObjectID) bson.M {
+	if orgID.IsZero() {
+		filter["org_id"] = bson.M{"$exists": false}
+	} else {
+		filter["org_id"] = orgID
+	}
+	return filter
+}
+
+// activeTenant returns the organization selected by ResolveTenant, or zero for the
+// personal workspace
+func activeTenant(r *http.Request) primitive.ObjectID {
+	if membership, ok := r.Context().Value("tenant").(*models.Membership); ok && membership != nil {
+		return membership.OrgID
+	}
+	return primitive.NilObjectID
+}
+
+// membershipFromContext returns the caller's membership resolved by RequireOrgMember
+func membershipFromContext(r *http.Request) (*models.Membership, bool) {
+	membership, ok := r.Context().Value("membership").(*models.Membership)
+	return membership, ok && membership != nil
+}
+
+// findMembership returns the user's membership of the organization, or nil if they are not a member
+func findMembership(ctx context.Context, orgID, userID primitive.ObjectID) (*models.Membership, error) {
+	var membership models.Membership
+	err := membershipsCollection.FindOne(ctx, bson.M{"org_id": orgID, "user_id": userID}).Decode(&membership)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &membership, nil
+}
+
+// removeMemberships takes a deleted account out of every organization
+func removeMemberships(ctx context.Context, userID primitive.ObjectID) {
+	cursor, err := membershipsCollection.Find(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		log.Printf("Error retrieving memberships of %s: %v", userID.Hex(), err)
+		return
+	}
+	defer cursor.Close(ctx)
+	var memberships []models.Membership
+	if err := cursor.All(ctx, &memberships); err != nil {
+		log.Printf("Error decoding memberships of %s: %v", userID.Hex(), err)
+		return
+	}
+	for _, membership := range memberships {
+		if _, err := membershipsCollection.DeleteOne(ctx, bson.M{"_id": membership.ID}); err != nil {
+			log.Printf("Error removing %s from organization %s: %v", userID.Hex(), membership.OrgID.Hex(), err)
+		}
+	}
+}
+
+// ResolveTenant selects the workspace for user-scoped routes from the X-Organization-ID
+// header. The account must be a member of that organization. It must run after
+// RequireAccountOwner.
+func ResolveTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get(tenantHeader)
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		targetUser, ok := targetUserFromContext(r)
+		if !ok {
+			http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+			return
+		}
+		orgID, err := primitive.ObjectIDFromHex(header)
+		if err != nil {
+			http.Error(w, "Invalid organization ID", http.StatusBadRequest)
+			return
+		}
+
+		membership, err := findMembership(r.Context(), orgID, targetUser.ID)
+		if err != nil {
+			log.Printf("Error looking up membership of %s in %s: %v", targetUser.Email, header, err)
+			http.Error(w, "Error retrieving organization", http.StatusInternalServerError)
+			return
+		}
+		if membership == nil {
+			log.Printf("Unauthorized %s %s by %s in organization %s", r.Method, r.URL.Path, targetUser.Email, header)
+			http.Error(w, "You are not a member of this organization", http.StatusForbidden)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), "tenant", membership)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequireOrgMember ensures the caller belongs to the organization named by the {orgID}
+// route variable and attaches their membership to the request context. It must run after
+// JWTAuthMiddleware. Unknown organizations get the same answer as other people's.
+func RequireOrgMember(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := callerID(r)
+		if !ok {
+			http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+			return
+		}
+		orgID, err := primitive.ObjectIDFromHex(mux.Vars(r)["orgID"])
+		if err != nil {
+			http.Error(w, "Invalid organization ID", http.StatusBadRequest)
+			return
+		}
+
+		membership, err := findMembership(r.Context(), orgID, userID)
+		if err != nil {
+			log.Printf("Error looking up membership in %s: %v", orgID.Hex(), err)
+			http.Error(w, "Error retrieving organization", http.StatusInternalServerError)
+			return
+		}
+		if membership == nil {
+			http.Error(w, "You are not a member of this organization", http.StatusForbidden)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), "membership", membership)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireOrgManager answers 403 unless the caller is an owner or admin of the organization
+func requireOrgManager(w http.ResponseWriter, r *http.Request) (*models.Membership, bool) {
+	membership, ok := membershipFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return nil, false
+	}
+	if !membership.CanManage() {
+		http.Error(w, "Only organization owners and admins can do this", http.StatusForbidden)
+		return nil, false
+	}
+	return membership, true
+}
+
+// orgAudit records an organization event with the organization in its details
+func orgAudit(r *http.Request, action string, orgID primitive.ObjectID, event audit.Event) {
+	event.Action = action
+	if event.Details == nil {
+		event.Details = map[string]string{}
+	}
+	event.Details["org_id"] = orgID.Hex()
+	recordAudit(r, event)
+}
+
+// CreateOrganization creates an organization named {"name": "..."} with the caller as its owner
+func CreateOrganization(w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerID(r)
+	if !ok {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid organization data", http.StatusBadRequest)
+		return
+	}
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" || len(body.Name) > 100 {
+		http.Error(w, "Organization name must be 1 to 100 characters", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	org := models.Organization{ID: primitive.NewObjectID(), Name: body.Name, CreatedBy: userID, CreatedAt: now}
+	if _, err := organizationsCollection.InsertOne(r.Context(), org); err != nil {
+		log.Printf("Error creating organization %q: %v", org.Name, err)
+		http.Error(w, "Error creating organization", http.StatusInternalServerError)
+		return
+	}
+	membership := models.Membership{ID: primitive.NewObjectID(), OrgID: org.ID, UserID: userID, Role: models.OrgRoleOwner, JoinedAt: now}
+	if _, err := membershipsCollection.InsertOne(r.Context(), membership); err != nil {
+		log.Printf("Error adding owner to organization %s: %v", org.ID.Hex(), err)
+		http.Error(w, "Error creating organization", http.StatusInternalServerError)
+		return
+	}
+
+	orgAudit(r, audit.ActionOrgCreate, org.ID, audit.Event{Target: org.Name})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"organization": org, "role": membership.Role})
+}
+
+// ListOrganizations returns the organizations the caller belongs to and their role in each
+func ListOrganizations(w http.ResponseWriter, r *http.Request) {
+	userID, ok := callerID(r)
+	if !ok {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+
+	cursor, err := membershipsCollection.Find(r.Context(), bson.M{"user_id": userID})
+	if err != nil {
+		log.Printf("Error retrieving memberships of %s: %v", userID.Hex(), err)
+		http.Error(w, "Error retrieving organizations", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(r.Context())
+	var memberships []models.Membership
+	if err := cursor.All(r.Context(), &memberships); err != nil {
+		log.Printf("Error decoding memberships: %v", err)
+		http.Error(w, "Error retrieving organizations", http.StatusInternalServerError)
+		return
+	}
+
+	type organizationView struct {
+		models.Organization
+		Role     string    `json:"role"`
+		JoinedAt time.Time `json:"joined_at"`
+	}
+	organizations := []organizationView{}
+	for _, membership := range memberships {
+		var org models.Organization
+		if err := organizationsCollection.FindOne(r.Context(), bson.M{"_id": membership.OrgID}).Decode(&org); err != nil {
+			log.Printf("Error retrieving organization %s: %v", membership.OrgID.Hex(), err)
+			continue
+		}
+		organizations = append(organizations, organizationView{Organization: org, Role: membership.Role, JoinedAt: membership.JoinedAt})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(organizations)
+}
+
+// ListMembers returns the organization's members with their names and roles
+func ListMembers(w http.ResponseWriter, r *http.Request) {
+	membership, ok := membershipFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+
+	cursor, err := membershipsCollection.Find(r.Context(), bson.M{"org_id": membership.OrgID})
+	if err != nil {
+		log.Printf("Error retrieving members of %s: %v", membership.OrgID.Hex(), err)
+		http.Error(w, "Error retrieving members", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(r.Context())
+	var memberships []models.Membership
+	if err := cursor.All(r.Context(), &memberships); err != nil {
+		log.Printf("Error decoding memberships: %v", err)
+		http.Error(w, "Error retrieving members", http.StatusInternalServerError)
+		return
+	}
+
+	type memberView struct {
+		UserID    primitive.ObjectID `json:"user_id"`
+		Email     string             `json:"email"`
+		FirstName string             `json:"first_name"`
+		Surname   string             `json:"surname"`
+		Role      string             `json:"role"`
+		JoinedAt  time.Time          `json:"joined_at"`
+	}
+	members := []memberView{}
+	for _, m := range memberships {
+		var user models.User
+		if err := usersCollection.FindOne(r.Context(), bson.M{"_id": m.UserID}).Decode(&user); err != nil {
+			log.Printf("Error retrieving member %s: %v", m.UserID.Hex(), err)
+			continue
+		}
+		members = append(members, memberView{UserID: user.ID, Email: user.Email, FirstName: user.FirstName, Surname: user.Surname, Role: m.Role, JoinedAt: m.JoinedAt})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(members)
+}
+
+// hashInvitationToken returns the form of an invitation token stored in the database
+func hashInvitationToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// InviteMember emails an invitation to {"email": "...", "role": "member"}. Inviting the
+// same address again replaces the earlier invitation. Only owners can invite owners.
+func InviteMember(w http.ResponseWriter, r *http.Request) {
+	inviter, ok := requireOrgManager(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid invitation", http.StatusBadRequest)
+		return
+	}
+	body.Email = strings.TrimSpace(body.Email)
+	if body.Role == "" {
+		body.Role = models.OrgRoleMember
+	}
+	if !strings.Contains(body.Email, "@") || !models.ValidOrgRole(body.Role) {
+		http.Error(w, "An email address and a role of owner, admin or member are required", http.StatusBadRequest)
+		return
+	}
+	if body.Role == models.OrgRoleOwner && inviter.Role != models.OrgRoleOwner {
+		http.Error(w, "Only owners can invite owners", http.StatusForbidden)
+		return
+	}
+
+	var org models.Organization
+	if err := organizationsCollection.FindOne(r.Context(), bson.M{"_id": inviter.OrgID}).Decode(&org); err != nil {
+		log.Printf("Error retrieving organization %s: %v", inviter.OrgID.Hex(), err)
+		http.Error(w, "Error creating invitation", http.StatusInternalServerError)
+		return
+	}
+	var existing models.User
+	if err := usersCollection.FindOne(r.Context(), bson.M{"email": body.Email}).Decode(&existing); err == nil {
+		if member, err := findMembership(r.Context(), org.ID, existing.ID); err == nil && member != nil {
+			http.Error(w, "This user is already a member", http.StatusConflict)
+			return
+		}
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		log.Printf("Error generating invitation token: %v", err)
+		http.Error(w, "Error creating invitation", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	invitation := models.Invitation{
+		ID:        primitive.NewObjectID(),
+		OrgID:     org.ID,
+		Email:     body.Email,
+		Role:      body.Role,
+		TokenHash: hashInvitationToken(token),
+		InvitedBy: inviter.UserID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(invitationTTL()),
+	}
+
+	// Only the newest invitation for an address stays valid
+	if _, err := invitationsCollection.UpdateMany(r.Context(), bson.M{
+		"org_id":      org.ID,
+		"email":       body.Email,
+		"accepted_at": bson.M{"$exists": false},
+	}, bson.M{"$set": bson.M{"expires_at": now}}); err != nil {
+		log.Printf("Error retiring earlier invitations for %s: %v", body.Email, err)
+	}
+	if _, err := invitationsCollection.InsertOne(r.Context(), invitation); err != nil {
+		log.Printf("Error storing invitation for %s: %v", body.Email, err)
+		http.Error(w, "Error creating invitation", http.StatusInternalServerError)
+		return
+	}
+
+	inviterName := ""
+	if claims, ok := claimsFromContext(r); ok {
+		inviterName = claims.Email
+	}
+	msg, err := mailer.Invitation.Render(body.Email, mailer.InvitationData{
+		Inviter:      inviterName,
+		Organization: org.Name,
+		Role:         body.Role,
+		Link:         appBaseURL() + "/invitations#token=" + url.QueryEscape(token),
+		ExpiresIn:    humanDuration(invitationTTL()),
+	})
+	if err != nil {
+		log.Printf("Error rendering invitation for %s: %v", body.Email, err)
+	} else {
+		sendMessage(msg)
+	}
+
+	orgAudit(r, audit.ActionOrgInvite, org.ID, audit.Event{
+		Target:  body.Email,
+		Details: map[string]string{"role": body.Role},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(invitation)
+}
+
+// ListInvitations returns the organization's invitations that can still be accepted
+func ListInvitations(w http.ResponseWriter, r *http.Request) {
+	membership, ok := requireOrgManager(w, r)
+	if !ok {
+		return
+	}
+
+	cursor, err := invitationsCollection.Find(r.Context(), bson.M{
+		"org_id":      membership.OrgID,
+		"accepted_at": bson.M{"$exists": false},
+	})
+	if err != nil {
+		log.Printf("Error retrieving invitations of %s: %v", membership.OrgID.Hex(), err)
+		http.Error(w, "Error retrieving invitations", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(r.Context())
+	var all []models.Invitation
+	if err := cursor.All(r.Context(), &all); err != nil {
+		log.Printf("Error decoding invitations: %v", err)
+		http.Error(w, "Error retrieving invitations", http.StatusInternalServerError)
+		return
+	}
+
+	pending := []models.Invitation{}
+	now := time.Now()
+	for _, invitation := range all {
+		if now.Before(invitation.ExpiresAt) {
+			pending = append(pending, invitation)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pending)
+}
+
+// RevokeInvitation withdraws a pending invitation
+func RevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	membership, ok := requireOrgManager(w, r)
+	if !ok {
+		return
+	}
+	invitationID, err := primitive.ObjectIDFromHex(mux.Vars(r)["invitationID"])
+	if err != nil {
+		http.Error(w, "Invalid invitation ID", http.StatusBadRequest)
+		return
+	}
+
+	var invitation models.Invitation
+	err = invitationsCollection.FindOne(r.Context(), bson.M{"_id": invitationID, "org_id": membership.OrgID}).Decode(&invitation)
+	if err != nil {
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+		return
+	}
+	if _, err := invitationsCollection.UpdateOne(r.Context(), bson.M{"_id": invitation.ID}, bson.M{"$set": bson.M{"expires_at": time.Now()}}); err != nil {
+		log.Printf("Error revoking invitation %s: %v", invitation.ID.Hex(), err)
+		http.Error(w, "Error revoking invitation", http.StatusInternalServerError)
+		return
+	}
+
+	orgAudit(r, audit.ActionOrgInviteRevoke, membership.OrgID, audit.Event{Target: invitation.Email})
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Invitation revoked"})
+}
+
+// AcceptInvitation adds the caller to an organization using {"token": "..."} from an
+// invitation email. The caller's account must have the email the invitation was sent to.
+func AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	claims, ok := claimsFromContext(r)
+	userID, found := callerID(r)
+	if !ok || !found {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Token == "" {
+		http.Error(w, "Invalid invitation", http.StatusBadRequest)
+		return
+	}
+
+	var invitation models.Invitation
+	err := invitationsCollection.FindOne(r.Context(), bson.M{"token_hash": hashInvitationToken(body.Token)}).Decode(&invitation)
+	if err != nil || invitation.AcceptedAt != nil || time.Now().After(invitation.ExpiresAt) {
+		http.Error(w, "Invalid or expired invitation", http.StatusBadRequest)
+		return
+	}
+	if !strings.EqualFold(invitation.Email, claims.Email) {
+		log.Printf("Invitation for %s presented by %s", invitation.Email, claims.Email)
+		http.Error(w, "This invitation was sent to a different email address", http.StatusForbidden)
+		return
+	}
+
+	// Conditional update so the same invitation cannot be accepted twice
+	now := time.Now()
+	result, err := invitationsCollection.UpdateOne(r.Context(), bson.M{
+		"_id":         invitation.ID,
+		"accepted_at": bson.M{"$exists": false},
+	}, bson.M{"$set": bson.M{"accepted_at": now}})
+	if err != nil {
+		log.Printf("Error accepting invitation %s: %v", invitation.ID.Hex(), err)
+		http.Error(w, "Error accepting invitation", http.StatusInternalServerError)
+		return
+	}
+	if result.ModifiedCount == 0 {
+		http.Error(w, "Invalid or expired invitation", http.StatusBadRequest)
+		return
+	}
+
+	membership, err := findMembership(r.Context(), invitation.OrgID, userID)
+	if err != nil {
+		log.Printf("Error looking up membership in %s: %v", invitation.OrgID.Hex(), err)
+		http.Error(w, "Error accepting invitation", http.StatusInternalServerError)
+		return
+	}
+	if membership == nil {
+		membership = &models.Membership{ID: primitive.NewObjectID(), OrgID: invitation.OrgID, UserID: userID, Role: invitation.Role, JoinedAt: now}
+		if _, err := membershipsCollection.InsertOne(r.Context(), membership); err != nil {
+			log.Printf("Error adding %s to organization %s: %v", claims.Email, invitation.OrgID.Hex(), err)
+			http.Error(w, "Error accepting invitation", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	orgAudit(r, audit.ActionOrgJoin, invitation.OrgID, audit.Event{
+		TargetUserID: userID,
+		Target:       claims.Email,
+		Details:      map[string]string{"role": membership.Role},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(membership)
+}
+
+// countOwners returns how many owners the organization has
+func countOwners(ctx context.Context, orgID primitive.ObjectID) (int, error) {
+	cursor, err := membershipsCollection.Find(ctx, bson.M{"org_id": orgID, "role": models.OrgRoleOwner})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+	var owners []models.Membership
+	if err := cursor.All(ctx, &owners); err != nil {
+		return 0, err
+	}
+	return len(owners), nil
+}
+
+// resolveMember looks up the membership of the user named by the {userID} route variable
+func resolveMember(w http.ResponseWriter, r *http.Request, orgID primitive.ObjectID) (*models.Membership, bool) {
+	userID, err := primitive.ObjectIDFromHex(mux.Vars(r)["userID"])
+	if err != nil {
+		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+		return nil, false
+	}
+	member, err := findMembership(r.Context(), orgID, userID)
+	if err != nil {
+		log.Printf("Error looking up member %s of %s: %v", userID.Hex(), orgID.Hex(), err)
+		http.Error(w, "Error retrieving member", http.StatusInternalServerError)
+		return nil, false
+	}
+	if member == nil {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return nil, false
+	}
+	return member, true
+}
+
+// UpdateMemberRole changes a member's role with {"role": "admin"}. Only owners can make
+// someone an owner or change an owner's role, and the last owner cannot be demoted.
+func UpdateMemberRole(w http.ResponseWriter, r *http.Request) {
+	caller, ok := requireOrgManager(w, r)
+	if !ok {
+		return
+	}
+	member, ok := resolveMember(w, r, caller.OrgID)
+	if !ok {
+		return
+	}
+	var body struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || !models.ValidOrgRole(body.Role) {
+		http.Error(w, "Role must be owner, admin or member", http.StatusBadRequest)
+		return
+	}
+	if (body.Role == models.OrgRoleOwner || member.Role == models.OrgRoleOwner) && caller.Role != models.OrgRoleOwner {
+		http.Error(w, "Only owners can change owners", http.StatusForbidden)
+		return
+	}
+	if member.Role == models.OrgRoleOwner && body.Role != models.OrgRoleOwner {
+		owners, err := countOwners(r.Context(), caller.OrgID)
+		if err != nil {
+			log.Printf("Error counting owners of %s: %v", caller.OrgID.Hex(), err)
+			http.Error(w, "Error updating member", http.StatusInternalServerError)
+			return
+		}
+		if owners <= 1 {
+			http.Error(w, "An organization must keep at least one owner", http.StatusConflict)
+			return
+		}
+	}
+
+	if _, err := membershipsCollection.UpdateOne(r.Context(), bson.M{"_id": member.ID}, bson.M{"$set": bson.M{"role": body.Role}}); err != nil {
+		log.Printf("Error updating member %s of %s: %v", member.UserID.Hex(), caller.OrgID.Hex(), err)
+		http.Error(w, "Error updating member", http.StatusInternalServerError)
+		return
+	}
+
+	orgAudit(r, audit.ActionOrgMemberRole, caller.OrgID, audit.Event{
+		TargetUserID: member.UserID,
+		Details:      map[string]string{"previous": member.Role, "role": body.Role},
+	})
+	member.Role = body.Role
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(member)
+}
+
+// RemoveMember removes a member from the organization; members may also remove themselves
+// to leave. Their documents stay with the organization. Admins cannot remove owners and
+// the last owner cannot leave.
+func RemoveMember(w http.ResponseWriter, r *http.Request) {
+	caller, ok := membershipFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+	member, ok := resolveMember(w, r, caller.OrgID)
+	if !ok {
+		return
+	}
+	leaving := member.UserID == caller.UserID
+	if !leaving && !caller.CanManage() {
+		http.Error(w, "Only organization owners and admins can do this", http.StatusForbidden)
+		return
+	}
+	if !leaving && member.Role == models.OrgRoleOwner && caller.Role != models.OrgRoleOwner {
+		http.Error(w, "Only owners can change owners", http.StatusForbidden)
+		return
+	}
+	if member.Role == models.OrgRoleOwner {
+		owners, err := countOwners(r.Context(), caller.OrgID)
+		if err != nil {
+			log.Printf("Error counting owners of %s: %v", caller.OrgID.Hex(), err)
+			http.Error(w, "Error removing member", http.StatusInternalServerError)
+			return
+		}
+		if owners <= 1 {
+			http.Error(w, "An organization must keep at least one owner", http.StatusConflict)
+			return
+		}
+	}
+
+	if _, err := membershipsCollection.DeleteOne(r.Context(), bson.M{"_id": member.ID}); err != nil {
+		log.Printf("Error removing member %s of %s: %v", member.UserID.Hex(), caller.OrgID.Hex(), err)
+		http.Error(w, "Error removing member", http.StatusInternalServerError)
+		return
+	}
+
+	orgAudit(r, audit.ActionOrgMemberRemove, caller.OrgID, audit.Event{
+		TargetUserID: member.UserID,
+		Details:      map[string]string{"role": member.Role},
+	})
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Member removed"})
+}
+
+// ListOrganizationDocuments returns every document version owned by the organization, for
+// its owners and admins
+func ListOrganizationDocuments(w http.ResponseWriter, r *http.Request) {
+	membership, ok := requireOrgManager(w, r)
+	if !ok {
+		return
+	}
+
+	cursor, err := documentsCollection.Find(r.Context(), bson.M{"org_id": membership.OrgID}, paginate(r))
+	if err != nil {
+		log.Printf("Error retrieving documents of %s: %v", membership.OrgID.Hex(), err)
+		http.Error(w, "Error retrieving documents", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(r.Context())
+
+	documents := []models.Document{}
+	if err := cursor.All(r.Context(), &documents); err != nil {
+		log.Printf("Error decoding documents: %v", err)
+		http.Error(w, "Error decoding documents", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(documents)
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"DocuDefense/backend/src/models"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// createOrg creates an organization owned by the holder of accessToken and returns its ID
+func (f *authFixture) createOrg(t *testing.T, accessToken, name string) string {
+	t.Helper()
+	rec := f.post(t, "/orgs", accessToken, `{"name":"`+name+`"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create organization: got %d %q", rec.Code, rec.Body.String())
+	}
+	var body struct {
+		Organization models.Organization `json:"organization"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return body.Organization.ID.Hex()
+}
+
+// inOrg sends a GET as the holder of accessToken in the workspace orgID
+func (f *authFixture) inOrg(t *testing.T, path, accessToken, orgID string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("GET", path, nil)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set(tenantHeader, orgID)
+	rec := httptest.NewRecorder()
+	f.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func (f *authFixture) bobToken(t *testing.T) string {
+	t.Helper()
+	session, err := issueSession(context.Background(), &f.bob, nil, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session["token"].(string)
+}
+
+func TestOrganizationDocumentsAreIsolated(t *testing.T) {
+	f := newAuthFixture(t)
+	access, _ := f.login(t)
+	orgID := f.createOrg(t, access, "Acme")
+	orgObjID, _ := primitive.ObjectIDFromHex(orgID)
+	f.docs.InsertOne(context.Background(), models.Document{
+		ID: primitive.NewObjectID(), UserID: f.alice.ID, OrgID: orgObjID, Filename: "acme.pdf", Version: 1, StorageKey: "documents/alice/acme",
+	})
+	files := "/users/" + f.alice.ID.Hex() + "/files"
+
+	personal := f.inOrg(t, files, access, "")
+	if personal.Code != http.StatusOK || strings.Contains(personal.Body.String(), "acme.pdf") || !strings.Contains(personal.Body.String(), "contract.pdf") {
+		t.Fatalf("personal workspace: got %d %q", personal.Code, personal.Body.String())
+	}
+	acme := f.inOrg(t, files, access, orgID)
+	if acme.Code != http.StatusOK || !strings.Contains(acme.Body.String(), "acme.pdf") || strings.Contains(acme.Body.String(), "contract.pdf") {
+		t.Fatalf("organization workspace: got %d %q", acme.Code, acme.Body.String())
+	}
+	if rec := f.inOrg(t, files+"/contract.pdf/download", access, orgID); rec.Code != http.StatusNotFound {
+		t.Fatalf("personal file from the organization workspace: got %d, want 404", rec.Code)
+	}
+
+	if rec := f.inOrg(t, "/users/"+f.bob.ID.Hex()+"/files", f.bobToken(t), orgID); rec.Code != http.StatusForbidden {
+		t.Fatalf("non-member selecting the organization: got %d, want 403", rec.Code)
+	}
+}
+
+func TestInvitationIsAcceptedByTheInvitedEmail(t *testing.T) {
+	f := newAuthFixture(t)
+	mail := f.captureMail(t)
+	access, _ := f.login(t)
+	orgID := f.createOrg(t, access, "Acme")
+
+	if rec := f.post(t, "/orgs/"+orgID+"/invitations", access, `{"email":"bob@example.com","role":"member"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("invite: got %d %q", rec.Code, rec.Body.String())
+	}
+	token := linkToken(t, mail.next(t, "invited to Acme"))
+	body := `{"token":"` + token + `"}`
+
+	if rec := f.post(t, "/invitations/accept", access, body); rec.Code != http.StatusForbidden {
+		t.Fatalf("accepting someone else's invitation: got %d, want 403", rec.Code)
+	}
+	bob := f.bobToken(t)
+	if rec := f.post(t, "/invitations/accept", bob, body); rec.Code != http.StatusOK {
+		t.Fatalf("accept invitation: got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := f.post(t, "/invitations/accept", bob, body); rec.Code != http.StatusBadRequest {
+		t.Fatalf("reused invitation: got %d, want 400", rec.Code)
+	}
+	if rec := f.inOrg(t, "/users/"+f.bob.ID.Hex()+"/files", bob, orgID); rec.Code != http.StatusOK {
+		t.Fatalf("member listing organization files: got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := f.post(t, "/orgs/"+orgID+"/invitations", bob, `{"email":"carol@example.com"}`); rec.Code != http.StatusForbidden {
+		t.Fatalf("member inviting others: got %d, want 403", rec.Code)
+	}
+}
+
+func TestLastOwnerCannotLeave(t *testing.T) {
+	f := newAuthFixture(t)
+	access, _ := f.login(t)
+	orgID := f.createOrg(t, access, "Acme")
+
+	if rec := f.send(t, "DELETE", "/orgs/"+orgID+"/members/"+f.alice.ID.Hex(), access, ""); rec.Code != http.StatusConflict {
+		t.Fatalf("last owner leaving: got %d, want 409", rec.Code)
+	}
+}
@@ -0,0 +1,431 @@
+package handlers
+
+import (
+	"DocuDefense/backend/src/models"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Version of the tus resumable upload protocol the upload routes speak
+const tusVersion = "1.0.0"
+
+// tus extensions the upload routes support
+const tusExtensions = "creation,expiration,termination"
+
+// Content type every PATCH of a tus upload must carry
+const tusChunkContentType = "application/offset+octet-stream"
+
+// Resumable uploads in progress, and finished ones until they expire
+var uploadsCollection DatabaseCollection
+
+// uploadExpiry is how long an upload is kept after its last chunk, 24 hours unless
+// UPLOAD_EXPIRY is set
+func uploadExpiry() time.Duration {
+	return durationFromEnv("UPLOAD_EXPIRY", 24*time.Hour)
+}
+
+// ensureUploadIndexes indexes uploads by expiry, for ExpireUploads
+func ensureUploadIndexes(ctx context.Context) error {
+	_, err := mongoDatabase.Collection("uploads").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "expires_at", Value: 1}},
+	})
+	return err
+}
+
+// uploadPartKey returns a new blob key for a chunk of upload starting at offset. Each PATCH
+// gets its own key, so a retry racing its original never overwrites the chunk that was
+// recorded, and the request that loses only discards its own blob.
+func uploadPartKey(upload *models.Upload, offset int64) string {
+	return "uploads/" + upload.UserID.Hex() + "/" + upload.ID.Hex() + "/" + strconv.FormatInt(offset, 10) + "-" + primitive.NewObjectID().Hex()
+}
+
+// checkTusVersion rejects requests for a protocol version other than tusVersion, as the
+// protocol requires, and marks the response with the version spoken
+func checkTusVersion(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "Unsupported tus version", http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// setUploadHeaders reports an upload's progress and expiry
+func setUploadHeaders(w http.ResponseWriter, upload *models.Upload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// parseUploadMetadata decodes an Upload-Metadata header: comma-separated pairs of a key
+// and an optional base64 value
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		switch len(fields) {
+		case 0:
+			continue
+		case 1:
+			metadata[fields[0]] = ""
+		case 2:
+			value, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("metadata %q is not base64: %w", fields[0], err)
+			}
+			metadata[fields[0]] = string(value)
+		default:
+			return nil, fmt.Errorf("invalid metadata pair %q", pair)
+		}
+	}
+	return metadata, nil
+}
+
+// GetUploadOptions describes the tus protocol support of the upload routes
+func GetUploadOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(maxUploadSize(), 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateUpload starts a resumable upload of a new version of one of the user's files in
+// the active workspace. Upload-Length gives the file's size and Upload-Metadata its
+// filename, optional note and optional expected_version. Like If-Match, that is checked
+// now and again when the upload completes, as is any check-out of the file.
+func CreateUpload(w http.ResponseWriter, r *http.Request) {
+	if !checkTusVersion(w, r) {
+		return
+	}
+	userIDObj, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		log.Printf("Invalid user ID format: %v", err)
+		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		http.Error(w, "Upload-Length must be a positive size", http.StatusBadRequest)
+		return
+	}
+	if limit := maxUploadSize(); length > limit {
+		writeRejection(w, http.StatusRequestEntityTooLarge, uploadRejection{
+			Error:   "too_large",
+			Message: "The file is larger than the " + strconv.FormatInt(limit>>20, 10) + " MiB upload limit",
+		})
+		return
+	}
+	metadata, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, "Invalid Upload-Metadata", http.StatusBadRequest)
+		return
+	}
+	filename := strings.ReplaceAll(metadata["filename"], " ", "_")
+	if filename == "" {
+		http.Error(w, "Upload-Metadata must include a filename", http.StatusBadRequest)
+		return
+	}
+
+	expected, ok := expectedVersion(w, r, userIDObj, activeTenant(r), filename, metadata["expected_version"])
+	if !ok {
+		return
+	}
+	// Refuse a file checked out to someone else before any chunks are sent
+	uploaderID, _ := callerID(r)
+	if err := checkLock(r.Context(), userIDObj, activeTenant(r), filename, uploaderID); err != nil {
+		writeVersionError(w, r, err, filename)
+		return
+	}
+
+	uploadedBy := ""
+	if claims, ok := claimsFromContext(r); ok {
+		uploadedBy = claims.Email
+	}
+	now := time.Now()
+	upload := models.Upload{
+		ID:              primitive.NewObjectID(),
+		UserID:          userIDObj,
+		OrgID:           activeTenant(r),
+		Filename:        filename,
+		ChangeNote:      metadata["note"],
+		UploadedBy:      uploadedBy,
+		Length:          length,
+		ExpectedVersion: expected,
+		Parts:           []models.UploadPart{},
+		CreatedAt:       now,
+		ExpiresAt:       now.Add(uploadExpiry()),
+	}
+	if _, err := uploadsCollection.InsertOne(r.Context(), upload); err != nil {
+		log.Printf("Error creating upload of %s: %v", filename, err)
+		http.Error(w, "Error creating upload", http.StatusInternalServerError)
+		return
+	}
+
+	setUploadHeaders(w, &upload)
+	w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+upload.ID.Hex())
+	w.WriteHeader(http.StatusCreated)
+}
+
+// findUpload loads the {uploadID} upload of the {id} user in the active workspace. Expired
+// uploads are discarded and answered with 410.
+func findUpload(w http.ResponseWriter, r *http.Request) (*models.Upload, bool) {
+	params := mux.Vars(r)
+	userIDObj, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+		return nil, false
+	}
+	uploadID, err := primitive.ObjectIDFromHex(params["uploadID"])
+	if err != nil {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	var upload models.Upload
+	err = uploadsCollection.FindOne(r.Context(), inTenant(bson.M{"_id": uploadID, "user_id": userIDObj}, activeTenant(r))).Decode(&upload)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Error retrieving upload %s: %v", uploadID.Hex(), err)
+		http.Error(w, "Error retrieving upload", http.StatusInternalServerError)
+		return nil, false
+	}
+	if time.Now().After(upload.ExpiresAt) {
+		discardUpload(r.Context(), &upload)
+		http.Error(w, "Upload has expired", http.StatusGone)
+		return nil, false
+	}
+	return &upload, true
+}
+
+// GetUploadOffset reports how much of an upload has been received, so a client can resume
+func GetUploadOffset(w http.ResponseWriter, r *http.Request) {
+	if !checkTusVersion(w, r) {
+		return
+	}
+	upload, ok := findUpload(w, r)
+	if !ok {
+		return
+	}
+	setUploadHeaders(w, upload)
+	w.WriteHeader(http.StatusOK)
+}
+
+// PatchUpload appends a chunk at Upload-Offset. The chunk that completes the upload turns
+// it into the next version of the file, through the same checks as UploadFile.
+func PatchUpload(w http.ResponseWriter, r *http.Request) {
+	if !checkTusVersion(w, r) {
+		return
+	}
+	if r.Header.Get("Content-Type") != tusChunkContentType {
+		http.Error(w, "Content-Type must be "+tusChunkContentType, http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "Invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+	upload, ok := findUpload(w, r)
+	if !ok {
+		return
+	}
+	if upload.Completed() {
+		http.Error(w, "Upload is already complete", http.StatusConflict)
+		return
+	}
+	if offset != upload.Offset {
+		setUploadHeaders(w, upload)
+		http.Error(w, "Upload-Offset does not match the upload", http.StatusConflict)
+		return
+	}
+	remaining := upload.Length - upload.Offset
+	if r.ContentLength > remaining {
+		http.Error(w, "Chunk extends past Upload-Length", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// Store the chunk sealed, like a version; a chunk cut short by a dropped connection
+	// is discarded and the client resumes from the last stored offset
+	part := models.UploadPart{StorageKey: uploadPartKey(upload, offset), Offset: offset}
+	counter := &countingReader{r: io.LimitReader(r.Body, remaining)}
+	sealed, err := sealContent(counter, r.ContentLength)
+	if err == nil {
+		part.EncryptionKeyID, part.WrappedDataKey = sealed.KeyID, sealed.WrappedKey
+		err = fileStorage.Put(r.Context(), part.StorageKey, sealed.Reader, sealed.Size)
+	}
+	if err != nil {
+		discardBlob(part.StorageKey)
+		log.Printf("Error storing chunk of upload %s at %d: %v", upload.ID.Hex(), offset, err)
+		http.Error(w, "Error saving chunk", http.StatusInternalServerError)
+		return
+	}
+	part.Size = counter.n
+	if part.Size == 0 {
+		discardBlob(part.StorageKey)
+		setUploadHeaders(w, upload)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// Only advance from the offset the chunk was written at, in case another PATCH won
+	parts := append(upload.Parts, part)
+	expiresAt := time.Now().Add(uploadExpiry())
+	result, err := uploadsCollection.UpdateOne(r.Context(), bson.M{"_id": upload.ID, "offset": offset}, bson.M{
+		"$set": bson.M{"offset": offset + part.Size, "parts": parts, "expires_at": expiresAt},
+	})
+	if err != nil || result.MatchedCount == 0 {
+		discardBlob(part.StorageKey)
+		if err != nil {
+			log.Printf("Error recording chunk of upload %s at %d: %v", upload.ID.Hex(), offset, err)
+			http.Error(w, "Error saving chunk", http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, "Upload-Offset does not match the upload", http.StatusConflict)
+		return
+	}
+	upload.Offset, upload.Parts, upload.ExpiresAt = offset+part.Size, parts, expiresAt
+
+	if upload.Offset < upload.Length {
+		setUploadHeaders(w, upload)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	finishUpload(w, r, upload)
+}
+
+// finishUpload turns a fully received upload into a version of its file. The parts are
+// removed either way; an upload whose content is refused is discarded with them.
+func finishUpload(w http.ResponseWriter, r *http.Request, upload *models.Upload) {
+	content := &uploadReader{ctx: r.Context(), parts: upload.Parts}
+	doc, ok := saveUploadedVersion(w, r, upload.UserID, upload.OrgID, upload.Filename, upload.ChangeNote, upload.ExpectedVersion, content)
+	content.Close()
+	if !ok {
+		discardUpload(r.Context(), upload)
+		return
+	}
+
+	for _, part := range upload.Parts {
+		discardBlob(part.StorageKey)
+	}
+	_, err := uploadsCollection.UpdateOne(r.Context(), bson.M{"_id": upload.ID}, bson.M{
+		"$set": bson.M{"document_id": doc.ID, "parts": []models.UploadPart{}},
+	})
+	if err != nil {
+		log.Printf("Error marking upload %s complete: %v", upload.ID.Hex(), err)
+	}
+
+	setUploadHeaders(w, upload)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TerminateUpload abandons an upload and removes the chunks received so far
+func TerminateUpload(w http.ResponseWriter, r *http.Request) {
+	if !checkTusVersion(w, r) {
+		return
+	}
+	upload, ok := findUpload(w, r)
+	if !ok {
+		return
+	}
+	if err := discardUpload(r.Context(), upload); err != nil {
+		log.Printf("Error terminating upload %s: %v", upload.ID.Hex(), err)
+		http.Error(w, "Error terminating upload", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// discardUpload removes an upload and its stored chunks
+func discardUpload(ctx context.Context, upload *models.Upload) error {
+	for _, part := range upload.Parts {
+		discardBlob(part.StorageKey)
+	}
+	_, err := uploadsCollection.DeleteOne(ctx, bson.M{"_id": upload.ID})
+	return err
+}
+
+// ExpireUploads removes every upload that has not received a chunk within UPLOAD_EXPIRY,
+// along with its chunks, and returns how many were removed
+func ExpireUploads(ctx context.Context) (int, error) {
+	cursor, err := uploadsCollection.Find(ctx, bson.M{"expires_at": bson.M{"$lt": time.Now()}})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var expired []models.Upload
+	if err := cursor.All(ctx, &expired); err != nil {
+		return 0, err
+	}
+	for i := range expired {
+		if err := discardUpload(ctx, &expired[i]); err != nil {
+			return i, fmt.Errorf("removing upload %s: %w", expired[i].ID.Hex(), err)
+		}
+	}
+	return len(expired), nil
+}
+
+// uploadReader streams the plaintext of an upload's parts in order, opening each part
+// only when the one before it is exhausted
+type uploadReader struct {
+	ctx     context.Context
+	parts   []models.UploadPart
+	current io.ReadCloser
+}
+
+func (u *uploadReader) Read(p []byte) (int, error) {
+	for {
+		if u.current == nil {
+			if len(u.parts) == 0 {
+				return 0, io.EOF
+			}
+			part := u.parts[0]
+			u.parts = u.parts[1:]
+			blob, err := openSealedBlob(u.ctx, part.StorageKey, part.EncryptionKeyID, part.WrappedDataKey)
+			if err != nil {
+				return 0, fmt.Errorf("opening chunk at %d: %w", part.Offset, err)
+			}
+			u.current = blob
+		}
+		n, err := u.current.Read(p)
+		if err == io.EOF {
+			u.current.Close()
+			u.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+// Close closes the part being read, if any
+func (u *uploadReader) Close() error {
+	if u.current == nil {
+		return nil
+	}
+	err := u.current.Close()
+	u.current = nil
+	return err
+}
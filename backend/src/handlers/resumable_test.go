@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"DocuDefense/backend/src/models"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// tus sends a tus 1.0 request with the given headers
+func (f *authFixture) tus(t *testing.T, method, path, accessToken string, headers map[string]string, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Tus-Resumable", tusVersion)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	f.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// createUpload starts a tus upload of length bytes as filename and returns its location
+func (f *authFixture) createUpload(t *testing.T, accessToken, filename string, length int) string {
+	t.Helper()
+	rec := f.tus(t, "POST", "/users/"+f.alice.ID.Hex()+"/uploads", accessToken, map[string]string{
+		"Upload-Length":   strconv.Itoa(length),
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte(filename)) + ",note " + base64.StdEncoding.EncodeToString([]byte("signed scan")),
+	}, nil)
+	if rec.Code != http.StatusCreated || rec.Header().Get("Location") == "" || rec.Header().Get("Upload-Expires") == "" {
+		t.Fatalf("creating upload: got %d %q %v", rec.Code, rec.Body.String(), rec.Header())
+	}
+	return rec.Header().Get("Location")
+}
+
+// racingUploads runs race just before its first update, like a retried PATCH that records
+// the same chunk before the original does
+type racingUploads struct {
+	*fakeCollection
+	race func()
+}
+
+func (c *racingUploads) UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	if c.race != nil {
+		race := c.race
+		c.race = nil
+		race()
+	}
+	return c.fakeCollection.UpdateOne(ctx, filter, update, opts...)
+}
+
+func patchChunk(offset int) map[string]string {
+	return map[string]string{"Content-Type": tusChunkContentType, "Upload-Offset": strconv.Itoa(offset)}
+}
+
+func TestResumableUploadBecomesVersion(t *testing.T) {
+	f := newAuthFixture(t)
+	access, _ := f.login(t)
+	content := testPDF("")
+	location := f.createUpload(t, access, "scanned lease.pdf", len(content))
+	half := len(content) / 2
+
+	if rec := f.tus(t, "PATCH", location, access, patchChunk(0), content[:half]); rec.Code != http.StatusNoContent || rec.Header().Get("Upload-Offset") != strconv.Itoa(half) {
+		t.Fatalf("first chunk: got %d %q, offset %q", rec.Code, rec.Body.String(), rec.Header().Get("Upload-Offset"))
+	}
+	// After a dropped connection the client asks where to resume
+	rec := f.tus(t, "HEAD", location, access, nil, nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Upload-Offset") != strconv.Itoa(half) || rec.Header().Get("Upload-Length") != strconv.Itoa(len(content)) {
+		t.Fatalf("offset: got %d %v", rec.Code, rec.Header())
+	}
+	if rec := f.tus(t, "PATCH", location, access, patchChunk(0), content[:half]); rec.Code != http.StatusConflict {
+		t.Fatalf("chunk at a stale offset: got %d, want 409", rec.Code)
+	}
+	if rec := f.tus(t, "PATCH", location, access, patchChunk(half), content[half:]); rec.Code != http.StatusNoContent {
+		t.Fatalf("last chunk: got %d %q", rec.Code, rec.Body.String())
+	}
+
+	var doc models.Document
+	if err := f.docs.FindOne(context.Background(), bson.M{"filename": "scanned_lease.pdf"}).Decode(&doc); err != nil {
+		t.Fatalf("the completed upload did not become a version: %v", err)
+	}
+	sum := sha256.Sum256(content)
+	if doc.Version != 1 || doc.SHA256 != hex.EncodeToString(sum[:]) || doc.ChangeNote != "signed scan" || doc.Size != int64(len(content)) {
+		t.Fatalf("recorded version: %+v", doc)
+	}
+	if parts, _ := fileStorage.List(context.Background(), "uploads/"); len(parts) != 0 {
+		t.Fatalf("chunks left in storage: %v", parts)
+	}
+	if rec := f.tus(t, "PATCH", location, access, patchChunk(len(content)), nil); rec.Code != http.StatusConflict {
+		t.Fatalf("chunk after completion: got %d, want 409", rec.Code)
+	}
+}
+
+func TestResumableUploadIsValidatedOnCompletion(t *testing.T) {
+	f := newAuthFixture(t)
+	access, _ := f.login(t)
+	content := testPDF("/OpenAction << /S /JavaScript /JS (app.alert(1)) >>")
+	location := f.createUpload(t, access, "lease.pdf", len(content))
+
+	if rec := f.tus(t, "PATCH", location, access, patchChunk(0), content); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("risky PDF: got %d %q, want 422", rec.Code, rec.Body.String())
+	}
+	if err := f.docs.FindOne(context.Background(), bson.M{"filename": "lease.pdf"}).Err(); err == nil {
+		t.Fatal("a refused upload became a version")
+	}
+	if rec := f.tus(t, "HEAD", location, access, nil, nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("refused upload: got %d, want 404", rec.Code)
+	}
+}
+
+func TestResumableUploadProtocolErrors(t *testing.T) {
+	f := newAuthFixture(t)
+	access, _ := f.login(t)
+	uploads := "/users/" + f.alice.ID.Hex() + "/uploads"
+
+	rec := f.tus(t, "POST", uploads, access, map[string]string{"Tus-Resumable": "0.2.2", "Upload-Length": "10"}, nil)
+	if rec.Code != http.StatusPreconditionFailed || rec.Header().Get("Tus-Version") != tusVersion {
+		t.Fatalf("unsupported version: got %d %v", rec.Code, rec.Header())
+	}
+	if rec := f.tus(t, "POST", uploads, access, map[string]string{"Upload-Length": "10"}, nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("missing filename: got %d, want 400", rec.Code)
+	}
+	t.Setenv("UPLOAD_MAX_BYTES", "1024")
+	if rec := f.tus(t, "POST", uploads, access, map[string]string{"Upload-Length": "2048", "Upload-Metadata": "filename YS5wZGY="}, nil); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("too large: got %d, want 413", rec.Code)
+	}
+
+	location := f.createUpload(t, access, "a.pdf", 100)
+	if rec := f.tus(t, "PATCH", location, access, map[string]string{"Content-Type": "application/pdf", "Upload-Offset": "0"}, []byte("%PDF")); rec.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("wrong content type: got %d, want 415", rec.Code)
+	}
+	if rec := f.tus(t, "PATCH", location, access, patchChunk(0), make([]byte, 101)); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("chunk past the length: got %d, want 413", rec.Code)
+	}
+	if rec := f.tus(t, "PATCH", location, access, patchChunk(0), []byte("%PDF")); rec.Code != http.StatusNoContent {
+		t.Fatalf("chunk: got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := f.tus(t, "HEAD", location, f.bobToken(t), nil, nil); rec.Code != http.StatusForbidden {
+		t.Fatalf("another user's upload: got %d, want 403", rec.Code)
+	}
+	if rec := f.tus(t, "DELETE", location, access, nil, nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("terminating: got %d", rec.Code)
+	}
+	if rec := f.tus(t, "HEAD", location, access, nil, nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("terminated upload: got %d, want 404", rec.Code)
+	}
+
+	// Abandoned uploads expire, with their chunks
+	location = f.createUpload(t, access, "b.pdf", 100)
+	f.tus(t, "PATCH", location, access, patchChunk(0), []byte("%PDF"))
+	f.uploads.UpdateMany(context.Background(), bson.M{}, bson.M{"$set": bson.M{"expires_at": time.Now().Add(-time.Minute)}})
+	if rec := f.tus(t, "HEAD", location, access, nil, nil); rec.Code != http.StatusGone {
+		t.Fatalf("expired upload: got %d, want 410", rec.Code)
+	}
+	location = f.createUpload(t, access, "c.pdf", 100)
+	f.tus(t, "PATCH", location, access, patchChunk(0), []byte("%PDF"))
+	f.uploads.UpdateMany(context.Background(), bson.M{}, bson.M{"$set": bson.M{"expires_at": time.Now().Add(-time.Minute)}})
+	if removed, err := ExpireUploads(context.Background()); err != nil || removed != 1 {
+		t.Fatalf("expiring uploads: removed %d, %v", removed, err)
+	}
+	if parts, _ := fileStorage.List(context.Background(), "uploads/"); len(parts) != 0 {
+		t.Fatalf("chunks left in storage: %v", parts)
+	}
+}
+
+func TestRetriedChunkDoesNotDiscardTheRecordedOne(t *testing.T) {
+	f := newAuthFixture(t)
+	access, _ := f.login(t)
+	content := testPDF("")
+	location := f.createUpload(t, access, "lease.pdf", len(content))
+	half := len(content) / 2
+
+	// The retry is recorded while the timed-out original is still being stored
+	uploadsCollection = &racingUploads{fakeCollection: f.uploads, race: func() {
+		if rec := f.tus(t, "PATCH", location, access, patchChunk(0), content[:half]); rec.Code != http.StatusNoContent {
+			t.Fatalf("retried chunk: got %d %q", rec.Code, rec.Body.String())
+		}
+	}}
+	t.Cleanup(func() { uploadsCollection = f.uploads })
+	if rec := f.tus(t, "PATCH", location, access, patchChunk(0), content[:half]); rec.Code != http.StatusConflict {
+		t.Fatalf("original chunk that lost the race: got %d, want 409", rec.Code)
+	}
+
+	if rec := f.tus(t, "PATCH", location, access, patchChunk(half), content[half:]); rec.Code != http.StatusNoContent {
+		t.Fatalf("last chunk: got %d %q", rec.Code, rec.Body.String())
+	}
+	var doc models.Document
+	if err := f.docs.FindOne(context.Background(), bson.M{"filename": "lease.pdf"}).Decode(&doc); err != nil {
+		t.Fatalf("the completed upload did not become a version: %v", err)
+	}
+	if sum := sha256.Sum256(content); doc.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Fatalf("stored content does not match the upload")
+	}
+}
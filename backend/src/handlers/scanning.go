@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"DocuDefense/backend/src/audit"
+	"DocuDefense/backend/src/models"
+	"DocuDefense/backend/src/scanner"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Malware scanner every new version passes through before it is recorded
+var malwareScanner scanner.Scanner = scanner.Noop{}
+
+// Versions found to be infected, kept for administrators to review
+var quarantineCollection DatabaseCollection
+
+// SetScanner sets the scanner that checks uploads for malware
+func SetScanner(s scanner.Scanner) {
+	malwareScanner = s
+}
+
+// errScanFailed means the scanner could not reach a verdict, so the version was not stored
+var errScanFailed = errors.New("malware scan failed")
+
+// infectedError is returned for content the scanner reported as malware
+type infectedError struct {
+	Signature string
+}
+
+func (e *infectedError) Error() string {
+	return "infected with " + e.Signature
+}
+
+// quarantineKey returns the blob key an infected version is moved to
+func quarantineKey(doc *models.Document) string {
+	return "quarantine/" + doc.UserID.Hex() + "/" + doc.ID.Hex()
+}
+
+// scanVersion scans a stored version's content, decrypting it if needed, and records the
+// verdict on doc
+func scanVersion(ctx context.Context, doc *models.Document) error {
+	content, err := openDocumentContent(ctx, doc)
+	if err != nil {
+		return fmt.Errorf("%w: opening %s: %v", errScanFailed, doc.BlobKey(), err)
+	}
+	defer content.Close()
+
+	result, err := malwareScanner.Scan(ctx, content)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errScanFailed, err)
+	}
+	now := time.Now()
+	doc.ScannedAt = &now
+	doc.ScannedBy = malwareScanner.Name()
+	doc.ScanStatus = models.ScanClean
+	if result.Infected {
+		doc.ScanStatus = models.ScanInfected
+		doc.ScanSignature = result.Signature
+	}
+	return nil
+}
+
+// quarantineVersion copies an infected version's blob, still sealed, under the quarantine/
+// prefix and records it in the quarantine collection. The original blob is removed unless
+// another version still uses it, as versions from before per-version storage may.
+func quarantineVersion(ctx context.Context, doc *models.Document) error {
+	source := doc.BlobKey()
+	blob, err := fileStorage.Get(ctx, source)
+	if err != nil {
+		return err
+	}
+	target := quarantineKey(doc)
+	err = fileStorage.Put(ctx, target, blob, -1)
+	blob.Close()
+	if err != nil {
+		return err
+	}
+	doc.StorageKey = target
+	if _, err := quarantineCollection.InsertOne(ctx, doc); err != nil {
+		return err
+	}
+	shared, err := blobInUse(ctx, source, doc.ID)
+	switch {
+	case err != nil:
+		log.Printf("Error checking whether blob %s is still in use, keeping it: %v", source, err)
+	case shared:
+		log.Printf("Keeping blob %s, which other versions still use", source)
+	default:
+		discardBlob(source)
+	}
+	log.Printf("Quarantined %s of %s (%s) as %s", doc.Filename, doc.UserID.Hex(), doc.ScanSignature, target)
+	return nil
+}
+
+// blobInUse reports whether a version other than except reads its content from key, either
+// as its storage key or, for versions from before per-version storage, as its filename
+func blobInUse(ctx context.Context, key string, except primitive.ObjectID) (bool, error) {
+	for _, filter := range []bson.M{
+		{"storage_key": key, "_id": bson.M{"$ne": except}},
+		{"storage_key": bson.M{"$exists": false}, "filename": key, "_id": bson.M{"$ne": except}},
+	} {
+		err := documentsCollection.FindOne(ctx, filter).Err()
+		if err == nil {
+			return true, nil
+		}
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// writeVersionError answers a request whose new version could not be stored, with a
+// structured rejection when the content was infected or could not be scanned, or the file
+// changed since the client read it or is checked out to someone else
+func writeVersionError(w http.ResponseWriter, r *http.Request, err error, filename string) {
+	var infected *infectedError
+	var locked *lockedError
+	switch {
+	case errors.As(err, &infected):
+		recordAudit(r, audit.Event{
+			Action:  audit.ActionDocumentQuarantine,
+			Target:  filename,
+			Details: map[string]string{"signature": infected.Signature, "scanner": malwareScanner.Name()},
+		})
+		writeRejection(w, http.StatusUnprocessableEntity, uploadRejection{
+			Error:   "infected",
+			Message: "The file contains malware (" + infected.Signature + ") and has been quarantined",
+		})
+	case errors.As(err, &locked):
+		writeLocked(w, locked.Lock)
+	case errors.Is(err, errVersionConflict):
+		writeVersionConflict(w, nil)
+	case errors.Is(err, errScanFailed):
+		log.Printf("Error scanning %s: %v", filename, err)
+		writeRejection(w, http.StatusServiceUnavailable, uploadRejection{
+			Error:   "scan_failed",
+			Message: "The file could not be scanned for malware. Try again later.",
+		})
+	default:
+		log.Printf("Error storing %s: %v", filename, err)
+		http.Error(w, "Error saving file", http.StatusInternalServerError)
+	}
+}
+
+// ScanUnscannedDocuments scans every version stored before malware scanning was enabled and
+// records the verdict. Infected versions are quarantined and can no longer be downloaded.
+func ScanUnscannedDocuments(ctx context.Context) (scanned, infected int, err error) {
+	cursor, err := documentsCollection.Find(ctx, bson.M{"scan_status": bson.M{"$exists": false}})
+	if err != nil {
+		return 0, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc models.Document
+		if err := cursor.Decode(&doc); err != nil {
+			return scanned, infected, err
+		}
+		if err := scanVersion(ctx, &doc); err != nil {
+			return scanned, infected, fmt.Errorf("document %s: %w", doc.ID.Hex(), err)
+		}
+		scanned++
+		if doc.ScanStatus == models.ScanInfected {
+			if err := quarantineVersion(ctx, &doc); err != nil {
+				return scanned, infected, fmt.Errorf("quarantining document %s: %w", doc.ID.Hex(), err)
+			}
+			infected++
+		}
+		_, err := documentsCollection.UpdateOne(ctx, bson.M{"_id": doc.ID}, bson.M{"$set": bson.M{
+			"scan_status":    doc.ScanStatus,
+			"scan_signature": doc.ScanSignature,
+			"scanned_by":     doc.ScannedBy,
+			"scanned_at":     doc.ScannedAt,
+			"storage_key":    doc.StorageKey,
+		}})
+		if err != nil {
+			return scanned, infected, fmt.Errorf("updating document %s: %w", doc.ID.Hex(), err)
+		}
+	}
+	return scanned, infected, cursor.Err()
+}
+
+// AdminListQuarantine returns quarantined versions, paginated with page and limit
+func AdminListQuarantine(w http.ResponseWriter, r *http.Request) {
+	cursor, err := quarantineCollection.Find(r.Context(), bson.M{}, paginate(r))
+	if err != nil {
+		log.Printf("Error retrieving quarantine: %v", err)
+		http.Error(w, "Error retrieving quarantine", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(r.Context())
+
+	documents := []models.Document{}
+	if err := cursor.All(r.Context(), &documents); err != nil {
+		log.Printf("Error decoding quarantine: %v", err)
+		http.Error(w, "Error retrieving quarantine", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(documents)
+}
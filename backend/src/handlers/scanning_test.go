@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"DocuDefense/backend/src/models"
+	"DocuDefense/backend/src/scanner"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// stubScanner reports every file as infected with signature, or fails with err
+type stubScanner struct {
+	signature string
+	err       error
+}
+
+func (s stubScanner) Scan(ctx context.Context, r io.Reader) (scanner.Result, error) {
+	io.Copy(io.Discard, r)
+	if s.err != nil {
+		return scanner.Result{}, s.err
+	}
+	return scanner.Result{Infected: s.signature != "", Signature: s.signature}, nil
+}
+
+func (s stubScanner) Name() string {
+	return "stub"
+}
+
+func useScanner(t *testing.T, s scanner.Scanner) {
+	prev := malwareScanner
+	malwareScanner = s
+	t.Cleanup(func() { malwareScanner = prev })
+}
+
+func TestInfectedUploadIsQuarantined(t *testing.T) {
+	f := newAuthFixture(t)
+	access, _ := f.login(t)
+	useScanner(t, stubScanner{signature: "Eicar-Test-Signature"})
+
+	rec := f.upload(t, access, "lease.pdf", testPDF(""))
+	var rejection uploadRejection
+	json.NewDecoder(rec.Body).Decode(&rejection)
+	if rec.Code != http.StatusUnprocessableEntity || rejection.Error != "infected" {
+		t.Fatalf("got %d %+v, want 422 infected", rec.Code, rejection)
+	}
+	if err := f.docs.FindOne(context.Background(), bson.M{"filename": "lease.pdf"}).Err(); err == nil {
+		t.Fatal("an infected upload became a document version")
+	}
+
+	var quarantined models.Document
+	if err := f.quarantine.FindOne(context.Background(), bson.M{"filename": "lease.pdf"}).Decode(&quarantined); err != nil {
+		t.Fatalf("quarantine record: %v", err)
+	}
+	if quarantined.ScanStatus != models.ScanInfected || quarantined.ScanSignature != "Eicar-Test-Signature" {
+		t.Fatalf("quarantine record: %+v", quarantined)
+	}
+	if _, err := fileStorage.Stat(context.Background(), quarantineKey(&quarantined)); err != nil {
+		t.Fatalf("quarantined blob: %v", err)
+	}
+	if _, err := fileStorage.Stat(context.Background(), documentStorageKey(quarantined.UserID, quarantined.ID)); err == nil {
+		t.Fatal("the infected blob was left in place")
+	}
+}
+
+func TestUploadFailsClosedWhenScannerIsDown(t *testing.T) {
+	f := newAuthFixture(t)
+	access, _ := f.login(t)
+	useScanner(t, stubScanner{err: errors.New("connection refused")})
+
+	if rec := f.upload(t, access, "lease.pdf", testPDF("")); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got %d %q, want 503", rec.Code, rec.Body.String())
+	}
+	if err := f.docs.FindOne(context.Background(), bson.M{"filename": "lease.pdf"}).Err(); err == nil {
+		t.Fatal("an unscanned upload became a document version")
+	}
+}
+
+func TestDownloadIsBlockedUntilScanPasses(t *testing.T) {
+	f := newAuthFixture(t)
+	access, _ := f.login(t)
+	download := "/users/" + f.alice.ID.Hex() + "/files/contract.pdf/download"
+
+	f.docs.UpdateOne(context.Background(), bson.M{"filename": "contract.pdf"}, bson.M{"$set": bson.M{"scan_status": models.ScanInfected}})
+	if rec := f.send(t, "GET", download, access, ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("infected version: got %d, want 403", rec.Code)
+	}
+	f.docs.UpdateOne(context.Background(), bson.M{"filename": "contract.pdf"}, bson.M{"$set": bson.M{"scan_status": models.ScanClean}})
+	if rec := f.send(t, "GET", download, access, ""); rec.Code != http.StatusOK {
+		t.Fatalf("clean version: got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestQuarantineKeepsBlobsOtherVersionsShare(t *testing.T) {
+	f := newAuthFixture(t)
+	access, _ := f.login(t)
+	// A later version that still reads the first one's blob, as migrated versions may
+	f.docs.InsertOne(context.Background(), models.Document{
+		ID: primitive.NewObjectID(), UserID: f.alice.ID, Filename: "contract.pdf", Version: 2, UploadDate: time.Now(),
+		StorageKey: "documents/alice/contract", ScanStatus: models.ScanClean,
+	})
+	useScanner(t, stubScanner{signature: "Eicar-Test-Signature"})
+
+	scanned, infected, err := ScanUnscannedDocuments(context.Background())
+	if err != nil || scanned != 1 || infected != 1 {
+		t.Fatalf("scan: %d scanned, %d infected, %v", scanned, infected, err)
+	}
+	if key := f.docs.matching(bson.M{"version": int32(1)})[0]["storage_key"]; key == "documents/alice/contract" {
+		t.Fatal("the infected version still points at the shared blob")
+	}
+	if _, err := fileStorage.Stat(context.Background(), "documents/alice/contract"); err != nil {
+		t.Fatalf("the blob another version uses was removed: %v", err)
+	}
+	rec := f.send(t, "GET", "/users/"+f.alice.ID.Hex()+"/files/contract.pdf/download", access, "")
+	if rec.Code != http.StatusOK || rec.Body.String() != "%PDF-1.7" {
+		t.Fatalf("download of the version sharing the blob: got %d %q", rec.Code, rec.Body.String())
+	}
+}
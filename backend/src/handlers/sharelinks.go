@@ -0,0 +1,418 @@
+package handlers
+
+import (
+	"DocuDefense/backend/src/audit"
+	"DocuDefense/backend/src/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Purpose of the signed tokens carried by public share links
+const shareLinkPurpose = "share_link"
+
+// shareLinkPasswordHeader carries the password of a protected share link
+const shareLinkPasswordHeader = "X-Share-Password"
+
+// Public links to single document versions
+var shareLinksCollection DatabaseCollection
+
+// shareLinkTTL is how long a link works when no expiry is requested, 7 days unless
+// SHARE_LINK_TTL is set
+func shareLinkTTL() time.Duration {
+	return durationFromEnv("SHARE_LINK_TTL", 7*24*time.Hour)
+}
+
+// shareLinkMaxTTL is the furthest ahead a link may expire, 30 days unless SHARE_LINK_MAX_TTL is set
+func shareLinkMaxTTL() time.Duration {
+	return durationFromEnv("SHARE_LINK_MAX_TTL", 30*24*time.Hour)
+}
+
+// shareLinkResumeWindow is how long after a counted download a range may resume it without
+// counting again, 1 hour unless SHARE_LINK_RESUME_WINDOW is set
+func shareLinkResumeWindow() time.Duration {
+	return durationFromEnv("SHARE_LINK_RESUME_WINDOW", time.Hour)
+}
+
+// ensureShareLinkIndexes indexes links by the file they belong to
+func ensureShareLinkIndexes(ctx context.Context) error {
+	_, err := mongoDatabase.Collection("share_links").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "owner_id", Value: 1}, {Key: "org_id", Value: 1}, {Key: "filename", Value: 1}},
+	})
+	return err
+}
+
+// signShareLink issues the token that identifies a link. The signature stops link IDs from
+// being guessed; revocation and download limits are checked against the stored link.
+func signShareLink(link *models.ShareLink) (string, error) {
+	return tokenKeys.Sign(&Claims{
+		Purpose: shareLinkPurpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        link.ID.Hex(),
+			Issuer:    tokenKeys.Issuer(),
+			Subject:   link.DocumentID.Hex(),
+			IssuedAt:  jwt.NewNumericDate(link.CreatedAt),
+			ExpiresAt: jwt.NewNumericDate(link.ExpiresAt),
+		},
+	})
+}
+
+// shareLinkView adds what the owner needs to know about a link without exposing its hash
+type shareLinkView struct {
+	models.ShareLink
+	PasswordProtected bool `json:"password_protected"`
+}
+
+// CreateShareLink creates a public link to the version named by {filename} and {version}
+// ("latest" pins the current newest version). The optional body
+// {"expires_at": "...", "password": "...", "max_downloads": 5} limits the link; the
+// response carries the signed token and the URL to hand out, both shown only once.
+func CreateShareLink(w http.ResponseWriter, r *http.Request) {
+	doc, ok := resolveFileVersion(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		ExpiresAt    *time.Time `json:"expires_at"`
+		Password     string     `json:"password"`
+		MaxDownloads int        `json:"max_downloads"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid share link request", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	link := models.ShareLink{
+		ID:           primitive.NewObjectID(),
+		DocumentID:   doc.ID,
+		OwnerID:      doc.UserID,
+		OrgID:        doc.OrgID,
+		Filename:     doc.Filename,
+		Version:      doc.Version,
+		MaxDownloads: body.MaxDownloads,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(shareLinkTTL()),
+	}
+	link.CreatedBy, _ = callerID(r)
+	if body.ExpiresAt != nil {
+		if !body.ExpiresAt.After(now) || body.ExpiresAt.After(now.Add(shareLinkMaxTTL())) {
+			http.Error(w, "Expiry must be in the future and within "+humanDuration(shareLinkMaxTTL()), http.StatusBadRequest)
+			return
+		}
+		link.ExpiresAt = *body.ExpiresAt
+	}
+	if body.MaxDownloads < 0 {
+		http.Error(w, "max_downloads cannot be negative", http.StatusBadRequest)
+		return
+	}
+	if body.Password != "" {
+		if err := link.HashPassword(body.Password); err != nil {
+			log.Printf("Error hashing share link password: %v", err)
+			http.Error(w, "Error creating share link", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	token, err := signShareLink(&link)
+	if err == nil {
+		_, err = shareLinksCollection.InsertOne(r.Context(), link)
+	}
+	if err != nil {
+		log.Printf("Error creating share link for %s: %v", doc.Filename, err)
+		http.Error(w, "Error creating share link", http.StatusInternalServerError)
+		return
+	}
+
+	details := map[string]string{
+		"link_id":    link.ID.Hex(),
+		"version":    strconv.Itoa(link.Version),
+		"expires_at": link.ExpiresAt.Format(time.RFC3339),
+		"password":   strconv.FormatBool(link.PasswordProtected()),
+	}
+	if link.MaxDownloads > 0 {
+		details["max_downloads"] = strconv.Itoa(link.MaxDownloads)
+	}
+	recordAudit(r, audit.Event{
+		Action:       audit.ActionShareLinkCreate,
+		TargetUserID: link.OwnerID,
+		DocumentID:   link.DocumentID,
+		Target:       link.Filename,
+		Details:      details,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"link":  shareLinkView{ShareLink: link, PasswordProtected: link.PasswordProtected()},
+		"token": token,
+		"url":   appBaseURL() + "/shared-link#token=" + url.QueryEscape(token),
+	})
+}
+
+// ListShareLinks returns every link to any version of the file named by {filename},
+// including expired and revoked ones
+func ListShareLinks(w http.ResponseWriter, r *http.Request) {
+	latest, ok := resolveSharedFile(w, r)
+	if !ok {
+		return
+	}
+	cursor, err := shareLinksCollection.Find(r.Context(), inTenant(bson.M{"owner_id": latest.UserID, "filename": latest.Filename}, latest.OrgID))
+	if err != nil {
+		log.Printf("Error retrieving share links of %s: %v", latest.Filename, err)
+		http.Error(w, "Error retrieving share links", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(r.Context())
+	var links []models.ShareLink
+	if err := cursor.All(r.Context(), &links); err != nil {
+		log.Printf("Error decoding share links: %v", err)
+		http.Error(w, "Error retrieving share links", http.StatusInternalServerError)
+		return
+	}
+
+	views := []shareLinkView{}
+	for _, link := range links {
+		views = append(views, shareLinkView{ShareLink: link, PasswordProtected: link.PasswordProtected()})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(views)
+}
+
+// RevokeShareLink stops a link to the file named by {filename} from working immediately
+func RevokeShareLink(w http.ResponseWriter, r *http.Request) {
+	latest, ok := resolveSharedFile(w, r)
+	if !ok {
+		return
+	}
+	linkID, err := primitive.ObjectIDFromHex(mux.Vars(r)["linkID"])
+	if err != nil {
+		http.Error(w, "Invalid share link ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := shareLinksCollection.UpdateOne(r.Context(), inTenant(bson.M{
+		"_id":        linkID,
+		"owner_id":   latest.UserID,
+		"filename":   latest.Filename,
+		"revoked_at": bson.M{"$exists": false},
+	}, latest.OrgID), bson.M{"$set": bson.M{"revoked_at": time.Now()}})
+	if err != nil {
+		log.Printf("Error revoking share link %s: %v", linkID.Hex(), err)
+		http.Error(w, "Error revoking share link", http.StatusInternalServerError)
+		return
+	}
+	if result.ModifiedCount == 0 {
+		http.Error(w, "Share link not found", http.StatusNotFound)
+		return
+	}
+
+	recordAudit(r, audit.Event{
+		Action:       audit.ActionShareLinkRevoke,
+		TargetUserID: latest.UserID,
+		Target:       latest.Filename,
+		Details:      map[string]string{"link_id": linkID.Hex()},
+	})
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Share link revoked"})
+}
+
+// recordLinkAccess audits an attempt to use a public link, whether or not it was let through
+func recordLinkAccess(r *http.Request, link *models.ShareLink, result string) {
+	recordAudit(r, audit.Event{
+		Action:       audit.ActionShareLinkAccess,
+		Actor:        "share link",
+		TargetUserID: link.OwnerID,
+		DocumentID:   link.DocumentID,
+		Target:       link.Filename,
+		Details:      map[string]string{"link_id": link.ID.Hex(), "version": strconv.Itoa(link.Version), "result": result},
+	})
+}
+
+// resolvePublicLink verifies the {token} route variable and returns the link it names if it
+// can still be used. Revoked, expired and used-up links get 410 Gone.
+func resolvePublicLink(w http.ResponseWriter, r *http.Request) (*models.ShareLink, bool) {
+	claims := &Claims{}
+	parsed, err := tokenKeys.Parse(mux.Vars(r)["token"], claims)
+	if err != nil || !parsed.Valid || claims.Purpose != shareLinkPurpose {
+		http.Error(w, "Link not found or expired", http.StatusNotFound)
+		return nil, false
+	}
+	linkID, err := primitive.ObjectIDFromHex(claims.ID)
+	if err != nil {
+		http.Error(w, "Link not found or expired", http.StatusNotFound)
+		return nil, false
+	}
+
+	var link models.ShareLink
+	err = shareLinksCollection.FindOne(r.Context(), bson.M{"_id": linkID}).Decode(&link)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "Link not found or expired", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Error finding share link %s: %v", linkID.Hex(), err)
+		http.Error(w, "Error opening link", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	switch {
+	case link.RevokedAt != nil:
+		recordLinkAccess(r, &link, "revoked")
+		http.Error(w, "This link has been revoked", http.StatusGone)
+		return nil, false
+	case !time.Now().Before(link.ExpiresAt):
+		recordLinkAccess(r, &link, "expired")
+		http.Error(w, "This link has expired", http.StatusGone)
+		return nil, false
+	case link.MaxDownloads > 0 && link.Downloads >= link.MaxDownloads:
+		recordLinkAccess(r, &link, "download_limit")
+		http.Error(w, "This link has reached its download limit", http.StatusGone)
+		return nil, false
+	}
+	return &link, true
+}
+
+// GetPublicShareLink describes a link before it is opened so the client knows whether to
+// ask for a password. The file name is only revealed by links without a password.
+func GetPublicShareLink(w http.ResponseWriter, r *http.Request) {
+	link, ok := resolvePublicLink(w, r)
+	if !ok {
+		return
+	}
+	response := map[string]interface{}{
+		"password_required": link.PasswordProtected(),
+		"expires_at":        link.ExpiresAt,
+	}
+	if link.MaxDownloads > 0 {
+		response["downloads_remaining"] = link.MaxDownloads - link.Downloads
+	}
+	if !link.PasswordProtected() {
+		response["filename"] = link.Filename
+		response["version"] = link.Version
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// countsAsDownload reports whether a request through a link starts a new copy of its document.
+// Revalidations answered with 304 send no content. A range continues a copy only if it
+// resumes past the start of the version its If-Range names, shortly after the link's last
+// counted download; a range from byte 0 is a new copy whatever its headers say.
+func countsAsDownload(r *http.Request, link *models.ShareLink, doc *models.Document) bool {
+	etag := documentETag(doc)
+	if notModified(r, etag, doc.UploadDate) {
+		return false
+	}
+	if r.Header.Get("If-Range") == "" || !servesRange(r, etag, doc.UploadDate) || !resumesRange(r.Header.Get("Range")) {
+		return true
+	}
+	return link.LastDownloadAt == nil || time.Since(*link.LastDownloadAt) > shareLinkResumeWindow()
+}
+
+// resumesRange reports whether every range in a Range header starts after the first byte.
+// Suffix ranges may cover the whole content, so they do not count as resuming.
+func resumesRange(header string) bool {
+	specs, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return false
+	}
+	for _, spec := range strings.Split(specs, ",") {
+		first, _, _ := strings.Cut(strings.TrimSpace(spec), "-")
+		start, err := strconv.ParseInt(first, 10, 64)
+		if err != nil || start <= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// GetPublicSharedDocument streams the document version behind a public link inline, or as an
+// attachment with ?download=1. It needs no JWT; protected links take the password in the
+// X-Share-Password header, and wrong guesses are throttled like failed logins. Every
+// access, allowed or not, is recorded in the owner's audit trail, and each new copy counts
+// as a download.
+func GetPublicSharedDocument(w http.ResponseWriter, r *http.Request) {
+	link, ok := resolvePublicLink(w, r)
+	if !ok {
+		return
+	}
+
+	if link.PasswordProtected() {
+		guard, ok := beginGuardedAttempt(w, r, "share_link:"+link.ID.Hex())
+		if !ok {
+			recordLinkAccess(r, link, "throttled")
+			return
+		}
+		defer guard.release(r.Context())
+		password := r.Header.Get(shareLinkPasswordHeader)
+		if password == "" {
+			http.Error(w, "This link requires a password", http.StatusUnauthorized)
+			return
+		}
+		if link.CheckPassword(password) != nil {
+			guard.fail(r.Context())
+			recordLinkAccess(r, link, "wrong_password")
+			http.Error(w, "Incorrect password", http.StatusUnauthorized)
+			return
+		}
+		guard.succeed(r.Context())
+	}
+
+	var doc models.Document
+	err := documentsCollection.FindOne(r.Context(), bson.M{"_id": link.DocumentID}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		recordLinkAccess(r, link, "deleted")
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error finding document %s for share link %s: %v", link.DocumentID.Hex(), link.ID.Hex(), err)
+		http.Error(w, "Error finding file", http.StatusInternalServerError)
+		return
+	}
+
+	if countsAsDownload(r, link, &doc) {
+		// Claim a download before streaming so concurrent requests cannot exceed the limit
+		filter := bson.M{"_id": link.ID, "revoked_at": bson.M{"$exists": false}}
+		if link.MaxDownloads > 0 {
+			filter["downloads"] = bson.M{"$lt": link.MaxDownloads}
+		}
+		result, err := shareLinksCollection.UpdateOne(r.Context(), filter, bson.M{
+			"$inc": bson.M{"downloads": 1},
+			"$set": bson.M{"last_download_at": time.Now()},
+		})
+		if err != nil {
+			log.Printf("Error counting download of share link %s: %v", link.ID.Hex(), err)
+			http.Error(w, "Error opening link", http.StatusInternalServerError)
+			return
+		}
+		if result.ModifiedCount == 0 {
+			recordLinkAccess(r, link, "download_limit")
+			http.Error(w, "This link is no longer available", http.StatusGone)
+			return
+		}
+	}
+	recordLinkAccess(r, link, "granted")
+
+	disposition := "inline"
+	if download, _ := strconv.ParseBool(r.URL.Query().Get("download")); download {
+		disposition = "attachment"
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	serveDocument(w, r, &doc, disposition)
+}
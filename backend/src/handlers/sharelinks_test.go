@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// createLink creates a public link to the latest contract.pdf and returns its ID and token
+func (f *authFixture) createLink(t *testing.T, accessToken, body string) (string, string) {
+	t.Helper()
+	rec := f.post(t, "/users/"+f.alice.ID.Hex()+"/files/contract.pdf/latest/links", accessToken, body)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create link: got %d %q", rec.Code, rec.Body.String())
+	}
+	var created struct {
+		Link  shareLinkView `json:"link"`
+		Token string        `json:"token"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	return created.Link.ID.Hex(), created.Token
+}
+
+// openLink fetches the document behind a public link without any JWT
+func (f *authFixture) openLink(t *testing.T, token, password string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/public/links/"+token+"/document", nil)
+	if password != "" {
+		req.Header.Set(shareLinkPasswordHeader, password)
+	}
+	rec := httptest.NewRecorder()
+	f.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestShareLinkStopsAtItsDownloadLimit(t *testing.T) {
+	f := newAuthFixture(t)
+	access, _ := f.login(t)
+	_, token := f.createLink(t, access, `{"max_downloads":2}`)
+
+	req := httptest.NewRequest("GET", "/public/links/"+token, nil)
+	info := httptest.NewRecorder()
+	f.router.ServeHTTP(info, req)
+	if info.Code != http.StatusOK || !strings.Contains(info.Body.String(), `"downloads_remaining":2`) {
+		t.Fatalf("link details: got %d %q", info.Code, info.Body.String())
+	}
+
+	for i := 1; i <= 2; i++ {
+		rec := f.openLink(t, token, "")
+		if rec.Code != http.StatusOK || rec.Body.String() != "%PDF-1.7" {
+			t.Fatalf("download %d: got %d %q", i, rec.Code, rec.Body.String())
+		}
+	}
+	if rec := f.openLink(t, token, ""); rec.Code != http.StatusGone {
+		t.Fatalf("download past the limit: got %d, want 410", rec.Code)
+	}
+}
+
+func TestShareLinkCountsOnlyNewCopies(t *testing.T) {
+	f := newAuthFixture(t)
+	access, _ := f.login(t)
+	sum := sha256.Sum256([]byte("%PDF-1.7"))
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+	f.docs.UpdateOne(context.Background(), bson.M{"filename": "contract.pdf"}, bson.M{"$set": bson.M{"sha256": hex.EncodeToString(sum[:])}})
+	_, token := f.createLink(t, access, `{"max_downloads":2}`)
+
+	open := func(headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/public/links/"+token+"/document", nil)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rec := httptest.NewRecorder()
+		f.router.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := open(nil); rec.Code != http.StatusOK {
+		t.Fatalf("download: got %d %q", rec.Code, rec.Body.String())
+	}
+	for i := 0; i < 3; i++ {
+		if rec := open(map[string]string{"Range": "bytes=2-4", "If-Range": etag}); rec.Code != http.StatusPartialContent {
+			t.Fatalf("continued range %d: got %d %q", i, rec.Code, rec.Body.String())
+		}
+		if rec := open(map[string]string{"If-None-Match": etag}); rec.Code != http.StatusNotModified {
+			t.Fatalf("revalidation %d: got %d, want 304", i, rec.Code)
+		}
+	}
+
+	// A range from the first byte is a new download whatever its If-Range says, and the last one
+	if rec := open(map[string]string{"Range": "bytes=0-", "If-Range": etag}); rec.Code != http.StatusPartialContent {
+		t.Fatalf("new range: got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := open(nil); rec.Code != http.StatusGone {
+		t.Fatalf("download past the limit: got %d, want 410", rec.Code)
+	}
+
+	// Long after the last counted download, a range no longer continues it
+	_, token = f.createLink(t, access, `{"max_downloads":1}`)
+	if rec := open(nil); rec.Code != http.StatusOK {
+		t.Fatalf("download: got %d %q", rec.Code, rec.Body.String())
+	}
+	f.shareLinks.UpdateOne(context.Background(), bson.M{"downloads": int32(1)}, bson.M{"$set": bson.M{"last_download_at": time.Now().Add(-2 * time.Hour)}})
+	if rec := open(map[string]string{"Range": "bytes=2-4", "If-Range": etag}); rec.Code != http.StatusGone {
+		t.Fatalf("range after the resume window: got %d, want 410", rec.Code)
+	}
+}
+
+func TestShareLinkPassword(t *testing.T) {
+	f := newAuthFixture(t)
+	access, _ := f.login(t)
+	_, token := f.createLink(t, access, `{"password":"open sesame"}`)
+
+	if rec := f.openLink(t, token, ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("no password: got %d, want 401", rec.Code)
+	}
+	if rec := f.openLink(t, token, "guess"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong password: got %d, want 401", rec.Code)
+	}
+	if rec := f.openLink(t, token, "open sesame"); rec.Code != http.StatusOK {
+		t.Fatalf("right password: got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRevokedShareLinkStopsWorking(t *testing.T) {
+	f := newAuthFixture(t)
+	access, _ := f.login(t)
+	linkID, token := f.createLink(t, access, "")
+
+	if rec := f.openLink(t, token+"x", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("tampered token: got %d, want 404", rec.Code)
+	}
+	if rec := f.send(t, "DELETE", "/users/"+f.alice.ID.Hex()+"/files/contract.pdf/links/"+linkID, access, ""); rec.Code != http.StatusOK {
+		t.Fatalf("revoke: got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := f.openLink(t, token, ""); rec.Code != http.StatusGone {
+		t.Fatalf("revoked link: got %d, want 410", rec.Code)
+	}
+}
@@ -0,0 +1,511 @@
+package handlers
+
+import (
+	"DocuDefense/backend/src/audit"
+	"DocuDefense/backend/src/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Access to files granted by their owners to other users and organizations
+var sharesCollection DatabaseCollection
+
+// accessOwner is the access level of a file's owner, above every share permission
+const accessOwner = "owner"
+
+// ensureShareIndexes indexes shares by file for the owner and by grantee for "shared with me"
+func ensureShareIndexes(ctx context.Context) error {
+	_, err := mongoDatabase.Collection("shares").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "owner_id", Value: 1}, {Key: "org_id", Value: 1}, {Key: "filename", Value: 1}}},
+		{Keys: bson.D{{Key: "grantee_type", Value: 1}, {Key: "grantee_id", Value: 1}}},
+	})
+	return err
+}
+
+// fileShares returns every share of one file in the workspace orgID
+func fileShares(ctx context.Context, ownerID, orgID primitive.ObjectID, filename string) ([]models.Share, error) {
+	cursor, err := sharesCollection.Find(ctx, inTenant(bson.M{"owner_id": ownerID, "filename": filename}, orgID))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	shares := []models.Share{}
+	if err := cursor.All(ctx, &shares); err != nil {
+		return nil, err
+	}
+	return shares, nil
+}
+
+// deleteFileShares removes every share of a file whose versions were all deleted
+func deleteFileShares(ctx context.Context, ownerID, orgID primitive.ObjectID, filename string) {
+	shares, err := fileShares(ctx, ownerID, orgID, filename)
+	if err != nil {
+		log.Printf("Error retrieving shares of %s: %v", filename, err)
+		return
+	}
+	for _, share := range shares {
+		if _, err := sharesCollection.DeleteOne(ctx, bson.M{"_id": share.ID}); err != nil {
+			log.Printf("Error deleting share %s: %v", share.ID.Hex(), err)
+		}
+	}
+}
+
+// sharedAccess returns the highest permission userID holds on doc through shares, or ""
+// when none applies. With wholeFile set only shares covering every version count, as
+// needed to add a version. Organization documents are only ever accessible to current
+// members of the organization, so removing someone from it also ends their shares.
+func sharedAccess(ctx context.Context, userID primitive.ObjectID, doc *models.Document, wholeFile bool) (string, error) {
+	if doc.UserID == userID {
+		return accessOwner, nil
+	}
+	if !doc.OrgID.IsZero() {
+		member, err := findMembership(ctx, doc.OrgID, userID)
+		if err != nil || member == nil {
+			return "", err
+		}
+	}
+
+	shares, err := fileShares(ctx, doc.UserID, doc.OrgID, doc.Filename)
+	if err != nil {
+		return "", err
+	}
+	best := ""
+	for _, share := range shares {
+		if share.Version != 0 && (wholeFile || share.Version != doc.Version) {
+			continue
+		}
+		if models.ShareLevel(share.Permission) <= models.ShareLevel(best) {
+			continue
+		}
+		switch share.GranteeType {
+		case models.GranteeUser:
+			if share.GranteeID != userID {
+				continue
+			}
+		case models.GranteeOrganization:
+			member, err := findMembership(ctx, share.GranteeID, userID)
+			if err != nil {
+				return "", err
+			}
+			if member == nil {
+				continue
+			}
+		default:
+			continue
+		}
+		best = share.Permission
+	}
+	return best, nil
+}
+
+// resolveSharedDocument looks up the document named by the {documentID} route variable and
+// checks the caller holds at least the given share permission on it. Callers with no
+// access at all get 404 so document IDs cannot be probed.
+func resolveSharedDocument(w http.ResponseWriter, r *http.Request, need string, wholeFile bool) (*models.Document, string, bool) {
+	userID, ok := callerID(r)
+	if !ok {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return nil, "", false
+	}
+	documentID, err := primitive.ObjectIDFromHex(mux.Vars(r)["documentID"])
+	if err != nil {
+		http.Error(w, "Invalid document ID format", http.StatusBadRequest)
+		return nil, "", false
+	}
+
+	var doc models.Document
+	err = documentsCollection.FindOne(r.Context(), bson.M{"_id": documentID}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return nil, "", false
+	}
+	if err != nil {
+		log.Printf("Error finding document %s: %v", documentID.Hex(), err)
+		http.Error(w, "Error finding file", http.StatusInternalServerError)
+		return nil, "", false
+	}
+
+	access, err := sharedAccess(r.Context(), userID, &doc, wholeFile)
+	if err != nil {
+		log.Printf("Error checking access to document %s: %v", documentID.Hex(), err)
+		http.Error(w, "Error finding file", http.StatusInternalServerError)
+		return nil, "", false
+	}
+	if access == "" {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return nil, "", false
+	}
+	if access != accessOwner && models.ShareLevel(access) < models.ShareLevel(need) {
+		log.Printf("Forbidden %s %s: %s access, %s required", r.Method, r.URL.Path, access, need)
+		http.Error(w, "Your access to this document does not allow this", http.StatusForbidden)
+		return nil, "", false
+	}
+	return &doc, access, true
+}
+
+// resolveSharedFile returns the owner's file named by the {filename} route variable in the
+// active workspace, answering 404 if it has no versions
+func resolveSharedFile(w http.ResponseWriter, r *http.Request) (*models.Document, bool) {
+	params := mux.Vars(r)
+	filename, err := url.QueryUnescape(params["filename"])
+	if err != nil {
+		http.Error(w, "Invalid filename", http.StatusBadRequest)
+		return nil, false
+	}
+	targetUser, ok := targetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return nil, false
+	}
+	latest, err := findLatestDocument(r.Context(), targetUser.ID, activeTenant(r), filename)
+	if err != nil {
+		log.Printf("Error finding latest version for file %s: %v", filename, err)
+		http.Error(w, "Error finding file", http.StatusInternalServerError)
+		return nil, false
+	}
+	if latest == nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return nil, false
+	}
+	return latest, true
+}
+
+// ShareFile grants access to the file named by {filename} with
+// {"email": "...", "permission": "viewer"} for one user or {"org_id": "...", ...} for every
+// member of an organization, plus an optional "version" to share only that version.
+// Sharing the same file with the same grantee again replaces the permission.
+// Organization files can only be shared within their organization.
+func ShareFile(w http.ResponseWriter, r *http.Request) {
+	latest, ok := resolveSharedFile(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		Email      string `json:"email"`
+		OrgID      string `json:"org_id"`
+		Permission string `json:"permission"`
+		Version    int    `json:"version"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid share request", http.StatusBadRequest)
+		return
+	}
+	if models.ShareLevel(body.Permission) == 0 {
+		http.Error(w, "Permission must be viewer, commenter or editor", http.StatusBadRequest)
+		return
+	}
+	if (body.Email == "") == (body.OrgID == "") {
+		http.Error(w, "Share with either an email or an org_id", http.StatusBadRequest)
+		return
+	}
+	if body.Version < 0 || body.Version > latest.Version {
+		http.Error(w, "Version not found", http.StatusNotFound)
+		return
+	}
+
+	share := models.Share{
+		ID:         primitive.NewObjectID(),
+		OwnerID:    latest.UserID,
+		OrgID:      latest.OrgID,
+		Filename:   latest.Filename,
+		Version:    body.Version,
+		Permission: body.Permission,
+		CreatedAt:  time.Now(),
+	}
+	share.GrantedBy, _ = callerID(r)
+
+	grantee := body.Email
+	if body.Email != "" {
+		var user models.User
+		if err := usersCollection.FindOne(r.Context(), bson.M{"email": body.Email}).Decode(&user); err != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if user.ID == latest.UserID {
+			http.Error(w, "You already own this file", http.StatusBadRequest)
+			return
+		}
+		if !latest.OrgID.IsZero() {
+			member, err := findMembership(r.Context(), latest.OrgID, user.ID)
+			if err != nil || member == nil {
+				http.Error(w, "Organization files can only be shared with members of the organization", http.StatusForbidden)
+				return
+			}
+		}
+		share.GranteeType, share.GranteeID = models.GranteeUser, user.ID
+	} else {
+		orgID, err := primitive.ObjectIDFromHex(body.OrgID)
+		if err != nil {
+			http.Error(w, "Invalid organization ID", http.StatusBadRequest)
+			return
+		}
+		if !latest.OrgID.IsZero() && orgID != latest.OrgID {
+			http.Error(w, "Organization files can only be shared with members of the organization", http.StatusForbidden)
+			return
+		}
+		member, err := findMembership(r.Context(), orgID, latest.UserID)
+		if err != nil || member == nil {
+			http.Error(w, "You can only share with organizations you belong to", http.StatusForbidden)
+			return
+		}
+		share.GranteeType, share.GranteeID = models.GranteeOrganization, orgID
+		grantee = orgID.Hex()
+	}
+
+	// One share per grantee and version: a repeated grant changes the permission
+	existing := inTenant(bson.M{
+		"owner_id":     share.OwnerID,
+		"filename":     share.Filename,
+		"grantee_type": share.GranteeType,
+		"grantee_id":   share.GranteeID,
+	}, share.OrgID)
+	if share.Version != 0 {
+		existing["version"] = share.Version
+	} else {
+		existing["version"] = bson.M{"$exists": false}
+	}
+	var previous models.Share
+	err := sharesCollection.FindOne(r.Context(), existing).Decode(&previous)
+	switch {
+	case err == nil:
+		share.ID, share.CreatedAt = previous.ID, previous.CreatedAt
+		_, err = sharesCollection.UpdateOne(r.Context(), bson.M{"_id": previous.ID}, bson.M{"$set": bson.M{"permission": share.Permission, "granted_by": share.GrantedBy}})
+	case errors.Is(err, mongo.ErrNoDocuments):
+		_, err = sharesCollection.InsertOne(r.Context(), share)
+	}
+	if err != nil {
+		log.Printf("Error sharing %s: %v", share.Filename, err)
+		http.Error(w, "Error sharing file", http.StatusInternalServerError)
+		return
+	}
+
+	details := map[string]string{"share_id": share.ID.Hex(), "grantee": grantee, "grantee_type": share.GranteeType, "permission": share.Permission}
+	if share.Version != 0 {
+		details["version"] = strconv.Itoa(share.Version)
+	}
+	recordAudit(r, audit.Event{
+		Action:       audit.ActionShareCreate,
+		TargetUserID: share.OwnerID,
+		Target:       share.Filename,
+		Details:      details,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(share)
+}
+
+// ListFileShares returns who the file named by {filename} is shared with
+func ListFileShares(w http.ResponseWriter, r *http.Request) {
+	latest, ok := resolveSharedFile(w, r)
+	if !ok {
+		return
+	}
+	shares, err := fileShares(r.Context(), latest.UserID, latest.OrgID, latest.Filename)
+	if err != nil {
+		log.Printf("Error retrieving shares of %s: %v", latest.Filename, err)
+		http.Error(w, "Error retrieving shares", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(shares)
+}
+
+// RevokeShare removes one share of the file named by {filename}
+func RevokeShare(w http.ResponseWriter, r *http.Request) {
+	latest, ok := resolveSharedFile(w, r)
+	if !ok {
+		return
+	}
+	shareID, err := primitive.ObjectIDFromHex(mux.Vars(r)["shareID"])
+	if err != nil {
+		http.Error(w, "Invalid share ID", http.StatusBadRequest)
+		return
+	}
+
+	var share models.Share
+	err = sharesCollection.FindOne(r.Context(), inTenant(bson.M{"_id": shareID, "owner_id": latest.UserID, "filename": latest.Filename}, latest.OrgID)).Decode(&share)
+	if err != nil {
+		http.Error(w, "Share not found", http.StatusNotFound)
+		return
+	}
+	if _, err := sharesCollection.DeleteOne(r.Context(), bson.M{"_id": share.ID}); err != nil {
+		log.Printf("Error revoking share %s: %v", share.ID.Hex(), err)
+		http.Error(w, "Error revoking share", http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r, audit.Event{
+		Action:       audit.ActionShareRevoke,
+		TargetUserID: share.OwnerID,
+		Target:       share.Filename,
+		Details:      map[string]string{"share_id": share.ID.Hex(), "grantee": share.GranteeID.Hex(), "grantee_type": share.GranteeType},
+	})
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Share revoked"})
+}
+
+// sharedDocument is an entry in the "shared with me" view: the newest version the caller
+// can see, plus how it was shared
+type sharedDocument struct {
+	models.Document
+	ShareID    primitive.ObjectID `json:"share_id"`
+	Permission string             `json:"permission"`
+	Owner      string             `json:"owner"`
+}
+
+// listSharedWithMe writes the documents other users have shared with the account, directly
+// or through one of its organizations
+func listSharedWithMe(w http.ResponseWriter, r *http.Request, user *models.User) {
+	ctx := r.Context()
+	grantees := []bson.M{{"grantee_type": models.GranteeUser, "grantee_id": user.ID}}
+	cursor, err := membershipsCollection.Find(ctx, bson.M{"user_id": user.ID})
+	if err == nil {
+		var memberships []models.Membership
+		if err = cursor.All(ctx, &memberships); err == nil {
+			for _, m := range memberships {
+				grantees = append(grantees, bson.M{"grantee_type": models.GranteeOrganization, "grantee_id": m.OrgID})
+			}
+		}
+	}
+	if err != nil {
+		log.Printf("Error retrieving memberships of %s: %v", user.Email, err)
+		http.Error(w, "Error retrieving documents", http.StatusInternalServerError)
+		return
+	}
+
+	var shares []models.Share
+	for _, grantee := range grantees {
+		cursor, err := sharesCollection.Find(ctx, grantee)
+		if err != nil {
+			log.Printf("Error retrieving shares for %s: %v", user.Email, err)
+			http.Error(w, "Error retrieving documents", http.StatusInternalServerError)
+			return
+		}
+		var found []models.Share
+		if err := cursor.All(ctx, &found); err != nil {
+			log.Printf("Error decoding shares: %v", err)
+			http.Error(w, "Error retrieving documents", http.StatusInternalServerError)
+			return
+		}
+		shares = append(shares, found...)
+	}
+
+	documents := []sharedDocument{}
+	owners := map[primitive.ObjectID]string{}
+	for _, share := range shares {
+		var doc *models.Document
+		if share.Version == 0 {
+			doc, err = findLatestDocument(ctx, share.OwnerID, share.OrgID, share.Filename)
+		} else {
+			doc, err = findDocumentVersion(ctx, share.OwnerID, share.OrgID, share.Filename, share.Version)
+		}
+		if err != nil {
+			log.Printf("Error retrieving shared file %s: %v", share.Filename, err)
+			continue
+		}
+		if doc == nil {
+			continue
+		}
+		// Re-check access so shares of organization files vanish once the user leaves
+		if access, err := sharedAccess(ctx, user.ID, doc, false); err != nil || access == "" {
+			continue
+		}
+		if _, ok := owners[share.OwnerID]; !ok {
+			var owner models.User
+			if err := usersCollection.FindOne(ctx, bson.M{"_id": share.OwnerID}).Decode(&owner); err == nil {
+				owners[share.OwnerID] = owner.Email
+			}
+		}
+		documents = append(documents, sharedDocument{Document: *doc, ShareID: share.ID, Permission: share.Permission, Owner: owners[share.OwnerID]})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(documents)
+}
+
+// GetSharedDocumentBlob streams a document shared with the caller inline; add ?download=1
+// to receive it as an attachment
+func GetSharedDocumentBlob(w http.ResponseWriter, r *http.Request) {
+	doc, _, ok := resolveSharedDocument(w, r, models.ShareViewer, false)
+	if !ok {
+		return
+	}
+	disposition := "inline"
+	if download, _ := strconv.ParseBool(r.URL.Query().Get("download")); download {
+		disposition = "attachment"
+	}
+	serveDocument(w, r, doc, disposition)
+}
+
+// DownloadSharedDocument sends a document shared with the caller as an attachment
+func DownloadSharedDocument(w http.ResponseWriter, r *http.Request) {
+	doc, _, ok := resolveSharedDocument(w, r, models.ShareViewer, false)
+	if !ok {
+		return
+	}
+	serveDocument(w, r, doc, "attachment")
+}
+
+// GetSharedDocumentVersions lists the versions of a shared file the caller can see: all of
+// them when the whole file is shared, otherwise only the shared version
+func GetSharedDocumentVersions(w http.ResponseWriter, r *http.Request) {
+	doc, _, ok := resolveSharedDocument(w, r, models.ShareViewer, false)
+	if !ok {
+		return
+	}
+	userID, _ := callerID(r)
+
+	cursor, err := documentsCollection.Find(r.Context(), inTenant(bson.M{
+		"user_id":  doc.UserID,
+		"filename": doc.Filename,
+	}, doc.OrgID), options.Find().SetSort(bson.D{{Key: "version", Value: 1}}))
+	if err != nil {
+		log.Printf("Error retrieving versions of %s: %v", doc.Filename, err)
+		http.Error(w, "Error retrieving versions", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(r.Context())
+	var all []models.Document
+	if err := cursor.All(r.Context(), &all); err != nil {
+		log.Printf("Error decoding versions: %v", err)
+		http.Error(w, "Error decoding versions", http.StatusInternalServerError)
+		return
+	}
+
+	versions := []models.Document{}
+	for i := range all {
+		if access, err := sharedAccess(r.Context(), userID, &all[i], false); err == nil && access != "" {
+			versions = append(versions, all[i])
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"filename": doc.Filename,
+		"versions": versions,
+	})
+}
+
+// UploadSharedDocumentVersion adds a new version to a file shared with the caller as an
+// editor. Only shares of the whole file allow this.
+func UploadSharedDocumentVersion(w http.ResponseWriter, r *http.Request) {
+	doc, _, ok := resolveSharedDocument(w, r, models.ShareEditor, true)
+	if !ok {
+		return
+	}
+	storeUpload(w, r, doc.UserID, doc.OrgID, doc.Filename)
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"DocuDefense/backend/src/models"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// shareContract shares Alice's contract.pdf and returns the new share's ID
+func (f *authFixture) shareContract(t *testing.T, accessToken, body string) string {
+	t.Helper()
+	rec := f.post(t, "/users/"+f.alice.ID.Hex()+"/files/contract.pdf/shares", accessToken, body)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("share: got %d %q", rec.Code, rec.Body.String())
+	}
+	var share models.Share
+	if err := json.NewDecoder(rec.Body).Decode(&share); err != nil {
+		t.Fatal(err)
+	}
+	return share.ID.Hex()
+}
+
+func (f *authFixture) contractVersion(t *testing.T, version int) string {
+	t.Helper()
+	var doc models.Document
+	if err := f.docs.FindOne(context.Background(), bson.M{"filename": "contract.pdf", "version": version}).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc.ID.Hex()
+}
+
+func TestSharedDocumentPermissions(t *testing.T) {
+	f := newAuthFixture(t)
+	access, _ := f.login(t)
+	bob := f.bobToken(t)
+	shared := "/shared/documents/" + f.contractVersion(t, 1)
+
+	if rec := f.send(t, "GET", shared+"/download", bob, ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("download before sharing: got %d, want 404", rec.Code)
+	}
+	shareID := f.shareContract(t, access, `{"email":"bob@example.com","permission":"viewer"}`)
+
+	if rec := f.send(t, "GET", shared+"/download", bob, ""); rec.Code != http.StatusOK {
+		t.Fatalf("viewer download: got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := f.post(t, shared+"/upload", bob, ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("viewer upload: got %d, want 403", rec.Code)
+	}
+	if rec := f.send(t, "DELETE", "/users/"+f.alice.ID.Hex()+"/files/contract.pdf/delete", bob, ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("grantee deleting the owner's file: got %d, want 403", rec.Code)
+	}
+	list := f.send(t, "GET", "/users/"+f.bob.ID.Hex()+"/files?view=shared", bob, "")
+	if list.Code != http.StatusOK || !strings.Contains(list.Body.String(), `"contract.pdf"`) || !strings.Contains(list.Body.String(), `"permission":"viewer"`) {
+		t.Fatalf("shared with me: got %d %q", list.Code, list.Body.String())
+	}
+
+	if rec := f.send(t, "DELETE", "/users/"+f.alice.ID.Hex()+"/files/contract.pdf/shares/"+shareID, access, ""); rec.Code != http.StatusOK {
+		t.Fatalf("revoke: got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := f.send(t, "GET", shared+"/download", bob, ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("download after revoking: got %d, want 404", rec.Code)
+	}
+}
+
+func TestVersionShareCoversOnlyThatVersion(t *testing.T) {
+	f := newAuthFixture(t)
+	access, _ := f.login(t)
+	bob := f.bobToken(t)
+	f.docs.InsertOne(context.Background(), models.Document{
+		ID: primitive.NewObjectID(), UserID: f.alice.ID, Filename: "contract.pdf", Version: 2, StorageKey: "documents/alice/contract",
+	})
+	f.shareContract(t, access, `{"email":"bob@example.com","permission":"editor","version":1}`)
+
+	if rec := f.send(t, "GET", "/shared/documents/"+f.contractVersion(t, 1)+"/download", bob, ""); rec.Code != http.StatusOK {
+		t.Fatalf("shared version: got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := f.send(t, "GET", "/shared/documents/"+f.contractVersion(t, 2)+"/download", bob, ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("other version: got %d, want 404", rec.Code)
+	}
+	// Adding versions needs an editor share of the whole file
+	if rec := f.post(t, "/shared/documents/"+f.contractVersion(t, 1)+"/upload", bob, ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("upload through a version share: got %d, want 404", rec.Code)
+	}
+}
+
+func TestOrganizationFilesAreSharedOnlyWithMembers(t *testing.T) {
+	f := newAuthFixture(t)
+	access, _ := f.login(t)
+	orgID := f.createOrg(t, access, "Acme")
+	orgObjID, _ := primitive.ObjectIDFromHex(orgID)
+	f.docs.InsertOne(context.Background(), models.Document{
+		ID: primitive.NewObjectID(), UserID: f.alice.ID, OrgID: orgObjID, Filename: "acme.pdf", Version: 1, StorageKey: "documents/alice/contract",
+	})
+
+	req := httptest.NewRequest("POST", "/users/"+f.alice.ID.Hex()+"/files/acme.pdf/shares", strings.NewReader(`{"email":"bob@example.com","permission":"viewer"}`))
+	req.Header.Set("Authorization", "Bearer "+access)
+	req.Header.Set(tenantHeader, orgID)
+	rec := httptest.NewRecorder()
+	f.router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("sharing an organization file outside it: got %d, want 403", rec.Code)
+	}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"io"
+	"os"
+)
+
+// spooledUpload is uploaded content copied to a temporary file, so a large upload is never
+// held in memory. The file is removed as soon as it is created where the platform allows,
+// so nothing is left behind if the server stops, and otherwise on Close.
+type spooledUpload struct {
+	file   *os.File
+	path   string // still to be removed, if it could not be while open
+	size   int64
+	mapped []byte
+}
+
+// spoolUpload copies up to limit+1 bytes of content to a temporary file; a size over limit
+// means the content was larger than allowed
+func spoolUpload(content io.Reader, limit int64) (*spooledUpload, error) {
+	file, err := os.CreateTemp("", "docudefense-upload-*")
+	if err != nil {
+		return nil, err
+	}
+	spool := &spooledUpload{file: file, path: file.Name()}
+	if os.Remove(spool.path) == nil {
+		spool.path = ""
+	}
+	if spool.size, err = io.Copy(file, io.LimitReader(content, limit+1)); err != nil {
+		spool.Close()
+		return nil, err
+	}
+	return spool, nil
+}
+
+// Bytes maps the content into memory for inspection. The pages are backed by the file, so
+// they do not count towards the heap.
+func (s *spooledUpload) Bytes() ([]byte, error) {
+	if s.mapped == nil && s.size > 0 {
+		mapped, err := mapFile(s.file, s.size)
+		if err != nil {
+			return nil, err
+		}
+		s.mapped = mapped
+	}
+	return s.mapped, nil
+}
+
+// Reader returns a reader over the whole content
+func (s *spooledUpload) Reader() io.Reader {
+	return io.NewSectionReader(s.file, 0, s.size)
+}
+
+func (s *spooledUpload) Close() error {
+	if s.mapped != nil {
+		unmapFile(s.mapped)
+		s.mapped = nil
+	}
+	err := s.file.Close()
+	if s.path != "" {
+		os.Remove(s.path)
+	}
+	return err
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package handlers
+
+import (
+	"io"
+	"os"
+)
+
+// Without mmap the content is read into memory for inspection
+func mapFile(file *os.File, size int64) ([]byte, error) {
+	data := make([]byte, size)
+	_, err := io.ReadFull(io.NewSectionReader(file, 0, size), data)
+	return data, err
+}
+
+func unmapFile([]byte) {}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package handlers
+
+import (
+	"os"
+	"syscall"
+)
+
+func mapFile(file *os.File, size int64) ([]byte, error) {
+	return syscall.Mmap(int(file.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func unmapFile(data []byte) {
+	syscall.Munmap(data)
+}
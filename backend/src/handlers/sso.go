@@ -0,0 +1,400 @@
+package handlers
+
+import (
+	"DocuDefense/backend/src/audit"
+	"DocuDefense/backend/src/models"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/oauth2"
+)
+
+// How long a user has to complete a single sign-on login at the identity provider
+const oidcLoginTimeout = 10 * time.Minute
+
+// OIDCConfig configures single sign-on with an OpenID Connect identity provider
+type OIDCConfig struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string // empty for public clients relying on PKCE alone
+	RedirectURL  string // this backend's /auth/oidc/callback URL, as registered with the provider
+	Scopes       []string
+
+	// Frontend page that receives the DocuDefense tokens in its URL fragment. When empty
+	// the callback responds with the tokens as JSON instead.
+	PostLoginRedirect string
+}
+
+// OIDCConfigFromEnv reads OIDC_ISSUER_URL, OIDC_CLIENT_ID, OIDC_CLIENT_SECRET,
+// OIDC_REDIRECT_URL, OIDC_SCOPES and OIDC_POST_LOGIN_REDIRECT. It reports false when
+// single sign-on is not configured.
+func OIDCConfigFromEnv() (OIDCConfig, bool) {
+	cfg := OIDCConfig{
+		IssuerURL:         os.Getenv("OIDC_ISSUER_URL"),
+		ClientID:          os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret:      os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:       os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:            strings.Fields(os.Getenv("OIDC_SCOPES")),
+		PostLoginRedirect: os.Getenv("OIDC_POST_LOGIN_REDIRECT"),
+	}
+	return cfg, cfg.IssuerURL != ""
+}
+
+// OIDCClient runs the authorization code flow against one identity provider
+type OIDCClient struct {
+	oauth             oauth2.Config
+	verifier          *oidc.IDTokenVerifier
+	postLoginRedirect string
+}
+
+// NewOIDCClient discovers the provider's endpoints and signing keys from its issuer URL
+func NewOIDCClient(ctx context.Context, cfg OIDCConfig) (*OIDCClient, error) {
+	if cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, fmt.Errorf("OIDC client ID and redirect URL are required")
+	}
+	provider, err := oidc.NewProvider(ctx, cfg.IssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("discovering OIDC provider %s: %w", cfg.IssuerURL, err)
+	}
+
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{oidc.ScopeOpenID, "email", "profile"}
+	}
+	return &OIDCClient{
+		oauth: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       scopes,
+		},
+		verifier:          provider.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+		postLoginRedirect: cfg.PostLoginRedirect,
+	}, nil
+}
+
+// Single sign-on client; nil when SSO is not configured
+var oidcClient *OIDCClient
+
+// Pending single sign-on logins
+var oidcStatesCollection DatabaseCollection
+
+// SetOIDCClient enables single sign-on through the given client
+func SetOIDCClient(client *OIDCClient) {
+	oidcClient = client
+}
+
+// ensureOIDCIndexes lets expired login states age out and keeps each external identity
+// linked to at most one account
+func ensureOIDCIndexes(ctx context.Context) error {
+	_, err := mongoDatabase.Collection("oidc_login_states").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = mongoDatabase.Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "external_issuer", Value: 1}, {Key: "external_subject", Value: 1}},
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{
+			"external_subject": bson.M{"$exists": true},
+		}),
+	})
+	return err
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// OIDCLogin starts a single sign-on login by redirecting to the identity provider with a
+// fresh state, nonce and PKCE challenge
+func OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if oidcClient == nil {
+		http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+
+	state, err := randomToken()
+	if err != nil {
+		http.Error(w, "Error starting single sign-on", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		http.Error(w, "Error starting single sign-on", http.StatusInternalServerError)
+		return
+	}
+	login := models.OIDCLoginState{
+		State:        state,
+		CodeVerifier: oauth2.GenerateVerifier(),
+		Nonce:        nonce,
+		ExpiresAt:    time.Now().Add(oidcLoginTimeout),
+	}
+	if _, err := oidcStatesCollection.InsertOne(r.Context(), login); err != nil {
+		log.Printf("Error saving OIDC login state: %v", err)
+		http.Error(w, "Error starting single sign-on", http.StatusInternalServerError)
+		return
+	}
+
+	authURL := oidcClient.oauth.AuthCodeURL(state, oauth2.S256ChallengeOption(login.CodeVerifier), oidc.Nonce(nonce))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// consumeOIDCState looks up and deletes a pending login, so each state is usable once
+func consumeOIDCState(ctx context.Context, state string) (*models.OIDCLoginState, error) {
+	var login models.OIDCLoginState
+	if err := oidcStatesCollection.FindOne(ctx, bson.M{"_id": state}).Decode(&login); err != nil {
+		return nil, err
+	}
+	result, err := oidcStatesCollection.DeleteOne(ctx, bson.M{"_id": state})
+	if err != nil {
+		return nil, err
+	}
+	if result.DeletedCount == 0 {
+		return nil, mongo.ErrNoDocuments // consumed by a concurrent callback
+	}
+	if time.Now().After(login.ExpiresAt) {
+		return nil, errors.New("login state expired")
+	}
+	return &login, nil
+}
+
+// oidcIdentity holds the ID token claims used to find or provision the account
+type oidcIdentity struct {
+	Issuer        string `json:"iss"`
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	GivenName     string `json:"given_name"`
+	FamilyName    string `json:"family_name"`
+}
+
+// OIDCCallback completes a single sign-on login: it checks the state, redeems the code with
+// the PKCE verifier, validates the ID token and nonce, provisions or links the account and
+// issues normal DocuDefense tokens, or an MFA challenge when the account has a second factor
+func OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if oidcClient == nil {
+		http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query()
+	if providerErr := query.Get("error"); providerErr != "" {
+		log.Printf("OIDC provider returned error %q: %s", providerErr, query.Get("error_description"))
+		http.Error(w, "Single sign-on was not completed", http.StatusUnauthorized)
+		return
+	}
+
+	login, err := consumeOIDCState(r.Context(), query.Get("state"))
+	if err != nil {
+		log.Printf("Rejected OIDC callback: %v", err)
+		http.Error(w, "Invalid or expired login state", http.StatusBadRequest)
+		return
+	}
+
+	token, err := oidcClient.oauth.Exchange(r.Context(), query.Get("code"), oauth2.VerifierOption(login.CodeVerifier))
+	if err != nil {
+		log.Printf("Error redeeming OIDC authorization code: %v", err)
+		http.Error(w, "Single sign-on failed", http.StatusUnauthorized)
+		return
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		log.Println("OIDC token response did not include an ID token")
+		http.Error(w, "Single sign-on failed", http.StatusUnauthorized)
+		return
+	}
+	idToken, err := oidcClient.verifier.Verify(r.Context(), rawIDToken)
+	if err != nil {
+		log.Printf("Invalid OIDC ID token: %v", err)
+		http.Error(w, "Single sign-on failed", http.StatusUnauthorized)
+		return
+	}
+	if idToken.Nonce != login.Nonce {
+		log.Println("OIDC ID token nonce does not match the login state")
+		http.Error(w, "Single sign-on failed", http.StatusUnauthorized)
+		return
+	}
+
+	var identity oidcIdentity
+	if err := idToken.Claims(&identity); err != nil {
+		log.Printf("Error decoding OIDC claims: %v", err)
+		http.Error(w, "Single sign-on failed", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := provisionOIDCUser(r, &identity)
+	if err != nil {
+		log.Printf("OIDC login for %s at %s refused: %v", identity.Subject, identity.Issuer, err)
+		recordAudit(r, audit.Event{
+			Action:  audit.ActionLoginFailed,
+			Actor:   identity.Email,
+			Target:  identity.Email,
+			Details: map[string]string{"method": "oidc", "issuer": identity.Issuer, "reason": err.Error()},
+		})
+		http.Error(w, "This identity cannot be used to sign in", http.StatusForbidden)
+		return
+	}
+
+	// The provider's own checks do not replace the second factor enrolled here, so enrolled
+	// users complete the same challenge at /login/mfa as after a password
+	if user.MFAEnabled {
+		challenge, err := generateMFAChallenge(user)
+		if err != nil {
+			log.Printf("Error generating MFA challenge for user %s: %v", user.Email, err)
+			http.Error(w, "Error generating token", http.StatusInternalServerError)
+			return
+		}
+		if oidcClient.postLoginRedirect == "" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"message":      "Two-factor authentication required",
+				"mfa_required": true,
+				"mfa_token":    challenge,
+			})
+			return
+		}
+		fragment := url.Values{}
+		fragment.Set("mfa_required", "true")
+		fragment.Set("mfa_token", challenge)
+		http.Redirect(w, r, oidcClient.postLoginRedirect+"#"+fragment.Encode(), http.StatusFound)
+		return
+	}
+
+	session, err := issueSession(r.Context(), user, nil, time.Time{})
+	if errors.Is(err, errAccountDisabled) {
+		log.Printf("OIDC login refused: account %s is disabled", user.Email)
+		http.Error(w, "This account has been disabled", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Printf("Error generating token for user %s: %v", user.Email, err)
+		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r, audit.Event{
+		Action:       audit.ActionLogin,
+		Actor:        user.Email,
+		ActorID:      user.ID,
+		TargetUserID: user.ID,
+		Target:       user.Email,
+		Details:      map[string]string{"method": "oidc", "issuer": identity.Issuer},
+	})
+
+	if oidcClient.postLoginRedirect == "" {
+		session["message"] = "Login successful"
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(session)
+		return
+	}
+
+	// Tokens travel in the fragment, which browsers never send to servers or in Referer headers
+	fragment := url.Values{}
+	fragment.Set("token", session["token"].(string))
+	fragment.Set("refresh_token", session["refresh_token"].(string))
+	fragment.Set("expires_in", strconv.Itoa(session["expires_in"].(int)))
+	http.Redirect(w, r, oidcClient.postLoginRedirect+"#"+fragment.Encode(), http.StatusFound)
+}
+
+// provisionOIDCUser returns the account linked to the external identity. An existing
+// password account with the same email is linked only when both the provider and the
+// account have verified the address, so an account registered with someone else's email
+// cannot be taken over by their first SSO login; otherwise a new account is created just
+// in time.
+func provisionOIDCUser(r *http.Request, identity *oidcIdentity) (*models.User, error) {
+	ctx := r.Context()
+	if identity.Subject == "" || identity.Issuer == "" {
+		return nil, errors.New("ID token has no subject")
+	}
+
+	var user models.User
+	err := usersCollection.FindOne(ctx, bson.M{
+		"external_issuer":  identity.Issuer,
+		"external_subject": identity.Subject,
+	}).Decode(&user)
+	if err == nil {
+		return &user, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	if identity.Email == "" {
+		return nil, errors.New("identity provider did not return an email address")
+	}
+	err = usersCollection.FindOne(ctx, bson.M{"email": identity.Email}).Decode(&user)
+	switch {
+	case err == nil:
+		if user.ExternalSubject != "" {
+			return nil, errors.New("email is already linked to another identity")
+		}
+		if !identity.EmailVerified {
+			return nil, errors.New("email address is not verified by the identity provider")
+		}
+		if !user.EmailVerified {
+			return nil, errors.New("existing account has not verified its email address; sign in with the password and verify it first")
+		}
+		_, err := usersCollection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{
+			"$set": bson.M{"external_issuer": identity.Issuer, "external_subject": identity.Subject},
+		})
+		if err != nil {
+			return nil, err
+		}
+		user.ExternalIssuer, user.ExternalSubject = identity.Issuer, identity.Subject
+		log.Printf("Linked %s to OIDC subject %s at %s", user.Email, identity.Subject, identity.Issuer)
+		return &user, nil
+	case !errors.Is(err, mongo.ErrNoDocuments):
+		return nil, err
+	}
+
+	firstName, surname := identity.GivenName, identity.FamilyName
+	if firstName == "" && surname == "" {
+		firstName, surname, _ = strings.Cut(identity.Name, " ")
+	}
+	// No password is set, so the account can only sign in through the identity provider
+	user = models.User{
+		ID:              primitive.NewObjectID(),
+		FirstName:       firstName,
+		Surname:         surname,
+		Email:           identity.Email,
+		EmailVerified:   identity.EmailVerified,
+		ExternalIssuer:  identity.Issuer,
+		ExternalSubject: identity.Subject,
+	}
+	if _, err := usersCollection.InsertOne(ctx, user); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Provisioned %s from OIDC subject %s at %s", user.Email, identity.Subject, identity.Issuer)
+	recordAudit(r, audit.Event{
+		Action:       audit.ActionAccountCreate,
+		Actor:        user.Email,
+		ActorID:      user.ID,
+		TargetUserID: user.ID,
+		Target:       user.Email,
+		Details:      map[string]string{"method": "oidc", "issuer": identity.Issuer},
+	})
+	return &user, nil
+}
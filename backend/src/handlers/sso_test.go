@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"DocuDefense/backend/src/models"
+	"DocuDefense/backend/src/signing"
+	"DocuDefense/backend/src/totp"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// mockProvider is a minimal OpenID Connect provider that enforces PKCE at its token endpoint
+type mockProvider struct {
+	t      *testing.T
+	server *httptest.Server
+	keys   *signing.KeySet
+	codes  map[string]mockGrant
+
+	// Identity returned for the next authorization
+	subject       string
+	email         string
+	emailVerified bool
+}
+
+type mockGrant struct {
+	challenge, nonce, subject, email string
+	emailVerified                    bool
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockProvider{t: t, keys: &signing.KeySet{}, codes: map[string]mockGrant{}}
+	p.keys.Add("idp-1", key)
+	p.keys.SetActive("idp-1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                p.server.URL,
+			"authorization_endpoint":                p.server.URL + "/authorize",
+			"token_endpoint":                        p.server.URL + "/token",
+			"jwks_uri":                              p.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(p.keys.JWKS())
+	})
+	mux.HandleFunc("/token", p.token)
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+// authorize plays the user approving the login at the provider and returns the callback query
+func (p *mockProvider) authorize(authURL string) url.Values {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		p.t.Fatalf("authorization request without a PKCE challenge: %s", authURL)
+	}
+	code := "code-" + q.Get("state")
+	p.codes[code] = mockGrant{
+		challenge:     q.Get("code_challenge"),
+		nonce:         q.Get("nonce"),
+		subject:       p.subject,
+		email:         p.email,
+		emailVerified: p.emailVerified,
+	}
+	return url.Values{"code": {code}, "state": {q.Get("state")}}
+}
+
+func (p *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	grant, ok := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+
+	idToken, err := p.keys.Sign(jwt.MapClaims{
+		"iss":            p.server.URL,
+		"sub":            grant.subject,
+		"aud":            "docudefense",
+		"exp":            time.Now().Add(time.Minute).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          grant.nonce,
+		"email":          grant.email,
+		"email_verified": grant.emailVerified,
+		"given_name":     "Sam",
+		"family_name":    "Sso",
+	})
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "provider-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     idToken,
+	})
+}
+
+func newSSOFixture(t *testing.T) (*authFixture, *mockProvider) {
+	f := newAuthFixture(t)
+	p := newMockProvider(t)
+
+	client, err := NewOIDCClient(context.Background(), OIDCConfig{
+		IssuerURL:   p.server.URL,
+		ClientID:    "docudefense",
+		RedirectURL: "http://localhost:8000/auth/oidc/callback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevClient, prevStates := oidcClient, oidcStatesCollection
+	oidcClient, oidcStatesCollection = client, newFakeCollection(t)
+	t.Cleanup(func() { oidcClient, oidcStatesCollection = prevClient, prevStates })
+	return f, p
+}
+
+// ssoLogin runs the flow from /auth/oidc/login through the provider to the callback
+func (f *authFixture) ssoLogin(t *testing.T, p *mockProvider) (url.Values, *httptest.ResponseRecorder) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	f.router.ServeHTTP(rec, httptest.NewRequest("GET", "/auth/oidc/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login: got %d %q", rec.Code, rec.Body.String())
+	}
+	callback := p.authorize(rec.Header().Get("Location"))
+	return callback, f.callback(t, callback)
+}
+
+func (f *authFixture) callback(t *testing.T, query url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	f.router.ServeHTTP(rec, httptest.NewRequest("GET", "/auth/oidc/callback?"+query.Encode(), nil))
+	return rec
+}
+
+func TestOIDCLoginProvisionsUser(t *testing.T) {
+	f, p := newSSOFixture(t)
+	p.subject, p.email, p.emailVerified = "idp-user-1", "sam@example.com", true
+
+	_, rec := f.ssoLogin(t, p)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("callback: got %d %q", rec.Code, rec.Body.String())
+	}
+	var session struct {
+		Token string `json:"token"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &session)
+
+	var user models.User
+	if err := f.users.FindOne(context.Background(), bson.M{"external_subject": "idp-user-1"}).Decode(&user); err != nil {
+		t.Fatalf("user was not provisioned: %v", err)
+	}
+	if user.Email != "sam@example.com" || user.ExternalIssuer != p.server.URL || user.FirstName != "Sam" {
+		t.Fatalf("unexpected provisioned user %+v", user)
+	}
+
+	req := httptest.NewRequest("GET", "/users/"+user.ID.Hex()+"/files", nil)
+	req.Header.Set("Authorization", "Bearer "+session.Token)
+	files := httptest.NewRecorder()
+	f.router.ServeHTTP(files, req)
+	if files.Code != http.StatusOK {
+		t.Fatalf("DocuDefense token from SSO rejected with %d", files.Code)
+	}
+
+	// A second login reuses the linked account
+	if _, rec := f.ssoLogin(t, p); rec.Code != http.StatusOK {
+		t.Fatalf("second login: got %d", rec.Code)
+	}
+	cursor, _ := f.users.Find(context.Background(), bson.M{"email": "sam@example.com"})
+	var matches []models.User
+	cursor.All(context.Background(), &matches)
+	if len(matches) != 1 {
+		t.Fatalf("got %d accounts for the same identity", len(matches))
+	}
+}
+
+func TestOIDCStateIsSingleUse(t *testing.T) {
+	f, p := newSSOFixture(t)
+	p.subject, p.email = "idp-user-1", "sam@example.com"
+
+	callback, rec := f.ssoLogin(t, p)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("callback: got %d", rec.Code)
+	}
+	if rec := f.callback(t, callback); rec.Code != http.StatusBadRequest {
+		t.Fatalf("replayed callback: got %d, want 400", rec.Code)
+	}
+	if rec := f.callback(t, url.Values{"code": {"x"}, "state": {"forged"}}); rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown state: got %d, want 400", rec.Code)
+	}
+}
+
+func TestOIDCLinksExistingAccountOnlyWhenBothSidesVerified(t *testing.T) {
+	f, p := newSSOFixture(t)
+	p.subject, p.email, p.emailVerified = "idp-alice", f.alice.Email, false
+
+	if _, rec := f.ssoLogin(t, p); rec.Code != http.StatusForbidden {
+		t.Fatalf("unverified email claiming an existing account: got %d, want 403", rec.Code)
+	}
+
+	// Someone may have registered the address without owning it
+	p.emailVerified = true
+	if _, rec := f.ssoLogin(t, p); rec.Code != http.StatusForbidden {
+		t.Fatalf("verified email claiming an unverified account: got %d, want 403", rec.Code)
+	}
+
+	f.users.UpdateOne(context.Background(), bson.M{"_id": f.alice.ID}, bson.M{"$set": bson.M{"email_verified": true}})
+	if _, rec := f.ssoLogin(t, p); rec.Code != http.StatusOK {
+		t.Fatalf("verified email: got %d %q", rec.Code, rec.Body.String())
+	}
+	var alice models.User
+	f.users.FindOne(context.Background(), bson.M{"_id": f.alice.ID}).Decode(&alice)
+	if alice.ExternalSubject != "idp-alice" {
+		t.Fatalf("existing account was not linked: %+v", alice)
+	}
+}
+
+func TestOIDCLoginAsksEnrolledUsersForTheirSecondFactor(t *testing.T) {
+	f, p := newSSOFixture(t)
+	secret, _ := f.enrollAlice(t)
+	f.users.UpdateOne(context.Background(), bson.M{"_id": f.alice.ID}, bson.M{"$set": bson.M{"email_verified": true}})
+	p.subject, p.email, p.emailVerified = "idp-alice", f.alice.Email, true
+
+	_, rec := f.ssoLogin(t, p)
+	var body struct {
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
+		Token       string `json:"token"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if rec.Code != http.StatusOK || !body.MFARequired || body.Token != "" {
+		t.Fatalf("SSO login for an enrolled user: got %d %q", rec.Code, rec.Body.String())
+	}
+
+	next := time.Now().Add(totp.Period)
+	mfa := `{"mfa_token":"` + body.MFAToken + `",` + strings.TrimPrefix(codeBody(t, secret, next), "{")
+	if rec := f.post(t, "/login/mfa", "", mfa); rec.Code != http.StatusOK {
+		t.Fatalf("login/mfa after SSO: got %d %q", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,353 @@
+package handlers
+
+import (
+	"DocuDefense/backend/src/audit"
+	"DocuDefense/backend/src/models"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Server-side token state: issued refresh tokens and access tokens revoked before expiry
+var refreshTokensCollection DatabaseCollection
+var revokedTokensCollection DatabaseCollection
+
+// errInvalidRefreshToken covers unknown, expired, revoked and replayed refresh tokens alike
+var errInvalidRefreshToken = errors.New("invalid refresh token")
+
+// errAccountDisabled is returned when a session is requested for an account an administrator disabled
+var errAccountDisabled = errors.New("account is disabled")
+
+// accessTokenTTL is the lifetime of access tokens, 15 minutes unless ACCESS_TOKEN_TTL is set
+func accessTokenTTL() time.Duration {
+	return durationFromEnv("ACCESS_TOKEN_TTL", 15*time.Minute)
+}
+
+// refreshTokenTTL is how long a refresh token stays usable, 7 days unless REFRESH_TOKEN_TTL is set
+func refreshTokenTTL() time.Duration {
+	return durationFromEnv("REFRESH_TOKEN_TTL", 7*24*time.Hour)
+}
+
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	if value := os.Getenv(name); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Ignoring invalid %s=%q", name, value)
+	}
+	return fallback
+}
+
+// ensureTokenIndexes indexes refresh tokens by hash and lets MongoDB expire old token records
+func ensureTokenIndexes(ctx context.Context) error {
+	_, err := mongoDatabase.Collection("refresh_tokens").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "family_id", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = mongoDatabase.Collection("revoked_tokens").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// hashRefreshToken returns the form of a refresh token stored in the database
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRefreshToken stores a new refresh token for the user and returns its plaintext. It
+// starts a new family unless it replaces a rotated token, whose family it joins under the
+// ID the rotation recorded as its replacement.
+func newRefreshToken(ctx context.Context, userID primitive.ObjectID, replaces *models.RefreshToken, mfaAt *time.Time) (string, *models.RefreshToken, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	now := time.Now()
+	record := &models.RefreshToken{
+		ID:        primitive.NewObjectID(),
+		TokenHash: hashRefreshToken(token),
+		UserID:    userID,
+		FamilyID:  primitive.NewObjectID(),
+		IssuedAt:  now,
+		ExpiresAt: now.Add(refreshTokenTTL()),
+		MFAAt:     mfaAt,
+	}
+	if replaces != nil {
+		record.ID, record.FamilyID = replaces.ReplacedBy, replaces.FamilyID
+	}
+	if _, err := refreshTokensCollection.InsertOne(ctx, record); err != nil {
+		return "", nil, err
+	}
+	return token, record, nil
+}
+
+// issueSession returns the login/refresh response body: a short-lived access token and a
+// refresh token replacing the rotated token replaces (nil for a login). mfaAt is when the
+// login completed two-factor verification, zero if it did not; refreshed tokens keep the
+// original time.
+func issueSession(ctx context.Context, user *models.User, replaces *models.RefreshToken, mfaAt time.Time) (map[string]interface{}, error) {
+	if user.Disabled {
+		return nil, errAccountDisabled
+	}
+	accessToken, err := generateAccessToken(user, mfaAt)
+	if err != nil {
+		return nil, fmt.Errorf("generating access token: %w", err)
+	}
+	var refreshMFAAt *time.Time
+	if !mfaAt.IsZero() {
+		refreshMFAAt = &mfaAt
+	}
+	refreshToken, _, err := newRefreshToken(ctx, user.ID, replaces, refreshMFAAt)
+	if err != nil {
+		return nil, fmt.Errorf("storing refresh token: %w", err)
+	}
+	return map[string]interface{}{
+		"token":         accessToken,
+		"refresh_token": refreshToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(accessTokenTTL().Seconds()),
+	}, nil
+}
+
+// revokeTokenFamily revokes every refresh token descended from the same login
+func revokeTokenFamily(ctx context.Context, familyID primitive.ObjectID) error {
+	_, err := refreshTokensCollection.UpdateMany(ctx, bson.M{
+		"family_id":  familyID,
+		"revoked_at": bson.M{"$exists": false},
+	}, bson.M{"$set": bson.M{"revoked_at": time.Now()}})
+	return err
+}
+
+// revokeUserTokens invalidates every access and refresh token issued to the user
+func revokeUserTokens(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := usersCollection.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$inc": bson.M{"token_version": 1}})
+	if err != nil {
+		return err
+	}
+	return revokeRefreshTokens(ctx, userID)
+}
+
+// revokeRefreshTokens revokes the user's refresh tokens. Access tokens are invalidated by
+// incrementing token_version, which callers can do in the same update as the change that
+// calls for it.
+func revokeRefreshTokens(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := refreshTokensCollection.UpdateMany(ctx, bson.M{
+		"user_id":    userID,
+		"revoked_at": bson.M{"$exists": false},
+	}, bson.M{"$set": bson.M{"revoked_at": time.Now()}})
+	return err
+}
+
+// rotateRefreshToken consumes a refresh token and returns its record. Presenting a token
+// that was already rotated means it was copied, so its whole family is revoked.
+func rotateRefreshToken(r *http.Request, token string) (*models.RefreshToken, error) {
+	ctx := r.Context()
+
+	var record models.RefreshToken
+	err := refreshTokensCollection.FindOne(ctx, bson.M{"token_hash": hashRefreshToken(token)}).Decode(&record)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, errInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if record.RevokedAt != nil || time.Now().After(record.ExpiresAt) {
+		return nil, errInvalidRefreshToken
+	}
+
+	// Conditional update so two concurrent refreshes cannot both rotate the same token. The
+	// replacement's ID is recorded with it, so a reused token can be traced down its family.
+	replacement := primitive.NewObjectID()
+	result, err := refreshTokensCollection.UpdateOne(ctx, bson.M{
+		"_id":        record.ID,
+		"rotated_at": bson.M{"$exists": false},
+		"revoked_at": bson.M{"$exists": false},
+	}, bson.M{"$set": bson.M{"rotated_at": time.Now(), "replaced_by": replacement}})
+	if err != nil {
+		return nil, err
+	}
+	if result.ModifiedCount == 0 {
+		log.Printf("Refresh token reuse detected for user %s; revoking token family %s", record.UserID.Hex(), record.FamilyID.Hex())
+		if err := revokeTokenFamily(ctx, record.FamilyID); err != nil {
+			log.Printf("Error revoking token family %s: %v", record.FamilyID.Hex(), err)
+		}
+		recordAudit(r, audit.Event{
+			Action:       audit.ActionTokenReuse,
+			ActorID:      record.UserID,
+			TargetUserID: record.UserID,
+			Details:      map[string]string{"family": record.FamilyID.Hex(), "replaced_by": record.ReplacedBy.Hex()},
+		})
+		return nil, errInvalidRefreshToken
+	}
+	record.ReplacedBy = replacement
+	return &record, nil
+}
+
+// RefreshToken exchanges a refresh token for a new access token and a new refresh token
+func RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.RefreshToken == "" {
+		http.Error(w, "Invalid refresh request", http.StatusBadRequest)
+		return
+	}
+
+	record, err := rotateRefreshToken(r, body.RefreshToken)
+	if errors.Is(err, errInvalidRefreshToken) {
+		http.Error(w, "Invalid or expired refresh token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("Error rotating refresh token: %v", err)
+		http.Error(w, "Error refreshing token", http.StatusInternalServerError)
+		return
+	}
+
+	var user models.User
+	if err := usersCollection.FindOne(r.Context(), bson.M{"_id": record.UserID}).Decode(&user); err != nil {
+		log.Printf("Refresh for unknown user %s: %v", record.UserID.Hex(), err)
+		http.Error(w, "Invalid or expired refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	var mfaAt time.Time
+	if record.MFAAt != nil {
+		mfaAt = *record.MFAAt
+	}
+	session, err := issueSession(r.Context(), &user, record, mfaAt)
+	if errors.Is(err, errAccountDisabled) {
+		http.Error(w, "This account has been disabled", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Printf("Error refreshing session for %s: %v", user.Email, err)
+		http.Error(w, "Error refreshing token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(session)
+}
+
+// Logout revokes the caller's access token and the refresh token family it sends.
+// With "all": true every token issued to the account is invalidated.
+func Logout(w http.ResponseWriter, r *http.Request) {
+	claims, ok := claimsFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+	userID, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil {
+		http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+		return
+	}
+
+	var body struct {
+		RefreshToken string `json:"refresh_token"`
+		All          bool   `json:"all"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid logout request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx := r.Context()
+	_, err = revokedTokensCollection.InsertOne(ctx, models.RevokedToken{
+		ID:        claims.ID,
+		UserID:    userID,
+		ExpiresAt: claims.ExpiresAt.Time,
+	})
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		log.Printf("Error revoking access token for %s: %v", claims.Email, err)
+		http.Error(w, "Error logging out", http.StatusInternalServerError)
+		return
+	}
+
+	if body.RefreshToken != "" {
+		var record models.RefreshToken
+		err := refreshTokensCollection.FindOne(ctx, bson.M{"token_hash": hashRefreshToken(body.RefreshToken), "user_id": userID}).Decode(&record)
+		if err == nil {
+			err = revokeTokenFamily(ctx, record.FamilyID)
+		}
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Printf("Error revoking refresh token for %s: %v", claims.Email, err)
+			http.Error(w, "Error logging out", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if body.All {
+		if err := revokeUserTokens(ctx, userID); err != nil {
+			log.Printf("Error revoking all tokens for %s: %v", claims.Email, err)
+			http.Error(w, "Error logging out", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	recordAudit(r, audit.Event{
+		Action:       audit.ActionLogout,
+		TargetUserID: userID,
+		Target:       claims.Email,
+		Details:      map[string]string{"all_sessions": fmt.Sprint(body.All)},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Logged out"})
+}
+
+// validateSession rejects access tokens that were revoked by logout or whose token
+// version no longer matches the account (password reset, "log out everywhere", deletion,
+// role change) or whose account has been disabled
+func validateSession(ctx context.Context, claims *Claims) error {
+	userID, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil || claims.ID == "" {
+		return errors.New("token has no subject or ID")
+	}
+
+	var user models.User
+	if err := usersCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		return fmt.Errorf("looking up token subject: %w", err)
+	}
+	if user.TokenVersion != claims.TokenVersion {
+		return errors.New("token version is out of date")
+	}
+	if user.Disabled {
+		return errAccountDisabled
+	}
+
+	err = revokedTokensCollection.FindOne(ctx, bson.M{"_id": claims.ID}).Err()
+	if err == nil {
+		return errors.New("token was revoked")
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+	return nil
+}
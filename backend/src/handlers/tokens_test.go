@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func (f *authFixture) login(t *testing.T) (accessToken, refreshToken string) {
+	t.Helper()
+	session, err := issueSession(context.Background(), &f.alice, nil, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session["token"].(string), session["refresh_token"].(string)
+}
+
+func (f *authFixture) post(t *testing.T, path, accessToken, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("POST", path, strings.NewReader(body))
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	rec := httptest.NewRecorder()
+	f.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func (f *authFixture) listFiles(t *testing.T, accessToken string) int {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/users/"+f.alice.ID.Hex()+"/files", nil)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	rec := httptest.NewRecorder()
+	f.router.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func refreshBody(token string) string {
+	body, _ := json.Marshal(map[string]string{"refresh_token": token})
+	return string(body)
+}
+
+func TestRefreshRotatesToken(t *testing.T) {
+	f := newAuthFixture(t)
+	_, refresh := f.login(t)
+
+	rec := f.post(t, "/token/refresh", "", refreshBody(refresh))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("refresh: got %d %q", rec.Code, rec.Body.String())
+	}
+	var session struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &session); err != nil {
+		t.Fatal(err)
+	}
+	if session.RefreshToken == "" || session.RefreshToken == refresh {
+		t.Fatalf("refresh token was not rotated")
+	}
+	if code := f.listFiles(t, session.Token); code != http.StatusOK {
+		t.Fatalf("refreshed access token rejected with %d", code)
+	}
+
+	// The rotated record points at its replacement in the same family
+	rotated := f.refreshTokens.matching(bson.M{"token_hash": hashRefreshToken(refresh)})[0]
+	replacement := f.refreshTokens.matching(bson.M{"token_hash": hashRefreshToken(session.RefreshToken)})[0]
+	if rotated["replaced_by"] != replacement["_id"] || rotated["family_id"] != replacement["family_id"] {
+		t.Fatalf("rotated token replaced by %v in family %v, want %v in family %v", rotated["replaced_by"], rotated["family_id"], replacement["_id"], replacement["family_id"])
+	}
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	f := newAuthFixture(t)
+	_, stolen := f.login(t)
+
+	rec := f.post(t, "/token/refresh", "", refreshBody(stolen))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("first refresh: got %d", rec.Code)
+	}
+	var session struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &session)
+
+	if rec := f.post(t, "/token/refresh", "", refreshBody(stolen)); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("replayed refresh token: got %d, want 401", rec.Code)
+	}
+	if rec := f.post(t, "/token/refresh", "", refreshBody(session.RefreshToken)); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("refresh token from a revoked family: got %d, want 401", rec.Code)
+	}
+}
+
+func TestLogoutRevokesTokens(t *testing.T) {
+	f := newAuthFixture(t)
+	access, refresh := f.login(t)
+
+	if rec := f.post(t, "/logout", access, refreshBody(refresh)); rec.Code != http.StatusOK {
+		t.Fatalf("logout: got %d %q", rec.Code, rec.Body.String())
+	}
+	if code := f.listFiles(t, access); code != http.StatusUnauthorized {
+		t.Fatalf("access token after logout: got %d, want 401", code)
+	}
+	if rec := f.post(t, "/token/refresh", "", refreshBody(refresh)); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("refresh token after logout: got %d, want 401", rec.Code)
+	}
+}
+
+func TestLogoutEverywhereInvalidatesOtherSessions(t *testing.T) {
+	f := newAuthFixture(t)
+	access, _ := f.login(t)
+	otherAccess, otherRefresh := f.login(t)
+
+	if rec := f.post(t, "/logout", access, `{"all": true}`); rec.Code != http.StatusOK {
+		t.Fatalf("logout everywhere: got %d %q", rec.Code, rec.Body.String())
+	}
+	if code := f.listFiles(t, otherAccess); code != http.StatusUnauthorized {
+		t.Fatalf("other session's access token: got %d, want 401", code)
+	}
+	if rec := f.post(t, "/token/refresh", "", refreshBody(otherRefresh)); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("other session's refresh token: got %d, want 401", rec.Code)
+	}
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"DocuDefense/backend/src/audit"
+	"DocuDefense/backend/src/models"
+	"DocuDefense/backend/src/pdfcheck"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// uploadRejection is the body of a refused upload. Error is a stable code for clients;
+// Findings lists what was wrong with a PDF that was rejected; Lock is the check-out that
+// refused it.
+type uploadRejection struct {
+	Error    string               `json:"error"`
+	Message  string               `json:"message"`
+	Findings []pdfcheck.Finding   `json:"findings,omitempty"`
+	Lock     *models.DocumentLock `json:"lock,omitempty"`
+}
+
+// maxUploadSize is the largest file accepted, 100 MiB unless UPLOAD_MAX_BYTES is set
+func maxUploadSize() int64 {
+	return int64(intFromEnv("UPLOAD_MAX_BYTES", 100<<20))
+}
+
+// flaggedFindings are the PDF findings accepted and recorded on the version instead of
+// rejected, from the comma-separated PDF_FLAG_FINDINGS (e.g. "encrypted,malformed").
+// Everything is rejected unless listed.
+func flaggedFindings() map[string]bool {
+	flagged := map[string]bool{}
+	for _, code := range strings.Split(os.Getenv("PDF_FLAG_FINDINGS"), ",") {
+		if code = strings.TrimSpace(code); code != "" {
+			flagged[code] = true
+		}
+	}
+	return flagged
+}
+
+// uploadDetails describes a stored upload for the audit trail
+func uploadDetails(doc *models.Document) map[string]string {
+	details := map[string]string{"version": strconv.Itoa(doc.Version), "sha256": doc.SHA256}
+	if len(doc.ValidationFlags) > 0 {
+		details["validation_flags"] = strings.Join(doc.ValidationFlags, ",")
+	}
+	return details
+}
+
+func writeRejection(w http.ResponseWriter, status int, rejection uploadRejection) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(rejection)
+}
+
+// validateUpload spools an upload to disk and checks it is a PDF the policy accepts. It returns
+// the spooled content, which the caller closes, and the findings to flag on the new version, or
+// answers the request with a structured rejection and records it in the audit trail.
+func validateUpload(w http.ResponseWriter, r *http.Request, content io.Reader, filename string) (*spooledUpload, []string, bool) {
+	limit := maxUploadSize()
+	spool, err := spoolUpload(content, limit)
+	if err != nil {
+		log.Printf("Error reading uploaded file: %v", err)
+		http.Error(w, "Error reading the file", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	reject := func(status int, rejection uploadRejection) (*spooledUpload, []string, bool) {
+		spool.Close()
+		codes := []string{rejection.Error}
+		for _, f := range rejection.Findings {
+			codes = append(codes, f.Code)
+		}
+		recordAudit(r, audit.Event{
+			Action:  audit.ActionDocumentReject,
+			Target:  filename,
+			Details: map[string]string{"reason": strings.Join(codes, ","), "size": strconv.FormatInt(spool.size, 10)},
+		})
+		writeRejection(w, status, rejection)
+		return nil, nil, false
+	}
+
+	if spool.size > limit {
+		return reject(http.StatusRequestEntityTooLarge, uploadRejection{
+			Error:   "too_large",
+			Message: "The file is larger than the " + strconv.FormatInt(limit>>20, 10) + " MiB upload limit",
+		})
+	}
+
+	data, err := spool.Bytes()
+	if err != nil {
+		spool.Close()
+		log.Printf("Error mapping upload %s: %v", filename, err)
+		http.Error(w, "Error reading the file", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	report, err := pdfcheck.Inspect(data)
+	if errors.Is(err, pdfcheck.ErrNotPDF) {
+		return reject(http.StatusUnsupportedMediaType, uploadRejection{
+			Error:   "not_pdf",
+			Message: "Only PDF documents can be uploaded",
+		})
+	}
+	if err != nil {
+		spool.Close()
+		log.Printf("Error inspecting %s: %v", filename, err)
+		http.Error(w, "Error reading the file", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+
+	flagged := flaggedFindings()
+	var refused []pdfcheck.Finding
+	var flags []string
+	for _, f := range report.Findings {
+		if flagged[f.Code] {
+			flags = append(flags, f.Code)
+		} else {
+			refused = append(refused, f)
+		}
+	}
+	if len(refused) > 0 {
+		return reject(http.StatusUnprocessableEntity, uploadRejection{
+			Error:    "invalid_pdf",
+			Message:  "The PDF was rejected: " + refused[0].Message,
+			Findings: refused,
+		})
+	}
+	return spool, flags, true
+}
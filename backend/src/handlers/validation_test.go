@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"DocuDefense/backend/src/models"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// testPDF builds a one-page PDF with a valid cross-reference table; extra is added to the catalog
+func testPDF(extra string) []byte {
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R " + extra + ">>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] >>",
+	}
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.7\n")
+	offsets := make([]int, len(objects))
+	for i, body := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, body)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return b.Bytes()
+}
+
+// upload sends content as Alice's file in the "contract" form field
+func (f *authFixture) upload(t *testing.T, accessToken, filename string, content []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("contract", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(content)
+	form.Close()
+
+	req := httptest.NewRequest("POST", "/users/"+f.alice.ID.Hex()+"/upload", &body)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	rec := httptest.NewRecorder()
+	f.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestUploadRejectsFilesThatAreNotPDFs(t *testing.T) {
+	f := newAuthFixture(t)
+	access, _ := f.login(t)
+
+	rec := f.upload(t, access, "invoice.pdf", []byte("MZ\x90\x00 not really a PDF"))
+	var rejection uploadRejection
+	json.NewDecoder(rec.Body).Decode(&rejection)
+	if rec.Code != http.StatusUnsupportedMediaType || rejection.Error != "not_pdf" {
+		t.Fatalf("got %d %+v, want 415 not_pdf", rec.Code, rejection)
+	}
+
+	if rec := f.upload(t, access, "lease.pdf", testPDF("")); rec.Code != http.StatusOK {
+		t.Fatalf("valid PDF: got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestUploadRejectsOrFlagsRiskyPDFs(t *testing.T) {
+	f := newAuthFixture(t)
+	access, _ := f.login(t)
+	risky := testPDF("/OpenAction << /S /JavaScript /JS (app.alert(1)) >> ")
+
+	rec := f.upload(t, access, "lease.pdf", risky)
+	var rejection uploadRejection
+	json.NewDecoder(rec.Body).Decode(&rejection)
+	if rec.Code != http.StatusUnprocessableEntity || rejection.Error != "invalid_pdf" || len(rejection.Findings) != 1 || rejection.Findings[0].Code != "javascript" {
+		t.Fatalf("got %d %+v, want 422 with a javascript finding", rec.Code, rejection)
+	}
+
+	t.Setenv("PDF_FLAG_FINDINGS", "javascript")
+	if rec := f.upload(t, access, "lease.pdf", risky); rec.Code != http.StatusOK {
+		t.Fatalf("flagged upload: got %d %q", rec.Code, rec.Body.String())
+	}
+	var doc models.Document
+	if err := f.docs.FindOne(context.Background(), bson.M{"filename": "lease.pdf"}).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.ValidationFlags) != 1 || doc.ValidationFlags[0] != "javascript" || doc.ContentType != "application/pdf" {
+		t.Fatalf("stored version: flags %v, content type %q", doc.ValidationFlags, doc.ContentType)
+	}
+}
@@ -0,0 +1,367 @@
+package handlers
+
+import (
+	"DocuDefense/backend/src/audit"
+	"DocuDefense/backend/src/mailer"
+	"DocuDefense/backend/src/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Purpose claims of the tokens sent by email
+const (
+	verifyEmailPurpose   = "verify_email"
+	passwordResetPurpose = "password_reset"
+)
+
+var errInvalidAccountToken = errors.New("invalid or expired token")
+
+// Issued email verification and password reset tokens, used to make each one single use
+var accountTokensCollection DatabaseCollection
+
+// Mailer used for account emails; messages are only logged until SetMailer is called
+var mailSender mailer.Mailer = mailer.LogMailer{}
+
+// SetMailer sets how verification and password reset emails are delivered
+func SetMailer(m mailer.Mailer) {
+	mailSender = m
+}
+
+// emailVerificationTTL is how long a verification link stays valid, 24 hours unless
+// EMAIL_VERIFICATION_TTL is set
+func emailVerificationTTL() time.Duration {
+	return durationFromEnv("EMAIL_VERIFICATION_TTL", 24*time.Hour)
+}
+
+// passwordResetTTL is how long a password reset link stays valid, 1 hour unless
+// PASSWORD_RESET_TTL is set
+func passwordResetTTL() time.Duration {
+	return durationFromEnv("PASSWORD_RESET_TTL", time.Hour)
+}
+
+// appBaseURL is where the frontend is served; emailed links point at its pages
+func appBaseURL() string {
+	if base := os.Getenv("APP_BASE_URL"); base != "" {
+		return strings.TrimSuffix(base, "/")
+	}
+	return "http://localhost:3000"
+}
+
+// ensureAccountTokenIndexes lets used and expired email tokens age out
+func ensureAccountTokenIndexes(ctx context.Context) error {
+	_, err := mongoDatabase.Collection("account_tokens").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// newAccountToken signs a single-use token for purpose and records its jti. Earlier unused
+// tokens for the same purpose are retired so only the most recent email works.
+func newAccountToken(ctx context.Context, user *models.User, purpose string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	record := models.AccountToken{
+		ID:        primitive.NewObjectID().Hex(),
+		UserID:    user.ID,
+		Purpose:   purpose,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	_, err := accountTokensCollection.UpdateMany(ctx, bson.M{
+		"user_id": user.ID,
+		"purpose": purpose,
+		"used_at": bson.M{"$exists": false},
+	}, bson.M{"$set": bson.M{"used_at": now}})
+	if err != nil {
+		return "", err
+	}
+	if _, err := accountTokensCollection.InsertOne(ctx, record); err != nil {
+		return "", err
+	}
+
+	return tokenKeys.Sign(&Claims{
+		Email:        user.Email,
+		TokenVersion: user.TokenVersion,
+		Purpose:      purpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        record.ID,
+			Issuer:    tokenKeys.Issuer(),
+			Subject:   user.ID.Hex(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(record.ExpiresAt),
+		},
+	})
+}
+
+// consumeAccountToken verifies an emailed token and marks it used, returning the account it
+// was issued for. The token is rejected if the account's email changed since it was sent,
+// and password reset tokens also stop working once the user's sessions are revoked.
+func consumeAccountToken(ctx context.Context, token, purpose string) (*models.User, error) {
+	claims := &Claims{}
+	parsed, err := tokenKeys.Parse(token, claims)
+	if err != nil || !parsed.Valid || claims.Purpose != purpose || claims.ID == "" {
+		return nil, errInvalidAccountToken
+	}
+	userID, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil {
+		return nil, errInvalidAccountToken
+	}
+
+	result, err := accountTokensCollection.UpdateOne(ctx, bson.M{
+		"_id":     claims.ID,
+		"purpose": purpose,
+		"used_at": bson.M{"$exists": false},
+	}, bson.M{"$set": bson.M{"used_at": time.Now()}})
+	if err != nil {
+		return nil, err
+	}
+	if result.ModifiedCount == 0 {
+		return nil, errInvalidAccountToken
+	}
+
+	var user models.User
+	err = usersCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, errInvalidAccountToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.Email != claims.Email || (purpose == passwordResetPurpose && user.TokenVersion != claims.TokenVersion) {
+		return nil, errInvalidAccountToken
+	}
+	return &user, nil
+}
+
+// sendAccountEmail renders tmpl for the user and sends it in the background, so response
+// times do not reveal whether an email was sent
+func sendAccountEmail(tmpl *mailer.Template, user *models.User, data mailer.LinkData) {
+	data.Name = user.FirstName
+	msg, err := tmpl.Render(user.Email, data)
+	if err != nil {
+		log.Printf("Error rendering email for %s: %v", user.Email, err)
+		return
+	}
+	sendMessage(msg)
+}
+
+// sendMessage delivers an email in the background so a slow mail relay does not hold up the response
+func sendMessage(msg mailer.Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := mailSender.Send(ctx, msg); err != nil {
+			log.Printf("Error sending %q to %s: %v", msg.Subject, msg.To, err)
+		}
+	}()
+}
+
+// sendVerificationEmail emails the user a link that confirms their address
+func sendVerificationEmail(ctx context.Context, user *models.User) error {
+	ttl := emailVerificationTTL()
+	token, err := newAccountToken(ctx, user, verifyEmailPurpose, ttl)
+	if err != nil {
+		return err
+	}
+	sendAccountEmail(mailer.VerifyEmail, user, mailer.LinkData{
+		Link:      appBaseURL() + "/verify-email#token=" + url.QueryEscape(token),
+		ExpiresIn: humanDuration(ttl),
+	})
+	return nil
+}
+
+// humanDuration formats link lifetimes for emails, e.g. "1 hour" or "24 hours"
+func humanDuration(d time.Duration) string {
+	switch {
+	case d >= time.Hour && d%time.Hour == 0:
+		return pluralize(int(d/time.Hour), "hour")
+	case d >= time.Minute && d%time.Minute == 0:
+		return pluralize(int(d/time.Minute), "minute")
+	default:
+		return d.String()
+	}
+}
+
+func pluralize(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+// VerifyEmail marks the account's email address as verified using the token from the
+// verification email
+func VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Token == "" {
+		http.Error(w, "Invalid verification request", http.StatusBadRequest)
+		return
+	}
+
+	user, err := consumeAccountToken(r.Context(), body.Token, verifyEmailPurpose)
+	if err != nil {
+		if !errors.Is(err, errInvalidAccountToken) {
+			log.Printf("Error checking verification token: %v", err)
+		}
+		http.Error(w, "Invalid or expired verification link", http.StatusBadRequest)
+		return
+	}
+
+	_, err = usersCollection.UpdateOne(r.Context(), bson.M{"_id": user.ID, "email": user.Email}, bson.M{
+		"$set": bson.M{"email_verified": true},
+	})
+	if err != nil {
+		log.Printf("Error verifying email for %s: %v", user.Email, err)
+		http.Error(w, "Error verifying email", http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r, audit.Event{
+		Action:       audit.ActionEmailVerify,
+		Actor:        user.Email,
+		ActorID:      user.ID,
+		TargetUserID: user.ID,
+		Target:       user.Email,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Email address verified"})
+}
+
+// ResendVerificationEmail sends a fresh verification link to the signed-in user
+func ResendVerificationEmail(w http.ResponseWriter, r *http.Request) {
+	targetUser, ok := targetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		return
+	}
+	if targetUser.EmailVerified {
+		http.Error(w, "Email address is already verified", http.StatusConflict)
+		return
+	}
+
+	if err := sendVerificationEmail(r.Context(), targetUser); err != nil {
+		log.Printf("Error creating verification token for %s: %v", targetUser.Email, err)
+		http.Error(w, "Error sending verification email", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Verification email sent"})
+}
+
+// ForgotPassword emails a password reset link. The response is the same whether or not the
+// address belongs to an account, so it cannot be used to discover registered emails.
+func ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Email == "" {
+		http.Error(w, "Email is required", http.StatusBadRequest)
+		return
+	}
+
+	var user models.User
+	err := usersCollection.FindOne(r.Context(), bson.M{"email": body.Email}).Decode(&user)
+	switch {
+	case err == nil && user.Password != "":
+		ttl := passwordResetTTL()
+		token, err := newAccountToken(r.Context(), &user, passwordResetPurpose, ttl)
+		if err != nil {
+			log.Printf("Error creating password reset token for %s: %v", user.Email, err)
+			break
+		}
+		sendAccountEmail(mailer.PasswordReset, &user, mailer.LinkData{
+			Link:      appBaseURL() + "/reset-password#token=" + url.QueryEscape(token),
+			ExpiresIn: humanDuration(ttl),
+		})
+		recordAudit(r, audit.Event{
+			Action:       audit.ActionPasswordResetRequest,
+			Actor:        user.Email,
+			ActorID:      user.ID,
+			TargetUserID: user.ID,
+			Target:       user.Email,
+		})
+	case err == nil:
+		// Accounts created through single sign-on have no password to reset
+		log.Printf("Password reset requested for SSO-only account %s", user.Email)
+	case !errors.Is(err, mongo.ErrNoDocuments):
+		log.Printf("Error looking up %s for password reset: %v", body.Email, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "If an account exists for that email, a password reset link has been sent",
+	})
+}
+
+// ResetPassword sets a new password using the token from the reset email. Every existing
+// session is signed out, and the email address counts as verified.
+func ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Token == "" {
+		http.Error(w, "Invalid password reset request", http.StatusBadRequest)
+		return
+	}
+	if body.Password == "" {
+		http.Error(w, "Password is required", http.StatusBadRequest)
+		return
+	}
+
+	user, err := consumeAccountToken(r.Context(), body.Token, passwordResetPurpose)
+	if err != nil {
+		if !errors.Is(err, errInvalidAccountToken) {
+			log.Printf("Error checking password reset token: %v", err)
+		}
+		http.Error(w, "Invalid or expired password reset link", http.StatusBadRequest)
+		return
+	}
+
+	if err := user.HashPassword(body.Password); err != nil {
+		log.Printf("Error hashing password for %s: %v", user.Email, err)
+		http.Error(w, "Error resetting password", http.StatusInternalServerError)
+		return
+	}
+	_, err = usersCollection.UpdateOne(r.Context(), bson.M{"_id": user.ID}, bson.M{
+		"$set": bson.M{"password": user.Password, "email_verified": true},
+	})
+	if err == nil {
+		err = revokeUserTokens(r.Context(), user.ID)
+	}
+	if err != nil {
+		log.Printf("Error resetting password for %s: %v", user.Email, err)
+		http.Error(w, "Error resetting password", http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r, audit.Event{
+		Action:       audit.ActionPasswordReset,
+		Actor:        user.Email,
+		ActorID:      user.ID,
+		TargetUserID: user.ID,
+		Target:       user.Email,
+	})
+	sendAccountEmail(mailer.PasswordChanged, user, mailer.LinkData{})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Password has been reset. Please log in again."})
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"DocuDefense/backend/src/mailer"
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// captureMailer records sent messages so tests can follow the emailed links
+type captureMailer struct {
+	sent chan mailer.Message
+}
+
+func (m *captureMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.sent <- msg
+	return nil
+}
+
+func (f *authFixture) captureMail(t *testing.T) *captureMailer {
+	t.Helper()
+	m := &captureMailer{sent: make(chan mailer.Message, 10)}
+	prev := mailSender
+	mailSender = m
+	t.Cleanup(func() { mailSender = prev })
+	return m
+}
+
+// next waits for the next email and checks its subject
+func (m *captureMailer) next(t *testing.T, subject string) mailer.Message {
+	t.Helper()
+	select {
+	case msg := <-m.sent:
+		if !strings.Contains(msg.Subject, subject) {
+			t.Fatalf("got email %q, want %q", msg.Subject, subject)
+		}
+		return msg
+	case <-time.After(time.Second):
+		t.Fatalf("no %q email was sent", subject)
+		return mailer.Message{}
+	}
+}
+
+// linkToken extracts the token from the link in an account email
+func linkToken(t *testing.T, msg mailer.Message) string {
+	t.Helper()
+	_, fragment, found := strings.Cut(msg.Text, "#token=")
+	if !found {
+		t.Fatalf("email %q has no token link", msg.Subject)
+	}
+	token, err := url.QueryUnescape(strings.Fields(fragment)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestPasswordResetIsSingleUseAndSignsOut(t *testing.T) {
+	f := newAuthFixture(t)
+	mail := f.captureMail(t)
+	f.alice.Password = "$2a$04$invalid" // any password makes the account eligible for a reset
+	f.users.UpdateOne(context.Background(), bson.M{"_id": f.alice.ID}, bson.M{"$set": bson.M{"password": f.alice.Password}})
+	access, refresh := f.login(t)
+
+	if rec := f.post(t, "/password/forgot", "", `{"email":"alice@example.com"}`); rec.Code != http.StatusAccepted {
+		t.Fatalf("forgot password: got %d", rec.Code)
+	}
+	token := linkToken(t, mail.next(t, "Reset your DocuDefense password"))
+
+	body := `{"token":"` + token + `","password":"new password"}`
+	if rec := f.post(t, "/password/reset", "", body); rec.Code != http.StatusOK {
+		t.Fatalf("reset password: got %d %q", rec.Code, rec.Body.String())
+	}
+	mail.next(t, "password was changed")
+
+	if rec := f.post(t, "/password/reset", "", body); rec.Code != http.StatusBadRequest {
+		t.Fatalf("reused reset token: got %d, want 400", rec.Code)
+	}
+	if code := f.listFiles(t, access); code != http.StatusUnauthorized {
+		t.Fatalf("access token after reset: got %d, want 401", code)
+	}
+	if rec := f.post(t, "/token/refresh", "", refreshBody(refresh)); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("refresh token after reset: got %d, want 401", rec.Code)
+	}
+}
+
+func TestChangingPasswordNeedsTheCurrentOneAndSignsOut(t *testing.T) {
+	f := newAuthFixture(t)
+	mail := f.captureMail(t)
+	f.setAlicePassword(t, "old password")
+	access, refresh := f.login(t)
+	profile := "/users/" + f.alice.ID.Hex()
+
+	for _, body := range []string{
+		`{"password":"new password"}`,
+		`{"password":"new password","current_password":"guess"}`,
+		`{"email":"mallory@example.com","current_password":"guess"}`,
+	} {
+		if rec := f.send(t, "PUT", profile, access, body); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("%s: got %d, want 401", body, rec.Code)
+		}
+	}
+	if rec := f.send(t, "PUT", profile, access, `{"surname":"Smith"}`); rec.Code != http.StatusOK {
+		t.Fatalf("profile update: got %d %q", rec.Code, rec.Body.String())
+	}
+
+	rec := f.send(t, "PUT", profile, access, `{"password":"new password","current_password":"old password"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("password change: got %d %q", rec.Code, rec.Body.String())
+	}
+	mail.next(t, "password was changed")
+	if code := f.listFiles(t, access); code != http.StatusUnauthorized {
+		t.Fatalf("access token after the change: got %d, want 401", code)
+	}
+	if rec := f.post(t, "/token/refresh", "", refreshBody(refresh)); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("refresh token after the change: got %d, want 401", rec.Code)
+	}
+}
+
+func TestEmailAddressBelongsToOneAccount(t *testing.T) {
+	f := newAuthFixture(t)
+	mail := f.captureMail(t)
+	access, _ := f.login(t)
+
+	if rec := f.post(t, "/users", "", `{"email":"bob@example.com","password":"secret"}`); rec.Code != http.StatusConflict {
+		t.Fatalf("registering a taken address: got %d, want 409", rec.Code)
+	}
+	if len(f.users.docs) != 2 {
+		t.Fatalf("refused registration stored an account: %d users", len(f.users.docs))
+	}
+	if rec := f.send(t, "PUT", "/users/"+f.alice.ID.Hex(), access, `{"email":"bob@example.com"}`); rec.Code != http.StatusConflict {
+		t.Fatalf("changing to a taken address: got %d, want 409", rec.Code)
+	}
+	if email := f.users.matching(bson.M{"_id": f.alice.ID})[0]["email"]; email != f.alice.Email {
+		t.Fatalf("refused change set the email to %v", email)
+	}
+
+	if rec := f.post(t, "/users", "", `{"email":"carol@example.com","password":"secret"}`); rec.Code != http.StatusOK {
+		t.Fatalf("registering a new address: got %d %q", rec.Code, rec.Body.String())
+	}
+	mail.next(t, "Confirm your DocuDefense email address")
+	if rec := f.send(t, "PUT", "/users/"+f.alice.ID.Hex(), access, `{"email":"alice@example.com"}`); rec.Code != http.StatusOK {
+		t.Fatalf("keeping one's own address: got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestForgotPasswordDoesNotRevealAccounts(t *testing.T) {
+	f := newAuthFixture(t)
+	mail := f.captureMail(t)
+
+	rec := f.post(t, "/password/forgot", "", `{"email":"nobody@example.com"}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("forgot password for unknown email: got %d", rec.Code)
+	}
+	select {
+	case msg := <-mail.sent:
+		t.Fatalf("unexpected email %q", msg.Subject)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	f := newAuthFixture(t)
+	mail := f.captureMail(t)
+	access, _ := f.login(t)
+
+	if rec := f.post(t, "/users/"+f.alice.ID.Hex()+"/email/verification", access, ""); rec.Code != http.StatusOK {
+		t.Fatalf("resend verification: got %d %q", rec.Code, rec.Body.String())
+	}
+	token := linkToken(t, mail.next(t, "Confirm your DocuDefense email address"))
+
+	if rec := f.post(t, "/password/reset", "", `{"token":"`+token+`","password":"x"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("verification token used as reset token: got %d, want 400", rec.Code)
+	}
+	if code := f.listFiles(t, token); code != http.StatusUnauthorized {
+		t.Fatalf("verification token used as access token: got %d, want 401", code)
+	}
+	if rec := f.post(t, "/email/verify", "", `{"token":"`+token+`"}`); rec.Code != http.StatusOK {
+		t.Fatalf("verify email: got %d %q", rec.Code, rec.Body.String())
+	}
+
+	alice := f.users.matching(bson.M{"_id": f.alice.ID})[0]
+	if alice["email_verified"] != true {
+		t.Fatalf("email_verified = %v after verification", alice["email_verified"])
+	}
+	if rec := f.post(t, "/users/"+f.alice.ID.Hex()+"/email/verification", access, ""); rec.Code != http.StatusConflict {
+		t.Fatalf("resend after verifying: got %d, want 409", rec.Code)
+	}
+}
@@ -0,0 +1,375 @@
+package handlers
+
+import (
+	"DocuDefense/backend/src/audit"
+	"DocuDefense/backend/src/models"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// versionUpload describes new content for a user's file in the workspace OrgID (zero for
+// the personal workspace)
+type versionUpload struct {
+	UserID       primitive.ObjectID
+	OrgID        primitive.ObjectID
+	Filename     string
+	UploadedBy   string
+	UploaderID   primitive.ObjectID // who is adding the version, checked against locks
+	ChangeNote   string
+	ContentType  string
+	RestoredFrom primitive.ObjectID
+	Flags        []string // PDF validation findings accepted by policy
+	Content      io.Reader
+	Size         int64 // -1 when unknown
+
+	// ExpectedVersion is the version the client last saw as latest, 0 for a file that must
+	// not exist yet. The upload fails with errVersionConflict if another version got there
+	// first. Nil takes whatever version is latest.
+	ExpectedVersion *int
+}
+
+// maxVersionAttempts bounds how often a version number is allocated again after losing a
+// race with a concurrent upload of the same file
+const maxVersionAttempts = 5
+
+// errVersionConflict means a newer version of the file was stored after the client read it
+var errVersionConflict = errors.New("the file has a newer version than expected")
+
+// ensureDocumentIndexes gives every version of a file a distinct number. Databases holding
+// duplicates from before the index keep working without it until -migrate renumbers them.
+func ensureDocumentIndexes(ctx context.Context) error {
+	_, err := mongoDatabase.Collection("documents").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "user_id", Value: 1},
+			{Key: "org_id", Value: 1},
+			{Key: "filename", Value: 1},
+			{Key: "version", Value: 1},
+		},
+		Options: options.Index().SetUnique(true),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		log.Println("Warning: some files have duplicate version numbers; run -migrate to renumber them")
+		return nil
+	}
+	return err
+}
+
+// latestVersion returns the number of the latest version of a file, 0 if it has none
+func latestVersion(doc *models.Document) int {
+	if doc == nil {
+		return 0
+	}
+	return doc.Version
+}
+
+// expectedVersion reads an upload's precondition on the latest version of a file: an
+// If-Match header with that version's ETag ("*" for any version), or expected, the
+// number of the latest version ("0" for a new file). It returns nil without a
+// precondition, and answers 412 when the precondition already fails.
+func expectedVersion(w http.ResponseWriter, r *http.Request, ownerID, orgID primitive.ObjectID, filename, expected string) (*int, bool) {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" && expected == "" {
+		return nil, true
+	}
+	want := -1
+	if ifMatch == "" {
+		n, err := strconv.Atoi(expected)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid expected_version", http.StatusBadRequest)
+			return nil, false
+		}
+		want = n
+	}
+
+	latest, err := findLatestDocument(r.Context(), ownerID, orgID, filename)
+	if err != nil {
+		log.Printf("Error finding latest version of %s: %v", filename, err)
+		http.Error(w, "Error finding file", http.StatusInternalServerError)
+		return nil, false
+	}
+	if ifMatch != "" && latest != nil {
+		etag := documentETag(latest)
+		for _, candidate := range strings.Split(ifMatch, ",") {
+			if candidate = strings.TrimSpace(candidate); candidate == "*" || (etag != "" && candidate == etag) {
+				want = latest.Version
+				break
+			}
+		}
+	}
+	if want != latestVersion(latest) {
+		writeVersionConflict(w, latest)
+		return nil, false
+	}
+	return &want, true
+}
+
+// writeVersionConflict refuses an upload whose precondition failed, with the ETag of the
+// latest version when there is one
+func writeVersionConflict(w http.ResponseWriter, latest *models.Document) {
+	if latest != nil {
+		if etag := documentETag(latest); etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+	}
+	writeRejection(w, http.StatusPreconditionFailed, uploadRejection{
+		Error:   "precondition_failed",
+		Message: "The file was changed by someone else since you opened it. Reload it and try again.",
+	})
+}
+
+// createDocumentVersion stores the content as a new immutable blob and records it as the
+// next version of the file, linked to the version before it. The blob is scanned for
+// malware first: infected content is quarantined and never becomes a version. A file
+// checked out to anyone but the uploader fails with a *lockedError.
+func createDocumentVersion(ctx context.Context, upload versionUpload) (*models.Document, error) {
+	if err := checkLock(ctx, upload.UserID, upload.OrgID, upload.Filename, upload.UploaderID); err != nil {
+		return nil, err
+	}
+	// Fail fast, before storing and scanning, when the client is already out of date
+	if upload.ExpectedVersion != nil {
+		lastDoc, err := findLatestDocument(ctx, upload.UserID, upload.OrgID, upload.Filename)
+		if err != nil {
+			return nil, fmt.Errorf("finding latest version: %w", err)
+		}
+		if latestVersion(lastDoc) != *upload.ExpectedVersion {
+			return nil, errVersionConflict
+		}
+	}
+
+	doc := models.Document{
+		ID:              primitive.NewObjectID(),
+		UserID:          upload.UserID,
+		OrgID:           upload.OrgID,
+		Filename:        upload.Filename,
+		UploadDate:      time.Now(),
+		UploadedBy:      upload.UploadedBy,
+		ChangeNote:      upload.ChangeNote,
+		ContentType:     upload.ContentType,
+		RestoredFromID:  upload.RestoredFrom,
+		ValidationFlags: upload.Flags,
+	}
+	doc.StorageKey = documentStorageKey(doc.UserID, doc.ID)
+
+	// Hash and count the plaintext; only the sealed form reaches storage
+	hasher := sha256.New()
+	counter := &countingReader{r: io.TeeReader(upload.Content, hasher)}
+	sealed, err := sealContent(counter, upload.Size)
+	if err != nil {
+		return nil, fmt.Errorf("encrypting file: %w", err)
+	}
+	doc.EncryptionKeyID = sealed.KeyID
+	doc.WrappedDataKey = sealed.WrappedKey
+
+	if err := fileStorage.Put(ctx, doc.StorageKey, sealed.Reader, sealed.Size); err != nil {
+		return nil, fmt.Errorf("saving file to storage: %w", err)
+	}
+	doc.Size = counter.n
+	doc.SHA256 = hex.EncodeToString(hasher.Sum(nil))
+
+	if err := scanVersion(ctx, &doc); err != nil {
+		discardBlob(doc.StorageKey)
+		return nil, err
+	}
+	if doc.ScanStatus == models.ScanInfected {
+		if err := quarantineVersion(ctx, &doc); err != nil {
+			discardBlob(doc.StorageKey)
+			return nil, fmt.Errorf("quarantining infected file: %w", err)
+		}
+		return nil, &infectedError{Signature: doc.ScanSignature}
+	}
+
+	// The file may have been checked out while the content was stored and scanned
+	if err := checkLock(ctx, doc.UserID, doc.OrgID, doc.Filename, upload.UploaderID); err != nil {
+		discardBlob(doc.StorageKey)
+		return nil, err
+	}
+
+	// Allocate the next version number. The unique version index turns a concurrent upload
+	// of the same file into a duplicate key error: the number is allocated again, unless
+	// the client asked for the version it saw to still be the latest.
+	for attempt := 1; ; attempt++ {
+		lastDoc, err := findLatestDocument(ctx, doc.UserID, doc.OrgID, doc.Filename)
+		if err != nil {
+			discardBlob(doc.StorageKey)
+			return nil, fmt.Errorf("finding latest version: %w", err)
+		}
+		if upload.ExpectedVersion != nil && latestVersion(lastDoc) != *upload.ExpectedVersion {
+			discardBlob(doc.StorageKey)
+			return nil, errVersionConflict
+		}
+
+		doc.Version = latestVersion(lastDoc) + 1
+		doc.PreviousVersionID = primitive.NilObjectID
+		if lastDoc != nil {
+			doc.PreviousVersionID = lastDoc.ID
+		}
+
+		_, err = documentsCollection.InsertOne(ctx, doc)
+		if err == nil {
+			return &doc, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) || attempt == maxVersionAttempts {
+			discardBlob(doc.StorageKey)
+			return nil, fmt.Errorf("creating document entry: %w", err)
+		}
+		log.Printf("Version %d of %s was taken by a concurrent upload; allocating again", doc.Version, doc.Filename)
+	}
+}
+
+// knownSize maps the zero size recorded for legacy documents to "unknown" for storage backends
+func knownSize(size int64) int64 {
+	if size <= 0 {
+		return -1
+	}
+	return size
+}
+
+// GetFileVersions returns the full version history of a file, oldest first
+func GetFileVersions(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	filename, err := url.QueryUnescape(params["filename"])
+	if err != nil {
+		http.Error(w, "Invalid filename", http.StatusBadRequest)
+		return
+	}
+
+	userIDObj, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		log.Printf("Invalid user ID format: %v", err)
+		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+		return
+	}
+
+	cursor, err := documentsCollection.Find(r.Context(), inTenant(bson.M{
+		"user_id":  userIDObj,
+		"filename": filename,
+	}, activeTenant(r)), options.Find().SetSort(bson.D{{Key: "version", Value: 1}}))
+	if err != nil {
+		log.Printf("Error retrieving versions of %s: %v", filename, err)
+		http.Error(w, "Error retrieving versions", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(r.Context())
+
+	versions := []models.Document{}
+	if err := cursor.All(r.Context(), &versions); err != nil {
+		log.Printf("Error decoding versions: %v", err)
+		http.Error(w, "Error decoding versions", http.StatusInternalServerError)
+		return
+	}
+	if len(versions) == 0 {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"filename": filename,
+		"versions": versions,
+	})
+}
+
+// RestoreFileVersion makes an older version current again by copying its content into a
+// new version, so the history and PreviousVersionID chain stay intact
+func RestoreFileVersion(w http.ResponseWriter, r *http.Request) {
+	source, ok := resolveFileVersion(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Note            string `json:"note"`
+		ExpectedVersion *int   `json:"expected_version"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+			http.Error(w, "Invalid restore request", http.StatusBadRequest)
+			return
+		}
+	}
+	if body.Note == "" {
+		body.Note = fmt.Sprintf("Restored from version %d", source.Version)
+	}
+
+	expected := ""
+	if body.ExpectedVersion != nil {
+		expected = strconv.Itoa(*body.ExpectedVersion)
+	}
+	expectedLatest, ok := expectedVersion(w, r, source.UserID, source.OrgID, source.Filename, expected)
+	if !ok {
+		return
+	}
+
+	// Never copy tampered content into a fresh version with a fresh hash
+	integrity, err := verifyDocument(r.Context(), source)
+	if err != nil || integrity.Status == IntegrityMismatch {
+		log.Printf("Refusing to restore version %d of %s: status %q, error %v", source.Version, source.Filename, integrity.Status, err)
+		http.Error(w, "Version failed integrity verification", http.StatusConflict)
+		return
+	}
+
+	content, err := openDocumentContent(r.Context(), source)
+	if err != nil {
+		log.Printf("Error opening version %d of %s for restore: %v", source.Version, source.Filename, err)
+		http.Error(w, "Version content not found", http.StatusNotFound)
+		return
+	}
+	defer content.Close()
+
+	uploadedBy := ""
+	if claims, ok := claimsFromContext(r); ok {
+		uploadedBy = claims.Email
+	}
+	uploaderID, _ := callerID(r)
+
+	restored, err := createDocumentVersion(r.Context(), versionUpload{
+		UserID:          source.UserID,
+		OrgID:           source.OrgID,
+		Filename:        source.Filename,
+		UploadedBy:      uploadedBy,
+		UploaderID:      uploaderID,
+		ChangeNote:      body.Note,
+		ContentType:     documentContentType(source),
+		RestoredFrom:    source.ID,
+		Flags:           source.ValidationFlags,
+		Content:         content,
+		Size:            knownSize(source.Size),
+		ExpectedVersion: expectedLatest,
+	})
+	if err != nil {
+		writeVersionError(w, r, err, source.Filename)
+		return
+	}
+
+	recordAudit(r, audit.Event{
+		Action:       audit.ActionDocumentRestore,
+		TargetUserID: restored.UserID,
+		DocumentID:   restored.ID,
+		Target:       restored.Filename,
+		Details: map[string]string{
+			"version":       strconv.Itoa(restored.Version),
+			"restored_from": strconv.Itoa(source.Version),
+			"sha256":        restored.SHA256,
+		},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(restored)
+}
@@ -0,0 +1,43 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// NewFromEnv builds the mailer selected by MAIL_DRIVER ("log" or "smtp"). The log driver is
+// used when nothing is configured so existing setups keep working.
+//
+//	smtp: SMTP_HOST, SMTP_PORT (default 587), SMTP_USERNAME, SMTP_PASSWORD, MAIL_FROM
+func NewFromEnv() (Mailer, error) {
+	switch driver := os.Getenv("MAIL_DRIVER"); driver {
+	case "", "log":
+		return LogMailer{}, nil
+	case "smtp":
+		port, err := strconv.Atoi(envOrDefault("SMTP_PORT", "587"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid SMTP_PORT: %w", err)
+		}
+		cfg := SMTPConfig{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     envOrDefault("MAIL_FROM", "DocuDefense <no-reply@docudefense.local>"),
+		}
+		if cfg.Host == "" {
+			return nil, fmt.Errorf("SMTP_HOST is required for the smtp mail driver")
+		}
+		return NewSMTPMailer(cfg), nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_DRIVER %q", driver)
+	}
+}
+
+func envOrDefault(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
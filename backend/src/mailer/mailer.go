@@ -0,0 +1,29 @@
+package mailer
+
+import (
+	"context"
+	"log"
+)
+
+// Message is a single outgoing email with a plain text body and an optional HTML alternative
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer delivers transactional email such as verification and password reset links
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// LogMailer writes messages to the server log instead of sending them. It is the default
+// when no SMTP server is configured so local development works without one.
+type LogMailer struct{}
+
+// Send logs the message, including its body, so links can be copied from the log
+func (LogMailer) Send(ctx context.Context, msg Message) error {
+	log.Printf("Email to %s: %s\n%s", msg.To, msg.Subject, msg.Text)
+	return nil
+}
@@ -0,0 +1,120 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+)
+
+// mailHog is a minimal SMTP server that accepts every message without TLS or
+// authentication, like MailHog or Mailpit in local development
+type mailHog struct {
+	listener net.Listener
+	messages chan string
+	rcpts    chan string
+}
+
+func newMailHog(t *testing.T) *mailHog {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mailHog{listener: ln, messages: make(chan string, 1), rcpts: make(chan string, 1)}
+	t.Cleanup(func() { ln.Close() })
+	go m.serve()
+	return m
+}
+
+func (m *mailHog) serve() {
+	conn, err := m.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 mailhog ready")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 mailhog")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			m.rcpts <- strings.TrimSpace(line[len("RCPT TO:"):])
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 end with .")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			m.messages <- data.String()
+			reply("250 queued")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func TestSMTPMailerDeliversTemplatedMessage(t *testing.T) {
+	server := newMailHog(t)
+	addr := server.listener.Addr().(*net.TCPAddr)
+	m := NewSMTPMailer(SMTPConfig{Host: "127.0.0.1", Port: addr.Port, From: "DocuDefense <no-reply@docudefense.local>"})
+
+	msg, err := PasswordReset.Render("alice@example.com", LinkData{
+		Name:      "<Alice>",
+		Link:      "http://localhost:3000/reset-password?token=abc",
+		ExpiresIn: "1 hour",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Send(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+
+	if rcpt := <-server.rcpts; rcpt != "<alice@example.com>" {
+		t.Fatalf("RCPT TO %q", rcpt)
+	}
+	data := <-server.messages
+	for _, want := range []string{
+		"To: <alice@example.com>",
+		"Subject: Reset your DocuDefense password",
+		"multipart/alternative",
+		"text/plain",
+		"text/html",
+		"&lt;Alice&gt;",
+	} {
+		if !strings.Contains(data, want) {
+			t.Errorf("message is missing %q:\n%s", want, data)
+		}
+	}
+}
+
+func TestSMTPMailerRejectsInvalidRecipient(t *testing.T) {
+	m := NewSMTPMailer(SMTPConfig{Host: "127.0.0.1", Port: 1, From: "no-reply@docudefense.local"})
+	err := m.Send(context.Background(), Message{To: "alice@example.com\r\nBcc: eve@example.com", Subject: "hi", Text: "hi"})
+	if err == nil {
+		t.Fatal("expected header injection in the recipient to be rejected")
+	}
+}
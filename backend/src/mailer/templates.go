@@ -0,0 +1,118 @@
+package mailer
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"text/template"
+)
+
+// Template is a named email with a subject, a plain text body and an HTML body. All three
+// are executed with the same data; the HTML body is escaped with html/template.
+type Template struct {
+	subject *template.Template
+	text    *template.Template
+	html    *htmltemplate.Template
+}
+
+// NewTemplate parses the three parts of an email template, panicking on syntax errors
+// since templates are defined at compile time
+func NewTemplate(name, subject, text, html string) *Template {
+	return &Template{
+		subject: template.Must(template.New(name + ".subject").Parse(subject)),
+		text:    template.Must(template.New(name + ".txt").Parse(text)),
+		html:    htmltemplate.Must(htmltemplate.New(name + ".html").Parse(html)),
+	}
+}
+
+// Render executes the template for one recipient
+func (t *Template) Render(to string, data interface{}) (Message, error) {
+	var subject, text, html bytes.Buffer
+	if err := t.subject.Execute(&subject, data); err != nil {
+		return Message{}, err
+	}
+	if err := t.text.Execute(&text, data); err != nil {
+		return Message{}, err
+	}
+	if err := t.html.Execute(&html, data); err != nil {
+		return Message{}, err
+	}
+	return Message{To: to, Subject: subject.String(), Text: text.String(), HTML: html.String()}, nil
+}
+
+// LinkData is the data passed to the account email templates
+type LinkData struct {
+	Name      string
+	Link      string
+	ExpiresIn string
+}
+
+// VerifyEmail asks a new user to confirm their address
+var VerifyEmail = NewTemplate("verify_email",
+	"Confirm your DocuDefense email address",
+	`Hi {{if .Name}}{{.Name}}{{else}}there{{end}},
+
+Please confirm your email address by opening the link below:
+
+{{.Link}}
+
+The link expires in {{.ExpiresIn}}. If you did not create a DocuDefense account you can ignore this email.
+`,
+	`<p>Hi {{if .Name}}{{.Name}}{{else}}there{{end}},</p>
+<p>Please confirm your email address by opening the link below:</p>
+<p><a href="{{.Link}}">Confirm email address</a></p>
+<p>The link expires in {{.ExpiresIn}}. If you did not create a DocuDefense account you can ignore this email.</p>
+`)
+
+// PasswordReset carries a link for choosing a new password
+var PasswordReset = NewTemplate("password_reset",
+	"Reset your DocuDefense password",
+	`Hi {{if .Name}}{{.Name}}{{else}}there{{end}},
+
+Someone asked to reset the password for your DocuDefense account. To choose a new password, open the link below:
+
+{{.Link}}
+
+The link expires in {{.ExpiresIn}} and can only be used once. If you did not ask for this you can ignore this email; your password has not been changed.
+`,
+	`<p>Hi {{if .Name}}{{.Name}}{{else}}there{{end}},</p>
+<p>Someone asked to reset the password for your DocuDefense account. To choose a new password, open the link below:</p>
+<p><a href="{{.Link}}">Reset password</a></p>
+<p>The link expires in {{.ExpiresIn}} and can only be used once. If you did not ask for this you can ignore this email; your password has not been changed.</p>
+`)
+
+// PasswordChanged tells the user their password was reset or changed and every session signed out
+var PasswordChanged = NewTemplate("password_changed",
+	"Your DocuDefense password was changed",
+	`Hi {{if .Name}}{{.Name}}{{else}}there{{end}},
+
+The password for your DocuDefense account was just changed and all existing sessions were signed out. If this was not you, reset your password again immediately and contact your administrator.
+`,
+	`<p>Hi {{if .Name}}{{.Name}}{{else}}there{{end}},</p>
+<p>The password for your DocuDefense account was just changed and all existing sessions were signed out. If this was not you, reset your password again immediately and contact your administrator.</p>
+`)
+
+// InvitationData is the data passed to the Invitation template
+type InvitationData struct {
+	Inviter      string
+	Organization string
+	Role         string
+	Link         string
+	ExpiresIn    string
+}
+
+// Invitation asks someone to join an organization
+var Invitation = NewTemplate("invitation",
+	"You have been invited to {{.Organization}} on DocuDefense",
+	`Hi,
+
+{{.Inviter}} has invited you to join {{.Organization}} on DocuDefense as {{.Role}}. To accept, sign in (or create an account with this email address) and open the link below:
+
+{{.Link}}
+
+The invitation expires in {{.ExpiresIn}}. If you were not expecting it you can ignore this email.
+`,
+	`<p>Hi,</p>
+<p>{{.Inviter}} has invited you to join {{.Organization}} on DocuDefense as {{.Role}}. To accept, sign in (or create an account with this email address) and open the link below:</p>
+<p><a href="{{.Link}}">Accept invitation</a></p>
+<p>The invitation expires in {{.ExpiresIn}}. If you were not expecting it you can ignore this email.</p>
+`)
@@ -6,11 +6,56 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Document is one version of a file. UserID is the account that owns the file; OrgID is the
+// organization it belongs to, or zero for files in the owner's personal workspace.
 type Document struct {
 	ID                primitive.ObjectID `json:"id" bson:"_id,omitempty"`
 	UserID            primitive.ObjectID `json:"user_id" bson:"user_id"`
+	OrgID             primitive.ObjectID `json:"org_id,omitempty" bson:"org_id,omitempty"`
 	Filename          string             `json:"filename" bson:"filename"`
 	Version           int                `json:"version" bson:"version"`
 	PreviousVersionID primitive.ObjectID `json:"previous_version_id,omitempty" bson:"previous_version_id,omitempty"`
 	UploadDate        time.Time          `json:"upload_date" bson:"upload_date"`
+	StorageKey        string             `json:"-" bson:"storage_key,omitempty"`
+	Size              int64              `json:"size" bson:"size"`
+	ContentType       string             `json:"content_type,omitempty" bson:"content_type,omitempty"`
+	SHA256            string             `json:"sha256,omitempty" bson:"sha256,omitempty"`
+	UploadedBy        string             `json:"uploaded_by,omitempty" bson:"uploaded_by,omitempty"`
+	ChangeNote        string             `json:"change_note,omitempty" bson:"change_note,omitempty"`
+	RestoredFromID    primitive.ObjectID `json:"restored_from_id,omitempty" bson:"restored_from_id,omitempty"`
+	ValidationFlags   []string           `json:"validation_flags,omitempty" bson:"validation_flags,omitempty"`
+	EncryptionKeyID   string             `json:"-" bson:"encryption_key_id,omitempty"`
+	WrappedDataKey    []byte             `json:"-" bson:"wrapped_data_key,omitempty"`
+
+	// Malware scan verdict. Versions stored before scanning was introduced have no status.
+	ScanStatus    string     `json:"scan_status,omitempty" bson:"scan_status,omitempty"`
+	ScanSignature string     `json:"scan_signature,omitempty" bson:"scan_signature,omitempty"`
+	ScannedBy     string     `json:"scanned_by,omitempty" bson:"scanned_by,omitempty"`
+	ScannedAt     *time.Time `json:"scanned_at,omitempty" bson:"scanned_at,omitempty"`
+}
+
+// Malware scan statuses. Only clean versions, and legacy versions that were never
+// scanned, can be downloaded.
+const (
+	ScanClean    = "clean"
+	ScanInfected = "infected"
+)
+
+// BlobKey returns the storage key holding this version's content.
+// Documents created before per-version keys were introduced fall back to the flat filename.
+func (d *Document) BlobKey() string {
+	if d.StorageKey != "" {
+		return d.StorageKey
+	}
+	return d.Filename
+}
+
+// ScanPassed reports whether the version may be served: it was scanned clean, or predates scanning
+func (d *Document) ScanPassed() bool {
+	return d.ScanStatus == "" || d.ScanStatus == ScanClean
+}
+
+// Encrypted reports whether this version's blob is stored encrypted with a wrapped data key
+func (d *Document) Encrypted() bool {
+	return len(d.WrappedDataKey) > 0
 }
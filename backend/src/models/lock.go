@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DocumentLock checks a file out to one user, who alone may add versions to it until they
+// check it back in, the lock expires or an administrator breaks it
+type DocumentLock struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	OwnerID     primitive.ObjectID `json:"owner_id" bson:"owner_id"`
+	OrgID       primitive.ObjectID `json:"org_id,omitempty" bson:"org_id,omitempty"`
+	Filename    string             `json:"filename" bson:"filename"`
+	HolderID    primitive.ObjectID `json:"holder_id" bson:"holder_id"`
+	HolderEmail string             `json:"holder_email" bson:"holder_email"`
+	Reason      string             `json:"reason,omitempty" bson:"reason,omitempty"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	ExpiresAt   time.Time          `json:"expires_at" bson:"expires_at"`
+}
+
+// HeldBy reports whether userID holds the lock
+func (l *DocumentLock) HeldBy(userID primitive.ObjectID) bool {
+	return l.HolderID == userID
+}
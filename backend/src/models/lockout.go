@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// LoginAttempt tracks recent failed logins for one account (keyed "account:<email>") or
+// one client IP (keyed "ip:<address>")
+type LoginAttempt struct {
+	ID            string     `bson:"_id"`
+	Failures      int        `bson:"failures"`
+	LastFailure   time.Time  `bson:"last_failure"`
+	NextAttemptAt time.Time  `bson:"next_attempt_at"`
+	LockedUntil   *time.Time `bson:"locked_until,omitempty"`
+	ExpiresAt     time.Time  `bson:"expires_at"`
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Roles a user can hold within an organization. Owners and admins manage members and
+// invitations; only owners can appoint or remove other owners.
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+// ValidOrgRole reports whether role is one of the organization roles
+func ValidOrgRole(role string) bool {
+	return role == OrgRoleOwner || role == OrgRoleAdmin || role == OrgRoleMember
+}
+
+// Organization is a tenant: a workspace whose documents are only visible to its members
+type Organization struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	Name      string             `json:"name" bson:"name"`
+	CreatedBy primitive.ObjectID `json:"created_by" bson:"created_by"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// Membership places a user in an organization with one organization role
+type Membership struct {
+	ID       primitive.ObjectID `json:"id" bson:"_id"`
+	OrgID    primitive.ObjectID `json:"org_id" bson:"org_id"`
+	UserID   primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Role     string             `json:"role" bson:"role"`
+	JoinedAt time.Time          `json:"joined_at" bson:"joined_at"`
+}
+
+// CanManage reports whether the member may invite, remove and change the roles of others
+func (m *Membership) CanManage() bool {
+	return m.Role == OrgRoleOwner || m.Role == OrgRoleAdmin
+}
+
+// Invitation is an emailed offer to join an organization. Only the SHA-256 of the token
+// in the link is stored; the invitation is accepted by the account with the invited email.
+type Invitation struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id"`
+	OrgID      primitive.ObjectID `json:"org_id" bson:"org_id"`
+	Email      string             `json:"email" bson:"email"`
+	Role       string             `json:"role" bson:"role"`
+	TokenHash  string             `json:"-" bson:"token_hash"`
+	InvitedBy  primitive.ObjectID `json:"invited_by" bson:"invited_by"`
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+	ExpiresAt  time.Time          `json:"expires_at" bson:"expires_at"`
+	AcceptedAt *time.Time         `json:"accepted_at,omitempty" bson:"accepted_at,omitempty"`
+}
@@ -0,0 +1,67 @@
+package models
+
+// Roles that can be granted to a user. Every account implicitly has RoleUser.
+const (
+	RoleUser    = "user"
+	RoleAuditor = "auditor"
+	RoleAdmin   = "admin"
+)
+
+// Permissions checked by RequirePermission
+const (
+	PermUsersRead       = "users:read"
+	PermUsersManage     = "users:manage"
+	PermDocumentsRead   = "documents:read_all"
+	PermDocumentsManage = "documents:manage"
+	PermAuditRead       = "audit:read_all"
+)
+
+// rolePermissions lists what each role may do beyond managing its own account and files.
+// Auditors can see everything for compliance reviews but change nothing.
+var rolePermissions = map[string][]string{
+	RoleUser:    {},
+	RoleAuditor: {PermUsersRead, PermDocumentsRead, PermAuditRead},
+	RoleAdmin:   {PermUsersRead, PermUsersManage, PermDocumentsRead, PermDocumentsManage, PermAuditRead},
+}
+
+// ValidRole reports whether role is one of the known roles
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// EffectiveRoles returns the user's roles, always including RoleUser
+func (u *User) EffectiveRoles() []string {
+	roles := []string{RoleUser}
+	for _, role := range u.Roles {
+		if role != RoleUser {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// Permissions returns the union of the permissions granted by the user's roles
+func (u *User) Permissions() []string {
+	seen := map[string]bool{}
+	permissions := []string{}
+	for _, role := range u.EffectiveRoles() {
+		for _, permission := range rolePermissions[role] {
+			if !seen[permission] {
+				seen[permission] = true
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+	return permissions
+}
+
+// HasRole reports whether the user has been granted role
+func (u *User) HasRole(role string) bool {
+	for _, r := range u.EffectiveRoles() {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
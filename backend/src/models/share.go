@@ -0,0 +1,95 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Permission levels a share can grant, from least to most. Viewers can read and download;
+// commenters can do the same and are reserved for annotations; editors can also upload new
+// versions. Deleting a file always stays with its owner.
+const (
+	ShareViewer    = "viewer"
+	ShareCommenter = "commenter"
+	ShareEditor    = "editor"
+)
+
+// Kinds of grantee a share can name
+const (
+	GranteeUser         = "user"
+	GranteeOrganization = "org"
+)
+
+// ShareLevel orders permissions so they can be compared; unknown permissions are 0
+func ShareLevel(permission string) int {
+	switch permission {
+	case ShareViewer:
+		return 1
+	case ShareCommenter:
+		return 2
+	case ShareEditor:
+		return 3
+	}
+	return 0
+}
+
+// Share grants a user, or every member of an organization, access to another user's file.
+// A zero Version covers every version of the file, including later ones; otherwise only
+// that version is shared. The file is identified the same way as its versions: owner,
+// workspace and filename.
+type Share struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	OwnerID     primitive.ObjectID `json:"owner_id" bson:"owner_id"`
+	OrgID       primitive.ObjectID `json:"org_id,omitempty" bson:"org_id,omitempty"`
+	Filename    string             `json:"filename" bson:"filename"`
+	Version     int                `json:"version,omitempty" bson:"version,omitempty"`
+	GranteeType string             `json:"grantee_type" bson:"grantee_type"`
+	GranteeID   primitive.ObjectID `json:"grantee_id" bson:"grantee_id"`
+	Permission  string             `json:"permission" bson:"permission"`
+	GrantedBy   primitive.ObjectID `json:"granted_by" bson:"granted_by"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// ShareLink lets anyone holding its signed token read one document version without an
+// account, until it expires, runs out of downloads or is revoked. Only a hash of the
+// optional password is stored.
+type ShareLink struct {
+	ID           primitive.ObjectID `json:"id" bson:"_id"`
+	DocumentID   primitive.ObjectID `json:"document_id" bson:"document_id"`
+	OwnerID      primitive.ObjectID `json:"owner_id" bson:"owner_id"`
+	OrgID        primitive.ObjectID `json:"org_id,omitempty" bson:"org_id,omitempty"`
+	Filename     string             `json:"filename" bson:"filename"`
+	Version      int                `json:"version" bson:"version"`
+	PasswordHash string             `json:"-" bson:"password_hash,omitempty"`
+	MaxDownloads int                `json:"max_downloads,omitempty" bson:"max_downloads,omitempty"`
+	Downloads    int                `json:"downloads" bson:"downloads"`
+	// LastDownloadAt is when the latest counted download started, so a range resuming it
+	// shortly afterwards is not counted again
+	LastDownloadAt *time.Time         `json:"last_download_at,omitempty" bson:"last_download_at,omitempty"`
+	CreatedBy      primitive.ObjectID `json:"created_by" bson:"created_by"`
+	CreatedAt      time.Time          `json:"created_at" bson:"created_at"`
+	ExpiresAt      time.Time          `json:"expires_at" bson:"expires_at"`
+	RevokedAt      *time.Time         `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+}
+
+// PasswordProtected reports whether the link asks for a password
+func (l *ShareLink) PasswordProtected() bool {
+	return l.PasswordHash != ""
+}
+
+// HashPassword protects the link with password, hashed like account passwords
+func (l *ShareLink) HashPassword(password string) error {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+	if err != nil {
+		return err
+	}
+	l.PasswordHash = string(bytes)
+	return nil
+}
+
+// CheckPassword compares the link's password hash with the provided password
+func (l *ShareLink) CheckPassword(password string) error {
+	return bcrypt.CompareHashAndPassword([]byte(l.PasswordHash), []byte(password))
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RefreshToken is a server-side record of an issued refresh token. Only the SHA-256 of the
+// token is stored. Every refresh rotates the token; all tokens descending from one login
+// share a FamilyID so the whole chain can be revoked if a rotated token is replayed.
+type RefreshToken struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id"`
+	TokenHash  string             `json:"-" bson:"token_hash"`
+	UserID     primitive.ObjectID `json:"user_id" bson:"user_id"`
+	FamilyID   primitive.ObjectID `json:"family_id" bson:"family_id"`
+	IssuedAt   time.Time          `json:"issued_at" bson:"issued_at"`
+	ExpiresAt  time.Time          `json:"expires_at" bson:"expires_at"`
+	RotatedAt  *time.Time         `json:"rotated_at,omitempty" bson:"rotated_at,omitempty"`
+	RevokedAt  *time.Time         `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+	ReplacedBy primitive.ObjectID `json:"replaced_by,omitempty" bson:"replaced_by,omitempty"`
+
+	// When the login that started this family completed two-factor verification
+	MFAAt *time.Time `json:"mfa_at,omitempty" bson:"mfa_at,omitempty"`
+}
+
+// RevokedToken records an access token (by its jti) that was revoked before it expired
+type RevokedToken struct {
+	ID        string             `bson:"_id"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+}
+
+// OIDCLoginState is the server-side half of an in-progress single sign-on login, keyed by
+// the OAuth state parameter and consumed by the callback
+type OIDCLoginState struct {
+	State        string    `bson:"_id"`
+	CodeVerifier string    `bson:"code_verifier"`
+	Nonce        string    `bson:"nonce"`
+	ExpiresAt    time.Time `bson:"expires_at"`
+}
+
+// AccountToken records an emailed email verification or password reset token, keyed by the
+// token's jti. The token itself is a signed JWT; this record makes it single use.
+type AccountToken struct {
+	ID        string             `bson:"_id"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	Purpose   string             `bson:"purpose"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+	UsedAt    *time.Time         `bson:"used_at,omitempty"`
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Upload is a resumable (tus) upload of a new version of a user's file. Each chunk is
+// stored as its own part until Offset reaches Length, when the parts become the version.
+type Upload struct {
+	ID              primitive.ObjectID `json:"id" bson:"_id"`
+	UserID          primitive.ObjectID `json:"user_id" bson:"user_id"`
+	OrgID           primitive.ObjectID `json:"org_id,omitempty" bson:"org_id,omitempty"`
+	Filename        string             `json:"filename" bson:"filename"`
+	ChangeNote      string             `json:"change_note,omitempty" bson:"change_note,omitempty"`
+	UploadedBy      string             `json:"uploaded_by" bson:"uploaded_by"`
+	Length          int64              `json:"length" bson:"length"`
+	Offset          int64              `json:"offset" bson:"offset"`
+	ExpectedVersion *int               `json:"expected_version,omitempty" bson:"expected_version,omitempty"`
+	Parts           []UploadPart       `json:"-" bson:"parts"`
+	DocumentID      primitive.ObjectID `json:"document_id,omitempty" bson:"document_id,omitempty"`
+	CreatedAt       time.Time          `json:"created_at" bson:"created_at"`
+	ExpiresAt       time.Time          `json:"expires_at" bson:"expires_at"`
+}
+
+// UploadPart is one stored chunk of an upload, sealed like a document version
+type UploadPart struct {
+	StorageKey      string `bson:"storage_key"`
+	Offset          int64  `bson:"offset"`
+	Size            int64  `bson:"size"`
+	EncryptionKeyID string `bson:"encryption_key_id,omitempty"`
+	WrappedDataKey  []byte `bson:"wrapped_data_key,omitempty"`
+}
+
+// Completed reports whether the upload has become a document version
+func (u *Upload) Completed() bool {
+	return !u.DocumentID.IsZero()
+}
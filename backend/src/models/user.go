@@ -12,6 +12,33 @@ type User struct {
 	Email     string             `json:"email" bson:"email"`
 	Birthdate string             `json:"birthdate" bson:"birthdate"`
 	Password  string             `json:"password" bson:"password"`
+
+	// Set once the user opens the link from the verification email (or the identity
+	// provider vouches for the address)
+	EmailVerified bool `json:"email_verified" bson:"email_verified"`
+
+	// Roles granted by an administrator (see role.go) and whether an administrator has
+	// disabled the account. Neither can be set through the public user endpoints.
+	Roles    []string `json:"roles,omitempty" bson:"roles,omitempty"`
+	Disabled bool     `json:"disabled,omitempty" bson:"disabled,omitempty"`
+
+	// Identity provider account linked through OpenID Connect single sign-on
+	ExternalIssuer  string `json:"-" bson:"external_issuer,omitempty"`
+	ExternalSubject string `json:"-" bson:"external_subject,omitempty"`
+
+	// TOTP two-factor authentication. The pending secret is replaced by TOTPSecret once the
+	// user proves their authenticator works; recovery codes are stored as SHA-256 hashes.
+	MFAEnabled        bool     `json:"-" bson:"mfa_enabled,omitempty"`
+	TOTPSecret        string   `json:"-" bson:"totp_secret,omitempty"`
+	PendingTOTPSecret string   `json:"-" bson:"pending_totp_secret,omitempty"`
+	TOTPLastStep      int64    `json:"-" bson:"totp_last_step,omitempty"`
+	RecoveryCodes     []string `json:"-" bson:"recovery_codes,omitempty"`
+
+	// Incremented to invalidate every access and refresh token issued to the user
+	TokenVersion int `json:"-" bson:"token_version"`
+
+	// Incremented by every profile update, for optimistic concurrency on updates
+	Revision int `json:"revision" bson:"revision,omitempty"`
 }
 
 // HashPassword hashes the user's password using bcrypt
@@ -0,0 +1,336 @@
+// Package pdfcheck inspects uploaded files to confirm they are well-formed PDF documents and
+// to detect features that are risky in documents shared with clients, such as embedded
+// JavaScript. It checks structure rather than rendering, and never executes anything.
+package pdfcheck
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+)
+
+// Codes of the problems Inspect reports
+const (
+	Malformed    = "malformed"
+	Encrypted    = "encrypted"
+	JavaScript   = "javascript"
+	LaunchAction = "launch_action"
+	EmbeddedFile = "embedded_file"
+)
+
+// ErrNotPDF is returned for content that does not start like a PDF document
+var ErrNotPDF = errors.New("not a PDF document")
+
+// Limits that keep a hostile file from exhausting memory while its streams are inflated
+const (
+	headerWindow      = 1024
+	trailerWindow     = 2048
+	maxInflatedStream = 16 << 20
+	maxInflatedTotal  = 64 << 20
+)
+
+// Finding is one problem found in a document
+type Finding struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Report is the result of inspecting a PDF. Findings lists each kind of problem once.
+type Report struct {
+	Version  string
+	Findings []Finding
+}
+
+// Has reports whether the report contains a finding with code
+func (r *Report) Has(code string) bool {
+	for _, f := range r.Findings {
+		if f.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Report) add(code, message string) {
+	if !r.Has(code) {
+		r.Findings = append(r.Findings, Finding{Code: code, Message: message})
+	}
+}
+
+var (
+	headerPattern    = regexp.MustCompile(`%PDF-(\d\.\d)`)
+	startxrefPattern = regexp.MustCompile(`startxref\s+(\d+)`)
+	objectPattern    = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+	streamPattern    = regexp.MustCompile(`stream\r?\n`)
+)
+
+// riskyNames maps PDF names to the finding they indicate
+var riskyNames = map[string]Finding{
+	"JavaScript":    {JavaScript, "The document contains JavaScript"},
+	"JS":            {JavaScript, "The document contains JavaScript"},
+	"Launch":        {LaunchAction, "The document can launch external programs or files"},
+	"EmbeddedFile":  {EmbeddedFile, "The document carries embedded files"},
+	"EmbeddedFiles": {EmbeddedFile, "The document carries embedded files"},
+}
+
+// Inspect checks data for the PDF header, end-of-file marker, cross-reference table and
+// document catalog, then looks for encryption and risky features. Compressed streams,
+// including object streams, are inflated so features cannot hide inside them. It returns
+// ErrNotPDF when data is not a PDF at all; every other problem is reported as a finding.
+func Inspect(data []byte) (*Report, error) {
+	head := data
+	if len(head) > headerWindow {
+		head = head[:headerWindow]
+	}
+	header := headerPattern.FindSubmatch(head)
+	if header == nil {
+		return nil, ErrNotPDF
+	}
+	report := &Report{Version: string(header[1])}
+
+	checkTrailer(data, report)
+	objects := objectPattern.FindAllIndex(data, -1)
+	if len(objects) == 0 {
+		report.add(Malformed, "The document contains no objects")
+	}
+	for _, obj := range objects {
+		if !bytes.Contains(data[obj[1]:], []byte("endobj")) {
+			report.add(Malformed, "An object is not terminated")
+			break
+		}
+	}
+
+	encrypted := hasName(data, "Encrypt")
+	if encrypted {
+		report.add(Encrypted, "The document is encrypted and its content cannot be checked")
+	}
+	scanNames(data, report)
+
+	// Encrypted streams cannot be inflated without the password, so only their
+	// dictionaries, which are in the clear, have been checked above
+	if !encrypted {
+		inspectStreams(data, objects, report)
+	}
+	return report, nil
+}
+
+// checkTrailer looks for the end-of-file marker and follows startxref to the cross-reference
+// table or stream, which must exist along with the document catalog (/Root)
+func checkTrailer(data []byte, report *Report) {
+	tail := data
+	if len(tail) > trailerWindow {
+		tail = tail[len(tail)-trailerWindow:]
+	}
+	if !bytes.Contains(tail, []byte("%%EOF")) {
+		report.add(Malformed, "The document has no end-of-file marker")
+	}
+
+	matches := startxrefPattern.FindAllSubmatch(tail, -1)
+	if len(matches) == 0 {
+		report.add(Malformed, "The document has no cross-reference table")
+		return
+	}
+	offset, err := strconv.Atoi(string(matches[len(matches)-1][1]))
+	if err != nil || offset >= len(data) {
+		report.add(Malformed, "The cross-reference offset points outside the document")
+		return
+	}
+	at := bytes.TrimLeft(data[offset:], " \t\r\n\f\x00")
+	if !bytes.HasPrefix(at, []byte("xref")) && objectPattern.FindIndex(at[:min(len(at), 32)]) == nil {
+		report.add(Malformed, "The cross-reference offset does not point to a cross-reference table")
+	}
+	if !hasName(data, "Root") {
+		report.add(Malformed, "The document has no catalog")
+	}
+}
+
+// inspectStreams inflates Flate-compressed streams and scans their content for risky names
+func inspectStreams(data []byte, objects [][]int, report *Report) {
+	budget := int64(maxInflatedTotal)
+	for _, loc := range streamPattern.FindAllIndex(data, -1) {
+		// Skip the "stream" inside "endstream"
+		if loc[0] >= 3 && string(data[loc[0]-3:loc[0]]) == "end" {
+			continue
+		}
+		start := loc[1]
+		end := bytes.Index(data[start:], []byte("endstream"))
+		if end < 0 {
+			report.add(Malformed, "A stream is not terminated")
+			return
+		}
+		if !bytes.Contains(streamDictionary(data, objects, loc[0]), []byte("/FlateDecode")) {
+			continue
+		}
+		if budget <= 0 {
+			report.add(Malformed, "The document's compressed content is too large to check")
+			return
+		}
+
+		zr, err := zlib.NewReader(bytes.NewReader(data[start : start+end]))
+		if err != nil {
+			report.add(Malformed, "A compressed stream is corrupt")
+			continue
+		}
+		// The inflated content is scanned as it is produced rather than held in memory
+		limit := min64(budget, maxInflatedStream)
+		names := newNameScanner(func(name string) { reportRiskyName(name, report) })
+		inflated, err := io.Copy(names, io.LimitReader(zr, limit+1))
+		names.Close()
+		zr.Close()
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			report.add(Malformed, "A compressed stream is corrupt")
+		}
+		if inflated > limit {
+			report.add(Malformed, "The document's compressed content is too large to check")
+			return
+		}
+		budget -= inflated
+	}
+}
+
+// streamDictionary returns the bytes between the start of the object holding a stream and
+// the stream keyword, which contain the stream's dictionary
+func streamDictionary(data []byte, objects [][]int, streamAt int) []byte {
+	from := 0
+	for _, obj := range objects {
+		if obj[0] > streamAt {
+			break
+		}
+		from = obj[1]
+	}
+	return data[from:streamAt]
+}
+
+// scanNames reports every risky name in data
+func scanNames(data []byte, report *Report) {
+	forEachName(data, func(name string) { reportRiskyName(name, report) })
+}
+
+func reportRiskyName(name string, report *Report) {
+	if f, ok := riskyNames[name]; ok {
+		report.add(f.Code, f.Message)
+	}
+}
+
+func hasName(data []byte, want string) bool {
+	found := false
+	forEachName(data, func(name string) {
+		if name == want {
+			found = true
+		}
+	})
+	return found
+}
+
+// forEachName calls fn with every name object (/Name) in data, with #xx escapes decoded so
+// that /J#61vaScript is seen as /JavaScript
+func forEachName(data []byte, fn func(string)) {
+	names := newNameScanner(fn)
+	names.Write(data)
+	names.Close()
+}
+
+// nameScanner finds name objects in content written to it in pieces, so a name split
+// between two writes is still seen whole
+type nameScanner struct {
+	fn      func(string)
+	inName  bool
+	name    []byte
+	escape  []byte // the characters after a '#' in a name, until there are two
+	escaped bool
+}
+
+func newNameScanner(fn func(string)) *nameScanner {
+	return &nameScanner{fn: fn}
+}
+
+func (s *nameScanner) Write(p []byte) (int, error) {
+	for _, c := range p {
+		s.feed(c)
+	}
+	return len(p), nil
+}
+
+func (s *nameScanner) feed(c byte) {
+	if !s.inName {
+		if c == '/' {
+			s.inName, s.name = true, s.name[:0]
+		}
+		return
+	}
+	if s.escaped {
+		s.escape = append(s.escape, c)
+		if len(s.escape) < 2 {
+			return
+		}
+		pending := s.escape
+		s.escaped, s.escape = false, nil
+		if v, err := strconv.ParseUint(string(pending), 16, 8); err == nil {
+			s.name = append(s.name, byte(v))
+			return
+		}
+		// Not an escape: the '#' is part of the name and what followed is read as usual
+		s.name = append(s.name, '#')
+		for _, p := range pending {
+			s.feed(p)
+		}
+		return
+	}
+	if isDelimiter(c) {
+		s.end()
+		if c == '/' {
+			s.inName = true
+		}
+		return
+	}
+	if c == '#' {
+		s.escaped = true
+		return
+	}
+	s.name = append(s.name, c)
+}
+
+// end reports the name being read, if any
+func (s *nameScanner) end() {
+	if len(s.name) > 0 {
+		s.fn(string(s.name))
+	}
+	s.inName, s.name = false, s.name[:0]
+}
+
+// Close reports a name left unfinished at the end of the content
+func (s *nameScanner) Close() error {
+	if s.escaped {
+		s.name = append(append(s.name, '#'), s.escape...)
+		s.escaped, s.escape = false, nil
+	}
+	if s.inName {
+		s.end()
+	}
+	return nil
+}
+
+func isDelimiter(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n', '\f', '\x00', '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
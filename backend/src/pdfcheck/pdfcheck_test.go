@@ -0,0 +1,131 @@
+package pdfcheck
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// buildPDF assembles a PDF with a correct cross-reference table from object bodies
+func buildPDF(trailerExtra string, objects ...string) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.7\n")
+	offsets := make([]int, len(objects))
+	for i, body := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, body)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R %s>>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, trailerExtra, xref)
+	return b.Bytes()
+}
+
+var catalog = []string{
+	"<< /Type /Catalog /Pages 2 0 R >>",
+	"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+	"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] >>",
+}
+
+func codes(r *Report) []string {
+	var out []string
+	for _, f := range r.Findings {
+		out = append(out, f.Code)
+	}
+	return out
+}
+
+func TestInspectAcceptsWellFormedPDF(t *testing.T) {
+	report, err := Inspect(buildPDF("", catalog...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Version != "1.7" || len(report.Findings) != 0 {
+		t.Fatalf("got version %q, findings %v", report.Version, codes(report))
+	}
+}
+
+func TestInspectRejectsNonPDF(t *testing.T) {
+	if _, err := Inspect([]byte("MZ\x90\x00 this is an executable")); !errors.Is(err, ErrNotPDF) {
+		t.Fatalf("got %v, want ErrNotPDF", err)
+	}
+}
+
+func TestInspectReportsMalformedStructure(t *testing.T) {
+	valid := buildPDF("", catalog...)
+	cases := map[string][]byte{
+		"truncated":  valid[:len(valid)/2],
+		"no objects": []byte("%PDF-1.4\nstartxref\n0\n%%EOF\n"),
+		"bad offset": bytes.Replace(valid, []byte("startxref\n"), []byte("startxref\n9"), 1),
+	}
+	for name, data := range cases {
+		report, err := Inspect(data)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !report.Has(Malformed) {
+			t.Errorf("%s: got findings %v, want malformed", name, codes(report))
+		}
+	}
+}
+
+func TestInspectDetectsEncryption(t *testing.T) {
+	objects := append(append([]string{}, catalog...), "<< /Filter /Standard /V 2 /R 3 /O (x) /U (y) /P -4 >>")
+	report, err := Inspect(buildPDF("/Encrypt 4 0 R ", objects...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Has(Encrypted) {
+		t.Fatalf("got findings %v, want encrypted", codes(report))
+	}
+}
+
+func TestInspectDetectsRiskyFeatures(t *testing.T) {
+	cases := map[string]struct {
+		object string
+		want   string
+	}{
+		"javascript":          {"<< /S /JavaScript /JS (app.alert(1)) >>", JavaScript},
+		"obfuscated name":     {"<< /S /J#61vaScript /JS (app.alert(1)) >>", JavaScript},
+		"launch action":       {"<< /S /Launch /F (calc.exe) >>", LaunchAction},
+		"embedded file":       {"<< /Type /EmbeddedFile /Length 0 >>", EmbeddedFile},
+		"in a compressed one": {compressedObjectStream("<< /S /JavaScript /JS (x) >>"), JavaScript},
+	}
+	for name, c := range cases {
+		report, err := Inspect(buildPDF("", append(append([]string{}, catalog...), c.object)...))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !report.Has(c.want) || report.Has(Malformed) {
+			t.Errorf("%s: got findings %v, want only %s", name, codes(report), c.want)
+		}
+	}
+}
+
+// compressedObjectStream hides content in a Flate-compressed object stream
+func compressedObjectStream(content string) string {
+	var z bytes.Buffer
+	w := zlib.NewWriter(&z)
+	w.Write([]byte(content))
+	w.Close()
+	return fmt.Sprintf("<< /Type /ObjStm /N 1 /First 0 /Filter /FlateDecode /Length %d >>\nstream\n%s\nendstream", z.Len(), z.Bytes())
+}
+
+func TestNameScannerJoinsNamesSplitBetweenWrites(t *testing.T) {
+	var names []string
+	scanner := newNameScanner(func(name string) { names = append(names, name) })
+	for _, piece := range []string{"<< /J", "#6", "1vaScript (x) /Laun", "ch /A#zz", "/Emb#"} {
+		scanner.Write([]byte(piece))
+	}
+	scanner.Close()
+
+	want := []string{"JavaScript", "Launch", "A#zz", "Emb#"}
+	if fmt.Sprint(names) != fmt.Sprint(want) {
+		t.Fatalf("got %q, want %q", names, want)
+	}
+}
@@ -0,0 +1,134 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// clamdChunkSize is the size of the chunks streamed to clamd, well below its default
+// StreamMaxLength so a single chunk is never refused
+const clamdChunkSize = 64 << 10
+
+// ClamdScanner sends files to a ClamAV daemon using the INSTREAM command of the clamd
+// protocol. Network is "tcp" or "unix".
+type ClamdScanner struct {
+	Network string
+	Address string
+	Timeout time.Duration
+}
+
+// NewClamdScanner returns a scanner for the clamd listening at network and address
+func NewClamdScanner(network, address string, timeout time.Duration) *ClamdScanner {
+	return &ClamdScanner{Network: network, Address: address, Timeout: timeout}
+}
+
+// Name returns "clamd"
+func (c *ClamdScanner) Name() string {
+	return "clamd"
+}
+
+func (c *ClamdScanner) dial(ctx context.Context) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: c.Timeout}
+	conn, err := dialer.DialContext(ctx, c.Network, c.Address)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to clamd: %w", err)
+	}
+	if c.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(c.Timeout))
+	}
+	return conn, nil
+}
+
+// Ping checks that clamd is reachable and answering
+func (c *ClamdScanner) Ping(ctx context.Context) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("zPING\x00")); err != nil {
+		return fmt.Errorf("sending PING to clamd: %w", err)
+	}
+	reply, err := readReply(conn)
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("unexpected clamd reply to PING: %q", reply)
+	}
+	return nil
+}
+
+// Scan streams r to clamd as length-prefixed chunks and parses the verdict
+func (c *ClamdScanner) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return Result{}, err
+	}
+	defer conn.Close()
+
+	w := bufio.NewWriterSize(conn, clamdChunkSize+4)
+	if _, err := w.WriteString("zINSTREAM\x00"); err != nil {
+		return Result{}, fmt.Errorf("sending INSTREAM to clamd: %w", err)
+	}
+	buf := make([]byte, clamdChunkSize)
+	var size [4]byte
+	for {
+		n, readErr := io.ReadFull(r, buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size[:], uint32(n))
+			w.Write(size[:])
+			if _, err := w.Write(buf[:n]); err != nil {
+				return Result{}, fmt.Errorf("streaming to clamd: %w", err)
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return Result{}, fmt.Errorf("reading file to scan: %w", readErr)
+		}
+	}
+	// A zero-length chunk ends the stream
+	binary.BigEndian.PutUint32(size[:], 0)
+	w.Write(size[:])
+	if err := w.Flush(); err != nil {
+		return Result{}, fmt.Errorf("streaming to clamd: %w", err)
+	}
+
+	reply, err := readReply(conn)
+	if err != nil {
+		return Result{}, err
+	}
+	return parseReply(reply)
+}
+
+// readReply reads one NUL-terminated reply, as requested by the "z" command prefix
+func readReply(conn net.Conn) (string, error) {
+	reply, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil && !(err == io.EOF && len(reply) > 0) {
+		return "", fmt.Errorf("reading clamd reply: %w", err)
+	}
+	return string(bytes.TrimRight(reply, "\x00\n")), nil
+}
+
+// parseReply interprets "stream: OK", "stream: <signature> FOUND" and error replies such
+// as "INSTREAM size limit exceeded. ERROR"
+func parseReply(reply string) (Result, error) {
+	verdict := strings.TrimSpace(strings.TrimPrefix(reply, "stream:"))
+	switch {
+	case verdict == "OK":
+		return Result{}, nil
+	case strings.HasSuffix(verdict, " FOUND"):
+		return Result{Infected: true, Signature: strings.TrimSuffix(verdict, " FOUND")}, nil
+	default:
+		return Result{}, fmt.Errorf("clamd could not scan the file: %s", reply)
+	}
+}
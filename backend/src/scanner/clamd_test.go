@@ -0,0 +1,110 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// eicar is the standard antivirus test file, which every scanner reports as infected
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// fakeClamd serves the clamd protocol on a unix socket, reporting the EICAR string as
+// infected. It records the size of every stream it receives.
+func fakeClamd(t *testing.T) (*ClamdScanner, *[]int) {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "clamd.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	var sizes []int
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			serveClamd(conn, &sizes)
+		}
+	}()
+	return NewClamdScanner("unix", socket, 5*time.Second), &sizes
+}
+
+func serveClamd(conn net.Conn, sizes *[]int) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	command, err := r.ReadString(0)
+	if err != nil {
+		return
+	}
+	switch command {
+	case "zPING\x00":
+		conn.Write([]byte("PONG\x00"))
+	case "zINSTREAM\x00":
+		var content bytes.Buffer
+		for {
+			var size uint32
+			if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+				return
+			}
+			if size == 0 {
+				break
+			}
+			if _, err := io.CopyN(&content, r, int64(size)); err != nil {
+				return
+			}
+		}
+		*sizes = append(*sizes, content.Len())
+		if strings.Contains(content.String(), eicar) {
+			conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+		} else {
+			conn.Write([]byte("stream: OK\x00"))
+		}
+	default:
+		conn.Write([]byte("UNKNOWN COMMAND\x00"))
+	}
+}
+
+func TestClamdScannerReportsVerdicts(t *testing.T) {
+	clamd, sizes := fakeClamd(t)
+	ctx := context.Background()
+
+	if err := clamd.Ping(ctx); err != nil {
+		t.Fatalf("ping: %v", err)
+	}
+
+	// Larger than one chunk, to exercise the chunked stream
+	clean := bytes.Repeat([]byte("%PDF-1.7 clean contract "), 5000)
+	result, err := clamd.Scan(ctx, bytes.NewReader(clean))
+	if err != nil || result.Infected {
+		t.Fatalf("clean file: got %+v, %v", result, err)
+	}
+	if len(*sizes) != 1 || (*sizes)[0] != len(clean) {
+		t.Fatalf("clamd received %v bytes, want %d", *sizes, len(clean))
+	}
+
+	result, err = clamd.Scan(ctx, strings.NewReader("%PDF-1.7 "+eicar))
+	if err != nil || !result.Infected || result.Signature != "Eicar-Test-Signature" {
+		t.Fatalf("infected file: got %+v, %v", result, err)
+	}
+}
+
+func TestClamdScannerErrors(t *testing.T) {
+	if _, err := parseReply("INSTREAM size limit exceeded. ERROR"); err == nil {
+		t.Fatal("expected an error for a clamd ERROR reply")
+	}
+	unreachable := NewClamdScanner("unix", filepath.Join(t.TempDir(), "missing.sock"), time.Second)
+	if _, err := unreachable.Scan(context.Background(), strings.NewReader("x")); err == nil {
+		t.Fatal("expected an error when clamd is unreachable")
+	}
+}
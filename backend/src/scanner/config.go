@@ -0,0 +1,39 @@
+package scanner
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// NewFromEnv builds the scanner selected by SCANNER_DRIVER ("none" or "clamd"). No scanning
+// happens when nothing is configured so existing setups keep working.
+//
+//	clamd: CLAMD_ADDRESS, either host:port or unix:/path/to/clamd.sock (default localhost:3310),
+//	       CLAMD_TIMEOUT (default 60s)
+func NewFromEnv() (Scanner, error) {
+	switch driver := os.Getenv("SCANNER_DRIVER"); driver {
+	case "", "none":
+		return Noop{}, nil
+	case "clamd":
+		timeout, err := time.ParseDuration(envOrDefault("CLAMD_TIMEOUT", "60s"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid CLAMD_TIMEOUT: %w", err)
+		}
+		address := envOrDefault("CLAMD_ADDRESS", "localhost:3310")
+		if path, ok := strings.CutPrefix(address, "unix:"); ok {
+			return NewClamdScanner("unix", path, timeout), nil
+		}
+		return NewClamdScanner("tcp", address, timeout), nil
+	default:
+		return nil, fmt.Errorf("unknown SCANNER_DRIVER %q", driver)
+	}
+}
+
+func envOrDefault(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,37 @@
+// Package scanner checks uploaded content for malware before it is stored as a document
+package scanner
+
+import (
+	"context"
+	"io"
+)
+
+// Result is the verdict on one file. Signature names the malware found when Infected is set.
+type Result struct {
+	Infected  bool
+	Signature string
+}
+
+// Scanner inspects content for malware. An error means no verdict could be reached, which
+// callers must treat as "not scanned" rather than clean.
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (Result, error)
+
+	// Name identifies the scanner in document metadata and logs
+	Name() string
+}
+
+// Noop accepts every file without looking at it. It is the default when no scanner is
+// configured so local development works without one.
+type Noop struct{}
+
+// Scan drains r and reports it clean
+func (Noop) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	_, err := io.Copy(io.Discard, r)
+	return Result{}, err
+}
+
+// Name returns "none"
+func (Noop) Name() string {
+	return "none"
+}
@@ -0,0 +1,55 @@
+package signing
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+)
+
+// JWK is the public half of a verification key in JSON Web Key form (RFC 7517)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set as served from /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns every verification key, including verification-only keys kept for rollover.
+// HS256 key sets publish nothing because the shared secret must stay private.
+func (ks *KeySet) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, id := range ks.order {
+		entry := ks.keys[id]
+		jwk := JWK{KeyID: id, Use: "sig", Algorithm: entry.method.Alg()}
+
+		switch pub := entry.public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = encodeBase64URL(pub.N.Bytes())
+			jwk.E = encodeBase64URL(big.NewInt(int64(pub.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (pub.Curve.Params().BitSize + 7) / 8
+			jwk.KeyType = "EC"
+			jwk.Curve = pub.Curve.Params().Name
+			jwk.X = encodeBase64URL(pub.X.FillBytes(make([]byte, size)))
+			jwk.Y = encodeBase64URL(pub.Y.FillBytes(make([]byte, size)))
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+func encodeBase64URL(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,223 @@
+package signing
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// ErrUnknownKey is returned when a token names a key ID that is not configured
+var ErrUnknownKey = errors.New("signing: unknown key ID")
+
+// key is one configured verification key and, when its private half is known, a signing key
+type key struct {
+	id      string
+	method  jwt.SigningMethod
+	public  crypto.PublicKey
+	private crypto.PrivateKey
+}
+
+// KeySet signs tokens with one active key and verifies tokens signed by any configured key,
+// so a new key can be introduced and an old one retired without invalidating live tokens.
+// A KeySet built from a shared secret signs and verifies HS256 tokens instead and publishes
+// no keys.
+type KeySet struct {
+	keys     map[string]*key
+	order    []string
+	activeID string
+	secret   []byte
+	issuer   string
+}
+
+// NewHMACKeySet returns a key set using a single HS256 shared secret
+func NewHMACKeySet(secret []byte, issuer string) *KeySet {
+	return &KeySet{secret: secret, issuer: issuer}
+}
+
+// NewKeySetFromEnv loads signing keys from JWT_SIGNING_KEYS, a comma separated list of
+// kid=path pairs pointing at PEM encoded RSA or P-256 EC keys. Private keys can sign and
+// verify; public keys are verification-only and are used to keep accepting tokens from a
+// retired key. JWT_ACTIVE_KEY_ID names the key used for new tokens (defaulting to the first
+// private key). Without JWT_SIGNING_KEYS, tokens are signed with HS256 using JWT_SECRET.
+// JWT_ISSUER, when set, is written to and required in every token.
+func NewKeySetFromEnv() (*KeySet, error) {
+	issuer := os.Getenv("JWT_ISSUER")
+	spec := os.Getenv("JWT_SIGNING_KEYS")
+	if spec == "" {
+		secret := os.Getenv("JWT_SECRET")
+		if secret == "" {
+			return nil, fmt.Errorf("signing: set JWT_SIGNING_KEYS or JWT_SECRET")
+		}
+		return NewHMACKeySet([]byte(secret), issuer), nil
+	}
+
+	ks := &KeySet{keys: map[string]*key{}, issuer: issuer}
+	for _, entry := range strings.Split(spec, ",") {
+		id, path, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || id == "" || path == "" {
+			return nil, fmt.Errorf("signing: invalid JWT_SIGNING_KEYS entry %q", entry)
+		}
+		pemBytes, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("signing: reading key %q: %w", id, err)
+		}
+		if err := ks.AddPEM(id, pemBytes); err != nil {
+			return nil, err
+		}
+	}
+
+	activeID := os.Getenv("JWT_ACTIVE_KEY_ID")
+	if activeID == "" {
+		for _, id := range ks.order {
+			if ks.keys[id].private != nil {
+				activeID = id
+				break
+			}
+		}
+	}
+	if err := ks.SetActive(activeID); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// AddPEM adds a PEM encoded private or public key under the given key ID
+func (ks *KeySet) AddPEM(id string, pemBytes []byte) error {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return fmt.Errorf("signing: key %q is not PEM encoded", id)
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return fmt.Errorf("signing: key %q has unsupported PEM type %q", id, block.Type)
+	}
+	if err != nil {
+		return fmt.Errorf("signing: parsing key %q: %w", id, err)
+	}
+	return ks.Add(id, parsed)
+}
+
+// Add adds an *rsa.PrivateKey, *ecdsa.PrivateKey or their public keys under the given key ID
+func (ks *KeySet) Add(id string, k interface{}) error {
+	if _, exists := ks.keys[id]; exists {
+		return fmt.Errorf("signing: duplicate key ID %q", id)
+	}
+
+	entry := &key{id: id}
+	switch k := k.(type) {
+	case *rsa.PrivateKey:
+		entry.method, entry.public, entry.private = jwt.SigningMethodRS256, &k.PublicKey, k
+	case *rsa.PublicKey:
+		entry.method, entry.public = jwt.SigningMethodRS256, k
+	case *ecdsa.PrivateKey:
+		entry.method, entry.public, entry.private = jwt.SigningMethodES256, &k.PublicKey, k
+	case *ecdsa.PublicKey:
+		entry.method, entry.public = jwt.SigningMethodES256, k
+	default:
+		return fmt.Errorf("signing: key %q must be an RSA or ECDSA key, got %T", id, k)
+	}
+	if pub, ok := entry.public.(*ecdsa.PublicKey); ok && pub.Curve != elliptic.P256() {
+		return fmt.Errorf("signing: key %q must use the P-256 curve for ES256", id)
+	}
+	if pub, ok := entry.public.(*rsa.PublicKey); ok && pub.N.BitLen() < 2048 {
+		return fmt.Errorf("signing: RSA key %q must be at least 2048 bits", id)
+	}
+
+	if ks.keys == nil {
+		ks.keys = map[string]*key{}
+	}
+	ks.keys[id] = entry
+	ks.order = append(ks.order, id)
+	return nil
+}
+
+// SetActive selects the key used to sign new tokens; it must have a private key
+func (ks *KeySet) SetActive(id string) error {
+	entry, ok := ks.keys[id]
+	if !ok {
+		return fmt.Errorf("signing: active key %q is not configured", id)
+	}
+	if entry.private == nil {
+		return fmt.Errorf("signing: active key %q has no private key", id)
+	}
+	ks.activeID = id
+	return nil
+}
+
+// ActiveKeyID returns the key ID written into new tokens, or "" for HS256
+func (ks *KeySet) ActiveKeyID() string {
+	return ks.activeID
+}
+
+// Issuer returns the configured token issuer, if any
+func (ks *KeySet) Issuer() string {
+	return ks.issuer
+}
+
+// Sign signs claims with the active key, recording its key ID in the "kid" header
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	if ks.activeID == "" {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(ks.secret)
+	}
+	active := ks.keys[ks.activeID]
+	token := jwt.NewWithClaims(active.method, claims)
+	token.Header["kid"] = active.id
+	return token.SignedString(active.private)
+}
+
+// Keyfunc returns the verification key for a parsed token. The algorithm in the header must
+// match the key it names, so a public key can never be used as an HMAC secret.
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	if ks.activeID == "" {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("signing: unexpected signing method %s", token.Method.Alg())
+		}
+		return ks.secret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	entry, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, kid)
+	}
+	if token.Method.Alg() != entry.method.Alg() {
+		return nil, fmt.Errorf("signing: key %q does not accept %s tokens", kid, token.Method.Alg())
+	}
+	return entry.public, nil
+}
+
+// Parse verifies a token string into claims, checking the issuer when one is configured
+func (ks *KeySet) Parse(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	token, err := jwt.ParseWithClaims(tokenString, claims, ks.Keyfunc)
+	if err != nil {
+		return nil, err
+	}
+	if ks.issuer != "" {
+		if registered, ok := claims.(interface{ VerifyIssuer(string, bool) bool }); ok && !registered.VerifyIssuer(ks.issuer, true) {
+			return nil, fmt.Errorf("signing: token issuer does not match %q", ks.issuer)
+		}
+	}
+	return token, nil
+}
@@ -0,0 +1,141 @@
+package signing
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func testClaims() jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{Subject: "user", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))}
+}
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func newECKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestSignAndVerifyWithKeyID(t *testing.T) {
+	for name, k := range map[string]interface{}{"RS256": newRSAKey(t), "ES256": newECKey(t)} {
+		ks := &KeySet{}
+		if err := ks.Add("k1", k); err != nil {
+			t.Fatal(err)
+		}
+		if err := ks.SetActive("k1"); err != nil {
+			t.Fatal(err)
+		}
+
+		signed, err := ks.Sign(testClaims())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var claims jwt.RegisteredClaims
+		token, err := ks.Parse(signed, &claims)
+		if err != nil || !token.Valid {
+			t.Fatalf("%s: verifying own token: %v", name, err)
+		}
+		if token.Header["kid"] != "k1" || token.Method.Alg() != name {
+			t.Fatalf("%s: got kid %v alg %s", name, token.Header["kid"], token.Method.Alg())
+		}
+	}
+}
+
+func TestRolloverKeepsOldTokensValid(t *testing.T) {
+	oldKey, newKey := newRSAKey(t), newECKey(t)
+
+	before := &KeySet{}
+	before.Add("old", oldKey)
+	before.SetActive("old")
+	oldToken, err := before.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The old key is kept as verification-only while the new key signs
+	pub, err := x509.MarshalPKIXPublicKey(&oldKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := &KeySet{}
+	if err := after.AddPEM("old", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub})); err != nil {
+		t.Fatal(err)
+	}
+	after.Add("new", newKey)
+	if err := after.SetActive("old"); err == nil {
+		t.Fatal("a verification-only key must not become the signing key")
+	}
+	after.SetActive("new")
+
+	if _, err := after.Parse(oldToken, &jwt.RegisteredClaims{}); err != nil {
+		t.Fatalf("token signed by the retired key: %v", err)
+	}
+	newToken, _ := after.Sign(testClaims())
+	if _, err := after.Parse(newToken, &jwt.RegisteredClaims{}); err != nil {
+		t.Fatalf("token signed by the new key: %v", err)
+	}
+	if _, err := before.Parse(newToken, &jwt.RegisteredClaims{}); err == nil {
+		t.Fatal("token with an unknown kid was accepted")
+	}
+	if got := len(after.JWKS().Keys); got != 2 {
+		t.Fatalf("JWKS published %d keys, want 2", got)
+	}
+}
+
+func TestRejectsAlgorithmConfusion(t *testing.T) {
+	k := newRSAKey(t)
+	ks := &KeySet{}
+	ks.Add("k1", k)
+	ks.SetActive("k1")
+
+	// An HS256 token "signed" with the public key bytes must not verify
+	pub, _ := x509.MarshalPKIXPublicKey(&k.PublicKey)
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+	forged.Header["kid"] = "k1"
+	signed, err := forged.SignedString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Parse(signed, &jwt.RegisteredClaims{}); err == nil {
+		t.Fatal("HS256 token accepted by an RSA key set")
+	}
+
+	hmac := NewHMACKeySet([]byte("secret"), "")
+	rsaToken, _ := ks.Sign(testClaims())
+	if _, err := hmac.Parse(rsaToken, &jwt.RegisteredClaims{}); err == nil {
+		t.Fatal("RS256 token accepted by an HMAC key set")
+	}
+}
+
+func TestIssuerIsEnforced(t *testing.T) {
+	ks := NewHMACKeySet([]byte("secret"), "https://docudefense.example")
+	claims := testClaims()
+	signed, _ := ks.Sign(claims)
+	if _, err := ks.Parse(signed, &jwt.RegisteredClaims{}); err == nil {
+		t.Fatal("token without the configured issuer was accepted")
+	}
+	claims.Issuer = ks.Issuer()
+	signed, _ = ks.Sign(claims)
+	if _, err := ks.Parse(signed, &jwt.RegisteredClaims{}); err != nil {
+		t.Fatalf("token with the configured issuer: %v", err)
+	}
+}
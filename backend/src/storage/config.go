@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// NewFromEnv builds the backend selected by STORAGE_DRIVER ("local", "gridfs" or "s3").
+// The local driver is used when nothing is configured so existing setups keep working.
+//
+//	local:  STORAGE_LOCAL_PATH (default ./uploads)
+//	gridfs: STORAGE_GRIDFS_BUCKET (default "documents"), stored in db
+//	s3:     S3_ENDPOINT, S3_ACCESS_KEY, S3_SECRET_KEY, S3_BUCKET, S3_REGION, S3_USE_SSL
+func NewFromEnv(ctx context.Context, db *mongo.Database) (Storage, error) {
+	switch driver := os.Getenv("STORAGE_DRIVER"); driver {
+	case "", "local":
+		return NewLocalStorage(envOrDefault("STORAGE_LOCAL_PATH", "./uploads"))
+	case "gridfs":
+		return NewGridFSStorage(db, envOrDefault("STORAGE_GRIDFS_BUCKET", "documents"))
+	case "s3":
+		useSSL, err := strconv.ParseBool(envOrDefault("S3_USE_SSL", "true"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid S3_USE_SSL: %w", err)
+		}
+		cfg := S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			Bucket:    envOrDefault("S3_BUCKET", "docudefense"),
+			Region:    os.Getenv("S3_REGION"),
+			UseSSL:    useSSL,
+		}
+		if cfg.Endpoint == "" {
+			return nil, fmt.Errorf("S3_ENDPOINT is required for the s3 storage driver")
+		}
+		return NewS3Storage(ctx, cfg)
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_DRIVER %q", driver)
+	}
+}
+
+func envOrDefault(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
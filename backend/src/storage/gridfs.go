@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"regexp"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GridFSStorage keeps blobs in a MongoDB GridFS bucket, using the key as the GridFS filename
+type GridFSStorage struct {
+	bucket *gridfs.Bucket
+}
+
+// NewGridFSStorage returns a backend that stores blobs in the named bucket of db
+func NewGridFSStorage(db *mongo.Database, bucketName string) (*GridFSStorage, error) {
+	bucket, err := gridfs.NewBucket(db, options.GridFSBucket().SetName(bucketName))
+	if err != nil {
+		return nil, err
+	}
+	return &GridFSStorage{bucket: bucket}, nil
+}
+
+// Put uploads a new revision of key and then drops the older revisions
+func (s *GridFSStorage) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	if _, err := cleanKey(key); err != nil {
+		return err
+	}
+	previous, err := s.find(ctx, bson.M{"filename": key})
+	if err != nil {
+		return err
+	}
+	if _, err := s.bucket.UploadFromStream(key, r); err != nil {
+		return err
+	}
+	for _, file := range previous {
+		if err := s.bucket.DeleteContext(ctx, file.ID); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get opens a download stream for the newest revision of key
+func (s *GridFSStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	stream, err := s.bucket.OpenDownloadStreamByName(key)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// Delete removes every revision stored under key
+func (s *GridFSStorage) Delete(ctx context.Context, key string) error {
+	files, err := s.find(ctx, bson.M{"filename": key})
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return ErrNotFound
+	}
+	for _, file := range files {
+		if err := s.bucket.DeleteContext(ctx, file.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stat returns the length and upload date of the newest revision of key
+func (s *GridFSStorage) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	files, err := s.find(ctx, bson.M{"filename": key}, options.GridFSFind().SetSort(bson.D{{Key: "uploadDate", Value: -1}}).SetLimit(1))
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if len(files) == 0 {
+		return ObjectInfo{}, ErrNotFound
+	}
+	return ObjectInfo{Key: key, Size: files[0].Length, LastModified: files[0].UploadDate}, nil
+}
+
+// List returns every file whose name starts with prefix
+func (s *GridFSStorage) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	filter := bson.M{"filename": bson.M{"$regex": "^" + regexp.QuoteMeta(prefix)}}
+	files, err := s.find(ctx, filter, options.GridFSFind().SetSort(bson.D{{Key: "filename", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]ObjectInfo, 0, len(files))
+	for _, file := range files {
+		objects = append(objects, ObjectInfo{Key: file.Name, Size: file.Length, LastModified: file.UploadDate})
+	}
+	return objects, nil
+}
+
+func (s *GridFSStorage) find(ctx context.Context, filter interface{}, opts ...*options.GridFSFindOptions) ([]gridfs.File, error) {
+	cursor, err := s.bucket.FindContext(ctx, filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var files []gridfs.File
+	if err := cursor.All(ctx, &files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
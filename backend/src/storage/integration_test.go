@@ -0,0 +1,62 @@
+//go:build integration
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Run with: go test -tags integration ./src/storage
+// Each backend is skipped unless its connection settings are in the environment.
+
+func TestS3StorageConformance(t *testing.T) {
+	if os.Getenv("S3_ENDPOINT") == "" {
+		t.Skip("S3_ENDPOINT is not set")
+	}
+	useSSL, _ := strconv.ParseBool(envOrDefault("S3_USE_SSL", "true"))
+	store, err := NewS3Storage(context.Background(), S3Config{
+		Endpoint:  os.Getenv("S3_ENDPOINT"),
+		AccessKey: os.Getenv("S3_ACCESS_KEY"),
+		SecretKey: os.Getenv("S3_SECRET_KEY"),
+		Bucket:    envOrDefault("S3_BUCKET", "docudefense-test"),
+		Region:    os.Getenv("S3_REGION"),
+		UseSSL:    useSSL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testConformance(t, store)
+}
+
+func TestGridFSStorageConformance(t *testing.T) {
+	uri := os.Getenv("MONGODB_URI")
+	if uri == "" {
+		t.Skip("MONGODB_URI is not set")
+	}
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Disconnect(ctx) })
+
+	db := client.Database(envOrDefault("STORAGE_TEST_DATABASE", "docudefense_test"))
+	bucket := fmt.Sprintf("conformance_%d", time.Now().UnixNano())
+	t.Cleanup(func() {
+		db.Collection(bucket + ".files").Drop(ctx)
+		db.Collection(bucket + ".chunks").Drop(ctx)
+	})
+	store, err := NewGridFSStorage(db, bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testConformance(t, store)
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when the requested key does not exist in the backend
+var ErrNotFound = errors.New("storage: object not found")
+
+// ErrInvalidKey is returned when a key is empty or tries to escape the storage root
+var ErrInvalidKey = errors.New("storage: invalid key")
+
+// ObjectInfo describes a blob held by a storage backend
+type ObjectInfo struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// Storage defines the operations every blob backend must support.
+// Keys are slash separated paths such as "documents/<id>".
+type Storage interface {
+	// Put stores the contents of r under key, replacing any existing blob.
+	// size may be -1 when the length is not known in advance.
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get opens a streaming reader for the blob stored under key
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob stored under key
+	Delete(ctx context.Context, key string) error
+	// Stat returns metadata for the blob stored under key
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	// List returns every blob whose key starts with prefix
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+}
+
+// cleanKey normalises a key and rejects anything that could escape the storage root
+func cleanKey(key string) (string, error) {
+	if key == "" || strings.Contains(key, "\\") || strings.ContainsRune(key, 0) {
+		return "", ErrInvalidKey
+	}
+	cleaned := path.Clean("/" + key)[1:]
+	if cleaned == "" || cleaned != strings.TrimPrefix(key, "/") {
+		return "", ErrInvalidKey
+	}
+	return cleaned, nil
+}
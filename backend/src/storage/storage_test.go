@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testConformance checks the behaviour every Storage backend must share. Keys are created
+// below a prefix unique to the run, so shared buckets can be used.
+func testConformance(t *testing.T, store Storage) {
+	ctx := context.Background()
+	prefix := fmt.Sprintf("conformance-%d/", time.Now().UnixNano())
+	key := prefix + "documents/contract"
+
+	get := func(t *testing.T, key string) string {
+		t.Helper()
+		r, err := store.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("get %s: %v", key, err)
+		}
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("read %s: %v", key, err)
+		}
+		return string(data)
+	}
+	put := func(t *testing.T, key, content string, size int64) {
+		t.Helper()
+		if err := store.Put(ctx, key, strings.NewReader(content), size); err != nil {
+			t.Fatalf("put %s: %v", key, err)
+		}
+	}
+
+	t.Run("missing keys", func(t *testing.T) {
+		if _, err := store.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("get: got %v, want ErrNotFound", err)
+		}
+		if _, err := store.Stat(ctx, key); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("stat: got %v, want ErrNotFound", err)
+		}
+		if err := store.Delete(ctx, key); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("delete: got %v, want ErrNotFound", err)
+		}
+		if objects, err := store.List(ctx, prefix); err != nil || len(objects) != 0 {
+			t.Fatalf("list: got %v, %v", objects, err)
+		}
+	})
+
+	t.Run("put and get", func(t *testing.T) {
+		put(t, key, "%PDF-1.7", 8)
+		if got := get(t, key); got != "%PDF-1.7" {
+			t.Fatalf("got %q", got)
+		}
+		info, err := store.Stat(ctx, key)
+		if err != nil || info.Size != 8 || info.LastModified.IsZero() {
+			t.Fatalf("stat: got %+v, %v", info, err)
+		}
+
+		// An unknown size is streamed
+		put(t, prefix+"documents/notes", "unknown length", -1)
+		if got := get(t, prefix+"documents/notes"); got != "unknown length" {
+			t.Fatalf("got %q", got)
+		}
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		put(t, key, "%PDF", 4)
+		if got := get(t, key); got != "%PDF" {
+			t.Fatalf("got %q after overwriting", got)
+		}
+		if info, err := store.Stat(ctx, key); err != nil || info.Size != 4 {
+			t.Fatalf("stat after overwriting: got %+v, %v", info, err)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		put(t, prefix+"uploads/part-1", "a", 1)
+		objects, err := store.List(ctx, prefix+"documents/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var keys []string
+		for _, object := range objects {
+			keys = append(keys, object.Key)
+		}
+		sort.Strings(keys)
+		want := []string{prefix + "documents/contract", prefix + "documents/notes"}
+		if strings.Join(keys, ",") != strings.Join(want, ",") {
+			t.Fatalf("got %v, want %v", keys, want)
+		}
+		if objects, err := store.List(ctx, prefix); err != nil || len(objects) != 3 {
+			t.Fatalf("list everything: got %v, %v", objects, err)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		for _, k := range []string{key, prefix + "documents/notes", prefix + "uploads/part-1"} {
+			if err := store.Delete(ctx, k); err != nil {
+				t.Fatalf("delete %s: %v", k, err)
+			}
+		}
+		if _, err := store.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("get after delete: got %v, want ErrNotFound", err)
+		}
+		if objects, err := store.List(ctx, prefix); err != nil || len(objects) != 0 {
+			t.Fatalf("list after delete: got %v, %v", objects, err)
+		}
+	})
+
+	t.Run("invalid keys", func(t *testing.T) {
+		for _, bad := range []string{"", "../escape", prefix + "a/../../escape", prefix + `a\b`} {
+			if err := store.Put(ctx, bad, strings.NewReader("x"), 1); !errors.Is(err, ErrInvalidKey) {
+				t.Fatalf("put %q: got %v, want ErrInvalidKey", bad, err)
+			}
+		}
+	})
+}
+
+func TestLocalStorageConformance(t *testing.T) {
+	store, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testConformance(t, store)
+}
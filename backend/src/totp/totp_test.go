@@ -0,0 +1,51 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// Test vectors from RFC 6238 appendix B (SHA-1), truncated to six digits
+func TestRFC6238Vectors(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, want := range vectors {
+		got, err := Code(secret, time.Unix(unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("at %d: got %s, want %s", unix, got, want)
+		}
+	}
+}
+
+func TestValidateAllowsDriftAndRejectsReplay(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	previous, _ := Code(secret, now.Add(-Period))
+
+	step, ok := Validate(secret, previous, now, 0)
+	if !ok || step != Step(now)-1 {
+		t.Fatalf("code from the previous step was rejected")
+	}
+	if _, ok := Validate(secret, previous, now, step); ok {
+		t.Fatal("replayed code was accepted")
+	}
+
+	stale, _ := Code(secret, now.Add(-3*Period))
+	if _, ok := Validate(secret, stale, now, 0); ok {
+		t.Fatal("code from outside the drift window was accepted")
+	}
+}
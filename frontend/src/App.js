@@ -7,7 +7,12 @@ import Navbar from './components/Navbar';
 import Home from './components/Homepage';
 import Footer from './components/Footer';
 import UserList from './components/UserList';
-import { isLoggedIn, clearToken, getUserEmail } from './services/authService';
+import SSOCallback from './components/SSOCallback';
+import VerifyEmail from './components/VerifyEmail';
+import ResetPassword from './components/ResetPassword';
+import AcceptInvitation from './components/AcceptInvitation';
+import PublicShareLink from './components/PublicShareLink';
+import { isLoggedIn, logoutUser, getUserEmail, authorizedFetch } from './services/authService';
 import bgElement from './assets/bg-element.svg';
 import './App.scss';
 
@@ -91,7 +96,7 @@ function App() {
             query.append("page", page);
             query.append("limit", limit);
     
-            const response = await fetch(`http://localhost:8000/api/users?${query.toString()}`);
+            const response = await authorizedFetch(`http://localhost:8000/api/users?${query.toString()}`);
             if (!response.ok) throw new Error("Error fetching users");
     
             const data = await response.json();
@@ -108,7 +113,7 @@ function App() {
             query.append("page", page);
             query.append("limit", limit);
     
-            const response = await fetch(`http://localhost:8000/api/users?${query.toString()}`);
+            const response = await authorizedFetch(`http://localhost:8000/api/users?${query.toString()}`);
             if (!response.ok) throw new Error("Error fetching search results");
     
             const data = await response.json();
@@ -118,12 +123,14 @@ function App() {
         }
     }, [page, limit]);
     
-    const handleLogout = () => {
-        clearToken();
+    const handleLogout = async () => {
+        await logoutUser();
         setLoggedIn(false);
         setCurrentUser(null);
     };
 
+    const handleSSOLogin = useCallback(() => setLoggedIn(true), []);
+
     const handleShowLogin = (registerMode = false) => {
         setIsRegistering(registerMode);
         setShowModal(true);
@@ -168,6 +175,11 @@ function App() {
                                         handlePreviousPage={handlePreviousPage} 
                                     />} 
                         />
+                        <Route path="/sso/callback" element={<SSOCallback onLogin={handleSSOLogin} />} />
+                        <Route path="/verify-email" element={<VerifyEmail />} />
+                        <Route path="/reset-password" element={<ResetPassword />} />
+                        <Route path="/invitations" element={<AcceptInvitation />} />
+                        <Route path="/shared-link" element={<PublicShareLink />} />
                         <Route path="*" element={<Navigate to="/" />} />
                     </Routes>
                 </MainContentWrapper>
@@ -0,0 +1,36 @@
+import React, { useEffect, useState } from 'react';
+import { isLoggedIn, setActiveOrganization } from '../services/authService';
+import { acceptInvitation } from '../services/organizationService';
+
+// Landing page for the link in an organization invitation, which carries the token in the fragment
+function AcceptInvitation() {
+  const [status, setStatus] = useState('Accepting your invitation...');
+
+  useEffect(() => {
+    const token = new URLSearchParams(window.location.hash.slice(1)).get('token');
+    if (!token) {
+      setStatus('This invitation link is incomplete.');
+      return;
+    }
+    // Keep the link intact until the user has signed in with the invited address
+    if (!isLoggedIn()) {
+      setStatus('Sign in with the email address the invitation was sent to, then open the link again.');
+      return;
+    }
+    window.history.replaceState(null, '', window.location.pathname);
+
+    acceptInvitation(token)
+      .then((membership) => {
+        setActiveOrganization(membership.org_id);
+        setStatus('You have joined the organization. Its documents are now shown on your dashboard.');
+      })
+      .catch((error) => {
+        console.error('Accepting invitation failed:', error);
+        setStatus('This invitation is invalid, has expired, or was sent to a different email address.');
+      });
+  }, []);
+
+  return <p>{status}</p>;
+}
+
+export default AcceptInvitation;
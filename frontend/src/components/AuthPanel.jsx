@@ -1,6 +1,7 @@
 import React, { useState, useEffect } from 'react';
+import { Link } from 'react-router-dom';
 import { loginUser, createUser } from '../services/userService';
-import { setToken } from '../services/authService';
+import { setToken, setRefreshToken, ssoLoginUrl } from '../services/authService';
 
 function AuthPanel({ onLogin, onClose, isRegistering: initialRegisteringState }) {
   const [isRegistering, setIsRegistering] = useState(initialRegisteringState);
@@ -27,16 +28,20 @@ function AuthPanel({ onLogin, onClose, isRegistering: initialRegisteringState })
     try {
       if (isRegistering) {
         await createUser(formData);
-        alert('Account created successfully. You may now log in.');
+        alert('Account created successfully. Check your email to confirm your address, then log in.');
         setIsRegistering(false);
       } else {
-        const { token } = await loginUser({ email: formData.email, password: formData.password });
+        const { token, refresh_token } = await loginUser({ email: formData.email, password: formData.password });
         setToken(token);
+        setRefreshToken(refresh_token);
         onLogin();
       }
       onClose();
     } catch (error) {
       console.error(isRegistering ? 'Registration failed:' : 'Login failed:', error);
+      if (!isRegistering) {
+        alert(error.message.startsWith('Too many') ? error.message : 'Invalid email or password');
+      }
     }
   };
 
@@ -61,6 +66,12 @@ function AuthPanel({ onLogin, onClose, isRegistering: initialRegisteringState })
               <input type="password" name="password" placeholder="Password" value={formData.password} onChange={handleInputChange} className="custom-input" />
               <button type="submit" className="custom-btn primary-btn w-100">{isRegistering ? 'Register' : 'Login'}</button>
             </form>
+            {!isRegistering && (
+              <>
+                <a href={ssoLoginUrl()} className="custom-btn secondary-btn w-100 mt-2">Sign in with company SSO</a>
+                <Link to="/reset-password" onClick={onClose} className="d-block mt-2">Forgot your password?</Link>
+              </>
+            )}
           </div>
           <div className="custom-modal-footer">
             <button className="custom-btn secondary-btn" onClick={toggleForm}>{isRegistering ? 'Already have an account? Login' : 'Create an account'}</button>
@@ -1,22 +1,23 @@
 import React, { useState } from 'react';
-import { setToken } from '../services/authService';
+import { setToken, setRefreshToken } from '../services/authService';
 import { loginUser } from '../services/userService';
 
 function Login({ onLogin }) {
   const [email, setEmail] = useState('');
   const [password, setPassword] = useState('');
-  const [error, setError] = useState(false);
+  const [error, setError] = useState('');
 
   const handleSubmit = async (e) => {
     e.preventDefault();
     try {
-      const { token } = await loginUser({ email, password });
+      const { token, refresh_token } = await loginUser({ email, password });
       setToken(token);
-      setError(false);
+      setRefreshToken(refresh_token);
+      setError('');
       onLogin();
     } catch (error) {
       console.error('Login failed:', error);
-      setError(true);
+      setError(error.message.startsWith('Too many') ? error.message : 'Invalid email or password');
     }
   };
 
@@ -37,7 +38,7 @@ function Login({ onLogin }) {
         className="custom-input"
       />
       {error && (
-        <p className="text-danger mt-2">Invalid credentials</p>
+        <p className="text-danger mt-2">{error}</p>
       )}
       <button type="submit" className="custom-btn primary-btn">Login</button>
     </form>
@@ -1,9 +1,9 @@
 import React from 'react';
-import { clearToken } from '../services/authService';
+import { logoutUser } from '../services/authService';
 
 function Logout({ onLogout }) {
-  const handleLogout = () => {
-    clearToken();
+  const handleLogout = async () => {
+    await logoutUser();
     onLogout();
   };
 
@@ -0,0 +1,65 @@
+import React, { useEffect, useState } from 'react';
+import { getPublicLink, openPublicDocument } from '../services/shareLinkService';
+
+// Landing page for a public share link, which carries the token in the fragment
+function PublicShareLink() {
+  const [token] = useState(() => new URLSearchParams(window.location.hash.slice(1)).get('token'));
+  const [link, setLink] = useState(null);
+  const [password, setPassword] = useState('');
+  const [documentUrl, setDocumentUrl] = useState(null);
+  const [status, setStatus] = useState('Opening the shared document...');
+
+  const open = (pass) => {
+    openPublicDocument(token, pass)
+      .then((blob) => {
+        setDocumentUrl(URL.createObjectURL(blob));
+        setStatus('');
+      })
+      .catch((error) => {
+        if (error.status === 401) {
+          setStatus('The password is incorrect.');
+        } else if (error.status === 429) {
+          setStatus('Too many attempts. Try again later.');
+        } else {
+          setStatus('This link is no longer available.');
+        }
+      });
+  };
+
+  useEffect(() => {
+    if (!token) {
+      setStatus('This link is incomplete.');
+      return;
+    }
+    getPublicLink(token)
+      .then((details) => {
+        setLink(details);
+        if (details.password_required) {
+          setStatus('This document is protected with a password.');
+        } else {
+          open();
+        }
+      })
+      .catch(() => setStatus('This link is invalid, has expired or has been revoked.'));
+    // eslint-disable-next-line react-hooks/exhaustive-deps
+  }, [token]);
+
+  useEffect(() => () => documentUrl && URL.revokeObjectURL(documentUrl), [documentUrl]);
+
+  if (documentUrl) {
+    return <iframe title="Shared document" src={documentUrl} style={{ width: '100%', height: '80vh' }} />;
+  }
+  return (
+    <div>
+      <p>{status}</p>
+      {link && link.password_required && (
+        <form onSubmit={(e) => { e.preventDefault(); open(password); }}>
+          <input type="password" value={password} onChange={(e) => setPassword(e.target.value)} placeholder="Password" />
+          <button type="submit">Open</button>
+        </form>
+      )}
+    </div>
+  );
+}
+
+export default PublicShareLink;
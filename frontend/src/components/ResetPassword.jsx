@@ -0,0 +1,56 @@
+import React, { useState } from 'react';
+import { useNavigate } from 'react-router-dom';
+import { requestPasswordReset, resetPassword } from '../services/userService';
+
+// Without a token in the fragment this asks for an email address to send a reset link to;
+// opened from the reset email it lets the user choose a new password
+function ResetPassword() {
+  const navigate = useNavigate();
+  const [token] = useState(() => {
+    const value = new URLSearchParams(window.location.hash.slice(1)).get('token');
+    window.history.replaceState(null, '', window.location.pathname);
+    return value;
+  });
+  const [email, setEmail] = useState('');
+  const [password, setPassword] = useState('');
+  const [message, setMessage] = useState('');
+
+  const handleRequest = async (e) => {
+    e.preventDefault();
+    try {
+      const { message } = await requestPasswordReset(email);
+      setMessage(message);
+    } catch (error) {
+      console.error('Password reset request failed:', error);
+      setMessage('Could not send a reset link. Please try again.');
+    }
+  };
+
+  const handleReset = async (e) => {
+    e.preventDefault();
+    try {
+      await resetPassword(token, password);
+      alert('Your password has been reset. You may now log in.');
+      navigate('/');
+    } catch (error) {
+      console.error('Password reset failed:', error);
+      setMessage('This reset link is invalid or has expired.');
+    }
+  };
+
+  return token ? (
+    <form className="custom-modal-form custom-modal add-skew" onSubmit={handleReset}>
+      <input type="password" placeholder="New password" value={password} onChange={(e) => setPassword(e.target.value)} className="custom-input" />
+      {message && <p className="text-danger mt-2">{message}</p>}
+      <button type="submit" className="custom-btn primary-btn">Set new password</button>
+    </form>
+  ) : (
+    <form className="custom-modal-form custom-modal add-skew" onSubmit={handleRequest}>
+      <input type="email" placeholder="Email" value={email} onChange={(e) => setEmail(e.target.value)} className="custom-input" />
+      {message && <p className="mt-2">{message}</p>}
+      <button type="submit" className="custom-btn primary-btn">Send reset link</button>
+    </form>
+  );
+}
+
+export default ResetPassword;
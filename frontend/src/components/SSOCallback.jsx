@@ -0,0 +1,38 @@
+import React, { useEffect } from 'react';
+import { useNavigate } from 'react-router-dom';
+import { setToken, setRefreshToken } from '../services/authService';
+import { completeMfaLogin } from '../services/userService';
+
+// Receives the tokens the backend appends to the URL fragment after single sign-on, or the
+// two-factor challenge for accounts that have it enabled
+function SSOCallback({ onLogin }) {
+  const navigate = useNavigate();
+
+  useEffect(() => {
+    const params = new URLSearchParams(window.location.hash.slice(1));
+    // Remove the tokens from the address bar and browser history
+    window.history.replaceState(null, '', window.location.pathname);
+
+    const signIn = async () => {
+      let session = { token: params.get('token'), refresh_token: params.get('refresh_token') };
+      if (params.get('mfa_required') === 'true') {
+        const code = window.prompt('Enter the code from your authenticator app, or a recovery code');
+        if (!code) throw new Error('Two-factor authentication cancelled');
+        session = await completeMfaLogin(params.get('mfa_token'), code);
+      }
+      if (!session.token) throw new Error('Single sign-on did not return a token');
+      setToken(session.token);
+      setRefreshToken(session.refresh_token);
+      onLogin();
+      navigate('/dashboard');
+    };
+    signIn().catch((error) => {
+      console.error(error.message);
+      navigate('/');
+    });
+  }, [navigate, onLogin]);
+
+  return <p>Signing you in...</p>;
+}
+
+export default SSOCallback;
@@ -1,6 +1,6 @@
 import React, { useState, useEffect, useCallback } from 'react';
-import { uploadFile, getUserFiles, fetchUserIDByEmail, deleteFile, updateUser, deleteUser } from '../services/userService';
-import { getUserEmail, getToken } from '../services/authService';
+import { uploadFileResumable, getUserFiles, fetchUserIDByEmail, deleteFile, updateUser, deleteUser } from '../services/userService';
+import { getUserEmail, getToken, workspaceHeaders } from '../services/authService';
 import PDFPreview from './pdfPreview';
 import UserProfileForm from './UserProfileForm';
 import UserProfileDelete from './UserProfileDelete';
@@ -83,12 +83,12 @@ function UserDashboard() {
         if (!selectedFile || !userId) return;
         try {
             setLoading(true);
-            await uploadFile(userId, selectedFile);
+            await uploadFileResumable(userId, selectedFile);
             fetchUserFiles();
             displayMessage('File uploaded successfully!');
         } catch (error) {
             console.error('Error uploading file:', error);
-            displayMessage('Error uploading file. Please try again.', true);
+            displayMessage(error.message !== 'Failed to upload file' ? error.message : 'Error uploading file. Please try again.', true);
         } finally {
             setLoading(false);
         }
@@ -132,6 +132,7 @@ function UserDashboard() {
             const response = await fetch(`http://localhost:8000/users/${userId}/files/${filename}/download`, {
                 method: 'GET',
                 headers: {
+                    ...workspaceHeaders(),
                     'Authorization': `Bearer ${token}`,
                     'Content-Type': 'application/pdf'
                 },
@@ -154,6 +155,7 @@ function UserDashboard() {
             const response = await fetch(`http://localhost:8000/users/${userId}/files/${filename}/download`, {
                 method: 'GET',
                 headers: {
+                    ...workspaceHeaders(),
                     'Authorization': `Bearer ${token}`,
                     'Content-Type': 'application/pdf'
                 },
@@ -3,6 +3,7 @@ import React, { useState } from 'react';
 const UserProfileForm = ({ user, onUpdate }) => {
   const [updatedData, setUpdatedData] = useState(user);
   const [newPassword, setNewPassword] = useState('');
+  const [currentPassword, setCurrentPassword] = useState('');
 
   const handleChange = (e) => {
     const { name, value } = e.target;
@@ -22,6 +23,9 @@ const UserProfileForm = ({ user, onUpdate }) => {
     if (newPassword) {
       dataToUpdate.password = newPassword; // Include the new password only if changed
     }
+    if (currentPassword) {
+      dataToUpdate.current_password = currentPassword; // Required to change the password or email
+    }
     try {
       await onUpdate(user.id, dataToUpdate);
       alert('Profile updated successfully');
@@ -64,6 +68,14 @@ const UserProfileForm = ({ user, onUpdate }) => {
         placeholder="New Password (leave blank to keep current)"
         className="form-control"
       />
+      <input
+        type="password"
+        name="current_password"
+        value={currentPassword}
+        onChange={(e) => setCurrentPassword(e.target.value)}
+        placeholder="Current Password (needed to change your email or password)"
+        className="form-control"
+      />
       <button type="submit" className="btn btn-primary mt-3">Update Profile</button>
     </form>
   );
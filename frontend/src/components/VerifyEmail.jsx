@@ -0,0 +1,28 @@
+import React, { useEffect, useState } from 'react';
+import { verifyEmail } from '../services/userService';
+
+// Landing page for the link in the verification email, which carries the token in the fragment
+function VerifyEmail() {
+  const [status, setStatus] = useState('Verifying your email address...');
+
+  useEffect(() => {
+    const token = new URLSearchParams(window.location.hash.slice(1)).get('token');
+    // Remove the token from the address bar and browser history
+    window.history.replaceState(null, '', window.location.pathname);
+
+    if (!token) {
+      setStatus('This verification link is incomplete.');
+      return;
+    }
+    verifyEmail(token)
+      .then(() => setStatus('Your email address has been verified.'))
+      .catch((error) => {
+        console.error('Email verification failed:', error);
+        setStatus('This verification link is invalid or has expired.');
+      });
+  }, []);
+
+  return <p>{status}</p>;
+}
+
+export default VerifyEmail;
@@ -18,9 +18,104 @@ export function getToken() {
     return token;
 }
 
+export function setRefreshToken(token) {
+    if (token) {
+        localStorage.setItem('refreshToken', token);
+    }
+}
+
+export function getRefreshToken() {
+    return localStorage.getItem('refreshToken');
+}
+
 export function clearToken() {
     console.log("Clearing token from localStorage");
     localStorage.removeItem('jwtToken');
+    localStorage.removeItem('refreshToken');
+    localStorage.removeItem('activeOrganization');
+}
+
+// The organization whose documents the file routes work on; none means the personal workspace
+export function setActiveOrganization(orgId) {
+    if (orgId) {
+        localStorage.setItem('activeOrganization', orgId);
+    } else {
+        localStorage.removeItem('activeOrganization');
+    }
+}
+
+export function getActiveOrganization() {
+    return localStorage.getItem('activeOrganization');
+}
+
+export function workspaceHeaders() {
+    const orgId = getActiveOrganization();
+    return orgId ? { 'X-Organization-ID': orgId } : {};
+}
+
+// Exchange the stored refresh token for a new access token. Returns false when the
+// session can no longer be refreshed and the user has to log in again.
+export async function refreshAccessToken() {
+    const refreshToken = getRefreshToken();
+    if (!refreshToken) {
+        return false;
+    }
+    const response = await fetch(`${BASE_URL}/token/refresh`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ refresh_token: refreshToken }),
+    });
+    if (!response.ok) {
+        clearToken();
+        return false;
+    }
+    const data = await response.json();
+    setToken(data.token);
+    setRefreshToken(data.refresh_token);
+    return true;
+}
+
+// Ask for a fresh two-factor code and swap the access token for one that records it.
+// Sensitive actions require this when the last verification is too old.
+export async function stepUpAuthentication() {
+    const token = getToken();
+    const code = token && window.prompt('Confirm this action with the code from your authenticator app');
+    if (!code) {
+        return false;
+    }
+    const { sub } = jwtDecode(token.split(" ")[1]);
+    const response = await fetch(`${BASE_URL}/users/${sub}/mfa/step-up`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json', Authorization: token },
+        body: JSON.stringify({ code: code.trim() }),
+    });
+    if (!response.ok) {
+        return false;
+    }
+    const data = await response.json();
+    setToken(data.token);
+    return true;
+}
+
+// fetch with the current access token, refreshing it once if the server rejects it and
+// asking for a two-factor code when the server requires a step-up
+export async function authorizedFetch(url, options = {}) {
+    const send = () => fetch(url, {
+        ...options,
+        headers: { ...workspaceHeaders(), ...options.headers, Authorization: getToken() },
+    });
+    const response = await send();
+    if (response.status !== 401) {
+        return response;
+    }
+    const challenge = response.headers.get('WWW-Authenticate') || '';
+    if (challenge.includes('insufficient_user_authentication')) {
+        return await stepUpAuthentication() ? send() : response;
+    }
+    if (await refreshAccessToken()) {
+        return send();
+    }
+    return response;
 }
 
 export function isLoggedIn() {
@@ -43,14 +138,25 @@ export function getUserEmail() {
         return null;
     }
 }
-async function fetchWithAuth(url, options = {}) {
+
+// Permissions granted by the current access token's roles, e.g. "users:manage"
+export function hasPermission(permission) {
     const token = getToken();
+    if (!token) return false;
+    try {
+        const decoded = jwtDecode(token.split(" ")[1]);
+        return (decoded.permissions || []).includes(permission);
+    } catch (error) {
+        return false;
+    }
+}
+
+async function fetchWithAuth(url, options = {}) {
     const headers = {
         ...options.headers,
         'Content-Type': 'application/json',
-        ...(token && { Authorization: token }), // Use the full "Bearer <token>" string
     };
-    return fetch(url, { ...options, headers });
+    return authorizedFetch(url, { ...options, headers });
 }
 
 export async function getUsers() {
@@ -97,12 +203,45 @@ export async function loginUser(credentials) {
     console.log("Login response data:", data); // Log the received response data
     if (data.token) {
         setToken(data.token); // Store JWT token if present
+        setRefreshToken(data.refresh_token);
     } else {
         console.error("Login response did not include a token.");
     }
     return data;
 }
 
-export function logoutUser() {
+// Backend endpoint that starts an OpenID Connect single sign-on login
+export function ssoLoginUrl() {
+    return `${BASE_URL}/auth/oidc/login`;
+}
+
+// Revoke the session server-side, then forget the tokens locally
+export async function logoutUser() {
+    const token = getToken();
+    if (token) {
+        try {
+            await fetch(`${BASE_URL}/logout`, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json', Authorization: token },
+                body: JSON.stringify({ refresh_token: getRefreshToken() }),
+            });
+        } catch (error) {
+            console.error('Logout request failed:', error);
+        }
+    }
     clearToken();
 }
+
+export async function getAdminUsers(page = 1, limit = 50) {
+    const response = await fetchWithAuth(`${BASE_URL}/admin/users?page=${page}&limit=${limit}`);
+    if (!response.ok) throw new Error('Failed to fetch users');
+    return response.json();
+}
+
+export async function setUserDisabled(userId, disabled) {
+    const response = await fetchWithAuth(`${BASE_URL}/admin/users/${userId}/${disabled ? 'disable' : 'enable'}`, {
+        method: 'POST',
+    });
+    if (!response.ok) throw new Error(await response.text());
+    return response.json();
+}
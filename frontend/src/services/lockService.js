@@ -0,0 +1,56 @@
+import { authorizedFetch } from './authService';
+
+const BASE_URL = 'http://localhost:8000';
+
+async function check(response) {
+    if (!response.ok) {
+        throw new Error(await response.text());
+    }
+    return response;
+}
+
+function lockPath(userId, filename) {
+    return `${BASE_URL}/users/${userId}/files/${encodeURIComponent(filename)}/lock`;
+}
+
+// Check a file out so nobody else can upload versions. Options: reason, expires_at
+export async function checkOutFile(userId, filename, options = {}) {
+    const response = await authorizedFetch(lockPath(userId, filename), {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify(options),
+    });
+    return (await check(response)).json();
+}
+
+export async function checkInFile(userId, filename) {
+    const response = await authorizedFetch(lockPath(userId, filename), { method: 'DELETE' });
+    return (await check(response)).json();
+}
+
+// The file's current lock, or null when it is not checked out
+export async function getFileLock(userId, filename) {
+    const response = await authorizedFetch(lockPath(userId, filename));
+    if (response.status === 404) {
+        return null;
+    }
+    return (await check(response)).json();
+}
+
+export async function listLocks(userId) {
+    return (await check(await authorizedFetch(`${BASE_URL}/users/${userId}/locks`))).json();
+}
+
+export async function checkOutSharedDocument(documentId, options = {}) {
+    const response = await authorizedFetch(`${BASE_URL}/shared/documents/${documentId}/lock`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify(options),
+    });
+    return (await check(response)).json();
+}
+
+export async function checkInSharedDocument(documentId) {
+    const response = await authorizedFetch(`${BASE_URL}/shared/documents/${documentId}/lock`, { method: 'DELETE' });
+    return (await check(response)).json();
+}
@@ -0,0 +1,39 @@
+import { authorizedFetch } from './authService';
+
+const BASE_URL = 'http://localhost:8000';
+
+async function send(path, options = {}) {
+    const response = await authorizedFetch(`${BASE_URL}${path}`, {
+        ...options,
+        headers: { 'Content-Type': 'application/json', ...options.headers },
+    });
+    if (!response.ok) {
+        throw new Error(await response.text());
+    }
+    return response.json();
+}
+
+// Organizations the current user belongs to, with their role in each
+export function listOrganizations() {
+    return send('/orgs');
+}
+
+export function createOrganization(name) {
+    return send('/orgs', { method: 'POST', body: JSON.stringify({ name }) });
+}
+
+export function listMembers(orgId) {
+    return send(`/orgs/${orgId}/members`);
+}
+
+export function inviteMember(orgId, email, role = 'member') {
+    return send(`/orgs/${orgId}/invitations`, { method: 'POST', body: JSON.stringify({ email, role }) });
+}
+
+export function acceptInvitation(token) {
+    return send('/invitations/accept', { method: 'POST', body: JSON.stringify({ token }) });
+}
+
+export function removeMember(orgId, userId) {
+    return send(`/orgs/${orgId}/members/${userId}`, { method: 'DELETE' });
+}
@@ -0,0 +1,49 @@
+import { authorizedFetch } from './authService';
+
+const BASE_URL = 'http://localhost:8000';
+
+async function check(response) {
+    if (!response.ok) {
+        throw new Error(await response.text());
+    }
+    return response;
+}
+
+function linksPath(userId, filename) {
+    return `${BASE_URL}/users/${userId}/files/${encodeURIComponent(filename)}`;
+}
+
+// Create a public link to one version ('latest' pins the current one). Options: expires_at, password, max_downloads
+export async function createShareLink(userId, filename, version = 'latest', options = {}) {
+    const response = await authorizedFetch(`${linksPath(userId, filename)}/${version}/links`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify(options),
+    });
+    return (await check(response)).json();
+}
+
+export async function listShareLinks(userId, filename) {
+    return (await check(await authorizedFetch(`${linksPath(userId, filename)}/links`))).json();
+}
+
+export async function revokeShareLink(userId, filename, linkId) {
+    const response = await authorizedFetch(`${linksPath(userId, filename)}/links/${linkId}`, { method: 'DELETE' });
+    return (await check(response)).json();
+}
+
+// The public endpoints below need no sign-in; the token is the credential
+export async function getPublicLink(token) {
+    return (await check(await fetch(`${BASE_URL}/public/links/${encodeURIComponent(token)}`))).json();
+}
+
+export async function openPublicDocument(token, password) {
+    const headers = password ? { 'X-Share-Password': password } : {};
+    const response = await fetch(`${BASE_URL}/public/links/${encodeURIComponent(token)}/document`, { headers });
+    if (!response.ok) {
+        const error = new Error(await response.text());
+        error.status = response.status;
+        throw error;
+    }
+    return response.blob();
+}
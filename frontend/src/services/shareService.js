@@ -0,0 +1,44 @@
+import { authorizedFetch } from './authService';
+
+const BASE_URL = 'http://localhost:8000';
+
+async function send(path, options = {}) {
+    const response = await authorizedFetch(`${BASE_URL}${path}`, {
+        ...options,
+        headers: { 'Content-Type': 'application/json', ...options.headers },
+    });
+    if (!response.ok) {
+        throw new Error(await response.text());
+    }
+    return response.json();
+}
+
+function filePath(userId, filename) {
+    return `/users/${userId}/files/${encodeURIComponent(filename)}/shares`;
+}
+
+// Share a file with a user by email; pass a version to share only that version
+export function shareWithUser(userId, filename, email, permission = 'viewer', version) {
+    return send(filePath(userId, filename), { method: 'POST', body: JSON.stringify({ email, permission, version }) });
+}
+
+export function shareWithOrganization(userId, filename, orgId, permission = 'viewer', version) {
+    return send(filePath(userId, filename), { method: 'POST', body: JSON.stringify({ org_id: orgId, permission, version }) });
+}
+
+export function listShares(userId, filename) {
+    return send(filePath(userId, filename));
+}
+
+export function revokeShare(userId, filename, shareId) {
+    return send(`${filePath(userId, filename)}/${shareId}`, { method: 'DELETE' });
+}
+
+// Documents other users have shared with the current user
+export function listSharedWithMe(userId) {
+    return send(`/users/${userId}/files?view=shared`);
+}
+
+export function sharedDocumentUrl(documentId, action = 'download') {
+    return `${BASE_URL}/shared/documents/${documentId}/${action}`;
+}
@@ -1,4 +1,4 @@
-import { getToken } from './authService';
+import { getToken, authorizedFetch } from './authService';
 
 const BASE_URL = 'http://localhost:8000';
 
@@ -9,7 +9,7 @@ export async function fetchUserIDByEmail(email) {
         throw new Error("Authorization token missing.");
     }
 
-    const response = await fetch(`${BASE_URL}/users/email?email=${encodeURIComponent(email)}`, {
+    const response = await authorizedFetch(`${BASE_URL}/users/email?email=${encodeURIComponent(email)}`, {
         method: 'GET',
         headers: {
             Authorization: token,
@@ -29,7 +29,7 @@ export async function uploadFile(userId, file) {
     formData.append('contract', file);
 
     const token = getToken();
-    const response = await fetch(`${BASE_URL}/users/${userId}/upload`, {
+    const response = await authorizedFetch(`${BASE_URL}/users/${userId}/upload`, {
         method: 'POST',
         headers: {
             Authorization: token,
@@ -38,16 +38,90 @@ export async function uploadFile(userId, file) {
     });
 
     if (!response.ok) {
-        console.error('Upload response:', await response.text());
-        throw new Error('Failed to upload file');
+        throw await uploadError(response);
     }
     return response.json();
 }
 
+const TUS_VERSION = '1.0.0';
+const CHUNK_SIZE = 5 * 1024 * 1024;
+const CHUNK_RETRIES = 3;
+
+// Base64 encode a string for the tus Upload-Metadata header
+function encodeMetadata(value) {
+    return btoa(unescape(encodeURIComponent(value)));
+}
+
+// Turn a refused upload into an Error carrying the server's message. Rejected files come
+// back as {"error", "message", "findings"}.
+async function uploadError(response) {
+    const text = await response.text();
+    console.error('Upload response:', text);
+    let message = 'Failed to upload file';
+    try {
+        message = JSON.parse(text).message || message;
+    } catch (e) {
+        // Not a structured rejection
+    }
+    return new Error(message);
+}
+
+// Upload a file in chunks with the tus protocol, resuming from the last stored chunk when
+// the connection drops. onProgress is called with the fraction uploaded.
+export async function uploadFileResumable(userId, file, onProgress = () => {}) {
+    const tusHeaders = { 'Tus-Resumable': TUS_VERSION };
+    const created = await authorizedFetch(`${BASE_URL}/users/${userId}/uploads`, {
+        method: 'POST',
+        headers: {
+            ...tusHeaders,
+            'Upload-Length': String(file.size),
+            'Upload-Metadata': `filename ${encodeMetadata(file.name)}`,
+        },
+    });
+    if (!created.ok) {
+        throw await uploadError(created);
+    }
+    const location = `${BASE_URL}${created.headers.get('Location')}`;
+
+    let offset = 0;
+    let failures = 0;
+    while (offset < file.size) {
+        let response;
+        try {
+            response = await authorizedFetch(location, {
+                method: 'PATCH',
+                headers: {
+                    ...tusHeaders,
+                    'Content-Type': 'application/offset+octet-stream',
+                    'Upload-Offset': String(offset),
+                },
+                body: file.slice(offset, offset + CHUNK_SIZE),
+            });
+        } catch (e) {
+            // Dropped connection: ask the server where to resume
+            if (++failures > CHUNK_RETRIES) {
+                throw new Error('Failed to upload file');
+            }
+            const head = await authorizedFetch(location, { method: 'HEAD', headers: tusHeaders });
+            if (!head.ok) {
+                throw new Error('Failed to upload file');
+            }
+            offset = Number(head.headers.get('Upload-Offset'));
+            continue;
+        }
+        if (!response.ok) {
+            throw await uploadError(response);
+        }
+        failures = 0;
+        offset = Number(response.headers.get('Upload-Offset'));
+        onProgress(offset / file.size);
+    }
+}
+
 // Fetch all versions of a user's files
 export async function getUserFiles(userId) {
     const token = getToken();
-    const response = await fetch(`${BASE_URL}/users/${userId}/files`, {
+    const response = await authorizedFetch(`${BASE_URL}/users/${userId}/files`, {
         headers: {
             Authorization: token,
         },
@@ -64,7 +138,7 @@ export async function getUserFiles(userId) {
 export async function downloadFile(userId, filename) {
     const token = getToken();
     const encodedFilename = encodeURIComponent(filename);
-    const response = await fetch(`${BASE_URL}/users/${userId}/files/${encodedFilename}/download`, {
+    const response = await authorizedFetch(`${BASE_URL}/users/${userId}/files/${encodedFilename}/download`, {
         method: 'GET',
         headers: {
             Authorization: token,
@@ -91,7 +165,7 @@ export async function downloadFile(userId, filename) {
 export async function deleteFile(userId, filename) {
     const token = getToken();
     const encodedFilename = encodeURIComponent(filename);
-    const response = await fetch(`${BASE_URL}/users/${userId}/files/${encodedFilename}/delete`, {
+    const response = await authorizedFetch(`${BASE_URL}/users/${userId}/files/${encodedFilename}/delete`, {
         method: 'DELETE',
         headers: {
             Authorization: token,
@@ -108,7 +182,7 @@ export async function deleteFile(userId, filename) {
 // Other user service functions for handling users
 export async function getUsers() {
     const token = getToken();
-    const response = await fetch(`${BASE_URL}/users`, {
+    const response = await authorizedFetch(`${BASE_URL}/users`, {
         headers: {
             Authorization: token,
         },
@@ -128,7 +202,7 @@ export async function createUser(user) {
 }
 
 export async function updateUser(userId, updatedUserData) {
-    const response = await fetch(`${BASE_URL}/users/${userId}`, {
+    const response = await authorizedFetch(`${BASE_URL}/users/${userId}`, {
         method: 'PUT',
         headers: {
             'Content-Type': 'application/json',
@@ -141,7 +215,7 @@ export async function updateUser(userId, updatedUserData) {
 }
 
 export async function deleteUser(userId) {
-    const response = await fetch(`${BASE_URL}/users/${userId}`, {
+    const response = await authorizedFetch(`${BASE_URL}/users/${userId}`, {
         method: 'DELETE',
         headers: {
             Authorization: getToken(),
@@ -157,18 +231,39 @@ export async function loginUser(credentials) {
         headers: { 'Content-Type': 'application/json' },
         body: JSON.stringify(credentials),
     });
+    if (response.status === 429) {
+        throw new Error(`Too many failed attempts. Try again in ${response.headers.get('Retry-After') || 'a few'} seconds.`);
+    }
     if (!response.ok) throw new Error('Login failed');
     
     const data = await response.json();
+    if (data.mfa_required) {
+        const code = window.prompt('Enter the code from your authenticator app, or a recovery code');
+        if (!code) throw new Error('Two-factor authentication cancelled');
+        return completeMfaLogin(data.mfa_token, code);
+    }
     return data;
 }
 
+// Finish a login for an account with two-factor authentication enabled. Codes containing a
+// dash are recovery codes, anything else is treated as a TOTP code.
+export async function completeMfaLogin(mfaToken, code) {
+    const factor = code.includes('-') ? { recovery_code: code } : { code: code.trim() };
+    const response = await fetch(`${BASE_URL}/login/mfa`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ mfa_token: mfaToken, ...factor }),
+    });
+    if (!response.ok) throw new Error('Two-factor authentication failed');
+    return response.json();
+}
+
 // Fetch PDF blob for previewing
 export async function fetchPdfBlob(userId, filename, version) {
     const token = getToken();
     const encodedFilename = encodeURIComponent(filename);
     
-    const response = await fetch(`${BASE_URL}/users/${userId}/files/${encodedFilename}/${version}/blob`, {
+    const response = await authorizedFetch(`${BASE_URL}/users/${userId}/files/${encodedFilename}/${version}/blob`, {
         method: 'GET',
         headers: {
             Authorization: token,
@@ -183,3 +278,36 @@ export async function fetchPdfBlob(userId, filename, version) {
     return await response.blob();
 }
 
+
+// Confirm an email address with the token from the verification email
+export async function verifyEmail(token) {
+    const response = await fetch(`${BASE_URL}/email/verify`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ token }),
+    });
+    if (!response.ok) throw new Error('Email verification failed');
+    return response.json();
+}
+
+// Ask for a password reset email; the response is the same whether or not the account exists
+export async function requestPasswordReset(email) {
+    const response = await fetch(`${BASE_URL}/password/forgot`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ email }),
+    });
+    if (!response.ok) throw new Error('Password reset request failed');
+    return response.json();
+}
+
+// Choose a new password with the token from the reset email
+export async function resetPassword(token, password) {
+    const response = await fetch(`${BASE_URL}/password/reset`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ token, password }),
+    });
+    if (!response.ok) throw new Error('Password reset failed');
+    return response.json();
+}